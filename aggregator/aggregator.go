@@ -57,29 +57,37 @@ type Aggregator interface {
 	// Process an aggregator command, which is a data point with insturctions on how to process it.
 	ProcessCmd(cmd *Command)
 	// Flush all aggregations to the undelying DataPointQueuer. If now is zero, time.Now() is used.
-	// All internal state is cleared after a flush.
+	// All internal state is cleared after a flush, except for the
+	// EWMA and sliding window rates, which live on across flushes.
 	Flush(now time.Time)
+	// Clear all internal state, including the rates, without
+	// flushing anything.
+	Reset()
 }
 
 type State struct {
-	t          DataPointQueuer
-	m          map[string]*aggregation
-	lastFlush  time.Time
-	Thresholds []int // List of percentiles for CmdAppend
-	AppendAttr string
+	t           DataPointQueuer
+	m           map[string]*aggregation
+	rates       map[string]*rateAggregation
+	lastFlush   time.Time
+	Thresholds  []int           // List of percentiles for CmdAppend
+	RateWindows []time.Duration // List of sliding windows for CmdAddWindow
+	AppendAttr  string
 }
 
 // Returns a new aggregator. The only argument needs to provide a
 // QueueDataPoint() method which is what the aggregator will use to
 // queue the aggregated points. The returned aggregator state has
-// Thresholds set to {90}.
+// Thresholds set to {90} and RateWindows set to {1m}.
 func NewAggregator(t DataPointQueuer) *State {
 	return &State{
-		t:          t,
-		m:          make(map[string]*aggregation),
-		lastFlush:  time.Now(),
-		Thresholds: []int{90},
-		AppendAttr: "value",
+		t:           t,
+		m:           make(map[string]*aggregation),
+		rates:       make(map[string]*rateAggregation),
+		lastFlush:   time.Now(),
+		Thresholds:  []int{90},
+		RateWindows: []time.Duration{time.Minute},
+		AppendAttr:  "value",
 	}
 }
 
@@ -127,7 +135,7 @@ func (a *State) append(ident serde.Ident, value float64) {
 }
 
 func (a *State) ProcessCmd(cmd *Command) {
-	if !cmd.ts.IsZero() && cmd.ts.Before(a.lastFlush) {
+	if cmd.cmd != cmdRate && !cmd.ts.IsZero() && cmd.ts.Before(a.lastFlush) {
 		return // this command is too old for this aggregator, ignore it
 	}
 	switch cmd.cmd {
@@ -139,6 +147,12 @@ func (a *State) ProcessCmd(cmd *Command) {
 		a.setGauge(cmd.ident, cmd.value)
 	case CmdAppend:
 		a.append(cmd.ident, cmd.value)
+	case CmdAddEWMA:
+		a.addEWMA(cmd.ident, cmd.value)
+	case CmdAddWindow:
+		a.addWindow(cmd.ident, cmd.ts, cmd.value)
+	case cmdRate:
+		a.restoreRate(cmd.ident, cmd.rate)
 	}
 }
 
@@ -206,18 +220,29 @@ func (a *State) Flush(now time.Time) {
 		}
 	}
 
+	a.flushRates(now)
+
 	// clear the map
 	a.m = make(map[string]*aggregation)
 	a.lastFlush = now
 }
 
+func (a *State) Reset() {
+	a.m = make(map[string]*aggregation)
+	a.rates = make(map[string]*rateAggregation)
+	a.lastFlush = time.Now()
+}
+
 type AggCmd int
 
 const (
-	CmdAdd      AggCmd = iota // Add the value, the flushed value is a per second rate.
-	CmdAddGauge               // Add the value, the flushed value is the sum as is (e.g. total traffic for all routers).
-	CmdSetGauge               // Overwrite the value, the flushed value is the last value as is.
-	CmdAppend                 // Append the value to a slice. The flushed values will be upper/lower/sum/mean and Threshold percentiles.
+	CmdAdd       AggCmd = iota // Add the value, the flushed value is a per second rate.
+	CmdAddGauge                // Add the value, the flushed value is the sum as is (e.g. total traffic for all routers).
+	CmdSetGauge                // Overwrite the value, the flushed value is the last value as is.
+	CmdAppend                  // Append the value to a slice. The flushed values will be upper/lower/sum/mean and Threshold percentiles.
+	CmdAddEWMA                 // Add the value, the flushed values are 1, 5 and 15 minute exponentially weighted per second rates.
+	CmdAddWindow               // Add the value, the flushed values are per second rates over each of RateWindows.
+	cmdRate                    // Carry on a rate handed over by another aggregator, see RateCommands().
 )
 
 // An aggregator command. Use NewCommand() to create one.
@@ -226,7 +251,8 @@ type Command struct {
 	ident serde.Ident
 	value float64
 	ts    time.Time
-	Hops  int // For cluster forwarding
+	rate  *rateState // cmdRate only
+	Hops  int        // For cluster forwarding
 }
 
 func (ac *Command) GobEncode() ([]byte, error) {
@@ -243,6 +269,9 @@ func (ac *Command) GobEncode() ([]byte, error) {
 	check(enc.Encode(ac.value))
 	check(enc.Encode(ac.ts))
 	check(enc.Encode(ac.Hops))
+	if ac.cmd == cmdRate {
+		check(enc.Encode(ac.rate))
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -262,9 +291,23 @@ func (ac *Command) GobDecode(b []byte) error {
 	check(dec.Decode(&ac.value))
 	check(dec.Decode(&ac.ts))
 	check(dec.Decode(&ac.Hops))
+	if err == nil && ac.cmd == cmdRate {
+		ac.rate = new(rateState)
+		check(dec.Decode(ac.rate))
+	}
 	return err
 }
 
+// Ident returns the ident of the command.
+func (ac *Command) Ident() serde.Ident {
+	return ac.ident
+}
+
+// SetIdent changes the ident of the command.
+func (ac *Command) SetIdent(ident serde.Ident) {
+	ac.ident = ident
+}
+
 // Create an aggregator command. The cmd argument dictates how the
 // data will be aggregated, see AggCmd.
 func NewCommand(cmd AggCmd, ident serde.Ident, value float64) *Command {
@@ -0,0 +1,337 @@
+//
+// Copyright 2016 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregator
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/tgres/tgres/serde"
+)
+
+// Unlike the CmdAdd rate, which is the sum divided by the time since
+// the last flush, the EWMA and sliding window rates below are based
+// on wall clock time and survive across flushes. This makes them
+// insensitive to flush interval jitter. A new rate is initialized
+// from the first interval it observes rather than decaying up from
+// zero. That interval starts at the previous flush, not at the first
+// value, which would otherwise be divided by a fraction of the
+// interval and show as a spike. When a cluster transition moves an
+// aggregator to another node, its rates are handed over (see
+// RateCommands()) and carry on there.
+
+// The EWMA windows, same as the UNIX load average (and
+// Dropwizard/Coda Hale meters).
+var ewmaWindows = []struct {
+	suffix string
+	window time.Duration
+}{
+	{".m1_rate", time.Minute},
+	{".m5_rate", 5 * time.Minute},
+	{".m15_rate", 15 * time.Minute},
+}
+
+// How many of its longest window a rate may go without any input
+// before its state is discarded.
+const rateIdleWindows = 4
+
+// ewma is an exponentially weighted moving average of a rate. The
+// alpha is computed from the actual time elapsed between ticks, so
+// irregular ticks do not skew the result.
+type ewma struct {
+	window time.Duration
+	rate   float64
+	init   bool
+}
+
+func (e *ewma) tick(count float64, dt time.Duration) {
+	if dt <= 0 {
+		return
+	}
+	instant := count / dt.Seconds()
+	if !e.init {
+		e.rate, e.init = instant, true
+		return
+	}
+	alpha := 1 - math.Exp(-dt.Seconds()/e.window.Seconds())
+	e.rate += alpha * (instant - e.rate)
+}
+
+// slidingWindow is a fixed size window divided into buckets. Each
+// bucket is identified by its absolute number since the epoch, a
+// bucket whose number is too old is simply ignored and reused.
+type slidingWindow struct {
+	window  time.Duration
+	width   int64 // bucket width in nanoseconds
+	buckets []float64
+	marks   []int64
+	first   time.Time
+}
+
+// Number of buckets per sliding window, this determines the
+// granularity with which values expire.
+const slidingWindowBuckets = 60
+
+func newSlidingWindow(window time.Duration) *slidingWindow {
+	width := window.Nanoseconds() / slidingWindowBuckets
+	if width < 1 {
+		width = 1
+	}
+	return &slidingWindow{
+		window:  window,
+		width:   width,
+		buckets: make([]float64, slidingWindowBuckets),
+		marks:   make([]int64, slidingWindowBuckets),
+	}
+}
+
+func (w *slidingWindow) add(ts time.Time, value float64) {
+	if w.first.IsZero() || ts.Before(w.first) {
+		w.first = ts
+	}
+	b := ts.UnixNano() / w.width
+	slot := b % int64(len(w.buckets))
+	if w.marks[slot] != b {
+		if w.marks[slot] > b {
+			return // older than the window
+		}
+		w.buckets[slot], w.marks[slot] = 0, b
+	}
+	w.buckets[slot] += value
+}
+
+// merge adds the buckets of a window handed over by another
+// aggregator, newer buckets replace older ones.
+func (w *slidingWindow) merge(ws windowState) {
+	if ws.Window != w.window || len(ws.Buckets) != len(w.buckets) || len(ws.Marks) != len(w.marks) {
+		return
+	}
+	for i, mark := range ws.Marks {
+		switch {
+		case mark == w.marks[i]:
+			w.buckets[i] += ws.Buckets[i]
+		case mark > w.marks[i]:
+			w.buckets[i], w.marks[i] = ws.Buckets[i], mark
+		}
+	}
+	if !ws.First.IsZero() && (w.first.IsZero() || ws.First.Before(w.first)) {
+		w.first = ws.First
+	}
+}
+
+// Per second rate over the window ending at now. If the window has
+// not been filled yet, the rate is over the time since the first
+// value.
+func (w *slidingWindow) rate(now time.Time) float64 {
+	nb := now.UnixNano() / w.width
+	oldest := nb - int64(len(w.buckets)) + 1
+	var sum float64
+	for i, mark := range w.marks {
+		if mark >= oldest && mark <= nb {
+			sum += w.buckets[i]
+		}
+	}
+	start := time.Unix(0, oldest*w.width)
+	if w.first.After(start) {
+		start = w.first
+	}
+	if elapsed := now.Sub(start); elapsed > 0 {
+		return sum / elapsed.Seconds()
+	}
+	return 0
+}
+
+type rateAggregation struct {
+	ident    serde.Ident
+	count    float64 // since last tick (EWMA only)
+	ewmas    []*ewma
+	windows  []*slidingWindow
+	lastTick time.Time
+	lastSeen time.Time
+}
+
+// Create the rate at key ident if not existing. The window list is
+// only consulted on creation.
+func (a *State) rate(ident serde.Ident, now time.Time) *rateAggregation {
+	key := ident.String()
+	ra := a.rates[key]
+	if ra == nil {
+		ra = &rateAggregation{ident: ident, lastTick: a.lastFlush}
+		a.rates[key] = ra
+	}
+	ra.lastSeen = now
+	return ra
+}
+
+func (ra *rateAggregation) initEWMAs() {
+	if ra.ewmas == nil {
+		for _, w := range ewmaWindows {
+			ra.ewmas = append(ra.ewmas, &ewma{window: w.window})
+		}
+	}
+}
+
+// The windows are observed from first on, i.e. a value added right
+// after first is not taken as the rate of a fraction of a second.
+func (ra *rateAggregation) initWindows(windows []time.Duration, first time.Time) {
+	if ra.windows == nil {
+		for _, w := range windows {
+			sw := newSlidingWindow(w)
+			sw.first = first
+			ra.windows = append(ra.windows, sw)
+		}
+	}
+}
+
+func (a *State) addEWMA(ident serde.Ident, value float64) {
+	ra := a.rate(ident, time.Now())
+	ra.initEWMAs()
+	ra.count += value
+}
+
+func (a *State) addWindow(ident serde.Ident, ts time.Time, value float64) {
+	now := time.Now()
+	if ts.IsZero() {
+		ts = now
+	}
+	ra := a.rate(ident, now)
+	ra.initWindows(a.RateWindows, a.lastFlush)
+	for _, w := range ra.windows {
+		w.add(ts, value)
+	}
+}
+
+func (a *State) flushRates(now time.Time) {
+	for key, ra := range a.rates {
+		var longest time.Duration
+		if len(ra.ewmas) > 0 {
+			dt := now.Sub(ra.lastTick)
+			for i, e := range ra.ewmas {
+				e.tick(ra.count, dt)
+				if e.init {
+					a.t.QueueDataPoint(appendIdent(ra.ident, a.AppendAttr, ewmaWindows[i].suffix), now, e.rate)
+				}
+				if e.window > longest {
+					longest = e.window
+				}
+			}
+			ra.count, ra.lastTick = 0, now
+		}
+		for _, w := range ra.windows {
+			suffix := fmt.Sprintf(".rate_%d", int64(w.window.Seconds()))
+			a.t.QueueDataPoint(appendIdent(ra.ident, a.AppendAttr, suffix), now, w.rate(now))
+			if w.window > longest {
+				longest = w.window
+			}
+		}
+		if now.Sub(ra.lastSeen) > longest*rateIdleWindows {
+			delete(a.rates, key)
+		}
+	}
+}
+
+// rateState is a rate as it is handed over to another aggregator,
+// with exported fields so that it can be gob-encoded.
+type rateState struct {
+	Count    float64
+	EWMAs    []ewmaState
+	Windows  []windowState
+	LastTick time.Time
+	LastSeen time.Time
+}
+
+type ewmaState struct {
+	Window time.Duration
+	Rate   float64
+	Init   bool
+}
+
+type windowState struct {
+	Window  time.Duration
+	Buckets []float64
+	Marks   []int64
+	First   time.Time
+}
+
+// RateCommands returns a command for every EWMA and sliding window
+// rate of this aggregator. Processed by another aggregator (normally
+// on the node acquiring this one in a cluster transition), they carry
+// the rates on from where they are rather than starting them anew.
+func (a *State) RateCommands() []*Command {
+	now := time.Now()
+	cmds := make([]*Command, 0, len(a.rates))
+	for _, ra := range a.rates {
+		rs := &rateState{Count: ra.count, LastTick: ra.lastTick, LastSeen: ra.lastSeen}
+		for _, e := range ra.ewmas {
+			rs.EWMAs = append(rs.EWMAs, ewmaState{Window: e.window, Rate: e.rate, Init: e.init})
+		}
+		for _, w := range ra.windows {
+			rs.Windows = append(rs.Windows, windowState{
+				Window:  w.window,
+				Buckets: append([]float64(nil), w.buckets...),
+				Marks:   append([]int64(nil), w.marks...),
+				First:   w.first,
+			})
+		}
+		cmds = append(cmds, &Command{cmd: cmdRate, ident: ra.ident, ts: now, rate: rs})
+	}
+	return cmds
+}
+
+// Carry on a rate handed over by another aggregator. If values for
+// it have already arrived here, the two are merged.
+func (a *State) restoreRate(ident serde.Ident, rs *rateState) {
+	if rs == nil {
+		return
+	}
+	key := ident.String()
+	ra := a.rates[key]
+	if ra == nil {
+		ra = &rateAggregation{ident: ident, lastTick: rs.LastTick}
+		a.rates[key] = ra
+	}
+	if rs.LastSeen.After(ra.lastSeen) {
+		ra.lastSeen = rs.LastSeen
+	}
+
+	if len(rs.EWMAs) > 0 {
+		// Unless the EWMAs have ticked here already, the count
+		// is over the time since the earlier of the two ticks.
+		if (ra.ewmas == nil || !ra.ewmas[0].init) && rs.LastTick.Before(ra.lastTick) {
+			ra.lastTick = rs.LastTick
+		}
+		ra.initEWMAs()
+		ra.count += rs.Count
+		for _, es := range rs.EWMAs {
+			for _, e := range ra.ewmas {
+				if e.window == es.Window && es.Init {
+					// the handed over rate has the longer history
+					e.rate, e.init = es.Rate, true
+				}
+			}
+		}
+	}
+
+	if len(rs.Windows) > 0 {
+		ra.initWindows(a.RateWindows, time.Time{})
+		for _, ws := range rs.Windows {
+			for _, w := range ra.windows {
+				w.merge(ws)
+			}
+		}
+	}
+}
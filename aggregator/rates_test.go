@@ -0,0 +1,178 @@
+//
+// Copyright 2016 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregator
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/tgres/tgres/serde"
+)
+
+type fakeQueuer struct {
+	points map[string]float64
+}
+
+func (f *fakeQueuer) QueueDataPoint(ident serde.Ident, ts time.Time, v float64) {
+	f.points[ident["name"]] = v
+}
+
+func Test_ewma_tick(t *testing.T) {
+	e := &ewma{window: time.Minute}
+	e.tick(100, 10*time.Second)
+	if !e.init || e.rate != 10 {
+		t.Errorf("first tick should initialize rate to 10, got %v", e.rate)
+	}
+	// A jittery interval with the same underlying rate should
+	// not move the average.
+	e.tick(130, 13*time.Second)
+	if math.Abs(e.rate-10) > 1e-9 {
+		t.Errorf("rate should stay 10 regardless of interval, got %v", e.rate)
+	}
+	e.tick(0, time.Minute)
+	if exp := 10 * math.Exp(-1); math.Abs(e.rate-exp) > 1e-9 {
+		t.Errorf("after one idle window rate should be %v, got %v", exp, e.rate)
+	}
+}
+
+func Test_slidingWindow(t *testing.T) {
+	w := newSlidingWindow(time.Minute)
+	t0 := time.Unix(1000*60, 0)
+	for i := 0; i < 60; i++ {
+		w.add(t0.Add(time.Duration(i)*time.Second), 2)
+	}
+	if r := w.rate(t0.Add(60 * time.Second)); math.Abs(r-2) > 1e-9 {
+		t.Errorf("window rate should be 2, got %v", r)
+	}
+	// half the window has expired, no new values (the rate is
+	// only as precise as the bucket width)
+	if r := w.rate(t0.Add(90 * time.Second)); math.Abs(r-1) > 0.05 {
+		t.Errorf("window rate should be 1, got %v", r)
+	}
+	// way too old, ignored
+	w.add(t0, 1000)
+	if r := w.rate(t0.Add(90 * time.Second)); math.Abs(r-1) > 0.05 {
+		t.Errorf("old values should be ignored, got %v", r)
+	}
+}
+
+func Test_State_rates(t *testing.T) {
+	q := &fakeQueuer{points: make(map[string]float64)}
+	a := NewAggregator(q)
+	a.AppendAttr = "name"
+
+	a.ProcessCmd(NewCommand(CmdAddEWMA, serde.Ident{"name": "foo"}, 10))
+	a.ProcessCmd(NewCommand(CmdAddWindow, serde.Ident{"name": "bar"}, 10))
+	a.Flush(time.Now().Add(10 * time.Second))
+
+	for _, name := range []string{"foo.m1_rate", "foo.m5_rate", "foo.m15_rate", "bar.rate_60"} {
+		if _, ok := q.points[name]; !ok {
+			t.Errorf("%s not flushed", name)
+		}
+	}
+
+	// rates live on across flushes
+	q.points = make(map[string]float64)
+	a.Flush(time.Now().Add(20 * time.Second))
+	if _, ok := q.points["foo.m1_rate"]; !ok {
+		t.Errorf("EWMA rate should survive a flush")
+	}
+
+	a.Reset()
+	q.points = make(map[string]float64)
+	a.Flush(time.Now().Add(30 * time.Second))
+	if len(q.points) != 0 {
+		t.Errorf("nothing should be flushed after Reset(), got %v", q.points)
+	}
+}
+
+func Test_State_rates_firstInterval(t *testing.T) {
+	q := &fakeQueuer{points: make(map[string]float64)}
+	a := NewAggregator(q)
+	a.AppendAttr = "name"
+	start := time.Now()
+	a.lastFlush = start.Add(-10 * time.Second)
+
+	// The first values arrive just before the flush, they are a
+	// rate over the whole interval since the previous flush.
+	a.ProcessCmd(NewCommand(CmdAddEWMA, serde.Ident{"name": "foo"}, 10))
+	a.ProcessCmd(NewCommand(CmdAddWindow, serde.Ident{"name": "bar"}, 10))
+	a.Flush(start.Add(time.Millisecond))
+
+	for _, name := range []string{"foo.m1_rate", "bar.rate_60"} {
+		if v := q.points[name]; math.Abs(v-1) > 0.01 {
+			t.Errorf("%s: expected a rate of about 1, got %v", name, v)
+		}
+	}
+}
+
+func Test_State_RateCommands(t *testing.T) {
+	q := &fakeQueuer{points: make(map[string]float64)}
+	a := NewAggregator(q)
+	a.AppendAttr = "name"
+	now := time.Now()
+	a.lastFlush = now.Add(-10 * time.Second)
+	a.ProcessCmd(NewCommand(CmdAddEWMA, serde.Ident{"name": "foo"}, 10))
+	a.ProcessCmd(NewCommand(CmdAddWindow, serde.Ident{"name": "bar"}, 10))
+	a.Flush(now)
+	a.ProcessCmd(NewCommand(CmdAddEWMA, serde.Ident{"name": "foo"}, 5))
+	expect := q.points
+
+	cmds := a.RateCommands()
+	if len(cmds) != 2 {
+		t.Fatalf("RateCommands: expected 2 commands, got %d", len(cmds))
+	}
+
+	// The other aggregator has seen a value already, and
+	// flushed after the commands were created.
+	q = &fakeQueuer{points: make(map[string]float64)}
+	b := NewAggregator(q)
+	b.AppendAttr = "name"
+	b.ProcessCmd(NewCommand(CmdAddWindow, serde.Ident{"name": "bar"}, 20))
+	b.lastFlush = time.Now().Add(time.Second)
+	for _, cmd := range cmds {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(cmd); err != nil {
+			t.Fatal(err)
+		}
+		var dcmd Command
+		if err := gob.NewDecoder(&buf).Decode(&dcmd); err != nil {
+			t.Fatal(err)
+		}
+		b.ProcessCmd(&dcmd)
+	}
+
+	rb := b.rates[serde.Ident{"name": "foo"}.String()]
+	if rb == nil || rb.count != 5 || !rb.lastTick.Equal(now) {
+		t.Fatalf("RateCommands: the count and last tick should be carried on: %+v", rb)
+	}
+	for _, e := range rb.ewmas {
+		if !e.init || e.rate != expect["foo"+ewmaWindows[0].suffix] {
+			t.Errorf("RateCommands: EWMA %v should be carried on: %+v", e.window, e)
+		}
+	}
+	w := b.rates[serde.Ident{"name": "bar"}.String()].windows[0]
+	var sum float64
+	for _, v := range w.buckets {
+		sum += v
+	}
+	if sum != 30 || !w.first.Equal(a.lastFlush.Add(-10*time.Second)) {
+		t.Errorf("RateCommands: window should be merged, sum %v first %v", sum, w.first)
+	}
+}
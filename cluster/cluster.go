@@ -39,26 +39,32 @@ import (
 	"encoding/binary"
 	"encoding/gob"
 	"fmt"
-	"log"
 	"net"
 	"net/rpc"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/memberlist"
+	"github.com/tgres/tgres/logging"
 )
 
 var (
 	debug     bool
 	startTime time.Time
+	logger    = logging.New("cluster")
 )
 
 func init() {
 	startTime = time.Now()
 	debug = os.Getenv("TGRES_CLUSTER_DEBUG") != ""
+	if debug {
+		logger.SetLevel(logging.Debug)
+	}
 }
 
 const updateNodeTO = 30 * time.Second
@@ -74,15 +80,24 @@ type Cluster struct {
 	*memberlist.Memberlist
 	sync.RWMutex
 	rcvChs    []chan *Msg
+	reqHdlrs  []RequestHandler
+	reqLock   sync.Mutex
+	reqConns  map[string]*rpc.Client
 	chgNotify []chan bool
 	meta      []byte
 	dds       map[string]*ddEntry
 	snd, rcv  chan *Msg // dds messages
 	copies    int
+	placement Placement
+	ringLock  sync.Mutex
+	ring      *hashRing
 	rpcPort   int
 	rpc       net.Listener
 	joined    bool
 	ncache    map[*memberlist.Node]*Node
+	histLock  sync.Mutex
+	history   []TransitionStats
+	inTrans   int32 // atomic, see Transitioning()
 }
 
 // NewCluster creates a new Cluster with reasonable defaults.
@@ -99,6 +114,7 @@ func NewCluster() (*Cluster, error) {
 func NewClusterBind(baddr string, bport int, aaddr string, aport int, rpcport int, name string) (*Cluster, error) {
 	c := &Cluster{
 		rcvChs:    make([]chan *Msg, 0),
+		reqConns:  make(map[string]*rpc.Client),
 		chgNotify: make([]chan bool, 0),
 		dds:       make(map[string]*ddEntry),
 		copies:    1,
@@ -124,7 +140,7 @@ func NewClusterBind(baddr string, bport int, aaddr string, aport int, rpcport in
 	if name != "" {
 		cfg.Name = name
 	}
-	cfg.LogOutput = &logger{}
+	cfg.LogOutput = &memberlistLog{}
 	cfg.Delegate, cfg.Events = c, c
 	var err error
 	if c.Memberlist, err = memberlist.Create(cfg); err != nil {
@@ -133,7 +149,7 @@ func NewClusterBind(baddr string, bport int, aaddr string, aport int, rpcport in
 	md := &nodeMeta{sortBy: startTime.UnixNano()}
 	c.saveMeta(md)
 	if err = c.UpdateNode(updateNodeTO); err != nil {
-		log.Printf("NewClusterBind(): UpdateNode() failed: %v", err)
+		logger.Errorf("NewClusterBind(): UpdateNode() failed: %v", err)
 		return nil, err
 	}
 
@@ -170,16 +186,33 @@ func (rpc *ClusterRPC) Message(msg Msg, reply *Msg) error {
 	if msg.Id < len(rpc.c.rcvChs) {
 		rpc.c.rcvChs[msg.Id] <- &msg
 	} else {
-		log.Printf("Cluster.Message() (via RPC): unknown msg Id: %d, dropping message.", msg.Id)
+		logger.Warnf("Cluster.Message() (via RPC): unknown msg Id: %d, dropping message.", msg.Id)
 	}
 
 	//*reply = Msg{Id: 495, Body: []byte("HELLO")}
 	return nil
 }
 
+func (rpc *ClusterRPC) Request(msg Msg, reply *Msg) error {
+	if msg.Id >= len(rpc.c.reqHdlrs) {
+		return fmt.Errorf("Cluster.Request() (via RPC): unknown request Id: %d", msg.Id)
+	}
+	result, err := rpc.c.reqHdlrs[msg.Id](&msg)
+	if err != nil {
+		return err
+	}
+	resp, err := NewMsg(msg.Src, result)
+	if err != nil {
+		return err
+	}
+	*reply = *resp
+	return nil
+}
+
 // Set the number of copies of DistDatims that the Cluster will
 // keep. The default is 1. You can only set it while the cluster is
-// empty.
+// empty. The first node returned by NodesForDistDatum() is the lead,
+// the rest are the copies.
 func (c *Cluster) Copies(n ...int) int {
 	if len(n) > 0 && len(c.dds) == 0 {
 		// only allow setting copies when the cluster is still empty
@@ -205,11 +238,15 @@ func (c *Cluster) readyNodes() ([]*Node, error) {
 }
 
 // selectNodes uses a simple module to assign a node given an integer
-// id.
+// id. The n nodes are distinct, thus if there are fewer than n nodes,
+// fewer than n are returned.
 func selectNodes(nodes []*Node, id int64, n int) []*Node {
 	if len(nodes) == 0 {
 		return nil
 	}
+	if n > len(nodes) {
+		n = len(nodes)
+	}
 	result := make([]*Node, n)
 	for i := 0; i < n; i++ {
 		result[i] = nodes[(int(id)+i)%len(nodes)]
@@ -243,7 +280,7 @@ func (c *Cluster) LoadDistData(f func() ([]DistDatum, error)) error {
 
 	for _, dd := range dds {
 		key := fmt.Sprintf("%s:%d", dd.Type(), dd.Id())
-		c.dds[key] = &ddEntry{dd: dd, nodes: selectNodes(readyNodes, dd.Id(), c.copies)}
+		c.dds[key] = &ddEntry{dd: dd, nodes: c.placeNodes(readyNodes, dd.Id(), c.copies)}
 	}
 
 	return nil
@@ -334,16 +371,16 @@ func (c *Cluster) RegisterMsgType() (snd, rcv chan *Msg) {
 			msg := <-snd
 
 			if msg.Dst == nil {
-				log.Printf("Cluster: cannot send message when Dst is not set, ignoring.")
+				logger.Warnf("Cluster: cannot send message when Dst is not set, ignoring.")
 				continue
 			}
 
 			if msg.Dst.rpc == nil {
 				addr := fmt.Sprintf("%s:%d", msg.Dst.Addr, c.rpcPort)
-				log.Printf("Cluster: establishing RPC connection to node %s via %s", msg.Dst.Name(), addr)
+				logger.Infof("Cluster: establishing RPC connection to node %s via %s", msg.Dst.Name(), addr)
 				conn, err := net.DialTimeout("tcp", addr, 3*time.Second)
 				if err != nil {
-					log.Printf("Cluster: cannot establish connection to %s: %v, dropping this message.", addr, err)
+					logger.Errorf("Cluster: cannot establish connection to %s: %v, dropping this message.", addr, err)
 					continue
 				}
 				msg.Dst.rpc = rpc.NewClient(conn)
@@ -354,7 +391,7 @@ func (c *Cluster) RegisterMsgType() (snd, rcv chan *Msg) {
 
 			var resp Msg
 			if err := msg.Dst.rpc.Call("ClusterRPC.Message", msg, &resp); err != nil {
-				log.Printf("Cluster: error sending message to %s", msg.Dst.Name())
+				logger.Errorf("Cluster: error sending message to %s", msg.Dst.Name())
 				msg.Dst.rpc = nil
 			}
 		}
@@ -363,6 +400,70 @@ func (c *Cluster) RegisterMsgType() (snd, rcv chan *Msg) {
 	return snd, rcv
 }
 
+// A RequestHandler handles a request sent by Request() and returns a
+// gob-encodable response.
+type RequestHandler func(req *Msg) (interface{}, error)
+
+// RegisterRequestType is like RegisterMsgType, except that the
+// sender waits for a response. It returns the id to pass to
+// Request(). The nodes of the cluster must call RegisterRequestType
+// in exact same order.
+func (c *Cluster) RegisterRequestType(h RequestHandler) int {
+	c.reqHdlrs = append(c.reqHdlrs, h)
+	return len(c.reqHdlrs) - 1
+}
+
+// Request sends payload to the handler of the request type id on
+// node dst and waits (up to timeout) for the response, which can be
+// decoded with Msg.Decode().
+func (c *Cluster) Request(dst *Node, id int, payload interface{}, timeout time.Duration) (*Msg, error) {
+	msg, err := NewMsg(dst, payload)
+	if err != nil {
+		return nil, err
+	}
+	msg.Src = c.LocalNode()
+	msg.Id = id
+
+	client, err := c.requestClient(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp Msg
+	call := client.Go("ClusterRPC.Request", msg, &resp, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		if call.Error != nil {
+			if call.Error == rpc.ErrShutdown {
+				c.reqLock.Lock()
+				delete(c.reqConns, dst.Name())
+				c.reqLock.Unlock()
+			}
+			return nil, call.Error
+		}
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("Request to %s timed out after %v", dst.Name(), timeout)
+	}
+	return &resp, nil
+}
+
+// requestClient returns an RPC connection for requests to node n.
+func (c *Cluster) requestClient(n *Node) (*rpc.Client, error) {
+	c.reqLock.Lock()
+	defer c.reqLock.Unlock()
+	if client := c.reqConns[n.Name()]; client != nil {
+		return client, nil
+	}
+	addr := net.JoinHostPort(n.Addr.String(), strconv.Itoa(c.rpcPort))
+	conn, err := net.DialTimeout("tcp", addr, 3*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("cannot establish connection to %s: %v", addr, err)
+	}
+	client := rpc.NewClient(conn)
+	c.reqConns[n.Name()] = client
+	return client, nil
+}
+
 // NotifyClusterChanges returns a bool channel which will be sent true
 // any time a cluster change happens (nodes join or leave, or node
 // metadata changes).
@@ -429,7 +530,7 @@ func (c *Cluster) SetMetaData(b []byte) error {
 	md.user = b
 	c.saveMeta(md)
 	if err = c.UpdateNode(updateNodeTO); err != nil {
-		log.Printf("Cluster.SetMetaData(): UpdateNode() failed: %v", err)
+		logger.Errorf("Cluster.SetMetaData(): UpdateNode() failed: %v", err)
 	}
 	return err
 }
@@ -444,13 +545,13 @@ func (c *Cluster) NotifyMsg(b []byte) {
 
 	m := &Msg{}
 	if err := gob.NewDecoder(flate.NewReader(bytes.NewBuffer(b))).Decode(m); err != nil {
-		log.Printf("NotifyMsg(): error decoding: %#v", err)
+		logger.Errorf("NotifyMsg(): error decoding: %#v", err)
 	}
 
 	if m.Id < len(c.rcvChs) {
 		c.rcvChs[m.Id] <- m
 	} else {
-		log.Printf("NotifyMsg(): unknown msg Id: %d, dropping message", m.Id)
+		logger.Warnf("NotifyMsg(): unknown msg Id: %d, dropping message", m.Id)
 	}
 }
 
@@ -522,7 +623,7 @@ func (c *Cluster) Ready(status bool) error {
 	md.ready = status
 	c.saveMeta(md)
 	if err = c.UpdateNode(updateNodeTO); err != nil {
-		log.Printf("Ready(): UpdateNode() failed: %v", err)
+		logger.Errorf("Ready(): UpdateNode() failed: %v", err)
 		return err
 	}
 	return nil
@@ -533,6 +634,44 @@ func (c *Cluster) Shutdown() error {
 	return c.Memberlist.Shutdown()
 }
 
+// NodeStatus describes a cluster member for diagnostic purposes.
+type NodeStatus struct {
+	Name    string    `json:"name"`
+	Addr    string    `json:"addr"`
+	Port    uint16    `json:"port"`
+	Local   bool      `json:"local"`
+	Ready   bool      `json:"ready"`
+	Started time.Time `json:"started"`
+	Meta    []byte    `json:"meta,omitempty"` // user meta, see SetMetaData()
+}
+
+// NodeStatuses returns the status of every member, in the same order
+// as SortedNodes().
+func (c *Cluster) NodeStatuses() ([]NodeStatus, error) {
+	nodes, err := c.SortedNodes()
+	if err != nil {
+		return nil, err
+	}
+	ln := c.LocalNode()
+	result := make([]NodeStatus, len(nodes))
+	for i, node := range nodes {
+		md, err := node.extractMeta()
+		if err != nil {
+			return nil, err
+		}
+		result[i] = NodeStatus{
+			Name:    node.Name(),
+			Addr:    node.Addr.String(),
+			Port:    node.Port,
+			Local:   ln != nil && node.Name() == ln.Name(),
+			Ready:   md.ready,
+			Started: time.Unix(0, md.sortBy),
+			Meta:    md.user,
+		}
+	}
+	return result, nil
+}
+
 // Ready returns the status of a node.
 func (n *Node) Ready() bool {
 	md, err := n.extractMeta()
@@ -566,7 +705,7 @@ func (m *Msg) bytes() []byte {
 	z, _ := flate.NewWriter(&buf, -1)
 	enc := gob.NewEncoder(z)
 	if err := enc.Encode(m); err != nil {
-		log.Printf("Msg.bytes(): Error encountered in encoding: %v", err)
+		logger.Errorf("Msg.bytes(): Error encountered in encoding: %v", err)
 		return nil
 	}
 	z.Close()
@@ -576,21 +715,29 @@ func (m *Msg) bytes() []byte {
 // implement gob.GobDecoder interface.
 func (m *Msg) Decode(dst interface{}) error {
 	if err := gob.NewDecoder(bytes.NewBuffer(m.Body)).Decode(dst); err != nil {
-		log.Printf("Msg.Decode() decoding error: %v", err)
+		logger.Errorf("Msg.Decode() decoding error: %v", err)
 		return err
 	}
 	return nil
 }
 
-type logger struct{}
-
-// Ignore [DEBUG]
-func (l *logger) Write(b []byte) (int, error) {
-	s := string(b)
-	if !strings.Contains(s, "[DEBUG]") {
-		log.Printf(s)
+// memberlistLog passes memberlist log lines to our logger at the
+// corresponding level.
+type memberlistLog struct{}
+
+func (l *memberlistLog) Write(b []byte) (int, error) {
+	s := strings.TrimRight(string(b), "\n")
+	switch {
+	case strings.Contains(s, "[DEBUG]"):
+		logger.Debugf("%s", s)
+	case strings.Contains(s, "[WARN]"):
+		logger.Warnf("%s", s)
+	case strings.Contains(s, "[ERR]"):
+		logger.Errorf("%s", s)
+	default:
+		logger.Infof("%s", s)
 	}
-	return len(s), nil
+	return len(b), nil
 }
 
 // DistDatum is an interface for a piece of data distributed across
@@ -631,14 +778,20 @@ type DistDatum interface {
 // the rest are up to the user to decide. The nodes are cached, the
 // call doesn't compute anything. The idea is that a
 // NodesForDistDatum() should be pretty fast so that you can call it a
-// lot, e.g. for every incoming data point.
+// lot, e.g. for every incoming data point. For a DistDatum that was
+// never loaded by this node, the nodes are computed.
 func (c *Cluster) NodesForDistDatum(dd DistDatum) []*Node {
 	c.RLock()
-	defer c.RUnlock()
-	if dde, ok := c.dds[fmt.Sprintf("%s:%d", dd.Type(), dd.Id())]; ok {
+	dde, ok := c.dds[fmt.Sprintf("%s:%d", dd.Type(), dd.Id())]
+	c.RUnlock()
+	if ok {
 		return dde.nodes
 	}
-	return nil
+	readyNodes, err := c.readyNodes()
+	if err != nil {
+		return nil
+	}
+	return c.placeNodes(readyNodes, dd.Id(), c.copies)
 }
 
 func (c *Cluster) List() map[string]*ddEntry {
@@ -656,6 +809,75 @@ func (dde *ddEntry) DD() DistDatum {
 	return dde.dd
 }
 
+// Number of most recent transitions kept for Transitions().
+const MaxTransitionHistory = 32
+
+// TransitionStats describes a transition for diagnostic purposes.
+type TransitionStats struct {
+	Start        time.Time     `json:"start"`
+	Duration     time.Duration `json:"duration"`
+	DistData     int           `json:"dist_data"`    // DistDatums considered
+	Relinquished int           `json:"relinquished"` // by this node
+	Waited       int           `json:"waited"`       // relinquish messages waited on
+	Acquired     int           `json:"acquired"`     // by this node, relinquished or from nodes gone
+	Unconfirmed  int           `json:"unconfirmed"`  // acquired after timing out waiting on relinquish
+	Failed       int           `json:"failed"`       // Relinquish() or Acquire() errors
+	TimedOut     bool          `json:"timed_out"`    // waiting on relinquish messages
+	Err          string        `json:"error,omitempty"`
+}
+
+func (c *Cluster) addTransitionStats(st TransitionStats) {
+	c.histLock.Lock()
+	defer c.histLock.Unlock()
+	c.history = append(c.history, st)
+	if len(c.history) > MaxTransitionHistory {
+		c.history = c.history[len(c.history)-MaxTransitionHistory:]
+	}
+}
+
+// Transitions returns the most recent transitions, oldest first.
+func (c *Cluster) Transitions() []TransitionStats {
+	c.histLock.Lock()
+	defer c.histLock.Unlock()
+	return append([]TransitionStats(nil), c.history...)
+}
+
+// Transitioning returns true while a Transition() is in progress.
+func (c *Cluster) Transitioning() bool {
+	return atomic.LoadInt32(&c.inTrans) == 1
+}
+
+// ForceTransition triggers a transition as if the cluster changed,
+// see NotifyClusterChanges().
+func (c *Cluster) ForceTransition() {
+	c.notifyAll()
+}
+
+// Assignment is a DistDatum and the nodes it is assigned to, lead
+// first.
+type Assignment struct {
+	Key   string   `json:"key"` // type:id
+	Name  string   `json:"name"`
+	Nodes []string `json:"nodes"`
+}
+
+// Assignments returns the DistDatums known to this node and their
+// nodes, sorted by key.
+func (c *Cluster) Assignments() []Assignment {
+	c.RLock()
+	defer c.RUnlock()
+	result := make([]Assignment, 0, len(c.dds))
+	for key, dde := range c.dds {
+		a := Assignment{Key: key, Name: dde.dd.GetName(), Nodes: make([]string, len(dde.nodes))}
+		for i, node := range dde.nodes {
+			a.Nodes[i] = node.Name()
+		}
+		result = append(result, a)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
 // Transition() provides the transition on cluster
 // changes. Transitions should be triggered by user-land after
 // receiving a cluster change event from a channel returned by
@@ -664,26 +886,44 @@ func (dde *ddEntry) DD() DistDatum {
 // confirmation of Relinquish() from other nodes for DistDatums
 // transferring to this node. Generally a node should be buffering all
 // the data it receives during a transition.
-func (c *Cluster) Transition(timeout time.Duration) error {
+func (c *Cluster) Transition(timeout time.Duration) (err error) {
+	st := TransitionStats{Start: time.Now()}
+	atomic.StoreInt32(&c.inTrans, 1)
 	defer func() {
+		atomic.StoreInt32(&c.inTrans, 0)
 		if e := recover(); e != nil {
-			log.Printf("WARNING: Transition panic!")
+			logger.Warnf("Transition panic!")
+			st.Err = fmt.Sprintf("panic: %v", e)
 		}
-		log.Printf("Transition(): Complete!")
+		if err != nil {
+			st.Err = err.Error()
+		}
+		st.Duration = time.Now().Sub(st.Start)
+		c.addTransitionStats(st)
+		logger.Infof("Transition(): Complete!")
 	}()
 	var wg sync.WaitGroup
 
 	c.Lock()
 	defer c.Unlock()
-	log.Printf("Transition(): Starting...")
+	logger.Infof("Transition(): Starting...")
+	st.DistData = len(c.dds)
 
 	readyNodes, err := c.readyNodes()
 	if err != nil {
 		return err
 	}
 
+	// A node that is no longer a member is gone without (or after)
+	// relinquishing, there is no point waiting on it.
+	members := make(map[string]bool)
+	for _, node := range c.Members() {
+		members[node.Name()] = true
+	}
+
 	var waitDdsLock sync.RWMutex
 	waitDds := make(map[string]DistDatum)
+	goneDds := make([]DistDatum, 0)
 	relCnt := 0
 
 	for _, dde := range c.dds {
@@ -691,13 +931,13 @@ func (c *Cluster) Transition(timeout time.Duration) error {
 		go func(dde *ddEntry) {
 			defer wg.Done()
 
-			//log.Printf("Transition(): processing %s", dde.dd.GetName())
+			//logger.Infof("Transition(): processing %s", dde.dd.GetName())
 
 			// The idea is that the first node in the list is the
 			// "lead" responsible for saving the data. What happens
 			// with the rest is up to the userland to deal with.
 			var newNode, oldNode *Node
-			newNodes := selectNodes(readyNodes, dde.dd.Id(), c.copies)
+			newNodes := c.placeNodes(readyNodes, dde.dd.Id(), c.copies)
 			if len(newNodes) > 0 {
 				newNode = newNodes[0]
 			}
@@ -707,37 +947,45 @@ func (c *Cluster) Transition(timeout time.Duration) error {
 			if newNode == nil || oldNode.Name() != newNode.Name() {
 				ln := c.LocalNode()
 				if ln.Name() == oldNode.Name() { // we are the ex-node
-					if newNode != nil && debug {
-						log.Printf("Transition(): Id %s:%d (%s) is moving away to node %s", dde.dd.Type(), dde.dd.Id(), dde.dd.GetName(), newNode.Name())
+					if newNode != nil && logger.Enabled(logging.Debug) {
+						logger.Debugf("Transition(): Id %s:%d (%s) is moving away to node %s", dde.dd.Type(), dde.dd.Id(), dde.dd.GetName(), newNode.Name())
 					}
-					if debug {
-						log.Printf("Transition(): Calling Relinquish for %s:%d (%s).", dde.dd.Type(), dde.dd.Id(), dde.dd.GetName())
+					if logger.Enabled(logging.Debug) {
+						logger.Debugf("Transition(): Calling Relinquish for %s:%d (%s).", dde.dd.Type(), dde.dd.Id(), dde.dd.GetName())
 					}
-					if err = dde.dd.Relinquish(); err != nil {
-						log.Printf("Transition(): Warning: Relinquish() failed for id %s:%d (%s) with: %v", dde.dd.Type(), dde.dd.Id(), dde.dd.GetName(), err)
+					rerr := dde.dd.Relinquish()
+					if rerr != nil {
+						logger.Warnf("Transition(): Relinquish() failed for id %s:%d (%s) with: %v", dde.dd.Type(), dde.dd.Id(), dde.dd.GetName(), rerr)
 					} else if newNode != nil {
 						// Notify the new node expecting this dd of Relinquish completion
 						body := []byte(fmt.Sprintf("%s:%d", dde.dd.Type(), dde.dd.Id()))
 						m := &Msg{Dst: newNode, Body: body}
-						log.Printf("Transition(): Sending relinquish of id %s:%d to node %s", dde.dd.Type(), dde.dd.Id(), newNode.Name())
+						logger.Infof("Transition(): Sending relinquish of id %s:%d to node %s", dde.dd.Type(), dde.dd.Id(), newNode.Name())
 						c.snd <- m
 					}
 
 					waitDdsLock.Lock()
 					relCnt++
+					if rerr != nil {
+						st.Failed++
+					}
 					if relCnt%1000 == 0 {
-						log.Printf("Transition(): %d of %d relinquish processed.", relCnt, len(c.dds))
+						logger.Infof("Transition(): %d of %d relinquish processed.", relCnt, len(c.dds))
 					}
 					waitDdsLock.Unlock()
 
 				} else if oldNode != nil && newNode != nil && ln.Name() == newNode.Name() { // we are the new node
-					if debug {
-						log.Printf("Transition(): Id %s:%d (%s) is moving to this node from node %s", dde.dd.Type(), dde.dd.Id(), dde.dd.GetName(), oldNode.Name())
+					if logger.Enabled(logging.Debug) {
+						logger.Debugf("Transition(): Id %s:%d (%s) is moving to this node from node %s", dde.dd.Type(), dde.dd.Id(), dde.dd.GetName(), oldNode.Name())
 					}
 					// Add to the list of dds to wait on, but only if there existed nodes
 					waitDdsLock.Lock()
 					if oldNode.Name() != "<nil>" {
-						waitDds[fmt.Sprintf("%s:%d", dde.dd.Type(), dde.dd.Id())] = dde.dd
+						if members[oldNode.Name()] {
+							waitDds[fmt.Sprintf("%s:%d", dde.dd.Type(), dde.dd.Id())] = dde.dd
+						} else {
+							goneDds = append(goneDds, dde.dd)
+						}
 					}
 					waitDdsLock.Unlock()
 				}
@@ -749,12 +997,36 @@ func (c *Cluster) Transition(timeout time.Duration) error {
 	// Wait for this phase to finish
 	wg.Wait()
 
+	st.Relinquished, st.Waited = relCnt, len(waitDds)
+
+	// acquire calls Acquire() and counts the result
+	acquire := func(dd DistDatum, confirmed bool) {
+		if err := dd.Acquire(); err != nil {
+			logger.Warnf("Transition(): Acquire() failed for id %s:%d (%s) with: %v", dd.Type(), dd.Id(), dd.GetName(), err)
+			st.Failed++
+		} else if confirmed {
+			st.Acquired++
+		} else {
+			st.Unconfirmed++
+		}
+	}
+
+	// Nobody will relinquish these, take them over right away. If
+	// there were copies on this node, this is where they become the
+	// lead ones.
+	if len(goneDds) > 0 {
+		logger.Infof("Transition(): Acquiring %d DistDatums from nodes no longer in the cluster.", len(goneDds))
+	}
+	for _, dd := range goneDds {
+		acquire(dd, true)
+	}
+
 	// Now wait on the reqinquishes
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		log.Printf("Transition(): Waiting on %d relinquish messages... (timeout %v) %v", len(waitDds), timeout, waitDds)
+		logger.Infof("Transition(): Waiting on %d relinquish messages... (timeout %v) %v", len(waitDds), timeout, waitDds)
 
 		tmout := make(chan bool, 1)
 		go func() {
@@ -771,31 +1043,28 @@ func (c *Cluster) Transition(timeout time.Duration) error {
 			select {
 			case m = <-c.rcv:
 			case <-tmout:
-				log.Printf("Transition(): WARNING: Relinquish wait timeout! Continuing. Some data is likely lost.")
+				logger.Warnf("Transition(): Relinquish wait timeout! Continuing. Some data is likely lost.")
+				st.TimedOut = true
 				// We should still call Acquire on the ones we've been waiting for as we are ultimately taking them over
 				for _, dd := range waitDds {
-					log.Printf("Transition(): Calling Acquire for %s:%d (%s).", dd.Type(), dd.Id(), dd.GetName())
-					if err := dd.Acquire(); err != nil {
-						log.Printf("Transition(): Warning: Acquire() failed for id %s:%d (%s) with: %v", dd.Type(), dd.Id(), dd.GetName(), err)
-					}
+					logger.Infof("Transition(): Calling Acquire for %s:%d (%s).", dd.Type(), dd.Id(), dd.GetName())
+					acquire(dd, false)
 				}
 				return
 			}
 
 			key := string(m.Body)
-			log.Printf("Transition(): Got relinquish message for %s from %s.", key, m.Src.Name())
+			logger.Infof("Transition(): Got relinquish message for %s from %s.", key, m.Src.Name())
 			if waitDds[key] != nil {
 				dd := waitDds[key]
-				log.Printf("Transition(): Calling Acquire for %s:%d (%s).", dd.Type(), dd.Id(), dd.GetName())
-				if err := dd.Acquire(); err != nil {
-					log.Printf("Transition(): Warning: Acquire() failed for id %s:%d (%s) with: %v", dd.Type(), dd.Id(), dd.GetName(), err)
-				}
+				logger.Infof("Transition(): Calling Acquire for %s:%d (%s).", dd.Type(), dd.Id(), dd.GetName())
+				acquire(dd, true)
 			}
 			waitDdsLock.Lock()
 			delete(waitDds, key)
 			waitDdsLock.Unlock()
 			if len(waitDds) > 0 {
-				log.Printf("Transition(): Still waiting on %d relinquish messages: %v", len(waitDds), waitDds)
+				logger.Infof("Transition(): Still waiting on %d relinquish messages: %v", len(waitDds), waitDds)
 			}
 		}
 
@@ -0,0 +1,214 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Discoverer provides addresses ("host" or "host:port") of
+// existing cluster members, suitable for Join().
+type Discoverer interface {
+	Discover() ([]string, error)
+	String() string
+}
+
+// StaticDiscovery is a fixed list of addresses.
+type StaticDiscovery []string
+
+func (s StaticDiscovery) Discover() ([]string, error) {
+	return []string(s), nil
+}
+
+func (s StaticDiscovery) String() string {
+	return fmt.Sprintf("static %v", []string(s))
+}
+
+// DNSDiscovery looks up members in DNS. If Name begins with an
+// underscore (e.g. "_tgres._tcp.example.com") it is an SRV record
+// and the port comes from the record, otherwise it is resolved to A
+// (or AAAA) records and Port, if not 0, is appended to every
+// address.
+type DNSDiscovery struct {
+	Name string
+	Port int
+}
+
+// Can be replaced for testing.
+var (
+	lookupSRV  = net.LookupSRV
+	lookupHost = net.LookupHost
+)
+
+func (d *DNSDiscovery) Discover() ([]string, error) {
+	var result []string
+	if strings.HasPrefix(d.Name, "_") {
+		_, srvs, err := lookupSRV("", "", d.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range srvs {
+			result = append(result, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))))
+		}
+		return result, nil
+	}
+
+	addrs, err := lookupHost(d.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if d.Port != 0 {
+			addr = net.JoinHostPort(addr, strconv.Itoa(d.Port))
+		}
+		result = append(result, addr)
+	}
+	return result, nil
+}
+
+func (d *DNSDiscovery) String() string {
+	return fmt.Sprintf("dns %q", d.Name)
+}
+
+// FileDiscovery reads addresses from a file, one per line. Blank
+// lines and lines beginning with # are ignored. See also
+// WatchDiscovery().
+type FileDiscovery struct {
+	Path string
+}
+
+func (f *FileDiscovery) Discover() ([]string, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var result []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		result = append(result, line)
+	}
+	return result, scanner.Err()
+}
+
+func (f *FileDiscovery) String() string {
+	return fmt.Sprintf("file %q", f.Path)
+}
+
+// MultiDiscovery combines the addresses of several Discoverers. It
+// only fails if all of them fail, individual failures are logged.
+type MultiDiscovery []Discoverer
+
+func (m MultiDiscovery) Discover() ([]string, error) {
+	var (
+		result []string
+		err    error
+		seen   = make(map[string]bool)
+	)
+	for _, d := range m {
+		addrs, e := d.Discover()
+		if e != nil {
+			logger.Errorf("MultiDiscovery: %v failed: %v", d, e)
+			err = e
+			continue
+		}
+		for _, addr := range addrs {
+			if !seen[addr] {
+				seen[addr] = true
+				result = append(result, addr)
+			}
+		}
+	}
+	if len(result) == 0 && err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (m MultiDiscovery) String() string {
+	var s []string
+	for _, d := range m {
+		s = append(s, d.String())
+	}
+	return strings.Join(s, ", ")
+}
+
+// WatchDiscovery periodically runs the Discoverer and joins any
+// addresses it has not returned before, e.g. when a FileDiscovery
+// file is edited or DNS records are added. It returns immediately,
+// the watching runs until stop is closed. An interval of 0 disables
+// watching.
+func (c *Cluster) WatchDiscovery(d Discoverer, interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+	known := make(map[string]bool)
+	if addrs, err := d.Discover(); err == nil {
+		for _, addr := range addrs {
+			known[addr] = true
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			addrs, err := d.Discover()
+			if err != nil {
+				logger.Errorf("WatchDiscovery: %v failed: %v", d, err)
+				continue
+			}
+			if added := newAddrs(known, addrs); len(added) > 0 {
+				logger.Infof("WatchDiscovery: %v has new addresses, joining: %v", d, added)
+				if err := c.Join(added); err != nil {
+					logger.Errorf("WatchDiscovery: join failed (will retry): %v", err)
+					continue
+				}
+				for _, addr := range added {
+					known[addr] = true
+				}
+			}
+		}
+	}()
+}
+
+// newAddrs returns the addresses not in known, sorted.
+func newAddrs(known map[string]bool, addrs []string) []string {
+	var added []string
+	for _, addr := range addrs {
+		if !known[addr] {
+			added = append(added, addr)
+		}
+	}
+	sort.Strings(added)
+	return added
+}
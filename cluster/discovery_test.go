@@ -0,0 +1,98 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_FileDiscovery(t *testing.T) {
+	f, err := ioutil.TempFile("", "tgres-peers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprintf(f, "# peers\n10.0.0.1\n\n  10.0.0.2:7946 \n")
+	f.Close()
+
+	addrs, err := (&FileDiscovery{Path: f.Name()}).Discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{"10.0.0.1", "10.0.0.2:7946"}; !reflect.DeepEqual(addrs, exp) {
+		t.Errorf("FileDiscovery: expected %v, got %v", exp, addrs)
+	}
+
+	if _, err := (&FileDiscovery{Path: f.Name() + ".missing"}).Discover(); err == nil {
+		t.Errorf("FileDiscovery: missing file should be an error")
+	}
+}
+
+func Test_DNSDiscovery(t *testing.T) {
+	saveSRV, saveHost := lookupSRV, lookupHost
+	defer func() { lookupSRV, lookupHost = saveSRV, saveHost }()
+
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		return name, []*net.SRV{{Target: "a.example.com.", Port: 7946}, {Target: "b.example.com.", Port: 7947}}, nil
+	}
+	lookupHost = func(host string) ([]string, error) {
+		return []string{"10.0.0.1", "10.0.0.2"}, nil
+	}
+
+	addrs, _ := (&DNSDiscovery{Name: "_tgres._tcp.example.com"}).Discover()
+	if exp := []string{"a.example.com:7946", "b.example.com:7947"}; !reflect.DeepEqual(addrs, exp) {
+		t.Errorf("DNSDiscovery SRV: expected %v, got %v", exp, addrs)
+	}
+	addrs, _ = (&DNSDiscovery{Name: "tgres.example.com"}).Discover()
+	if exp := []string{"10.0.0.1", "10.0.0.2"}; !reflect.DeepEqual(addrs, exp) {
+		t.Errorf("DNSDiscovery A: expected %v, got %v", exp, addrs)
+	}
+	addrs, _ = (&DNSDiscovery{Name: "tgres.example.com", Port: 7946}).Discover()
+	if exp := []string{"10.0.0.1:7946", "10.0.0.2:7946"}; !reflect.DeepEqual(addrs, exp) {
+		t.Errorf("DNSDiscovery A with port: expected %v, got %v", exp, addrs)
+	}
+}
+
+func Test_MultiDiscovery(t *testing.T) {
+	md := MultiDiscovery{
+		StaticDiscovery{"10.0.0.1", "10.0.0.2"},
+		&FileDiscovery{Path: "/nonexistent/tgres-peers"},
+		StaticDiscovery{"10.0.0.2", "10.0.0.3"},
+	}
+	addrs, err := md.Discover()
+	if err != nil {
+		t.Errorf("MultiDiscovery: one failure should not be an error: %v", err)
+	}
+	if exp := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}; !reflect.DeepEqual(addrs, exp) {
+		t.Errorf("MultiDiscovery: expected %v, got %v", exp, addrs)
+	}
+
+	if _, err := (MultiDiscovery{&FileDiscovery{Path: "/nonexistent/tgres-peers"}}).Discover(); err == nil {
+		t.Errorf("MultiDiscovery: all failing should be an error")
+	}
+}
+
+func Test_newAddrs(t *testing.T) {
+	known := map[string]bool{"b": true}
+	if added := newAddrs(known, []string{"c", "b", "a"}); !reflect.DeepEqual(added, []string{"a", "c"}) {
+		t.Errorf("newAddrs: expected [a c], got %v", added)
+	}
+}
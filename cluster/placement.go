@@ -0,0 +1,174 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+)
+
+// Placement is the scheme by which DistDatums are assigned to nodes.
+// All nodes of a cluster must use the same one.
+type Placement int
+
+const (
+	// ModuloPlacement assigns a DistDatum to the node at position id
+	// % number of nodes in the sorted node list. It is the default,
+	// but any change in membership moves nearly all DistDatums.
+	ModuloPlacement Placement = iota
+
+	// ConsistentPlacement uses a consistent hash ring with
+	// VirtualNodes points per node, a change in membership moves
+	// only about 1/N of DistDatums.
+	ConsistentPlacement
+)
+
+// Number of points on the hash ring per node for ConsistentPlacement.
+const VirtualNodes = 128
+
+func (p Placement) String() string {
+	switch p {
+	case ModuloPlacement:
+		return "modulo"
+	case ConsistentPlacement:
+		return "consistent"
+	}
+	return fmt.Sprintf("Placement(%d)", int(p))
+}
+
+// ParsePlacement returns the Placement by its name as returned by
+// String().
+func ParsePlacement(s string) (Placement, error) {
+	switch strings.ToLower(s) {
+	case "", "modulo":
+		return ModuloPlacement, nil
+	case "consistent":
+		return ConsistentPlacement, nil
+	}
+	return ModuloPlacement, fmt.Errorf("Unknown placement: %q (valid: modulo, consistent)", s)
+}
+
+// Placement sets the placement scheme, the default is
+// ModuloPlacement. Like Copies(), it can only be set while the
+// cluster is empty.
+func (c *Cluster) Placement(p ...Placement) Placement {
+	if len(p) > 0 && len(c.dds) == 0 {
+		c.placement = p[0]
+	}
+	return c.placement
+}
+
+// placeNodes returns n nodes for id according to the placement.
+func (c *Cluster) placeNodes(nodes []*Node, id int64, n int) []*Node {
+	if c.placement != ConsistentPlacement {
+		return selectNodes(nodes, id, n)
+	}
+	return c.hashRing(nodes).get(id, n)
+}
+
+// hashRing returns the ring for nodes, the last one is cached since
+// the nodes rarely change.
+func (c *Cluster) hashRing(nodes []*Node) *hashRing {
+	c.ringLock.Lock()
+	defer c.ringLock.Unlock()
+	if c.ring == nil || !c.ring.builtFor(nodes) {
+		c.ring = newHashRing(nodes, VirtualNodes)
+	}
+	return c.ring
+}
+
+type hashRing struct {
+	members []*Node // what it was built for
+	points  []uint64
+	nodes   map[uint64]*Node
+}
+
+func newHashRing(nodes []*Node, vnodes int) *hashRing {
+	r := &hashRing{
+		members: append([]*Node(nil), nodes...),
+		points:  make([]uint64, 0, len(nodes)*vnodes),
+		nodes:   make(map[uint64]*Node, len(nodes)*vnodes),
+	}
+	for _, node := range nodes {
+		for i := 0; i < vnodes; i++ {
+			h := fnv.New64a()
+			fmt.Fprintf(h, "%s#%d", node.Name(), i)
+			p := mix64(h.Sum64())
+			if _, ok := r.nodes[p]; ok {
+				continue // a collision is astronomically unlikely
+			}
+			r.points = append(r.points, p)
+			r.nodes[p] = node
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+func (r *hashRing) builtFor(nodes []*Node) bool {
+	if len(nodes) != len(r.members) {
+		return false
+	}
+	for i, node := range nodes {
+		if node != r.members[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// get returns n distinct nodes (or fewer if there aren't as many)
+// starting at the point of id and going clockwise.
+func (r *hashRing) get(id int64, n int) []*Node {
+	if len(r.points) == 0 {
+		return nil
+	}
+	if n > len(r.members) {
+		n = len(r.members)
+	}
+
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(id))
+	h := fnv.New64a()
+	h.Write(b[:])
+	hid := mix64(h.Sum64())
+
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hid })
+	result := make([]*Node, 0, n)
+	seen := make(map[*Node]bool, n)
+	for j := 0; len(result) < n && j < len(r.points); j++ {
+		node := r.nodes[r.points[(i+j)%len(r.points)]]
+		if !seen[node] {
+			seen[node] = true
+			result = append(result, node)
+		}
+	}
+	return result
+}
+
+// mix64 is the splitmix64 finalizer, FNV alone does not spread
+// similar inputs (such as sequential ids) well enough.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
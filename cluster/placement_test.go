@@ -0,0 +1,110 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/memberlist"
+)
+
+func testNodes(n int) []*Node {
+	var nodes []*Node
+	for i := 0; i < n; i++ {
+		nodes = append(nodes, &Node{Node: &memberlist.Node{Name: fmt.Sprintf("node%d", i)}})
+	}
+	return nodes
+}
+
+func Test_ParsePlacement(t *testing.T) {
+	for s, exp := range map[string]Placement{"": ModuloPlacement, "modulo": ModuloPlacement, "Consistent": ConsistentPlacement} {
+		p, err := ParsePlacement(s)
+		if err != nil || p != exp {
+			t.Errorf("ParsePlacement(%q) = %v, %v, expected %v", s, p, err, exp)
+		}
+	}
+	if _, err := ParsePlacement("bogus"); err == nil {
+		t.Errorf("ParsePlacement: expected an error for bogus")
+	}
+}
+
+func Test_hashRing_get(t *testing.T) {
+	nodes := testNodes(4)
+	r := newHashRing(nodes, VirtualNodes)
+
+	got := r.get(7, 3)
+	if len(got) != 3 {
+		t.Fatalf("get: expected 3 nodes, got %d", len(got))
+	}
+	if got[0] == got[1] || got[1] == got[2] || got[0] == got[2] {
+		t.Errorf("get: nodes are not distinct: %v", got)
+	}
+	if len(r.get(7, 10)) != 4 {
+		t.Errorf("get: n should be capped at number of nodes")
+	}
+	if r2 := newHashRing(nodes, VirtualNodes); r2.get(7, 1)[0] != got[0] {
+		t.Errorf("get: placement is not deterministic")
+	}
+	if newHashRing(nil, VirtualNodes).get(7, 1) != nil {
+		t.Errorf("get: empty ring should return nil")
+	}
+}
+
+func Test_hashRing_minimalMovement(t *testing.T) {
+	nodes := testNodes(5)
+	r4 := newHashRing(nodes[:4], VirtualNodes)
+	r5 := newHashRing(nodes, VirtualNodes)
+
+	const n = 10000
+	moved, counts := 0, make(map[*Node]int)
+	for id := int64(1); id <= n; id++ {
+		before, after := r4.get(id, 1)[0], r5.get(id, 1)[0]
+		counts[before]++
+		if before != after {
+			moved++
+			if after != nodes[4] {
+				t.Errorf("id %d moved to %s rather than the new node", id, after.Name())
+			}
+		}
+	}
+	// Ideally 1/5 moves, allow some slack
+	if moved < n/10 || moved > n*3/10 {
+		t.Errorf("expected about %d ids to move, %d did", n/5, moved)
+	}
+	for node, c := range counts {
+		if c < n/8 || c > n*3/8 { // ideally n/4
+			t.Errorf("uneven distribution: %s has %d of %d", node.Name(), c, n)
+		}
+	}
+}
+
+func Test_Cluster_Placement(t *testing.T) {
+	c := &Cluster{dds: make(map[string]*ddEntry)}
+	if c.Placement() != ModuloPlacement {
+		t.Errorf("default placement should be modulo")
+	}
+	nodes := testNodes(3)
+	c.Placement(ConsistentPlacement)
+	if got := c.placeNodes(nodes, 42, 2); len(got) != 2 || got[0] != newHashRing(nodes, VirtualNodes).get(42, 1)[0] {
+		t.Errorf("placeNodes: does not match the hash ring: %v", got)
+	}
+	c.dds["x"] = &ddEntry{}
+	c.Placement(ModuloPlacement)
+	if c.Placement() != ConsistentPlacement {
+		t.Errorf("placement should not change on a non-empty cluster")
+	}
+}
@@ -0,0 +1,162 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Backfill sends historical data points to the /backfill endpoint of
+// a running Tgres.
+//
+// Input is CSV (name,time,value) or JSON lines
+// ({"name": ..., "time": ..., "value": ...}), read from the files
+// given as arguments or stdin. Time is seconds since the epoch or
+// RFC3339. Since all points of a series must be sent in the same
+// request, the entire input is read and grouped by series first,
+// then sent in batches of whole series of approximately -batch
+// points.
+//
+//	./backfill -url http://tgres:8888/backfill old-system.csv
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	h "github.com/tgres/tgres/http"
+	"github.com/tgres/tgres/receiver"
+)
+
+func main() {
+	url := flag.String("url", "http://localhost:8888/backfill", "Tgres backfill URL")
+	batch := flag.Int("batch", 100000, "approximate number of points per request")
+	flag.Parse()
+
+	byName, order, err := readInput(flag.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	var (
+		total receiver.BackfillResult
+		body  bytes.Buffer
+		n     int
+	)
+	send := func() {
+		if n == 0 {
+			return
+		}
+		res, err := post(*url, &body)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		total.Series += res.Series
+		total.Stored += res.Stored
+		total.Queued += res.Queued
+		total.Slots += res.Slots
+		for _, e := range res.Errors {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", e)
+		}
+		total.Errors = append(total.Errors, res.Errors...)
+		fmt.Printf("Sent %d points, %d series so far.\n", n, total.Series)
+		body.Reset()
+		n = 0
+	}
+
+	for _, name := range order {
+		for _, line := range byName[name] {
+			body.WriteString(line)
+			body.WriteByte('\n')
+		}
+		n += len(byName[name])
+		if n >= *batch {
+			send()
+		}
+	}
+	send()
+
+	fmt.Printf("DONE: %d series, %d points backfilled (%d RRA slots), %d points newer than last update queued, %d errors.\n",
+		total.Series, total.Stored, total.Slots, total.Queued, len(total.Errors))
+	if len(total.Errors) > 0 {
+		os.Exit(1)
+	}
+}
+
+// readInput returns the input lines grouped by series name and the
+// names in the order first seen.
+func readInput(paths []string) (map[string][]string, []string, error) {
+	byName := make(map[string][]string)
+	var order []string
+
+	read := func(r io.Reader, path string) error {
+		scanner := bufio.NewScanner(r)
+		lineNo := 0
+		for scanner.Scan() {
+			lineNo++
+			name, _, err := h.ParseBackfillLine(scanner.Text())
+			if err != nil {
+				return fmt.Errorf("%s:%d: %v", path, lineNo, err)
+			}
+			if name == "" {
+				continue
+			}
+			if _, ok := byName[name]; !ok {
+				order = append(order, name)
+			}
+			byName[name] = append(byName[name], scanner.Text())
+		}
+		return scanner.Err()
+	}
+
+	if len(paths) == 0 {
+		return byName, order, read(os.Stdin, "stdin")
+	}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = read(f, path)
+		f.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return byName, order, nil
+}
+
+func post(url string, body io.Reader) (*receiver.BackfillResult, error) {
+	resp, err := http.Post(url, "text/plain", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		receiver.BackfillResult
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("%s: %s (%v)", url, resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK && len(res.Errors) == 0 {
+		return nil, fmt.Errorf("%s: %s %s", url, resp.Status, res.Error)
+	}
+	return &res.BackfillResult, nil
+}
@@ -69,28 +69,44 @@ type Config struct {
 	workers     int
 	width       int
 	sdb         *statusDb
+	relayListen string
+	relayCarbon string
+	relayTgres  string
+	relayQueue  int
 }
 
 func main() {
 
 	var cfg Config
 
-	flag.StringVar(&cfg.whisperDir, "whisper-dir", "/opt/graphite/storage/whisper/", "location where all whisper files are stored")
+	flag.StringVar(&cfg.whisperDir, "whisper-dir", "/opt/graphite/storage/whisper/", "location where all whisper (or RRDTool) files are stored")
 	flag.StringVar(&cfg.root, "root", "", "location of files to be imported, should be subdirectory of whisperDir, defaults to whisperDir")
 	flag.StringVar(&cfg.dbConnect, "dbconnect", "host=/var/run/postgresql dbname=tgres sslmode=disable", "db connect string")
 	flag.StringVar(&cfg.namePrefix, "prefix", "", "series name prefix (no trailing dot)")
 	flag.IntVar(&cfg.staleDays, "stale-days", 0, "Max days since last update before we ignore this DS (0 = process all)")
-	flag.StringVar(&cfg.specStr, "spec", "", "Spec (config file format, comma-separated) to use for new DSs (Blank = infer from whisper file)")
+	flag.StringVar(&cfg.specStr, "spec", "", "Spec (config file format, comma-separated) to use for new DSs (Blank = infer from whisper or RRDTool file)")
 	flag.IntVar(&cfg.rraSpecStep, "step", 10, "Step to be used with spec parameter (seconds)")
-	flag.StringVar(&cfg.mode, "mode", "", "Must be create or populate")
+	flag.StringVar(&cfg.mode, "mode", "", "Must be create, populate, sync or relay")
 	flag.IntVar(&cfg.heartbeat, "hb", 1800, "Heartbeat (seconds)")
 	flag.IntVar(&cfg.workers, "workers", 4, "Number of concurrent db workers")
 	flag.IntVar(&cfg.width, "width", serde.PgSegmentWidth, "Segment width (experimental/advanced)")
+	flag.StringVar(&rrdtoolCmd, "rrdtool", rrdtoolCmd, "rrdtool binary used to dump .rrd files")
+	flag.StringVar(&cfg.relayListen, "listen", ":2003", "Relay mode: address to accept Graphite plaintext on")
+	flag.StringVar(&cfg.relayCarbon, "carbon", "", "Relay mode: carbon address (host:port)")
+	flag.StringVar(&cfg.relayTgres, "tgres", "", "Relay mode: Tgres Graphite address (host:port)")
+	flag.IntVar(&cfg.relayQueue, "relay-queue", 100000, "Relay mode: lines queued per destination before dropping")
 
 	flag.Parse()
 
-	if cfg.mode != "create" && cfg.mode != "populate" {
-		fmt.Printf("Please specify -mode create or populate\n")
+	switch cfg.mode {
+	case "create", "populate", "sync":
+	case "relay":
+		if err := relay(&cfg); err != nil {
+			fmt.Printf("Relay error: %v\n", err)
+		}
+		return
+	default:
+		fmt.Printf("Please specify -mode create, populate, sync or relay\n")
 		return
 	}
 
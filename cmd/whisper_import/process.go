@@ -70,13 +70,12 @@ func mapFilesToDSs(db serde.SerDe, cfg *Config) map[int64]map[string]int64 {
 	filepath.Walk(
 		cfg.root,
 		func(path string, info os.FileInfo, err error) error {
-			if !strings.HasSuffix(path, ".wsp") {
+			if !strings.HasSuffix(path, ".wsp") && !isRRDPath(path) {
 				return nil
 			}
 
 			count++
 
-			name := nameFromPath(path, cfg.whisperDir, cfg.namePrefix)
 			if _, err := os.Stat(path); err != nil {
 				return nil // file does not exist
 			}
@@ -85,26 +84,35 @@ func mapFilesToDSs(db serde.SerDe, cfg *Config) map[int64]map[string]int64 {
 				fmt.Printf("Checked %d files, currently on: %v\n", count, path)
 			}
 
-			ds := byName[name]
+			keys, err := sourceKeys(path)
+			if err != nil {
+				fmt.Printf("Skipping %v due to error: %v\n", path, err)
+				return nil
+			}
 
-			if ds == nil {
-				// This means the series is not in our database, so we
-				// will assign it to the -1 segment, to be processed last.
-				if bySeg[-1] == nil {
-					bySeg[-1] = make(map[string]int64)
-				}
-				bySeg[-1][path] = 0
-			} else {
-				dbds := ds.(*serde.DbDataSource)
-				for _, rra := range dbds.RRAs() {
-					dbrra := rra.(*serde.DbRoundRobinArchive)
-					seg := dbrra.Seg()
-					id := dbds.Id()
-
-					if bySeg[seg] == nil {
-						bySeg[seg] = make(map[string]int64)
+			for _, key := range keys {
+				name := nameFromKey(key, cfg.whisperDir, cfg.namePrefix)
+				ds := byName[name]
+
+				if ds == nil {
+					// This means the series is not in our database, so we
+					// will assign it to the -1 segment, to be processed last.
+					if bySeg[-1] == nil {
+						bySeg[-1] = make(map[string]int64)
+					}
+					bySeg[-1][key] = 0
+				} else {
+					dbds := ds.(*serde.DbDataSource)
+					for _, rra := range dbds.RRAs() {
+						dbrra := rra.(*serde.DbRoundRobinArchive)
+						seg := dbrra.Seg()
+						id := dbds.Id()
+
+						if bySeg[seg] == nil {
+							bySeg[seg] = make(map[string]int64)
+						}
+						bySeg[seg][key] = id
 					}
-					bySeg[seg][path] = id
 				}
 			}
 
@@ -127,6 +135,27 @@ func processSegments(db serde.SerDe, ch chan *verticalCache, bySeg map[int64]map
 		return
 	}
 
+	if cfg.mode == "sync" {
+		// Every sync goes through everything, the status table is
+		// for populate only. Series not in the database are not
+		// synced, they need to be created and populated first.
+		fmt.Printf("Syncing points newer than last update by segments.\n")
+		n := 0
+		for seg, paths := range bySeg {
+			if seg == -1 {
+				continue
+			}
+			n++
+			fmt.Printf("Reading series for segment %d (%d of %d)...\n", seg, n, len(bySeg))
+			wg.Add(1)
+			processSegment(db, ch, seg, paths, cfg, &wg)
+		}
+		if len(bySeg[-1]) > 0 {
+			fmt.Printf("Warning: %d series not in the database were not synced, use create and populate mode for those.\n", len(bySeg[-1]))
+		}
+		return
+	}
+
 	if cfg.mode == "populate" {
 		fmt.Printf("Processing whisper files by segments.\n")
 
@@ -205,24 +234,64 @@ func processSegment(db serde.SerDe, ch chan *verticalCache, seg int64, paths map
 		ts:  seq,
 		dps: make(map[bundleKey]*verticalCacheSegment),
 		dss: make(map[int64]map[int64]interface{}),
+
+		dsValue:    make(map[int64]map[int64]interface{}),
+		dsDuration: make(map[int64]map[int64]interface{}),
 	}
 	seq++
 
-	stale := 0
-	for path, _ := range paths {
+	// Sorted, so that the DSs of an RRDTool file are together and
+	// the file only needs to be read once.
+	keys := make([]string, 0, len(paths))
+	for key, _ := range paths {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 
-		name := nameFromPath(path, cfg.whisperDir, cfg.namePrefix)
+	var (
+		dump     *rrdDump
+		dumpPath string
+	)
 
-		wsp, err := newWhisper(path)
-		if err != nil {
-			fmt.Printf("Skipping %v due to error: %v\n", path, err)
-			continue
+	stale := 0
+	for _, key := range keys {
+
+		name := nameFromKey(key, cfg.whisperDir, cfg.namePrefix)
+		path, dsName := splitSourceKey(key)
+
+		var src importSource
+		if isRRDPath(path) {
+			if dumpPath != path {
+				var err error
+				if dump, err = readRRD(path); err != nil {
+					fmt.Printf("Skipping %v due to error: %v\n", path, err)
+					dump = nil
+				}
+				dumpPath = path
+			}
+			if dump == nil {
+				continue
+			}
+			i := dump.dsIndex(dsName)
+			if i < 0 {
+				fmt.Printf("Skipping %v: no DS named %q in %v\n", name, dsName, path)
+				continue
+			}
+			src = &rrdSource{rrdDump: dump, i: i}
+		} else {
+			wsp, err := newWhisper(path)
+			if err != nil {
+				fmt.Printf("Skipping %v due to error: %v\n", path, err)
+				continue
+			}
+			src = wsp
 		}
 
 		if cfg.staleDays > 0 {
-			ts := findMostRecentTS(wsp)
+			ts := src.lastUpdate()
 			if time.Now().Sub(ts) > time.Duration(cfg.staleDays*24)*time.Hour {
 				stale++
+				src.Close()
 				continue
 			}
 		}
@@ -232,14 +301,14 @@ func processSegment(db serde.SerDe, ch chan *verticalCache, seg int64, paths map
 		if cfg.dsSpec != nil {
 			spec = cfg.dsSpec
 		} else {
-			spec = specFromHeader(wsp.header, cfg.heartbeat)
+			spec = src.spec(cfg.heartbeat)
 		}
 
 		// NB: If the DS exists, our spec is ignored
 		ds, err := db.Fetcher().FetchOrCreateDataSource(serde.Ident{"name": name}, spec)
 		if err != nil {
 			fmt.Printf("Database error: %v\n", err)
-			wsp.Close()
+			src.Close()
 			continue
 		}
 
@@ -248,7 +317,13 @@ func processSegment(db serde.SerDe, ch chan *verticalCache, seg int64, paths map
 		stats.Unlock()
 
 		if cfg.mode == "create" {
-			wsp.Close()
+			src.Close()
+			continue
+		}
+
+		if cfg.mode == "sync" {
+			syncDataSource(vcache, ds.(serde.DbDataSourcer), src)
+			src.Close()
 			continue
 		}
 
@@ -265,7 +340,9 @@ func processSegment(db serde.SerDe, ch chan *verticalCache, seg int64, paths map
 			dssp.RRAs[i].Xff = 0
 		}
 
-		newDs := rrd.NewDataSource(dssp)
+		newDs, withState := src.load(dssp)
+		src.Close()
+
 		rras := ds.RRAs()
 		for i, rra := range newDs.RRAs() {
 			latests = append(latests, rras[i].Latest())
@@ -277,21 +354,18 @@ func processSegment(db serde.SerDe, ch chan *verticalCache, seg int64, paths map
 		dbds.DataSourcer = newDs
 		dbds.SetRRAs(rras)
 
-		processAllPoints(ds, wsp)
-		wsp.Close()
-
 		for i, rra := range ds.RRAs() {
-			vcache.updateDps(rra.(serde.DbRoundRobinArchiver), latests[i])
+			vcache.updateDps(rra.(serde.DbRoundRobinArchiver), latests[i], withState)
 		}
 
 		// Only flush the DS if LastUpdate has advanced,
 		// otherwise leave as is.
 		if dbds.Created() || dbds.LastUpdate().After(oldDs.LastUpdate()) {
-			vcache.updateDss(dbds)
+			vcache.updateDss(dbds, withState)
 		}
 	}
 
-	if cfg.mode == "populate" {
+	if cfg.mode == "populate" || cfg.mode == "sync" {
 		fmt.Printf("+++ Sending vcache [%v] to flusher.\n", vcache.ts)
 		vcache.seg = seg
 		ch <- vcache
@@ -305,6 +379,29 @@ func processSegment(db serde.SerDe, ch chan *verticalCache, seg int64, paths map
 	stats.Unlock()
 }
 
+// syncDataSource updates the DS as it is in the database with the
+// points of src newer than its lastUpdate, i.e. exactly as if Tgres
+// had received them, the PDP state is carried over too. This only
+// makes sense if Tgres is not receiving data for this DS at the same
+// time.
+func syncDataSource(vcache *verticalCache, ds serde.DbDataSourcer, src importSource) {
+	lastUpdate := ds.LastUpdate()
+	latests := make([]time.Time, len(ds.RRAs()))
+	for i, rra := range ds.RRAs() {
+		latests[i] = rra.Latest()
+	}
+
+	processArchivePoints(ds, src.points())
+	if !ds.LastUpdate().After(lastUpdate) {
+		return // nothing new
+	}
+
+	for i, rra := range ds.RRAs() {
+		vcache.updateDps(rra.(serde.DbRoundRobinArchiver), latests[i], true)
+	}
+	vcache.updateDss(ds, true)
+}
+
 func vcacheFlusher(ch chan *verticalCache, db serde.Flusher, wg *sync.WaitGroup, cfg *Config) {
 	defer wg.Done()
 	for {
@@ -314,7 +411,9 @@ func vcacheFlusher(ch chan *verticalCache, db serde.Flusher, wg *sync.WaitGroup,
 			return
 		}
 		vcache.flush(db)
-		cfg.sdb.setSegmentFinished(vcache.seg)
+		if cfg.mode == "populate" {
+			cfg.sdb.setSegmentFinished(vcache.seg)
+		}
 	}
 }
 
@@ -325,7 +424,10 @@ func nameFromPath(path, whisperDir, prefix string) string {
 		withSlash += "/"
 	}
 
-	basename := strings.TrimSuffix(path[len(withSlash):], ".wsp")
+	basename := path[len(withSlash):]
+	for _, ext := range []string{".wsp", ".xml", ".rrd"} {
+		basename = strings.TrimSuffix(basename, ext)
+	}
 	name := strings.Replace(basename, "/", ".", -1)
 	if prefix != "" {
 		name = prefix + "." + name
@@ -333,6 +435,43 @@ func nameFromPath(path, whisperDir, prefix string) string {
 	return name
 }
 
+// A source key is the path of a file, or for RRDTool files with more
+// than one DS, path#dsname. The DS name is appended to the series
+// name in the latter case.
+func sourceKeys(path string) ([]string, error) {
+	if !isRRDPath(path) {
+		return []string{path}, nil
+	}
+	d, err := readRRDHeader(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(d.DSs) == 1 {
+		return []string{path}, nil
+	}
+	keys := make([]string, len(d.DSs))
+	for i, ds := range d.DSs {
+		keys[i] = path + "#" + ds.Name
+	}
+	return keys, nil
+}
+
+func splitSourceKey(key string) (path, dsName string) {
+	if i := strings.LastIndex(key, "#"); i >= 0 && isRRDPath(key[:i]) {
+		return key[:i], key[i+1:]
+	}
+	return key, ""
+}
+
+func nameFromKey(key, whisperDir, prefix string) string {
+	path, dsName := splitSourceKey(key)
+	name := nameFromPath(path, whisperDir, prefix)
+	if dsName != "" {
+		name += "." + dsName
+	}
+	return name
+}
+
 func findMostRecentTS(wsp *whisper) time.Time {
 	archs := wsp.header.archives
 
@@ -353,6 +492,13 @@ func findMostRecentTS(wsp *whisper) time.Time {
 }
 
 func processAllPoints(ds rrd.DataSourcer, wsp *whisper) {
+	processArchivePoints(ds, allPoints(wsp))
+}
+
+// allPoints returns the points of all archives, the finest
+// resolution taking precedence, coarser ones only fill in what is
+// before it.
+func allPoints(wsp *whisper) archive {
 
 	var allPoints archive
 	archs := wsp.header.archives
@@ -397,7 +543,7 @@ func processAllPoints(ds rrd.DataSourcer, wsp *whisper) {
 		}
 	}
 
-	processArchivePoints(ds, allPoints)
+	return allPoints
 }
 
 func processArchivePoints(ds rrd.DataSourcer, points archive) {
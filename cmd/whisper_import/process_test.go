@@ -0,0 +1,91 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tgres/tgres/rrd"
+	"github.com/tgres/tgres/serde"
+)
+
+// A testSource only provides points(), which is all sync needs.
+type testSource struct {
+	importSource
+	pts archive
+}
+
+func (s *testSource) points() archive { return s.pts }
+
+func Test_syncDataSource(t *testing.T) {
+	step := 10 * time.Second
+
+	// As in the database: updated at 1015, 5s into the PDP ending
+	// at 1020 with a value of 4.
+	rds := rrd.NewDataSource(rrd.DSSpec{
+		Step:       step,
+		Heartbeat:  time.Hour,
+		LastUpdate: time.Unix(1015, 0),
+		Value:      4,
+		Duration:   5 * time.Second,
+		RRAs:       []rrd.RRASpec{{Function: rrd.WMEAN, Step: step, Span: 10 * step, Latest: time.Unix(1010, 0)}},
+	})
+	rras := rds.RRAs()
+	rds.SetRRAs([]rrd.RoundRobinArchiver{&serde.DbRoundRobinArchive{RoundRobinArchiver: rras[0]}})
+	ds := serde.NewDbDataSource(1, serde.Ident{"name": "foo"}, 0, 0, rds)
+
+	vcache := &verticalCache{
+		dps:        make(map[bundleKey]*verticalCacheSegment),
+		dss:        make(map[int64]map[int64]interface{}),
+		dsValue:    make(map[int64]map[int64]interface{}),
+		dsDuration: make(map[int64]map[int64]interface{}),
+	}
+
+	// Nothing newer than lastUpdate, nothing to do
+	syncDataSource(vcache, ds, &testSource{pts: archive{{1000, 100}, {1015, 100}}})
+	if len(vcache.dps) != 0 || len(vcache.dss) != 0 {
+		t.Fatalf("syncDataSource: nothing expected for old points: %v %v", vcache.dps, vcache.dss)
+	}
+
+	syncDataSource(vcache, ds, &testSource{pts: archive{{1035, 35}, {1000, 100}, {1020, 20}, {1030, 30}}})
+
+	segment := vcache.dps[bundleKey{0, 0}]
+	if segment == nil {
+		t.Fatalf("syncDataSource: expected points in the vcache")
+	}
+	// The PDP ending at 1020 is half the carried over state
+	expect := map[int64]float64{1020: 12, 1030: 30}
+	if len(segment.rows) != len(expect) {
+		t.Errorf("syncDataSource: only points newer than lastUpdate expected, got %v", segment.rows)
+	}
+	for ts, v := range expect {
+		if got := segment.rows[rrd.SlotIndex(time.Unix(ts, 0), step, 10)][0]; got != v {
+			t.Errorf("syncDataSource: slot %d: expected %v, got %v", ts, v, got)
+		}
+	}
+	if l := segment.latests[0].(time.Time); !l.Equal(time.Unix(1030, 0)) {
+		t.Errorf("syncDataSource: expected latest 1030, got %v", l)
+	}
+
+	// The PDP state is that of 1035 now
+	if lu := vcache.dss[0][0].(time.Time); !lu.Equal(time.Unix(1035, 0)) {
+		t.Errorf("syncDataSource: expected lastUpdate 1035, got %v", lu)
+	}
+	if v, d := vcache.dsValue[0][0], vcache.dsDuration[0][0]; v != float64(35) || d != int64(5000) {
+		t.Errorf("syncDataSource: expected DS value 35 and duration 5000ms, got %v %v", v, d)
+	}
+}
@@ -0,0 +1,158 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Relay mode is a minimal carbon-relay: it accepts the Graphite
+// plaintext protocol over TCP and sends every line to both carbon
+// and Tgres. Pointing the senders at the relay for the duration of
+// the migration means that both have the live data, whisper files
+// can be imported (and synced) at leisure and the cutover is just a
+// matter of pointing the senders at Tgres.
+//
+// Each destination has its own queue and connection, which is
+// re-established as needed, so one being down or slow does not
+// affect the other. When a queue is full, lines for that destination
+// are dropped (and counted).
+//
+// Lines sent to Tgres are prefixed with -prefix, so that the names
+// match those of the imported whisper files.
+
+type relayDest struct {
+	name    string
+	addr    string
+	prefix  string
+	ch      chan string
+	sent    int64
+	dropped int64
+}
+
+func newRelayDest(name, addr, prefix string, queue int) *relayDest {
+	d := &relayDest{name: name, addr: addr, prefix: prefix, ch: make(chan string, queue)}
+	go d.run()
+	return d
+}
+
+func (d *relayDest) send(line string) {
+	select {
+	case d.ch <- line:
+	default:
+		atomic.AddInt64(&d.dropped, 1)
+	}
+}
+
+func (d *relayDest) run() {
+	var (
+		conn net.Conn
+		w    *bufio.Writer
+		err  error
+	)
+	for line := range d.ch {
+		for {
+			if conn == nil {
+				if conn, err = net.DialTimeout("tcp", d.addr, 5*time.Second); err != nil {
+					fmt.Printf("[relay] %s: unable to connect to %s (will retry): %v\n", d.name, d.addr, err)
+					conn = nil
+					time.Sleep(time.Second)
+					continue
+				}
+				fmt.Printf("[relay] %s: connected to %s\n", d.name, d.addr)
+				w = bufio.NewWriter(conn)
+			}
+			if d.prefix != "" {
+				w.WriteString(d.prefix)
+				w.WriteByte('.')
+			}
+			w.WriteString(line)
+			w.WriteByte('\n')
+			if len(d.ch) == 0 { // nothing else to send right now
+				err = w.Flush()
+			}
+			if err != nil {
+				fmt.Printf("[relay] %s: error writing to %s (reconnecting): %v\n", d.name, d.addr, err)
+				conn.Close()
+				conn, err = nil, nil
+				continue // resend the line, what was buffered is lost
+			}
+			atomic.AddInt64(&d.sent, 1)
+			break
+		}
+	}
+	if conn != nil {
+		w.Flush()
+		conn.Close()
+	}
+}
+
+func (d *relayDest) String() string {
+	return fmt.Sprintf("%s: %d sent, %d dropped", d.name, atomic.LoadInt64(&d.sent), atomic.LoadInt64(&d.dropped))
+}
+
+// relayConn reads lines from conn and sends them to dests.
+func relayConn(conn net.Conn, dests []*relayDest) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		for _, d := range dests {
+			d.send(line)
+		}
+	}
+}
+
+// relay runs the relay until the listener fails.
+func relay(cfg *Config) error {
+	if cfg.relayCarbon == "" || cfg.relayTgres == "" {
+		return fmt.Errorf("relay mode requires both -carbon and -tgres addresses")
+	}
+
+	ln, err := net.Listen("tcp", cfg.relayListen)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	dests := []*relayDest{
+		newRelayDest("carbon", cfg.relayCarbon, "", cfg.relayQueue),
+		newRelayDest("tgres", cfg.relayTgres, cfg.namePrefix, cfg.relayQueue),
+	}
+	fmt.Printf("[relay] listening on %s, relaying to carbon at %s and tgres at %s\n", cfg.relayListen, cfg.relayCarbon, cfg.relayTgres)
+
+	go func() {
+		for range time.Tick(time.Minute) {
+			fmt.Printf("[relay] %v; %v\n", dests[0], dests[1])
+		}
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go relayConn(conn, dests)
+	}
+}
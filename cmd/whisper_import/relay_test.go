@@ -0,0 +1,107 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_relayDest_send(t *testing.T) {
+	d := &relayDest{name: "test", ch: make(chan string, 2)} // not running
+	for i := 0; i < 5; i++ {
+		d.send("foo 1 1000")
+	}
+	if dropped := atomic.LoadInt64(&d.dropped); dropped != 3 || len(d.ch) != 2 {
+		t.Errorf("send: expected 3 dropped and 2 queued, got %d and %d", dropped, len(d.ch))
+	}
+}
+
+func Test_relayDest_reconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conns := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+	accept := func(timeout time.Duration) net.Conn {
+		select {
+		case conn := <-conns:
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			return conn
+		case <-time.After(timeout):
+			return nil
+		}
+	}
+
+	d := newRelayDest("test", ln.Addr().String(), "pfx", 10)
+	defer close(d.ch)
+
+	d.send("foo 1 1000")
+	conn := accept(5 * time.Second)
+	if conn == nil {
+		t.Fatalf("relayDest: no connection")
+	}
+	if line, _ := bufio.NewReader(conn).ReadString('\n'); line != "pfx.foo 1 1000\n" {
+		t.Errorf("relayDest: expected the prefixed line, got %q", line)
+	}
+
+	// The connection goes away, writing to it eventually fails and
+	// the dest reconnects.
+	conn.Close()
+	conn = nil
+	for i := 0; conn == nil; i++ {
+		if i == 100 {
+			t.Fatalf("relayDest: no reconnect")
+		}
+		d.send(fmt.Sprintf("bar %d 1000", i))
+		conn = accept(50 * time.Millisecond)
+	}
+	defer conn.Close()
+
+	// The line that failed is resent, followed by the rest
+	d.send("baz 1 1000")
+	r := bufio.NewReader(conn)
+	for n := 0; ; n++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("relayDest: data not sent after the reconnect: %v", err)
+		}
+		if n == 0 && !strings.HasPrefix(line, "pfx.bar ") {
+			t.Errorf("relayDest: expected the failed line resent first, got %q", line)
+		}
+		if line == "pfx.baz 1 1000\n" {
+			break
+		}
+	}
+	if sent := atomic.LoadInt64(&d.sent); sent < 3 {
+		t.Errorf("relayDest: expected at least 3 sent, got %d", sent)
+	}
+}
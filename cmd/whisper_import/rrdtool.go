@@ -0,0 +1,443 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tgres/tgres/rrd"
+)
+
+// Random notes on RRDTool files.
+//
+// The binary .rrd format depends on the architecture (word size,
+// endianness, alignment) of the box that wrote it, so rather than
+// reading it directly we read the output of "rrdtool dump", which is
+// the portable format. Files ending in .rrd are dumped by running
+// rrdtool, files ending in .xml are assumed to be dumps already.
+//
+// An RRD file can have more than one DS, each becomes a separate
+// Tgres DS. All DSs in a file share the RRA definitions.
+//
+// Like Tgres, RRDTool rows are timestamped by the end of the
+// slot. The last row ends at lastupdate truncated to the RRA step,
+// and the rows in the dump are ordered oldest first.
+//
+// RRDTool keeps the PDP in progress as the sum of value * seconds
+// and the number of unknown seconds, while in Tgres it is a weighted
+// mean and known duration. Similarly the CDP in progress (for
+// AVERAGE) is a sum of PDPs and the count of unknown ones.
+
+type rrdFloat float64
+
+func (f *rrdFloat) UnmarshalText(text []byte) error {
+	v, err := strconv.ParseFloat(strings.TrimSpace(string(text)), 64)
+	*f = rrdFloat(v)
+	return err
+}
+
+type rrdInt int64
+
+func (n *rrdInt) UnmarshalText(text []byte) error {
+	v, err := strconv.ParseInt(strings.TrimSpace(string(text)), 10, 64)
+	*n = rrdInt(v)
+	return err
+}
+
+type rrdDump struct {
+	Step       rrdInt   `xml:"step"`
+	LastUpdate rrdInt   `xml:"lastupdate"`
+	DSs        []rrdDS  `xml:"ds"`
+	RRAs       []rrdRRA `xml:"rra"`
+}
+
+type rrdDS struct {
+	Name       string   `xml:"name"`
+	Type       string   `xml:"type"`
+	Heartbeat  rrdInt   `xml:"minimal_heartbeat"`
+	Value      rrdFloat `xml:"value"`
+	UnknownSec rrdInt   `xml:"unknown_sec"`
+}
+
+type rrdRRA struct {
+	CF        string    `xml:"cf"`
+	PdpPerRow rrdInt    `xml:"pdp_per_row"`
+	Xff       *rrdFloat `xml:"params>xff"`
+	OldXff    *rrdFloat `xml:"xff"` // RRDTool 1.0
+	CDPs      []rrdCDP  `xml:"cdp_prep>ds"`
+	Rows      []rrdRow  `xml:"database>row"`
+}
+
+type rrdCDP struct {
+	Value   rrdFloat `xml:"value"`
+	Unknown rrdInt   `xml:"unknown_datapoints"`
+}
+
+type rrdRow struct {
+	Vs []rrdFloat `xml:"v"`
+}
+
+// Path to the rrdtool binary, set with -rrdtool.
+var rrdtoolCmd = "rrdtool"
+
+func isRRDPath(path string) bool {
+	return strings.HasSuffix(path, ".rrd") || strings.HasSuffix(path, ".xml")
+}
+
+func readRRD(path string) (*rrdDump, error) {
+	return decodeRRD(path, false)
+}
+
+// readRRDHeader is readRRD without the RRAs, which is all it takes to
+// know the DSs. Only the beginning of the dump is read, which for a
+// large file is much cheaper.
+func readRRDHeader(path string) (*rrdDump, error) {
+	return decodeRRD(path, true)
+}
+
+// rrdDumpCmd is the output of "rrdtool dump".
+type rrdDumpCmd struct {
+	io.Reader
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+}
+
+func (c *rrdDumpCmd) Close() error {
+	return c.cmd.Wait()
+}
+
+func openRRD(path string) (io.ReadCloser, error) {
+	if !strings.HasSuffix(path, ".rrd") {
+		return os.Open(path)
+	}
+	c := &rrdDumpCmd{cmd: exec.Command(rrdtoolCmd, "dump", path)}
+	c.cmd.Stderr = &c.stderr
+	out, err := c.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%s dump %s: %v", rrdtoolCmd, path, err)
+	}
+	c.Reader = out
+	return c, nil
+}
+
+func decodeRRD(path string, headerOnly bool) (*rrdDump, error) {
+	r, err := openRRD(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var d rrdDump
+	err = decodeRRDElements(xml.NewDecoder(r), &d, headerOnly)
+	if c, ok := r.(*rrdDumpCmd); !ok {
+		r.Close()
+	} else if headerOnly || err != nil {
+		c.cmd.Process.Kill() // the rest is not needed
+		c.Close()
+	} else if werr := c.Close(); werr != nil {
+		err = fmt.Errorf("%s dump: %v %s", rrdtoolCmd, werr, bytes.TrimSpace(c.stderr.Bytes()))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if d.Step <= 0 || len(d.DSs) == 0 {
+		return nil, fmt.Errorf("%s: not an rrdtool dump (no step or no DSs)", path)
+	}
+	for i := range d.DSs {
+		d.DSs[i].Name = strings.TrimSpace(d.DSs[i].Name)
+	}
+	for i := range d.RRAs {
+		d.RRAs[i].CF = strings.TrimSpace(d.RRAs[i].CF)
+	}
+	return &d, nil
+}
+
+// decodeRRDElements decodes the <rrd> elements one at a time, so
+// that it can stop at the first <rra> if headerOnly.
+func decodeRRDElements(dec *xml.Decoder, d *rrdDump, headerOnly bool) error {
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch se.Name.Local {
+		case "rrd":
+			continue // the root, its elements follow
+		case "step":
+			err = dec.DecodeElement(&d.Step, &se)
+		case "lastupdate":
+			err = dec.DecodeElement(&d.LastUpdate, &se)
+		case "ds":
+			var ds rrdDS
+			err = dec.DecodeElement(&ds, &se)
+			d.DSs = append(d.DSs, ds)
+		case "rra":
+			if headerOnly {
+				return nil
+			}
+			var rra rrdRRA
+			err = dec.DecodeElement(&rra, &se)
+			d.RRAs = append(d.RRAs, rra)
+		default:
+			err = dec.Skip()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (d *rrdDump) dsIndex(name string) int {
+	if name == "" {
+		return 0
+	}
+	for i, ds := range d.DSs {
+		if ds.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// rrdCF maps RRDTool CF to Tgres consolidation. The Holt-Winters CFs
+// are not supported.
+func rrdCF(cf string) (rrd.Consolidation, bool) {
+	switch cf {
+	case "AVERAGE":
+		return rrd.WMEAN, true
+	case "MIN":
+		return rrd.MIN, true
+	case "MAX":
+		return rrd.MAX, true
+	case "LAST":
+		return rrd.LAST, true
+	}
+	return 0, false
+}
+
+func (rra *rrdRRA) xff() float32 {
+	if rra.Xff != nil {
+		return float32(*rra.Xff)
+	}
+	if rra.OldXff != nil {
+		return float32(*rra.OldXff)
+	}
+	return 0.5
+}
+
+func (d *rrdDump) rraStep(rra *rrdRRA) int64 {
+	return int64(rra.PdpPerRow) * int64(d.Step)
+}
+
+// Time (seconds) on which the last row ends.
+func (d *rrdDump) lastRow(rra *rrdRRA) int64 {
+	step := d.rraStep(rra)
+	return int64(d.LastUpdate) - int64(d.LastUpdate)%step
+}
+
+// specFromRRD returns the spec for the i-th DS. The heartbeat is that
+// of the DS, the -hb flag does not apply.
+func specFromRRD(d *rrdDump, i int) *rrd.DSSpec {
+	spec := rrd.DSSpec{
+		Step:      time.Duration(d.Step) * time.Second,
+		Heartbeat: time.Duration(d.DSs[i].Heartbeat) * time.Second,
+	}
+	for k := range d.RRAs {
+		rra := &d.RRAs[k]
+		cf, ok := rrdCF(rra.CF)
+		if !ok || rra.PdpPerRow <= 0 || len(rra.Rows) == 0 {
+			continue
+		}
+		step := time.Duration(d.rraStep(rra)) * time.Second
+		spec.RRAs = append(spec.RRAs, rrd.RRASpec{
+			Function: cf,
+			Step:     step,
+			Span:     time.Duration(len(rra.Rows)) * step,
+			Xff:      rra.xff(),
+		})
+	}
+	return &spec
+}
+
+// matchRRA finds the RRA with the same CF and step as spec, if there
+// is more than one, the longest.
+func (d *rrdDump) matchRRA(spec rrd.RRASpec) *rrdRRA {
+	var found *rrdRRA
+	for k := range d.RRAs {
+		rra := &d.RRAs[k]
+		cf, ok := rrdCF(rra.CF)
+		if !ok || cf != spec.Function || time.Duration(d.rraStep(rra))*time.Second != spec.Step {
+			continue
+		}
+		if found == nil || len(rra.Rows) > len(found.Rows) {
+			found = rra
+		}
+	}
+	return found
+}
+
+// dataSource returns a new DS for the i-th DS of the dump, with the
+// RRAs populated directly from RRDTool RRAs and the PDP/CDP state
+// carried over. This is only possible if every RRA in spec has an
+// RRDTool counterpart with the same CF and step, otherwise it returns
+// false.
+func (d *rrdDump) dataSource(i int, spec rrd.DSSpec) (*rrd.DataSource, bool) {
+	if spec.Step != time.Duration(d.Step)*time.Second {
+		return nil, false
+	}
+
+	rras := make([]rrd.RRASpec, len(spec.RRAs))
+	for j, rspec := range spec.RRAs {
+		rra := d.matchRRA(rspec)
+		if rra == nil {
+			return nil, false
+		}
+		rras[j] = d.rraSpec(rra, i, rspec)
+	}
+	spec.RRAs = rras
+
+	// PDP in progress
+	ds := d.DSs[i]
+	spec.LastUpdate = time.Unix(int64(d.LastUpdate), 0)
+	known := int64(d.LastUpdate)%int64(d.Step) - int64(ds.UnknownSec)
+	if v := float64(ds.Value); known > 0 && !math.IsNaN(v) {
+		spec.Value = v / float64(known)
+		spec.Duration = time.Duration(known) * time.Second
+	}
+
+	return rrd.NewDataSource(spec), true
+}
+
+func (d *rrdDump) rraSpec(rra *rrdRRA, i int, spec rrd.RRASpec) rrd.RRASpec {
+	step, last := d.rraStep(rra), d.lastRow(rra)
+	size := spec.Span.Nanoseconds() / spec.Step.Nanoseconds()
+	begins := last - size*step // exclusive
+
+	spec.Latest = time.Unix(last, 0)
+	spec.DPs = make(map[int64]float64)
+	for k, row := range rra.Rows {
+		t := last - int64(len(rra.Rows)-1-k)*step
+		if t <= begins || i >= len(row.Vs) || math.IsNaN(float64(row.Vs[i])) {
+			continue
+		}
+		spec.DPs[rrd.SlotIndex(time.Unix(t, 0), spec.Step, size)] = float64(row.Vs[i])
+	}
+
+	// CDP in progress, i.e. PDPs since the last row.
+	if i < len(rra.CDPs) && rra.PdpPerRow > 1 {
+		cdp := rra.CDPs[i]
+		pdpEnd := int64(d.LastUpdate) - int64(d.LastUpdate)%int64(d.Step)
+		known := (pdpEnd-last)/int64(d.Step) - int64(cdp.Unknown)
+		if v := float64(cdp.Value); known > 0 && !math.IsNaN(v) {
+			if spec.Function == rrd.WMEAN {
+				v = v / float64(known) // RRDTool keeps the sum
+			}
+			spec.Value = v
+			spec.Duration = time.Duration(known*int64(d.Step)) * time.Second
+		}
+	}
+	return spec
+}
+
+// points returns the data of the i-th DS as points suitable for
+// processArchivePoints(), for when the RRAs cannot be populated
+// directly. Like with whisper, the finest resolution RRA takes
+// precedence, coarser ones only fill in what is before it. Only
+// AVERAGE RRAs are used if there are any.
+func (d *rrdDump) points(i int) archive {
+	var rras []*rrdRRA
+	for _, cf := range []string{"AVERAGE", "LAST", "MAX", "MIN"} {
+		for k := range d.RRAs {
+			if d.RRAs[k].CF == cf && d.RRAs[k].PdpPerRow > 0 {
+				rras = append(rras, &d.RRAs[k])
+			}
+		}
+		if len(rras) > 0 {
+			break
+		}
+	}
+	sort.Slice(rras, func(a, b int) bool { return rras[a].PdpPerRow < rras[b].PdpPerRow })
+
+	var result archive
+	end := int64(math.MaxInt64)
+	for _, rra := range rras {
+		if len(rra.Rows) == 0 {
+			continue
+		}
+		step, last := d.rraStep(rra), d.lastRow(rra)
+		start := last - int64(len(rra.Rows)-1)*step
+		for k, row := range rra.Rows {
+			t := start + int64(k)*step
+			if t >= end || i >= len(row.Vs) || math.IsNaN(float64(row.Vs[i])) {
+				continue
+			}
+			result = append(result, point{TimeStamp: uint32(t), Value: float64(row.Vs[i])})
+		}
+		if start < end {
+			end = start
+		}
+	}
+	return result
+}
+
+// rrdSource is the i-th DS of an RRDTool file.
+type rrdSource struct {
+	*rrdDump
+	i int
+}
+
+func (s *rrdSource) lastUpdate() time.Time {
+	return time.Unix(int64(s.LastUpdate), 0)
+}
+
+func (s *rrdSource) spec(hb int) *rrd.DSSpec {
+	return specFromRRD(s.rrdDump, s.i)
+}
+
+func (s *rrdSource) load(spec rrd.DSSpec) (*rrd.DataSource, bool) {
+	if ds, ok := s.dataSource(s.i, spec); ok {
+		return ds, true
+	}
+	ds := rrd.NewDataSource(spec)
+	processArchivePoints(ds, s.rrdDump.points(s.i))
+	return ds, false
+}
+
+func (s *rrdSource) points() archive {
+	return s.rrdDump.points(s.i)
+}
+
+func (s *rrdSource) Close() error { return nil }
@@ -0,0 +1,291 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/tgres/tgres/rrd"
+)
+
+// As produced by "rrdtool dump" of a file with step 10s, DSs a and b,
+// lastupdate 1015, i.e. 5s into the PDP ending at 1020 and 25s into
+// the 30s CDP ending at 1020 (of which two PDPs are complete).
+const testRRDDump = `<?xml version="1.0" encoding="utf-8"?>
+<!DOCTYPE rrd SYSTEM "http://oss.oetiker.ch/rrdtool/rrdtool.dtd">
+<!-- Round Robin Database Dump -->
+<rrd>
+	<version>0003</version>
+	<step>10</step> <!-- Seconds -->
+	<lastupdate>1015</lastupdate> <!-- 1970-01-01 00:16:55 UTC -->
+
+	<ds>
+		<name> a </name>
+		<type> GAUGE </type>
+		<minimal_heartbeat>120</minimal_heartbeat>
+		<min>NaN</min>
+		<max>NaN</max>
+
+		<!-- PDP Status -->
+		<last_ds>2</last_ds>
+		<value>8.0000000000e+00</value>
+		<unknown_sec> 1 </unknown_sec>
+	</ds>
+
+	<ds>
+		<name> b </name>
+		<type> GAUGE </type>
+		<minimal_heartbeat>120</minimal_heartbeat>
+		<min>NaN</min>
+		<max>NaN</max>
+
+		<!-- PDP Status -->
+		<last_ds>U</last_ds>
+		<value>NaN</value>
+		<unknown_sec> 5 </unknown_sec>
+	</ds>
+
+	<!-- Round Robin Archives -->
+	<rra>
+		<cf>AVERAGE</cf>
+		<pdp_per_row>1</pdp_per_row> <!-- 10 seconds -->
+
+		<params>
+		<xff>5.0000000000e-01</xff>
+		</params>
+		<cdp_prep>
+			<ds>
+			<primary_value>NaN</primary_value>
+			<secondary_value>NaN</secondary_value>
+			<value>NaN</value>
+			<unknown_datapoints>0</unknown_datapoints>
+			</ds>
+			<ds>
+			<primary_value>NaN</primary_value>
+			<secondary_value>NaN</secondary_value>
+			<value>NaN</value>
+			<unknown_datapoints>0</unknown_datapoints>
+			</ds>
+		</cdp_prep>
+		<database>
+			<!-- 1970-01-01 00:16:30 UTC / 990 --> <row><v>1.0000000000e+00</v><v>NaN</v></row>
+			<!-- 1970-01-01 00:16:40 UTC / 1000 --> <row><v>2.0000000000e+00</v><v>5.0000000000e+00</v></row>
+			<!-- 1970-01-01 00:16:50 UTC / 1010 --> <row><v>NaN</v><v>6.0000000000e+00</v></row>
+		</database>
+	</rra>
+	<rra>
+		<cf>AVERAGE</cf>
+		<pdp_per_row>3</pdp_per_row> <!-- 30 seconds -->
+
+		<params>
+		<xff>5.0000000000e-01</xff>
+		</params>
+		<cdp_prep>
+			<ds>
+			<primary_value>2.0000000000e+00</primary_value>
+			<secondary_value>NaN</secondary_value>
+			<value>6.0000000000e+00</value>
+			<unknown_datapoints>0</unknown_datapoints>
+			</ds>
+			<ds>
+			<primary_value>NaN</primary_value>
+			<secondary_value>NaN</secondary_value>
+			<value>NaN</value>
+			<unknown_datapoints>2</unknown_datapoints>
+			</ds>
+		</cdp_prep>
+		<database>
+			<!-- 1970-01-01 00:16:00 UTC / 960 --> <row><v>1.5000000000e+00</v><v>NaN</v></row>
+			<!-- 1970-01-01 00:16:30 UTC / 990 --> <row><v>2.5000000000e+00</v><v>3.5000000000e+00</v></row>
+		</database>
+	</rra>
+	<rra>
+		<cf>MAX</cf>
+		<pdp_per_row>3</pdp_per_row> <!-- 30 seconds -->
+
+		<params>
+		<xff>0.0000000000e+00</xff>
+		</params>
+		<cdp_prep>
+			<ds>
+			<primary_value>2.0000000000e+00</primary_value>
+			<secondary_value>NaN</secondary_value>
+			<value>7.0000000000e+00</value>
+			<unknown_datapoints>0</unknown_datapoints>
+			</ds>
+			<ds>
+			<primary_value>NaN</primary_value>
+			<secondary_value>NaN</secondary_value>
+			<value>NaN</value>
+			<unknown_datapoints>2</unknown_datapoints>
+			</ds>
+		</cdp_prep>
+		<database>
+			<!-- 1970-01-01 00:16:30 UTC / 990 --> <row><v>3.0000000000e+00</v><v>4.0000000000e+00</v></row>
+		</database>
+	</rra>
+</rrd>
+`
+
+func writeTestDump(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "tgres-rrd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_readRRD(t *testing.T) {
+	path := writeTestDump(t, "test.xml", testRRDDump)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	d, err := readRRD(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Step != 10 || d.LastUpdate != 1015 || len(d.DSs) != 2 || len(d.RRAs) != 3 {
+		t.Fatalf("readRRD: unexpected dump: %+v", d)
+	}
+	if d.DSs[0].Name != "a" || d.DSs[1].Name != "b" || d.RRAs[2].CF != "MAX" || d.RRAs[1].xff() != 0.5 {
+		t.Errorf("readRRD: names and CFs should be trimmed: %+v", d)
+	}
+	if len(d.RRAs[1].CDPs) != 2 || d.RRAs[1].CDPs[1].Unknown != 2 || len(d.RRAs[0].Rows[1].Vs) != 2 {
+		t.Errorf("readRRD: unexpected RRA: %+v", d.RRAs[1])
+	}
+
+	h, err := readRRDHeader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Step != 10 || h.LastUpdate != 1015 || len(h.DSs) != 2 || len(h.RRAs) != 0 {
+		t.Errorf("readRRDHeader: expected the DSs and no RRAs: %+v", h)
+	}
+	if keys, err := sourceKeys(path); err != nil || len(keys) != 2 || keys[1] != path+"#b" {
+		t.Errorf("sourceKeys: %v %v", keys, err)
+	}
+
+	bad := writeTestDump(t, "bad.xml", "<rrd><version>0003</version></rrd>")
+	defer os.RemoveAll(filepath.Dir(bad))
+	if _, err := readRRD(bad); err == nil {
+		t.Errorf("readRRD: expected an error for a dump without step")
+	}
+}
+
+func Test_rrdSource_load(t *testing.T) {
+	path := writeTestDump(t, "test.xml", testRRDDump)
+	defer os.RemoveAll(filepath.Dir(path))
+	d, err := readRRD(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type rraState struct {
+		dps      map[int64]float64 // by slot end time
+		value    float64
+		duration time.Duration
+	}
+	for _, c := range []struct {
+		desc     string
+		i        int
+		value    float64 // of the DS PDP, NaN if none
+		duration time.Duration
+		rras     []rraState // WMEAN 10s, WMEAN 30s, MAX 30s
+	}{
+		{
+			// 5s into the PDP, 1 unknown: 8 / 4 known seconds
+			desc: "a", i: 0, value: 2, duration: 4 * time.Second,
+			rras: []rraState{
+				{dps: map[int64]float64{990: 1, 1000: 2}},
+				// WMEAN CDP is a sum of 2 known PDPs
+				{dps: map[int64]float64{960: 1.5, 990: 2.5}, value: 3, duration: 20 * time.Second},
+				{dps: map[int64]float64{990: 3}, value: 7, duration: 20 * time.Second},
+			},
+		},
+		{
+			// all unknown
+			desc: "b", i: 1, value: math.NaN(),
+			rras: []rraState{
+				{dps: map[int64]float64{1000: 5, 1010: 6}},
+				{dps: map[int64]float64{990: 3.5}},
+				{dps: map[int64]float64{990: 4}},
+			},
+		},
+	} {
+		src := &rrdSource{rrdDump: d, i: c.i}
+		spec := src.spec(0)
+		if spec.Step != 10*time.Second || spec.Heartbeat != 2*time.Minute || len(spec.RRAs) != 3 {
+			t.Fatalf("%s: unexpected spec: %+v", c.desc, spec)
+		}
+		ds, direct := src.load(*spec)
+		if !direct {
+			t.Fatalf("%s: expected the RRAs to be populated directly", c.desc)
+		}
+		if !ds.LastUpdate().Equal(time.Unix(1015, 0)) {
+			t.Errorf("%s: lastUpdate: %v", c.desc, ds.LastUpdate())
+		}
+		if v := ds.Value(); !(v == c.value || math.IsNaN(v) && math.IsNaN(c.value)) || ds.Duration() != c.duration {
+			t.Errorf("%s: PDP: expected %v %v, got %v %v", c.desc, c.value, c.duration, v, ds.Duration())
+		}
+		for j, rra := range ds.RRAs() {
+			exp := c.rras[j]
+			dps := make(map[int64]float64)
+			for n, v := range rra.DPs() {
+				dps[rrd.SlotTime(n, rra.Latest(), rra.Step(), rra.Size()).Unix()] = v
+			}
+			if len(dps) != len(exp.dps) {
+				t.Errorf("%s: RRA %d: expected %v, got %v", c.desc, j, exp.dps, dps)
+			}
+			for ts, v := range exp.dps {
+				if dps[ts] != v {
+					t.Errorf("%s: RRA %d at %d: expected %v, got %v", c.desc, j, ts, v, dps[ts])
+				}
+			}
+			if rra.Duration() != exp.duration || exp.duration > 0 && rra.Value() != exp.value {
+				t.Errorf("%s: RRA %d CDP: expected %v %v, got %v %v", c.desc, j, exp.value, exp.duration, rra.Value(), rra.Duration())
+			}
+		}
+	}
+
+	// A spec without RRDTool counterparts falls back to points
+	src := &rrdSource{rrdDump: d, i: 0}
+	spec := src.spec(0)
+	spec.RRAs[0].Step = 20 * time.Second
+	if _, direct := src.load(*spec); direct {
+		t.Errorf("load: a 20s RRA cannot be populated directly")
+	}
+	pts := src.points()
+	sort.Sort(pts)
+	// finest RRA first, the 30s one only before it
+	expect := archive{{960, 1.5}, {990, 1}, {1000, 2}}
+	if len(pts) != len(expect) {
+		t.Fatalf("points: expected %v, got %v", expect, pts)
+	}
+	for n := range expect {
+		if pts[n] != expect[n] {
+			t.Errorf("points: expected %v, got %v", expect, pts)
+		}
+	}
+}
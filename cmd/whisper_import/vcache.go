@@ -15,6 +15,8 @@ type verticalCacheSegment struct {
 	rows map[int64]crossRRAPoints
 	// The latest timestamp for RRAs, keyed by RRA.pos.
 	latests     map[int64]interface{} // rra.latest
+	value       map[int64]interface{} // rra.value, only if withState
+	duration    map[int64]interface{} // rra.duration (ms), ditto
 	maxLatest   time.Time
 	latestIndex int64
 	step        time.Duration
@@ -26,13 +28,17 @@ type verticalCache struct {
 	seg int64 // which segment this was for
 	dps map[bundleKey]*verticalCacheSegment
 	dss map[int64]map[int64]interface{}
+	// DS value and duration (ms) by seg, only if withState
+	dsValue, dsDuration map[int64]map[int64]interface{}
 }
 
 type bundleKey struct {
 	bundleId, seg int64
 }
 
-func (vc verticalCache) updateDps(rra serde.DbRoundRobinArchiver, origLatest time.Time) {
+// If withState is true, the RRA value and duration (i.e. the slot in
+// progress) are saved too, but only if the latest advanced.
+func (vc verticalCache) updateDps(rra serde.DbRoundRobinArchiver, origLatest time.Time, withState bool) {
 
 	seg, idx := rra.Seg(), rra.Idx()
 	key := bundleKey{rra.BundleId(), seg}
@@ -40,10 +46,12 @@ func (vc verticalCache) updateDps(rra serde.DbRoundRobinArchiver, origLatest tim
 	segment := vc.dps[key]
 	if segment == nil {
 		segment = &verticalCacheSegment{
-			rows:    make(map[int64]crossRRAPoints),
-			latests: make(map[int64]interface{}),
-			step:    rra.Step(),
-			size:    rra.Size(),
+			rows:     make(map[int64]crossRRAPoints),
+			latests:  make(map[int64]interface{}),
+			value:    make(map[int64]interface{}),
+			duration: make(map[int64]interface{}),
+			step:     rra.Step(),
+			size:     rra.Size(),
 		}
 		vc.dps[key] = segment
 	}
@@ -70,14 +78,18 @@ func (vc verticalCache) updateDps(rra serde.DbRoundRobinArchiver, origLatest tim
 			segment.latestIndex = rrd.SlotIndex(latest, rra.Step(), rra.Size())
 		}
 		segment.latests[idx] = latest
+		if withState {
+			segment.value[idx] = rra.Value()
+			segment.duration[idx] = rra.Duration().Nanoseconds() / 1e6
+		}
 	} else {
 		segment.latests[idx] = origLatest
 	}
 
 }
 
-// Update DS state data
-func (vc *verticalCache) updateDss(ds serde.DbDataSourcer) {
+// Update DS state data, with value and duration if withState.
+func (vc *verticalCache) updateDss(ds serde.DbDataSourcer, withState bool) {
 
 	seg, idx := ds.Seg(), ds.Idx()
 
@@ -88,6 +100,15 @@ func (vc *verticalCache) updateDss(ds serde.DbDataSourcer) {
 	}
 
 	segment[idx] = ds.LastUpdate()
+
+	if withState {
+		if vc.dsValue[seg] == nil {
+			vc.dsValue[seg] = make(map[int64]interface{})
+			vc.dsDuration[seg] = make(map[int64]interface{})
+		}
+		vc.dsValue[seg][idx] = ds.Value()
+		vc.dsDuration[seg][idx] = ds.Duration().Nanoseconds() / 1e6
+	}
 }
 
 type vstats struct {
@@ -125,7 +146,7 @@ func (vc verticalCache) flush(db serde.Flusher) error {
 
 	fmt.Printf("[db] [%v] Flushing %d DS states ...\n", vc.ts, len(vc.dss))
 	for k, lu := range vc.dss {
-		ops, err := db.FlushDSStates(k, lu, nil, nil)
+		ops, err := db.FlushDSStates(k, lu, vc.dsValue[k], vc.dsDuration[k])
 		if err != nil {
 			fmt.Printf("[db] [%v] EROR flushing DS state: %v\n", vc.ts, err)
 		}
@@ -181,7 +202,7 @@ func flushSegment(db serde.Flusher, wg *sync.WaitGroup, st *vstats, k bundleKey,
 
 	if len(segment.latests) > 0 {
 		fmt.Printf("[db] [%v] flushing RRA state for segment %v:%v...\n", ts, k.bundleId, k.seg)
-		so, err := db.FlushRRAStates(k.bundleId, k.seg, segment.latests, nil, nil)
+		so, err := db.FlushRRAStates(k.bundleId, k.seg, segment.latests, segment.value, segment.duration)
 		if err != nil {
 			fmt.Printf("[db] [%v] Error flushing RRA segment %v:%v: %v\n", ts, k.bundleId, k.seg, err)
 			return
@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
+
+	"github.com/tgres/tgres/rrd"
 )
 
 type metadata struct {
@@ -93,3 +96,36 @@ func (w *whisper) readPoints(offset uint32, points []point) error {
 func (w *whisper) Close() error {
 	return w.file.Close()
 }
+
+// importSource is a series to be imported, either a whisper file or
+// a DS of an RRDTool file.
+type importSource interface {
+	lastUpdate() time.Time
+	// spec infers the DSSpec when -spec is not given.
+	spec(hb int) *rrd.DSSpec
+	// load returns a new DS per spec populated with the data, and
+	// whether its PDP state (DS and RRA value and duration) is
+	// valid and should be saved.
+	load(spec rrd.DSSpec) (*rrd.DataSource, bool)
+	// points returns all the data as points (see sync mode).
+	points() archive
+	Close() error
+}
+
+func (w *whisper) lastUpdate() time.Time {
+	return findMostRecentTS(w)
+}
+
+func (w *whisper) spec(hb int) *rrd.DSSpec {
+	return specFromHeader(w.header, hb)
+}
+
+func (w *whisper) load(spec rrd.DSSpec) (*rrd.DataSource, bool) {
+	ds := rrd.NewDataSource(spec)
+	processAllPoints(ds, w)
+	return ds, false
+}
+
+func (w *whisper) points() archive {
+	return allPoints(w)
+}
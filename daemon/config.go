@@ -18,7 +18,8 @@ package daemon
 import (
 	"errors"
 	"fmt"
-	"log"
+	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -26,33 +27,67 @@ import (
 	"strings"
 	"time"
 
-	"github.com/BurntSushi/toml"
+	"github.com/tgres/tgres/aggregator"
+	"github.com/tgres/tgres/cluster"
+	"github.com/tgres/tgres/dsl"
+	h "github.com/tgres/tgres/http"
+	"github.com/tgres/tgres/logging"
 	"github.com/tgres/tgres/misc"
+	"github.com/tgres/tgres/receiver"
 	"github.com/tgres/tgres/rrd"
+	"github.com/tgres/tgres/rules"
 	"github.com/tgres/tgres/serde"
+	"github.com/tgres/tgres/statsd"
+	"github.com/tgres/tgres/tracing"
 )
 
 type Config struct { // Needs to be exported for TOML to work
-	PidPath                  string   `toml:"pid-file"`
-	LogPath                  string   `toml:"log-file"`
-	LogCycle                 duration `toml:"log-cycle-interval"`
-	DbConnectString          string   `toml:"db-connect-string"`
-	PgSegmentWidth           int      `toml:"pg-segment-width"`
-	MinStep                  duration `toml:"min-step"`
-	MaxReceiverQueueSize     int      `toml:"max-receiver-queue-size"`
-	MaxMemoryBytes           int      `toml:"max-memory-bytes"`
-	GraphiteTextListenSpec   string   `toml:"graphite-text-listen-spec"`
-	GraphiteUdpListenSpec    string   `toml:"graphite-udp-listen-spec"`
-	GraphitePickleListenSpec string   `toml:"graphite-pickle-listen-spec"`
-	StatsdTextListenSpec     string   `toml:"statsd-text-listen-spec"`
-	StatsdUdpListenSpec      string   `toml:"statsd-udp-listen-spec"`
-	HttpListenSpec           string   `toml:"http-listen-spec"`
-	HttpAllowOrigin          string   `toml:"http-allow-origin"`
-	QueryCacheSize           int      `toml:"query-cache-size"`
+	IncludeDir               string            `toml:"include-dir"`
+	PidPath                  string            `toml:"pid-file"`
+	LogPath                  string            `toml:"log-file"`
+	LogCycle                 duration          `toml:"log-cycle-interval"`
+	LogFormat                string            `toml:"log-format"`
+	LogLevel                 string            `toml:"log-level"`
+	LogLevels                map[string]string `toml:"log-levels"`
+	DbConnectString          string            `toml:"db-connect-string"`
+	DbPasswordFile           string            `toml:"db-password-file"`
+	PgSegmentWidth           int               `toml:"pg-segment-width"`
+	MinStep                  duration          `toml:"min-step"`
+	MaxReceiverQueueSize     int               `toml:"max-receiver-queue-size"`
+	MaxMemoryBytes           int               `toml:"max-memory-bytes"`
+	MaxSeries                int               `toml:"max-series"`
+	MaxSeriesPerPrefix       int               `toml:"max-series-per-prefix"`
+	MaxNewSeriesPerMinute    int               `toml:"max-new-series-per-minute"`
+	SeriesPrefixDepth        int               `toml:"series-prefix-depth"`
+	GraphiteTextListenSpec   string            `toml:"graphite-text-listen-spec"`
+	GraphiteUdpListenSpec    string            `toml:"graphite-udp-listen-spec"`
+	GraphitePickleListenSpec string            `toml:"graphite-pickle-listen-spec"`
+	StatsdTextListenSpec     string            `toml:"statsd-text-listen-spec"`
+	StatsdUdpListenSpec      string            `toml:"statsd-udp-listen-spec"`
+	HttpListenSpec           string            `toml:"http-listen-spec"`
+	HttpAllowOrigin          string            `toml:"http-allow-origin"`
+	QueryCacheSize           int               `toml:"query-cache-size"`
 	Workers                  int
 	DSs                      []ConfigDSSpec `toml:"ds"`
 	StatFlush                duration       `toml:"stat-flush-interval"`
 	StatsNamePrefix          string         `toml:"stats-name-prefix"`
+	StatsdCounterAggregation string         `toml:"statsd-counter-aggregation"`
+	StatRateWindows          []duration     `toml:"stat-rate-windows"`
+	AggregatorShards         int            `toml:"aggregator-shards"`
+	ClusterCopies            int            `toml:"cluster-copies"`
+	ClusterPlacement         string         `toml:"cluster-placement"`
+	ClusterJoin              []string       `toml:"cluster-join"`
+	ClusterJoinDNS           string         `toml:"cluster-join-dns"`
+	ClusterJoinFile          string         `toml:"cluster-join-file"`
+	ClusterDiscoveryInterval *duration      `toml:"cluster-discovery-interval"`
+	DSLMacros                []string       `toml:"dsl-macros"`
+	TraceExporter            string         `toml:"trace-exporter"`
+	TraceIngestSampleRatio   *float64       `toml:"trace-ingest-sample-ratio"`
+	TraceQuerySampleRatio    *float64       `toml:"trace-query-sample-ratio"`
+	Rules                    []ConfigRule   `toml:"rule"`
+	NameSanitization         string         `toml:"name-sanitization"`
+
+	ingestRules rules.Rules // compiled Rules
 }
 
 type regex struct{ *regexp.Regexp }
@@ -76,15 +111,35 @@ type ConfigDSSpec struct {
 	Heartbeat duration
 	RRAs      []ConfigRRASpec
 }
+
+// An ingest rule, see rules.Rule
+type ConfigRule struct {
+	Action     string
+	Tag        string
+	Match      regex
+	Replace    string
+	Set        map[string]string
+	Components []string
+	Strip      bool
+}
+
 type ConfigRRASpec struct {
 	Function rrd.Consolidation
 	Step     time.Duration
 	Span     time.Duration
 	Xff      float64
+	Season   time.Duration // HWPREDICT only
+	HW       rrd.HWParams  // HWPREDICT only
 }
 
+// An RRA spec is "[wmean|min|max|last:]step:span[:xff]" or
+// "hwpredict:step:span[:season[:param=value,...]]". The latter
+// results in all of the Holt-Winters RRAs (see rrd/holtwinters.go).
 func (r *ConfigRRASpec) UnmarshalText(text []byte) error {
 	r.Xff = 0.5
+	if strings.HasPrefix(strings.ToUpper(string(text)), "HWPREDICT:") {
+		return r.unmarshalHW(text)
+	}
 	parts := strings.SplitN(string(text), ":", 4)
 	if len(parts) < 2 || len(parts) > 4 {
 		return fmt.Errorf("Invalid RRA specification (not enough or too many elements): %q", string(text))
@@ -96,50 +151,103 @@ func (r *ConfigRRASpec) UnmarshalText(text []byte) error {
 		parts = append([]string{"WMEAN"}, parts...)
 	}
 
-	switch strings.ToUpper(parts[0]) {
-	case "WMEAN":
-		r.Function = rrd.WMEAN
-	case "MIN":
-		r.Function = rrd.MIN
-	case "MAX":
-		r.Function = rrd.MAX
-	case "LAST":
-		r.Function = rrd.LAST
-	default:
-		return fmt.Errorf("Invalid consolidation: %q (valid funcs: wmean, min, max, last)", parts[0])
+	cf, err := rrd.ParseConsolidation(parts[0])
+	if err != nil || rrd.IsHoltWinters(cf) {
+		return fmt.Errorf("Invalid consolidation: %q (valid funcs: wmean, min, max, last, hwpredict)", parts[0])
 	}
+	r.Function = cf
 
+	if err := r.parseStepSpan(parts[1], parts[2]); err != nil {
+		return err
+	}
+	if len(parts) == 4 {
+		if r.Xff, err = strconv.ParseFloat(parts[3], 64); err != nil {
+			return fmt.Errorf("Invalid XFF: %q (%v)", parts[3], err)
+		}
+	}
+	return nil
+}
+
+func (r *ConfigRRASpec) parseStepSpan(step, span string) error {
 	var err error
-	if r.Step, err = misc.BetterParseDuration(parts[1]); err != nil {
-		return fmt.Errorf("Invalid Step: %q (%v)", parts[1], err)
+	if r.Step, err = misc.BetterParseDuration(step); err != nil {
+		return fmt.Errorf("Invalid Step: %q (%v)", step, err)
 	}
-	if r.Span, err = misc.BetterParseDuration(parts[2]); err != nil {
-		return fmt.Errorf("Invalid Size: %q (%v)", parts[2], err)
+	if r.Span, err = misc.BetterParseDuration(span); err != nil {
+		return fmt.Errorf("Invalid Size: %q (%v)", span, err)
 	}
 	if (r.Span.Nanoseconds() % r.Step.Nanoseconds()) != 0 {
 		newSpan := time.Duration(r.Span.Nanoseconds()/r.Step.Nanoseconds()*r.Step.Nanoseconds()) * time.Nanosecond
-		log.Printf("Span (%q) is not a multiple of step (%q), auto adjusting span to %v.", parts[2], parts[1], newSpan)
+		logger.Infof("Span (%q) is not a multiple of step (%q), auto adjusting span to %v.", span, step, newSpan)
 		r.Span = newSpan
 		if newSpan.Nanoseconds() == 0 {
 			return fmt.Errorf("invalid Size (%v)", newSpan)
 		}
 	}
-	if len(parts) == 4 {
+	return nil
+}
+
+func (r *ConfigRRASpec) unmarshalHW(text []byte) error {
+	parts := strings.SplitN(string(text), ":", 5)
+	if len(parts) < 3 {
+		return fmt.Errorf("Invalid RRA specification (not enough elements): %q", string(text))
+	}
+	r.Function = rrd.HWPREDICT
+	if err := r.parseStepSpan(parts[1], parts[2]); err != nil {
+		return err
+	}
+
+	r.Season = 24 * time.Hour
+	if len(parts) > 3 {
 		var err error
-		if r.Xff, err = strconv.ParseFloat(parts[3], 64); err != nil {
-			return fmt.Errorf("Invalid XFF: %q (%v)", parts[3], err)
+		if r.Season, err = misc.BetterParseDuration(parts[3]); err != nil {
+			return fmt.Errorf("Invalid Season: %q (%v)", parts[3], err)
+		}
+	}
+	if r.Season < r.Step || (r.Season.Nanoseconds()%r.Step.Nanoseconds()) != 0 {
+		return fmt.Errorf("Invalid Season: %v must be a multiple of step (%v)", r.Season, r.Step)
+	}
+
+	if len(parts) > 4 {
+		for _, kv := range strings.Split(parts[4], ",") {
+			pair := strings.SplitN(kv, "=", 2)
+			if len(pair) != 2 {
+				return fmt.Errorf("Invalid Holt-Winters parameter: %q (expecting name=value)", kv)
+			}
+			name, val := strings.ToLower(strings.TrimSpace(pair[0])), strings.TrimSpace(pair[1])
+			f, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return fmt.Errorf("Invalid Holt-Winters parameter %s value: %q (%v)", name, val, err)
+			}
+			switch name {
+			case "alpha":
+				r.HW.Alpha = f
+			case "beta":
+				r.HW.Beta = f
+			case "gamma":
+				r.HW.Gamma = f
+			case "delta":
+				r.HW.Delta = f
+			case "threshold":
+				r.HW.Threshold = int(f)
+			case "window":
+				r.HW.Window = int(f)
+			default:
+				return fmt.Errorf("Invalid Holt-Winters parameter: %q (valid: alpha, beta, gamma, delta, threshold, window)", name)
+			}
 		}
 	}
+	if r.HW.Window > rrd.MaxHWWindow {
+		return fmt.Errorf("Invalid Holt-Winters window: %d (max %d)", r.HW.Window, rrd.MaxHWWindow)
+	}
+	if r.HW.Threshold > r.HW.Window && r.HW.Window > 0 {
+		return fmt.Errorf("Invalid Holt-Winters threshold: %d exceeds window %d", r.HW.Threshold, r.HW.Window)
+	}
 	return nil
 }
 
 var readConfig = func(cfgPath string) (*Config, error) {
-	cfg := &Config{}
-	_, err := toml.DecodeFile(cfgPath, cfg)
-	if err != nil {
-		return nil, err
-	}
-	return cfg, nil
+	return readConfigFile(cfgPath, os.Getenv) // see overlay.go
 }
 
 func (c *Config) processConfigPidFile(wd string) error {
@@ -177,7 +285,7 @@ func (c *Config) processConfigLogFile(wd string) error {
 		return errors.New(fmt.Sprintf("Unable to create directory: '%s' (%v).", logDir, err))
 	}
 
-	log.Printf("Logs will be written to '%s'.", c.LogPath)
+	logger.Infof("Logs will be written to '%s'.", c.LogPath)
 	return nil
 }
 
@@ -185,13 +293,41 @@ func (c *Config) processConfigLogCycleInterval() error {
 	if c.LogCycle.Duration == 0 {
 		return fmt.Errorf("log-cycle-interval setting empty")
 	}
-	log.Printf("Will cycle logs every %v (log-cycle-interval).", c.LogCycle.Duration)
+	logger.Infof("Will cycle logs every %v (log-cycle-interval).", c.LogCycle.Duration)
 
 	logDir, _ := filepath.Split(c.LogPath)
-	log.Printf("All further status messages will be written to log file(s) in '%s'.", logDir)
+	logger.Infof("All further status messages will be written to log file(s) in '%s'.", logDir)
 	logFileCycler(c.LogPath, c.LogCycle.Duration)
-	log.Print("Server starting.")
+	logger.Infof("Server starting.")
+
+	return nil
+}
 
+func (c *Config) processLogging() error {
+	switch c.LogFormat {
+	case "", "text":
+		logging.SetJSON(false)
+	case "json":
+		logging.SetJSON(true)
+	default:
+		return fmt.Errorf("Invalid log-format: %q (must be text or json)", c.LogFormat)
+	}
+	if c.LogLevel != "" {
+		lv, err := logging.ParseLevel(c.LogLevel)
+		if err != nil {
+			return fmt.Errorf("Invalid log-level: %v", err)
+		}
+		logging.SetDefaultLevel(lv)
+	}
+	for subsystem, level := range c.LogLevels {
+		lv, err := logging.ParseLevel(level)
+		if err != nil {
+			return fmt.Errorf("Invalid log-levels entry for %q: %v", subsystem, err)
+		}
+		if err := logging.SetLevel(subsystem, lv); err != nil {
+			return fmt.Errorf("Invalid log-levels entry: %v", err)
+		}
+	}
 	return nil
 }
 
@@ -202,6 +338,16 @@ func (c *Config) processDbConnectString() error {
 	if c.DbConnectString == "" {
 		return fmt.Errorf("db-connect-string empty")
 	}
+	if c.DbPasswordFile != "" {
+		b, err := ioutil.ReadFile(c.DbPasswordFile)
+		if err != nil {
+			return fmt.Errorf("Unable to read db-password-file: %v", err)
+		}
+		password := strings.TrimRight(string(b), "\r\n")
+		if c.DbConnectString, err = withPassword(c.DbConnectString, password); err != nil {
+			return fmt.Errorf("Invalid db-connect-string: %v", err)
+		}
+	}
 	return nil
 }
 
@@ -209,40 +355,56 @@ func (c *Config) processMinStep() error {
 	if c.MinStep.Duration == 0 {
 		return fmt.Errorf("min-step is missing")
 	} else {
-		log.Printf("Smallest step allowed: %v (min-step).", c.MinStep.Duration)
+		logger.Infof("Smallest step allowed: %v (min-step).", c.MinStep.Duration)
 	}
 	return nil
 }
 
 func (c *Config) processMaxReceiverQueueSize() error {
 	if c.MaxReceiverQueueSize == 0 {
-		log.Printf("max-receiver-queue-size unspecified, defaults to 0 (unlimited)")
+		logger.Infof("max-receiver-queue-size unspecified, defaults to 0 (unlimited)")
 	} else if c.MaxReceiverQueueSize <= 0 {
-		log.Printf("Receiver Queue Size is unlimited (%d) (max-receiver-queue-size).", c.MaxReceiverQueueSize)
+		logger.Infof("Receiver Queue Size is unlimited (%d) (max-receiver-queue-size).", c.MaxReceiverQueueSize)
 	} else {
-		log.Printf("Receiver Queue Size is limited to %d (max-receiver-queue-size).", c.MaxReceiverQueueSize)
+		logger.Infof("Receiver Queue Size is limited to %d (max-receiver-queue-size).", c.MaxReceiverQueueSize)
 	}
 	return nil
 }
 
 func (c *Config) processMaxMemoryBytes() error {
 	if c.MaxMemoryBytes == 0 {
-		log.Printf("max-memory-bytes unspecified, defaults to 0 (unlimited)")
+		logger.Infof("max-memory-bytes unspecified, defaults to 0 (unlimited)")
 	} else if c.MaxMemoryBytes <= 0 {
-		log.Printf("Max Memory (heap allocation bytes) is unlimited (%d) (max-memory-bytes).", c.MaxMemoryBytes)
+		logger.Infof("Max Memory (heap allocation bytes) is unlimited (%d) (max-memory-bytes).", c.MaxMemoryBytes)
 	} else {
-		log.Printf("Max Memory (heap allocation bytes) is limited to %d (max-memory-bytes).", c.MaxMemoryBytes)
+		logger.Infof("Max Memory (heap allocation bytes) is limited to %d (max-memory-bytes).", c.MaxMemoryBytes)
 	}
 	return nil
 }
 
+func (c *Config) processSeriesLimits() error {
+	if c.MaxSeries < 0 || c.MaxSeriesPerPrefix < 0 || c.MaxNewSeriesPerMinute < 0 || c.SeriesPrefixDepth < 0 {
+		return fmt.Errorf("max-series, max-series-per-prefix, max-new-series-per-minute and series-prefix-depth cannot be negative")
+	}
+	if c.SeriesPrefixDepth == 0 {
+		c.SeriesPrefixDepth = receiver.DefaultPrefixDepth
+	}
+	if c.MaxSeries == 0 && c.MaxSeriesPerPrefix == 0 && c.MaxNewSeriesPerMinute == 0 {
+		logger.Infof("Series creation is unlimited (max-series, max-series-per-prefix, max-new-series-per-minute).")
+		return nil
+	}
+	logger.Infof("Series creation is limited to %d total, %d per %d component name prefix, %d per minute (0 is unlimited) (max-series, max-series-per-prefix, series-prefix-depth, max-new-series-per-minute).",
+		c.MaxSeries, c.MaxSeriesPerPrefix, c.SeriesPrefixDepth, c.MaxNewSeriesPerMinute)
+	return nil
+}
+
 func (c *Config) processPgSegmentWidth() error {
 	if c.PgSegmentWidth == 0 {
 		// do nothing and keep quiet about it since this is an "advanced" setting
 	} else if c.PgSegmentWidth <= 0 {
 		return fmt.Errorf("Invalid pg-segment-width: %d", c.PgSegmentWidth)
 	} else {
-		log.Printf("PG Segment Width is %d (pg-segment-width).", c.PgSegmentWidth)
+		logger.Infof("PG Segment Width is %d (pg-segment-width).", c.PgSegmentWidth)
 		serde.PgSegmentWidth = c.PgSegmentWidth
 	}
 	return nil
@@ -252,25 +414,130 @@ func (c *Config) processStatFlushInterval() error {
 	if c.StatFlush.Duration == 0 {
 		return fmt.Errorf("stat-flush-interval is missing")
 	} else {
-		log.Printf("Stats (a la statsd) will be flushed every %v (stat-flush-interval).", c.StatFlush.Duration)
+		logger.Infof("Stats (a la statsd) will be flushed every %v (stat-flush-interval).", c.StatFlush.Duration)
 	}
 	return nil
 }
 
 func (c *Config) processStatsNamePrefix() error {
 	if c.StatsNamePrefix == "" {
-		log.Printf("stats-name-prefix is empty, defaulting to 'stats'")
+		logger.Infof("stats-name-prefix is empty, defaulting to 'stats'")
 		c.StatsNamePrefix = "stats"
 
 	}
 	return nil
 }
 
+func (c *Config) processStatsdCounterAggregation() error {
+	switch strings.ToLower(c.StatsdCounterAggregation) {
+	case "", "rate":
+		statsd.CounterCmd = aggregator.CmdAdd
+	case "ewma":
+		statsd.CounterCmd = aggregator.CmdAddEWMA
+		logger.Infof("Statsd counters will be aggregated as 1m/5m/15m EWMA rates (statsd-counter-aggregation).")
+	case "window":
+		statsd.CounterCmd = aggregator.CmdAddWindow
+		logger.Infof("Statsd counters will be aggregated as sliding window rates (statsd-counter-aggregation).")
+	default:
+		return fmt.Errorf("Invalid statsd-counter-aggregation: %q (valid: rate, ewma, window)", c.StatsdCounterAggregation)
+	}
+	// The series are named by the window in whole seconds
+	seen := make(map[int64]bool, len(c.StatRateWindows))
+	for _, w := range c.StatRateWindows {
+		if w.Duration < time.Second {
+			return fmt.Errorf("Invalid stat-rate-windows: %v (must be at least 1s)", w.Duration)
+		}
+		secs := int64(w.Duration.Seconds())
+		if seen[secs] {
+			return fmt.Errorf("Invalid stat-rate-windows: %v is a duplicate (rate_%d)", w.Duration, secs)
+		}
+		seen[secs] = true
+	}
+	return nil
+}
+
+func (c *Config) processAggregatorShards() error {
+	if c.AggregatorShards < 0 {
+		return fmt.Errorf("Invalid aggregator-shards: %d (must be positive)", c.AggregatorShards)
+	}
+	if c.AggregatorShards == 0 {
+		c.AggregatorShards = receiver.DefaultAggregatorShards
+	}
+	logger.Infof("Statsd aggregation will be split into %d shards.", c.AggregatorShards)
+	return nil
+}
+
+func (c *Config) processClusterCopies() error {
+	if c.ClusterCopies < 0 {
+		return fmt.Errorf("Invalid cluster-copies: %d (must be positive)", c.ClusterCopies)
+	}
+	if c.ClusterCopies == 0 {
+		c.ClusterCopies = 1
+	}
+	if c.ClusterCopies > 1 {
+		logger.Infof("Cluster nodes will keep %d copies of every data source.", c.ClusterCopies)
+	}
+	return nil
+}
+
+func (c *Config) processClusterPlacement() error {
+	p, err := cluster.ParsePlacement(c.ClusterPlacement)
+	if err != nil {
+		return fmt.Errorf("Invalid cluster-placement: %v", err)
+	}
+	logger.Infof("Cluster placement of data sources will be %v.", p)
+	return nil
+}
+
+func (c *Config) processClusterDiscovery() error {
+	if c.ClusterJoinDNS != "" && !strings.HasPrefix(c.ClusterJoinDNS, "_") && strings.Contains(c.ClusterJoinDNS, ":") {
+		if _, port, err := net.SplitHostPort(c.ClusterJoinDNS); err != nil {
+			return fmt.Errorf("Invalid cluster-join-dns: %q (%v)", c.ClusterJoinDNS, err)
+		} else if _, err := strconv.Atoi(port); err != nil {
+			return fmt.Errorf("Invalid cluster-join-dns port: %q", port)
+		}
+	}
+	if c.ClusterDiscoveryInterval == nil { // 0 disables
+		c.ClusterDiscoveryInterval = &duration{30 * time.Second}
+	}
+	if c.ClusterDiscoveryInterval.Duration < 0 {
+		return fmt.Errorf("Invalid cluster-discovery-interval: %v", c.ClusterDiscoveryInterval.Duration)
+	}
+	if d := c.clusterDiscovery(); d != nil {
+		logger.Infof("Cluster members will be discovered via: %v.", d)
+	}
+	return nil
+}
+
+// clusterDiscovery returns the Discoverer for the cluster-join*
+// settings, or nil if there are none.
+func (c *Config) clusterDiscovery() cluster.Discoverer {
+	var md cluster.MultiDiscovery
+	if len(c.ClusterJoin) > 0 {
+		md = append(md, cluster.StaticDiscovery(c.ClusterJoin))
+	}
+	if c.ClusterJoinDNS != "" {
+		dd := &cluster.DNSDiscovery{Name: c.ClusterJoinDNS}
+		if host, port, err := net.SplitHostPort(c.ClusterJoinDNS); err == nil && !strings.HasPrefix(host, "_") {
+			dd.Name = host
+			dd.Port, _ = strconv.Atoi(port)
+		}
+		md = append(md, dd)
+	}
+	if c.ClusterJoinFile != "" {
+		md = append(md, &cluster.FileDiscovery{Path: c.ClusterJoinFile})
+	}
+	if len(md) == 0 {
+		return nil
+	}
+	return md
+}
+
 func (c *Config) processWorkers() error {
 	if c.Workers == 0 {
 		return fmt.Errorf("workers missing, must be an integer")
 	}
-	log.Printf("Number of workers (and flushers) will be %d.", c.Workers)
+	logger.Infof("Number of workers (and flushers) will be %d.", c.Workers)
 	return nil
 }
 
@@ -283,7 +550,7 @@ func (c *Config) processDSSpec() error {
 			}
 			if (rra.Step.Nanoseconds() % ds.Step.Duration.Nanoseconds()) != 0 {
 				newStep := time.Duration(rra.Step.Nanoseconds()/ds.Step.Duration.Nanoseconds()*ds.Step.Duration.Nanoseconds()) * time.Nanosecond
-				log.Printf("DS %q: RRA step (%v) is not a multiple of DS Step (%v), auto adjusting Step to %v.", ds.Regexp.String(), rra.Step, ds.Step.Duration, newStep)
+				logger.Infof("DS %q: RRA step (%v) is not a multiple of DS Step (%v), auto adjusting Step to %v.", ds.Regexp.String(), rra.Step, ds.Step.Duration, newStep)
 				if newStep.Nanoseconds() == 0 {
 					return fmt.Errorf("DS %q: invalid Step (%v)", ds.Regexp.String(), newStep)
 				}
@@ -295,6 +562,82 @@ func (c *Config) processDSSpec() error {
 	return nil
 }
 
+func (c *Config) processRules() error {
+	c.ingestRules = nil
+	for i, cr := range c.Rules {
+		action, err := rules.ParseAction(cr.Action)
+		if err != nil {
+			return fmt.Errorf("rule %d: %v", i+1, err)
+		}
+		r := &rules.Rule{
+			Action:     action,
+			Tag:        cr.Tag,
+			Match:      cr.Match.Regexp,
+			Replace:    cr.Replace,
+			Set:        cr.Set,
+			Components: cr.Components,
+			Strip:      cr.Strip,
+		}
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("rule %d: %v", i+1, err)
+		}
+		c.ingestRules = append(c.ingestRules, r)
+	}
+	if len(c.ingestRules) > 0 {
+		logger.Infof("Applying %d rules to incoming data points (rule).", len(c.ingestRules))
+	}
+	return nil
+}
+
+func (c *Config) processNameSanitization() error {
+	if c.NameSanitization == "" {
+		return nil
+	}
+	policy, err := misc.ParseNamePolicy(c.NameSanitization)
+	if err != nil {
+		return fmt.Errorf("Invalid name-sanitization: %v", err)
+	}
+	misc.SanitizeNamePolicy = policy
+	logger.Infof("Sanitizing metric names with the %v policy (name-sanitization).", policy)
+	return nil
+}
+
+func (c *Config) processDSLMacros() error {
+	for _, def := range c.DSLMacros {
+		macro, err := dsl.DefineMacro(def)
+		if err != nil {
+			return fmt.Errorf("Invalid dsl-macros: %v", err)
+		}
+		logger.Infof("DSL macro defined: %v", macro)
+	}
+	return nil
+}
+
+func (c *Config) processTracing() error {
+	if c.TraceExporter == "" {
+		return nil
+	}
+	for _, r := range []*float64{c.TraceIngestSampleRatio, c.TraceQuerySampleRatio} {
+		if r != nil && (*r < 0 || *r > 1) {
+			return fmt.Errorf("Trace sample ratio must be between 0 and 1, got %v", *r)
+		}
+	}
+	exp, err := tracing.OpenExporter(c.TraceExporter)
+	if err != nil {
+		return fmt.Errorf("Invalid trace-exporter: %v", err)
+	}
+	if c.TraceIngestSampleRatio != nil {
+		receiver.TraceSampleRatio = *c.TraceIngestSampleRatio
+	}
+	if c.TraceQuerySampleRatio != nil {
+		h.RenderTraceSampleRatio = *c.TraceQuerySampleRatio
+	}
+	tracing.SetExporter(exp)
+	logger.Infof("Tracing to %q, sampling %v of data points and %v of queries (trace-exporter).",
+		c.TraceExporter, receiver.TraceSampleRatio, h.RenderTraceSampleRatio)
+	return nil
+}
+
 func (c *Config) FindMatchingDSSpec(ident serde.Ident) *rrd.DSSpec {
 	for _, dsSpec := range c.DSs {
 		name := ident["name"]
@@ -309,20 +652,27 @@ func convertDSSpec(dsSpec *ConfigDSSpec) *rrd.DSSpec {
 	serdeDSSpec := &rrd.DSSpec{
 		Step:      dsSpec.Step.Duration,
 		Heartbeat: dsSpec.Heartbeat.Duration,
-		RRAs:      make([]rrd.RRASpec, len(dsSpec.RRAs)),
-	}
-	for i, r := range dsSpec.RRAs {
-		serdeDSSpec.RRAs[i] = rrd.RRASpec{
+		RRAs:      make([]rrd.RRASpec, 0, len(dsSpec.RRAs)),
+	}
+	for _, r := range dsSpec.RRAs {
+		if r.Function == rrd.HWPREDICT {
+			specs := rrd.HWRRASpecs(r.Step, r.Span, r.Season, r.HW)
+			specs[0].Xff = float32(r.Xff)
+			serdeDSSpec.RRAs = append(serdeDSSpec.RRAs, specs...)
+			continue
+		}
+		serdeDSSpec.RRAs = append(serdeDSSpec.RRAs, rrd.RRASpec{
 			Function: r.Function,
 			Step:     r.Step,
 			Span:     r.Span,
 			Xff:      float32(r.Xff),
-		}
+		})
 	}
 	return serdeDSSpec
 }
 
 type configer interface {
+	processLogging() error
 	processConfigPidFile(string) error
 	processConfigLogFile(string) error
 	processConfigLogCycleInterval() error
@@ -330,15 +680,28 @@ type configer interface {
 	processMinStep() error
 	processMaxReceiverQueueSize() error
 	processMaxMemoryBytes() error
+	processSeriesLimits() error
 	processPgSegmentWidth() error
 	processStatFlushInterval() error
 	processStatsNamePrefix() error
+	processStatsdCounterAggregation() error
+	processAggregatorShards() error
+	processClusterCopies() error
+	processClusterPlacement() error
+	processClusterDiscovery() error
 	processWorkers() error
 	processDSSpec() error
+	processRules() error
+	processNameSanitization() error
+	processDSLMacros() error
+	processTracing() error
 }
 
 var processConfig = func(c configer, wd string) error {
 
+	if err := c.processLogging(); err != nil {
+		return err
+	}
 	if err := c.processConfigPidFile(wd); err != nil {
 		return err
 	}
@@ -360,6 +723,9 @@ var processConfig = func(c configer, wd string) error {
 	if err := c.processMaxMemoryBytes(); err != nil {
 		return err
 	}
+	if err := c.processSeriesLimits(); err != nil {
+		return err
+	}
 	if err := c.processPgSegmentWidth(); err != nil {
 		return err
 	}
@@ -369,11 +735,38 @@ var processConfig = func(c configer, wd string) error {
 	if err := c.processStatsNamePrefix(); err != nil {
 		return err
 	}
+	if err := c.processStatsdCounterAggregation(); err != nil {
+		return err
+	}
+	if err := c.processAggregatorShards(); err != nil {
+		return err
+	}
+	if err := c.processClusterCopies(); err != nil {
+		return err
+	}
+	if err := c.processClusterPlacement(); err != nil {
+		return err
+	}
+	if err := c.processClusterDiscovery(); err != nil {
+		return err
+	}
 	if err := c.processWorkers(); err != nil {
 		return err
 	}
 	if err := c.processDSSpec(); err != nil {
 		return err
 	}
+	if err := c.processRules(); err != nil {
+		return err
+	}
+	if err := c.processNameSanitization(); err != nil {
+		return err
+	}
+	if err := c.processDSLMacros(); err != nil {
+		return err
+	}
+	if err := c.processTracing(); err != nil {
+		return err
+	}
 	return nil
 }
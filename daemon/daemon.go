@@ -34,6 +34,7 @@ import (
 	"github.com/tgres/tgres/dsl"
 	"github.com/tgres/tgres/receiver"
 	"github.com/tgres/tgres/serde"
+	"github.com/tgres/tgres/tracing"
 )
 
 var (
@@ -83,9 +84,17 @@ var determineClusterBindAddress = func(db serde.DbAddresser) (bindAddr, advAddr
 	return
 }
 
-var determineClusterJoinAddress = func(join string, db serde.DbAddresser) (ips []string, err error) {
+// The -join flag takes precedence over the discovery configured in the
+// config file, which in turn takes precedence over inferring peers
+// from database clients (which doesn't work behind a connection
+// pooler such as pgbouncer).
+var determineClusterJoinAddress = func(join string, disc cluster.Discoverer, db serde.DbAddresser) (ips []string, err error) {
 	if join != "" {
 		ips = strings.Split(join, ",")
+	} else if disc != nil {
+		if ips, err = disc.Discover(); err != nil {
+			return nil, err
+		}
 	} else if os.Getenv("TGRES_ADDRFROMDB") != "" {
 		if ips, err = db.ListDbClientIps(); err != nil {
 			return nil, err
@@ -112,8 +121,19 @@ var createReceiver = func(cfg *Config, c *cluster.Cluster, db serde.SerDe) *rece
 	r.MinStep = cfg.MinStep.Duration
 	r.StatFlushDuration = cfg.StatFlush.Duration
 	r.StatsNamePrefix = cfg.StatsNamePrefix
+	for _, w := range cfg.StatRateWindows {
+		r.StatRateWindows = append(r.StatRateWindows, w.Duration)
+	}
+	r.AggregatorShards = cfg.AggregatorShards
 	r.MaxReceiverQueueSize = cfg.MaxReceiverQueueSize
 	r.MaxMemoryBytes = uint64(cfg.MaxMemoryBytes)
+	r.Rules = cfg.ingestRules
+	r.SeriesLimits = receiver.SeriesLimits{
+		MaxSeries:          cfg.MaxSeries,
+		MaxSeriesPerPrefix: cfg.MaxSeriesPerPrefix,
+		MaxNewPerMinute:    cfg.MaxNewSeriesPerMinute,
+		PrefixDepth:        cfg.SeriesPrefixDepth,
+	}
 	r.ReportStats = true
 	r.NWorkers = cfg.Workers
 	r.SetCluster(c)
@@ -130,7 +150,7 @@ var waitForSignal = func(r *receiver.Receiver, sm *serviceManager, cfgPath, join
 		ch := make(chan os.Signal)
 		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 		s := <-ch
-		log.Printf("Got signal: %v", s)
+		logger.Infof("Got signal: %v", s)
 		if s == syscall.SIGHUP {
 			if gracefulChildPid == 0 {
 				gracefulRestart(r, sm, cfgPath, join)
@@ -144,49 +164,50 @@ var waitForSignal = func(r *receiver.Receiver, sm *serviceManager, cfgPath, join
 
 func Init(cfgPath, gracefulProtos, join string) (cfg *Config) { // not to be confused with init()
 
-	log.Printf("Tgres starting.")
+	logger.Infof("Tgres starting.")
 
 	// Read the config
 	cfg, err := readConfig(cfgPath)
 	if err != nil {
-		log.Printf("Unable to read config %q, exiting: %s.", cfgPath, err)
+		logger.Errorf("Unable to read config %q, exiting: %s.", cfgPath, err)
 		return
 	}
-	log.Printf("Using config file: '%s'.", cfgPath)
+	logger.Infof("Using config file: '%s'.", cfgPath)
 
 	// Get current directory
 	wd := getCwd() // a separate function for testability
 	if wd == "" {
-		log.Printf("WARNING: Could not determine current working directory, this only works if all paths in config are absolute.")
+		logger.Warnf("Could not determine current working directory, this only works if all paths in config are absolute.")
 	}
 
 	// Validate the configuration
 	if err := processConfig(cfg, wd); err != nil { // This validates the config
-		log.Printf("Error in config file %s, exiting: %v", cfgPath, err)
+		logger.Errorf("Error in config file %s, exiting: %v", cfgPath, err)
 		return
 	}
 
 	// Connect to the DB (and create tables if needed, etc)
 	db, err := initDb(cfg.DbConnectString)
 	if err != nil {
-		log.Printf("Error connecting to the DB, exiting: %v", err)
+		logger.Errorf("Error connecting to the DB, exiting: %v", err)
 		return
 	}
-	log.Printf("Initialized DB connection.")
+	logger.Infof("Initialized DB connection.")
 
 	// Determine cluster bind address
 	var bindAddr, advAddr string
 	bindAddr, advAddr, err = determineClusterBindAddress(db.DbAddresser())
 	if err != nil {
-		log.Printf("Cannot determine cluster bind / advertise addresses, exiting: %v", err)
+		logger.Errorf("Cannot determine cluster bind / advertise addresses, exiting: %v", err)
 		return
 	}
 
 	// Determine ips of other nodes to join
 	var joinIps []string
-	joinIps, err = determineClusterJoinAddress(join, db.DbAddresser())
+	disc := cfg.clusterDiscovery()
+	joinIps, err = determineClusterJoinAddress(join, disc, db.DbAddresser())
 	if err != nil {
-		log.Printf("Cannot determine cluster node addresses to join, exiting: %v", err)
+		logger.Errorf("Cannot determine cluster node addresses to join, exiting: %v", err)
 		return
 	}
 
@@ -199,7 +220,7 @@ func Init(cfgPath, gracefulProtos, join string) (cfg *Config) { // not to be con
 
 	// Is there a blaster?
 	if os.Getenv("TGRES_BLASTER") != "" {
-		log.Printf("Creating a blaster instance.")
+		logger.Infof("Creating a blaster instance.")
 		// As created the blaster is idle, it sends no points.
 		rcvr.Blaster = blaster.New(rcvr)
 	}
@@ -208,7 +229,7 @@ func Init(cfgPath, gracefulProtos, join string) (cfg *Config) { // not to be con
 	rcache := dsl.NewNamedDSFetcher(db.Fetcher(), rcvr.DsCache(), cfg.QueryCacheSize)
 	serviceMgr := newServiceManager(rcvr, rcache, cfg)
 	if err := serviceMgr.run(gracefulProtos); err != nil {
-		log.Printf("Could not run the service manager: %v", err)
+		logger.Errorf("Could not run the service manager: %v", err)
 		return
 	}
 
@@ -217,9 +238,9 @@ func Init(cfgPath, gracefulProtos, join string) (cfg *Config) { // not to be con
 
 	// Might as well populate the rcache here
 	if db.Fetcher() != nil {
-		log.Printf("Pre-populating Named DS Fetcher...")
+		logger.Infof("Pre-populating Named DS Fetcher...")
 		rcache.Preload()
-		log.Printf("Pre-populating Named DS Fetcher DONE.")
+		logger.Infof("Pre-populating Named DS Fetcher DONE.")
 	}
 
 	// Handle graceful file descriptors
@@ -229,17 +250,17 @@ func Init(cfgPath, gracefulProtos, join string) (cfg *Config) { // not to be con
 		// flushed correctly, at which point it is OK for us to
 		// start the receiver.
 
-		log.Printf("start(): All listeners are listening.")
+		logger.Infof("start(): All listeners are listening.")
 		parent := syscall.Getppid()
-		log.Printf("start(): Killing parent pid: %v", parent)
+		logger.Infof("start(): Killing parent pid: %v", parent)
 		syscall.Kill(parent, syscall.SIGTERM)
-		log.Printf("start(): Waiting for the parent to signal that flush is complete...")
+		logger.Infof("start(): Waiting for the parent to signal that flush is complete...")
 		ch := make(chan os.Signal)
 		signal.Notify(ch, syscall.SIGUSR1)
 		s := <-ch
-		log.Printf("start(): Received %v, proceeding to load the data", s)
+		logger.Infof("start(): Received %v, proceeding to load the data", s)
 	} else {
-		log.Printf("start(): Proceeding with initialization.") // i.e. this is not graceful
+		logger.Infof("start(): Proceeding with initialization.") // i.e. this is not graceful
 	}
 
 	// Initialize cluster
@@ -253,7 +274,7 @@ func Init(cfgPath, gracefulProtos, join string) (cfg *Config) { // not to be con
 		c, err = initCluster(bindAddr, advAddr, joinIps)
 		if err != nil {
 			if i > 1 { // silence the first message
-				log.Printf("Error initializing cluster, will try again in %v (up to %v times): %v", clusterPause, attempts, err)
+				logger.Warnf("Error initializing cluster, will try again in %v (up to %v times): %v", clusterPause, attempts, err)
 			}
 			time.Sleep(clusterPause)
 			continue
@@ -261,31 +282,42 @@ func Init(cfgPath, gracefulProtos, join string) (cfg *Config) { // not to be con
 		break
 	}
 	if err != nil {
-		log.Printf("Error initializing cluster, giving up and exiting: %v", err)
+		logger.Errorf("Error initializing cluster, giving up and exiting: %v", err)
 		return
 	} else {
-		log.Printf("Cluster initialized")
+		logger.Infof("Cluster initialized")
+	}
+	if c != nil {
+		if cfg.ClusterCopies > 0 {
+			c.Copies(cfg.ClusterCopies)
+		}
+		p, _ := cluster.ParsePlacement(cfg.ClusterPlacement) // validated in processClusterPlacement()
+		c.Placement(p)
+		if disc != nil && join == "" {
+			// Runs for the life of the process
+			c.WatchDiscovery(disc, cfg.ClusterDiscoveryInterval.Duration, nil)
+		}
 	}
 	rcvr.SetCluster(c)
 
 	// Save PID (by now the graceful parent pid can be overwritten)
 	if err := savePid(cfg.PidPath); err != nil {
 		// This is not good, but isn't fatal
-		log.Printf("WARNING: Unable to create pid file '%s', exiting: (%v)", cfg.PidPath, err)
+		logger.Warnf("Unable to create pid file '%s': %v", cfg.PidPath, err)
 	} else {
-		log.Printf("Pid saved in %q.", cfg.PidPath)
+		logger.Infof("Pid saved in %q.", cfg.PidPath)
 	}
 
 	// *finally* start the receiver (because graceful restart, parent must save data first)
 	startReceiver(rcvr)
-	log.Printf("Receiver started, Tgres is ready.")
+	logger.Infof("Receiver started, Tgres is ready.")
 
 	// start the rcache warmup
 	if cfg.QueryCacheSize > 0 {
 		go func() {
-			log.Printf("Starting the query cache warm up...")
+			logger.Infof("Starting the query cache warm up...")
 			rcache.Warmup()
-			log.Printf("Query cache warm up done.")
+			logger.Infof("Query cache warm up done.")
 			rcache.StartStateSaver() // it starts a goroutine
 		}()
 	}
@@ -322,11 +354,11 @@ var checkRemovePid = func(pidPath string) bool {
 }
 
 func Finish(cfg *Config) {
-	log.Printf("main: Waiting for all other goroutines to finish...")
-	log.Println("main: All goroutines finished, exiting.")
+	logger.Infof("main: Waiting for all other goroutines to finish...")
+	logger.Infof("main: All goroutines finished, exiting.")
 
 	if checkRemovePid(cfg.PidPath) {
-		log.Printf("Removed pid-file %q", cfg.PidPath)
+		logger.Infof("Removed pid-file %q", cfg.PidPath)
 	}
 
 	// Close log
@@ -339,12 +371,12 @@ func Finish(cfg *Config) {
 func gracefulRestart(rcvr *receiver.Receiver, serviceMgr *serviceManager, cfgPath, join string) {
 
 	if !filepath.IsAbs(os.Args[0]) {
-		log.Printf("ERROR: Graceful restart only possible when %q started with absolute path, ignoring this request.", os.Args[0])
+		logger.Errorf("Graceful restart only possible when %q started with absolute path, ignoring this request.", os.Args[0])
 		return
 	}
 
 	files, protos := serviceMgr.listenerFilesAndProtocols()
-	log.Printf("gracefulRestart(): Beginning graceful restart with sockets: %v and protos: %q", files, protos)
+	logger.Infof("gracefulRestart(): Beginning graceful restart with sockets: %v and protos: %q", files, protos)
 
 	mypath, _ := filepath.Abs(os.Args[0]) // TODO we should really be the starting working directory
 	args := []string{
@@ -365,10 +397,10 @@ func gracefulRestart(rcvr *receiver.Receiver, serviceMgr *serviceManager, cfgPat
 
 	err := cmd.Start()
 	if err != nil {
-		log.Printf("gracefulRestart(): Failed to launch, error: %v", err)
+		logger.Errorf("gracefulRestart(): Failed to launch, error: %v", err)
 	} else {
 		gracefulChildPid = cmd.Process.Pid
-		log.Printf("gracefulRestart(): Forked child, waiting to be killed...")
+		logger.Infof("gracefulRestart(): Forked child, waiting to be killed...")
 	}
 
 	// The new process will kill -TERM us when it's ready to accept
@@ -378,21 +410,21 @@ func gracefulRestart(rcvr *receiver.Receiver, serviceMgr *serviceManager, cfgPat
 
 func gracefulExit(rcvr *receiver.Receiver, serviceMgr *serviceManager) {
 
-	log.Printf("Gracefully exiting...")
+	logger.Infof("Gracefully exiting...")
 
 	// TODO We need to rethink how this works in a clustered
 	// setup. After closeListeners TCP connections are not accepted,
 	// but other nodes do not yet know we're leaving and will continue
 	// forwarding to us.
 
-	log.Printf("Closing TCP Listeners...")
+	logger.Infof("Closing TCP Listeners...")
 	serviceMgr.closeListeners(true) // TODO: do we really need this flag?
-	log.Printf("TCP listeners closed.")
+	logger.Infof("TCP listeners closed.")
 
 	// Wait for receiver to be drained.
-	log.Printf("Draining receiver channel...")
+	logger.Infof("Draining receiver channel...")
 	rcvr.Drain()
-	log.Printf("Receiver channel drained.")
+	logger.Infof("Receiver channel drained.")
 
 	// Triggers a transition and flush to vcache
 	rcvr.ClusterReady(false)
@@ -404,6 +436,10 @@ func gracefulExit(rcvr *receiver.Receiver, serviceMgr *serviceManager) {
 	// director loop.
 	rcvr.Stop()
 
+	if err := tracing.Shutdown(); err != nil {
+		logger.Errorf("Error closing trace exporter: %v", err)
+	}
+
 	if gracefulChildPid != 0 {
 		// let the child know the data is flushed
 		syscall.Kill(gracefulChildPid, syscall.SIGUSR1)
@@ -61,7 +61,7 @@ func Test_Init(t *testing.T) {
 
 	// determineClusterJoinAddress
 	save_determineClusterJoinAddress := determineClusterJoinAddress
-	determineClusterJoinAddress = func(join string, db serde.DbAddresser) (ips []string, err error) {
+	determineClusterJoinAddress = func(join string, disc cluster.Discoverer, db serde.DbAddresser) (ips []string, err error) {
 		return ips, err
 	}
 
@@ -19,7 +19,6 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"log"
 	"net"
 	"os"
 	"strings"
@@ -51,7 +50,7 @@ func (g *graphitePickleServiceManager) Stop() {
 		return
 	}
 	if g.listener != nil {
-		log.Printf("Closing listener %s\n", g.listenSpec)
+		logger.Infof("Closing listener %s\n", g.listenSpec)
 		g.listener.Close()
 	}
 	atomic.StoreInt32(&(g.stop), 1)
@@ -74,7 +73,7 @@ func (g *graphitePickleServiceManager) Start(file *os.File) error {
 			gl, err = net.Listen("tcp", processListenSpec(g.listenSpec))
 		}
 	} else {
-		log.Printf("Not starting Graphite Pickle Protocol because graphite-pickle-listen-spec is blank.")
+		logger.Infof("Not starting Graphite Pickle Protocol because graphite-pickle-listen-spec is blank.")
 		return nil
 	}
 
@@ -84,7 +83,7 @@ func (g *graphitePickleServiceManager) Start(file *os.File) error {
 
 	g.listener = graceful.NewListener(gl)
 
-	log.Printf("Graphite Pickle protocol Listening on %s\n", processListenSpec(g.listenSpec))
+	logger.Infof("Graphite Pickle protocol Listening on %s\n", processListenSpec(g.listenSpec))
 
 	go g.graphitePickleServer()
 
@@ -110,7 +109,7 @@ func (g *graphitePickleServiceManager) graphitePickleServer() error {
 				if max := 1 * time.Second; tempDelay > max {
 					tempDelay = max
 				}
-				log.Printf("Accept error: %v; retrying in %v", err, tempDelay)
+				logger.Errorf("Accept error: %v; retrying in %v", err, tempDelay)
 				time.Sleep(tempDelay)
 				continue
 			}
@@ -183,7 +182,9 @@ func (g *graphitePickleServiceManager) handleGraphitePickleProtocol(conn net.Con
 							}
 						}
 					}
-					g.rcvr.QueueDataPoint(serde.Ident{"name": name}, time.Unix(tstamp, 0), value)
+					if ident, ok := g.rcvr.ApplyRules(serde.Ident{"name": name}); ok {
+						g.rcvr.QueueDataPoint(ident, time.Unix(tstamp, 0), value)
+					}
 				} else {
 					err = fmt.Errorf("dp wrong length: %d", len(dp))
 					break
@@ -204,7 +205,7 @@ func (g *graphitePickleServiceManager) handleGraphitePickleProtocol(conn net.Con
 
 	if err != nil {
 		if !strings.Contains(err.Error(), "use of closed") {
-			log.Println("handleGraphitePickleProtocol(): Error reading: %v", err.Error())
+			logger.Errorf("handleGraphitePickleProtocol(): Error reading: %v", err.Error())
 		}
 	}
 }
@@ -18,7 +18,6 @@ package daemon
 import (
 	"bufio"
 	"fmt"
-	"log"
 	"net"
 	"os"
 	"strings"
@@ -50,11 +49,11 @@ func (g *graphiteTextServiceManager) Stop() {
 		return
 	}
 	if g.conn != nil {
-		log.Printf("Closing UDP listener %s", g.listenSpec)
+		logger.Infof("Closing UDP listener %s", g.listenSpec)
 		g.conn.Close()
 	}
 	if g.listener != nil {
-		log.Printf("Closing TCP listener %s", g.listenSpec)
+		logger.Infof("Closing TCP listener %s", g.listenSpec)
 		g.listener.Close()
 	}
 	atomic.StoreInt32(&(g.stop), 1)
@@ -99,7 +98,7 @@ func (g *graphiteTextServiceManager) startUDP(file *os.File) error {
 			}
 		}
 	} else {
-		log.Printf("Not starting Graphite UDP protocol because graphite-udp-listen-spec is blank.")
+		logger.Infof("Not starting Graphite UDP protocol because graphite-udp-listen-spec is blank.")
 		return nil
 	}
 	if err != nil {
@@ -127,7 +126,7 @@ func (g *graphiteTextServiceManager) startTCP(file *os.File) error {
 			gl, err = net.Listen("tcp", processListenSpec(g.listenSpec))
 		}
 	} else {
-		log.Printf("Not starting Graphite Text protocol because graphite-text-listen-spec is blank")
+		logger.Infof("Not starting Graphite Text protocol because graphite-text-listen-spec is blank")
 		return nil
 	}
 
@@ -164,7 +163,7 @@ func (g *graphiteTextServiceManager) graphiteTCPTextServer() error {
 				if max := 1 * time.Second; tempDelay > max {
 					tempDelay = max
 				}
-				log.Printf("graphiteTCPTextServer(): Accept error: %v; retrying in %v", err, tempDelay)
+				logger.Errorf("graphiteTCPTextServer(): Accept error: %v; retrying in %v", err, tempDelay)
 				time.Sleep(tempDelay)
 				continue
 			}
@@ -191,9 +190,11 @@ func (g *graphiteTextServiceManager) handleGraphiteTextProtocol(conn net.Conn) {
 		packetStr := connbuf.Text()
 
 		if name, ts, v, err := parseGraphitePacket(packetStr); err != nil {
-			log.Printf("handleGraphiteTextProtocol(): bad backet: %v")
+			logger.Warnf("handleGraphiteTextProtocol(): bad backet: %v", err)
 		} else {
-			g.rcvr.QueueDataPoint(serde.Ident{"name": name}, ts, v)
+			if ident, ok := g.rcvr.ApplyRules(serde.Ident{"name": name}); ok {
+				g.rcvr.QueueDataPoint(ident, ts, v)
+			}
 		}
 
 		if g.timeout != 0 {
@@ -207,7 +208,7 @@ func (g *graphiteTextServiceManager) handleGraphiteTextProtocol(conn net.Conn) {
 
 	if err := connbuf.Err(); err != nil {
 		if !strings.Contains(err.Error(), "use of closed") {
-			log.Println("handleGraphiteTextProtocol(): Error reading: %v", err)
+			logger.Errorf("handleGraphiteTextProtocol(): Error reading: %v", err)
 		}
 	}
 }
@@ -17,11 +17,11 @@ package daemon
 
 import (
 	"fmt"
-	"log"
 	"net"
 	"net/http"
 	"os"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/tgres/tgres/blaster"
@@ -35,6 +35,7 @@ func httpServer(addr string, l net.Listener, rcvr *receiver.Receiver, rcache dsl
 
 	// Not sure why, but we need both trailing slash and not versions. It has
 	// something to do with whether you use Grafana direct or proxy modes.
+	http.HandleFunc("/metrics", h.MetricsHandler(rcvr))
 	http.HandleFunc("/metrics/find", setOriginHdr(h.GraphiteMetricsFindHandler(rcache), origHdr))
 	http.HandleFunc("/metrics/find/", setOriginHdr(h.GraphiteMetricsFindHandler(rcache), origHdr))
 	http.HandleFunc("/render", setOriginHdr(h.GraphiteRenderHandler(rcache), origHdr))
@@ -42,10 +43,35 @@ func httpServer(addr string, l net.Listener, rcvr *receiver.Receiver, rcache dsl
 	http.HandleFunc("/events/get_data", setOriginHdr(h.GraphiteAnnotationsHandler(rcache), origHdr))
 	http.HandleFunc("/events/get_data/", setOriginHdr(h.GraphiteAnnotationsHandler(rcache), origHdr))
 
+	http.HandleFunc("/macros", setOriginHdr(h.MacrosHandler(), origHdr))
+	http.HandleFunc("/loglevel", h.LogLevelHandler())
+
+	http.HandleFunc("/cluster", h.ClusterHandler(rcvr))
+	http.HandleFunc("/cluster/assignments", h.ClusterAssignmentsHandler(rcvr))
+	for _, action := range []string{"drain", "ready", "transition"} {
+		http.HandleFunc("/cluster/"+action, h.ClusterActionHandler(rcvr, action, nil))
+	}
+	// Leaving is the same as being told to exit, see waitForSignal()
+	http.HandleFunc("/cluster/leave", h.ClusterActionHandler(rcvr, "leave", func() {
+		syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	}))
+
+	http.HandleFunc("/backfill", h.BackfillHandler(rcvr))
+
+	http.HandleFunc("/series/inspect", h.SeriesInspectHandler(rcvr))
+	http.HandleFunc("/series/search", h.SeriesSearchHandler(rcvr))
+	http.HandleFunc("/series/delete", h.SeriesDeleteHandler(rcvr))
+	http.HandleFunc("/series/rename", h.SeriesRenameHandler(rcvr))
+	http.HandleFunc("/series/cardinality", h.SeriesCardinalityHandler(rcvr))
+
+	http.HandleFunc("/health/live", h.HealthLiveHandler(rcvr))
+	http.HandleFunc("/health/ready", h.HealthReadyHandler(rcvr))
 	http.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) { fmt.Fprintf(w, "OK\n") })
 
 	http.HandleFunc("/pixel", h.PixelHandler(rcvr))
 	http.HandleFunc("/pixel/add", h.PixelAddHandler(rcvr))
+	http.HandleFunc("/pixel/addewma", h.PixelAddEWMAHandler(rcvr))
+	http.HandleFunc("/pixel/addwindow", h.PixelAddWindowHandler(rcvr))
 	http.HandleFunc("/pixel/addgauge", h.PixelAddGaugeHandler(rcvr))
 	http.HandleFunc("/pixel/setgauge", h.PixelSetGaugeHandler(rcvr))
 	http.HandleFunc("/pixel/append", h.PixelAppendHandler(rcvr))
@@ -84,7 +110,7 @@ func (g *wwwServer) Stop() {
 		return
 	}
 	if g.listener != nil {
-		log.Printf("Closing listener %s\n", g.listenSpec)
+		logger.Infof("Closing listener %s\n", g.listenSpec)
 		g.listener.Close()
 	}
 	atomic.StoreInt32(&(g.stop), 1)
@@ -107,7 +133,7 @@ func (g *wwwServer) Start(file *os.File) error {
 			gl, err = net.Listen("tcp", processListenSpec(g.listenSpec))
 		}
 	} else {
-		log.Printf("Not starting HTTP server because http-listen-spec is blank.")
+		logger.Infof("Not starting HTTP server because http-listen-spec is blank.")
 		return nil
 	}
 
@@ -118,7 +144,7 @@ func (g *wwwServer) Start(file *os.File) error {
 
 	g.listener = graceful.NewListener(gl)
 
-	log.Printf("HTTP protocol Listening on %s\n", processListenSpec(g.listenSpec))
+	logger.Infof("HTTP protocol Listening on %s\n", processListenSpec(g.listenSpec))
 
 	go httpServer(g.listenSpec, g.listener, g.rcvr, g.rcache, g.originHdr)
 
@@ -21,8 +21,12 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+
+	"github.com/tgres/tgres/logging"
 )
 
+var logger = logging.New("daemon")
+
 func init() {
 	log.SetPrefix(fmt.Sprintf("[%d] ", os.Getpid()))
 }
@@ -39,7 +43,7 @@ var renameLogFile = func(logPath string) {
 	logDir, logFile := filepath.Split(logPath)
 	filename := timeNow().Format(logFile + "-20060102_150405")
 	fullpath := filepath.Join(logDir, filename)
-	log.Printf("Starting new log file, current log archived as: '%s'", fullpath)
+	logger.Infof("Starting new log file, current log archived as: '%s'", fullpath)
 	osRename(logPath, fullpath)
 }
 
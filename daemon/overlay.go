@@ -0,0 +1,216 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Configuration overlays. After the config file, the *.toml files in
+// the include-dir (conf.d next to the config file by default) are read
+// in lexical order, a setting in a later file replaces an earlier
+// one, except that tables (e.g. log-levels) are merged. Then every
+// setting can be overridden by a TGRES_<KEY> environment variable,
+// e.g. TGRES_MAX_SERIES for max-series, or read from the file named by
+// TGRES_<KEY>_FILE, which is handy for secrets.
+
+const defaultIncludeDir = "conf.d"
+
+type configField struct {
+	index int
+	key   string // toml key
+}
+
+// envName returns the name of the environment variable overriding
+// this field.
+func (f configField) envName() string {
+	return "TGRES_" + strings.ToUpper(strings.Replace(f.key, "-", "_", -1))
+}
+
+// configFields lists the settable fields of Config.
+func configFields() []configField {
+	t := reflect.TypeOf(Config{})
+	result := make([]configField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" { // unexported
+			continue
+		}
+		key := f.Tag.Get("toml")
+		if key == "" {
+			key = strings.ToLower(f.Name)
+		}
+		result = append(result, configField{index: i, key: key})
+	}
+	return result
+}
+
+// readConfigFile reads the config file followed by the include-dir
+// and environment overrides.
+func readConfigFile(cfgPath string, getenv func(string) string) (*Config, error) {
+	cfg := &Config{}
+	if _, err := toml.DecodeFile(cfgPath, cfg); err != nil {
+		return nil, err
+	}
+
+	dir := cfg.IncludeDir
+	if s := getenv("TGRES_INCLUDE_DIR"); s != "" {
+		dir = s
+	}
+	if dir == "" {
+		dir = defaultIncludeDir
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(cfgPath), dir)
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.toml"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths { // Glob sorts
+		if err := cfg.mergeFile(path); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		logger.Infof("Using config file: '%s' (include-dir).", path)
+	}
+
+	if err := cfg.applyEnv(getenv); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// mergeFile overlays the settings present in the file at path.
+func (c *Config) mergeFile(path string) error {
+	var over Config
+	md, err := toml.DecodeFile(path, &over)
+	if err != nil {
+		return err
+	}
+	dst, src := reflect.ValueOf(c).Elem(), reflect.ValueOf(&over).Elem()
+	for _, f := range configFields() {
+		if !md.IsDefined(f.key) {
+			continue
+		}
+		d, s := dst.Field(f.index), src.Field(f.index)
+		if d.Kind() == reflect.Map && !d.IsNil() {
+			for _, k := range s.MapKeys() {
+				d.SetMapIndex(k, s.MapIndex(k))
+			}
+		} else {
+			d.Set(s)
+		}
+	}
+	return nil
+}
+
+// applyEnv overrides settings from TGRES_<KEY> or the file named by
+// TGRES_<KEY>_FILE (with trailing newlines removed). Empty variables
+// are ignored.
+func (c *Config) applyEnv(getenv func(string) string) error {
+	v := reflect.ValueOf(c).Elem()
+	for _, f := range configFields() {
+		name := f.envName()
+		val := getenv(name)
+		if val == "" {
+			path := getenv(name + "_FILE")
+			if path == "" {
+				continue
+			}
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("%s_FILE: %v", name, err)
+			}
+			val = strings.TrimRight(string(b), "\r\n")
+			name += "_FILE"
+		}
+		fv, err := parseConfigValue(f, val)
+		if err != nil {
+			return fmt.Errorf("Invalid %s: %v", name, err)
+		}
+		v.Field(f.index).Set(fv)
+	}
+	return nil
+}
+
+// parseConfigValue parses val as the TOML value of the field. Strings
+// need not be quoted, nor do the elements of comma-separated lists,
+// e.g. TGRES_CLUSTER_JOIN=10.0.0.1,10.0.0.2.
+func parseConfigValue(f configField, val string) (reflect.Value, error) {
+	kind := reflect.TypeOf(Config{}).Field(f.index).Type.Kind()
+
+	var candidates []string
+	if kind != reflect.String {
+		candidates = append(candidates, val)
+	}
+	if kind == reflect.Slice && !strings.HasPrefix(strings.TrimSpace(val), "[") {
+		items := strings.Split(val, ",")
+		for i, item := range items {
+			items[i] = tomlString(strings.TrimSpace(item))
+		}
+		candidates = append(candidates, "["+strings.Join(items, ", ")+"]")
+	} else {
+		candidates = append(candidates, tomlString(val))
+	}
+
+	var firstErr error
+	for _, cand := range candidates {
+		var tmp Config
+		if _, err := toml.Decode(f.key+" = "+cand, &tmp); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		return reflect.ValueOf(tmp).Field(f.index), nil
+	}
+	return reflect.Value{}, firstErr
+}
+
+// tomlString quotes s as a TOML basic string, whose escapes are a
+// superset of JSON's.
+func tomlString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// withPassword returns the connect string with the password added,
+// connect can be a URL or key=value pairs, see lib/pq.
+func withPassword(connect, password string) (string, error) {
+	if strings.HasPrefix(connect, "postgres://") || strings.HasPrefix(connect, "postgresql://") {
+		u, err := url.Parse(connect)
+		if err != nil {
+			return "", err
+		}
+		var user string
+		if u.User != nil {
+			user = u.User.Username()
+		}
+		u.User = url.UserPassword(user, password)
+		return u.String(), nil
+	}
+	password = strings.Replace(password, `\`, `\\`, -1)
+	password = strings.Replace(password, `'`, `\'`, -1)
+	return fmt.Sprintf("%s password='%s'", connect, password), nil
+}
@@ -16,7 +16,6 @@
 package daemon
 
 import (
-	"log"
 	"os"
 	"strings"
 	"time"
@@ -72,7 +71,7 @@ func (r *serviceManager) run(gracefulProtos string) error {
 	} else {
 
 		protos := strings.Split(gracefulProtos, ",")
-		log.Printf("Reusing file descriptors for graceful protocols: %v", protos)
+		logger.Infof("Reusing file descriptors for graceful protocols: %v", protos)
 
 		for n, p := range protos {
 			f := os.NewFile(uintptr(n+3), "")
@@ -103,7 +102,7 @@ func (r *serviceManager) closeListeners(wait bool) {
 		service.Stop()
 	}
 	if wait {
-		log.Printf("Waiting for graceful.TcpWg...")
+		logger.Infof("Waiting for graceful.TcpWg...")
 		graceful.TcpWg.Wait()
 	}
 }
@@ -18,7 +18,6 @@ package daemon
 import (
 	"bufio"
 	"fmt"
-	"log"
 	"net"
 	"os"
 	"strings"
@@ -49,11 +48,11 @@ func (g *statsdTextServiceManager) Stop() {
 		return
 	}
 	if g.conn != nil {
-		log.Printf("Closing UDP listener %s", g.listenSpec)
+		logger.Infof("Closing UDP listener %s", g.listenSpec)
 		g.conn.Close()
 	}
 	if g.listener != nil {
-		log.Printf("Closing TCP listener %s", g.listenSpec)
+		logger.Infof("Closing TCP listener %s", g.listenSpec)
 		g.listener.Close()
 	}
 	atomic.StoreInt32(&(g.stop), 1)
@@ -98,14 +97,14 @@ func (g *statsdTextServiceManager) startUDP(file *os.File) error {
 			}
 		}
 	} else {
-		log.Printf("Not starting Statsd UDP protocol because statsd-udp-listen-spec is blank.")
+		logger.Infof("Not starting Statsd UDP protocol because statsd-udp-listen-spec is blank.")
 		return nil
 	}
 	if err != nil {
 		return fmt.Errorf("Error starting Statsd UDP Text Protocol serviceManager: %v", err)
 	}
 
-	log.Printf("Statsd UDP protocol Listening on %s\n", processListenSpec(g.listenSpec))
+	logger.Infof("Statsd UDP protocol Listening on %s\n", processListenSpec(g.listenSpec))
 
 	// for UDP timeout must be 0
 	go g.handleStatsdTextProtocol(g.conn)
@@ -126,7 +125,7 @@ func (g *statsdTextServiceManager) startTCP(file *os.File) error {
 			gl, err = net.Listen("tcp", processListenSpec(g.listenSpec))
 		}
 	} else {
-		log.Printf("Not starting Statsd TCP protocol because statsd-text-listen-spec is blank")
+		logger.Infof("Not starting Statsd TCP protocol because statsd-text-listen-spec is blank")
 		return nil
 	}
 
@@ -160,7 +159,7 @@ func (g *statsdTextServiceManager) statsdTCPTextServer() error {
 				if max := 1 * time.Second; tempDelay > max {
 					tempDelay = max
 				}
-				log.Printf("statsdTCPTextServer(): Accept error: %v; retrying in %v", err, tempDelay)
+				logger.Errorf("statsdTCPTextServer(): Accept error: %v; retrying in %v", err, tempDelay)
 				time.Sleep(tempDelay)
 				continue
 			}
@@ -184,9 +183,13 @@ func (g *statsdTextServiceManager) handleStatsdTextProtocol(conn net.Conn) {
 
 	for connbuf.Scan() {
 		if stat, err := statsd.ParseStatsdPacket(connbuf.Text()); err == nil {
-			g.rcvr.QueueAggregatorCommand(stat.AggregatorCmd())
+			cmd := stat.AggregatorCmd()
+			if ident, ok := g.rcvr.ApplyRules(cmd.Ident()); ok {
+				cmd.SetIdent(ident)
+				g.rcvr.QueueAggregatorCommand(cmd)
+			}
 		} else {
-			log.Printf("parseStatsdPacket(): %v", err)
+			logger.Warnf("parseStatsdPacket(): %v", err)
 		}
 
 		if g.timeout != 0 {
@@ -200,7 +203,7 @@ func (g *statsdTextServiceManager) handleStatsdTextProtocol(conn net.Conn) {
 
 	if err := connbuf.Err(); err != nil {
 		if !strings.Contains(err.Error(), "use of closed") {
-			log.Println("handleStatsdTextProtocol(): Error reading: %v", err)
+			logger.Errorf("handleStatsdTextProtocol(): Error reading: %v", err)
 		}
 	}
 }
@@ -17,6 +17,7 @@ package dsl
 
 import (
 	"fmt"
+	"math"
 	"sync"
 	"time"
 
@@ -24,6 +25,7 @@ import (
 	"github.com/tgres/tgres/rrd"
 	"github.com/tgres/tgres/serde"
 	"github.com/tgres/tgres/series"
+	"github.com/tgres/tgres/tracing"
 )
 
 type dsLRU struct {
@@ -33,12 +35,20 @@ type dsLRU struct {
 	dl        rraDataLoader
 	ch        chan DataPoint
 	dsc       watcher
+	tf        tailFetcher
 	cap       int
 	evictions int
 	hits      int
 	misses    int
 }
 
+// A tailFetcher provides the not yet persisted data points of a DS,
+// possibly from another node, as an RRASpec (with Latest, Value,
+// Duration and DPs) per RRA in the same order as ds.RRAs().
+type tailFetcher interface {
+	FetchTail(ds rrd.DataSourcer) ([]rrd.RRASpec, error)
+}
+
 type DataPoint struct {
 	serde.Ident
 	T time.Time
@@ -49,11 +59,13 @@ type DataPoint struct {
 func newDsLRU(db dsFetcher, dsc watcher, cap int) *dsLRU {
 	// If db does not provide rraDataLoader none of this is possible.
 	dl, _ := db.(rraDataLoader)
+	tf, _ := dsc.(tailFetcher)
 	d := &dsLRU{
 		db:    db,
 		dl:    dl,
 		ch:    make(chan DataPoint, 256),
 		dsc:   dsc,
+		tf:    tf,
 		Mutex: &sync.Mutex{},
 		cap:   cap,
 	}
@@ -157,7 +169,7 @@ func (d *dsLRU) loadState() error {
 						break
 					}
 					wg.Add(1)
-					go d.loadDs(wds, &wg)
+					go d.loadDs(wds, &wg, nil)
 					jobs++
 					if jobs >= 32 {
 						wg.Wait()
@@ -181,7 +193,12 @@ func (d *dsLRU) stateSaver(nap time.Duration) {
 }
 
 func (d *dsLRU) FetchOrCreateDataSource(ident serde.Ident, _ *rrd.DSSpec) (rrd.DataSourcer, error) {
+	return d.fetchDataSourceTraced(ident, nil)
+}
+
+func (d *dsLRU) fetchDataSourceTraced(ident serde.Ident, sp *tracing.Span) (rrd.DataSourcer, error) {
 	if d.dl == nil { // RRA loading not available
+		sp.SetAttr("lru", "disabled")
 		return d.db.FetchOrCreateDataSource(ident, nil)
 	}
 	var wds *watchedDs
@@ -196,12 +213,14 @@ func (d *dsLRU) FetchOrCreateDataSource(ident serde.Ident, _ *rrd.DSSpec) (rrd.D
 			d.Lock()
 			d.misses++
 			d.Unlock()
+			sp.SetAttr("lru", "loading")
 			return d.db.FetchOrCreateDataSource(ident, nil)
 		}
 
 		d.Lock()
 		d.hits++
 		d.Unlock()
+		sp.SetAttr("lru", "hit")
 		return wds, nil
 	}
 
@@ -220,24 +239,26 @@ func (d *dsLRU) FetchOrCreateDataSource(ident serde.Ident, _ *rrd.DSSpec) (rrd.D
 		// Submit load job
 		var wg sync.WaitGroup
 		wg.Add(1)
-		go d.loadDs(wds, &wg)
-	} else {
-		// This DS is not watchable, which means it is somehow bogus,
-		// and we shouldn't bother with it. DSL should warn about it
-		// (it's a TODO).
-		return nil, nil
+		go d.loadDs(wds, &wg, sp.Child("dsl.lru_load"))
 	}
+	// else this node has no recent data for it, e.g. in a cluster it
+	// may be that this node does not receive the data, the recent
+	// part then comes from the tail.
 
 	// revert to non-cache behavior because it is being loaded (or not)
 	d.Lock()
 	d.misses++
 	d.Unlock()
+	sp.SetAttr("lru", "miss")
 	return d.db.FetchOrCreateDataSource(ident, nil)
 }
 
-func (d *dsLRU) loadDs(wds *watchedDs, wg *sync.WaitGroup) {
+// loadDs loads all the RRA data, sp (if not nil) is ended when done.
+func (d *dsLRU) loadDs(wds *watchedDs, wg *sync.WaitGroup, sp *tracing.Span) {
 	defer wg.Done()
+	defer sp.End()
 	rras := wds.RRAs()
+	sp.SetAttr("rras", len(rras))
 	newRRAs := make([]rrd.RoundRobinArchiver, len(rras))
 	for i, rra := range rras {
 		dbrra, ok := rra.(*serde.DbRoundRobinArchive)
@@ -247,6 +268,7 @@ func (d *dsLRU) loadDs(wds *watchedDs, wg *sync.WaitGroup) {
 		if r, err := d.dl.LoadRRAData(dbrra); err == nil {
 			newRRAs[i] = r
 		} else {
+			sp.SetError(err)
 			return // serde logs something here
 		}
 	}
@@ -259,11 +281,34 @@ func (d *dsLRU) loadDs(wds *watchedDs, wg *sync.WaitGroup) {
 }
 
 func (d *dsLRU) FetchSeries(ds rrd.DataSourcer, from, to time.Time, maxPoints int64) (series.Series, error) {
+	return d.fetchSeriesTraced(ds, from, to, maxPoints, nil)
+}
+
+func (d *dsLRU) fetchSeriesTraced(ds rrd.DataSourcer, from, to time.Time, maxPoints int64, sp *tracing.Span) (series.Series, error) {
+	if tds, ok := ds.(*tailedDs); ok {
+		if tds.series != nil {
+			sp.SetAttr("source", "tail")
+			return tds.series, nil
+		}
+		sp.SetAttr("source", "db")
+		return d.db.FetchSeries(tds.DataSourcer, from, to, maxPoints)
+	}
+
 	var wds *watchedDs
 	if wds, _ = ds.(*watchedDs); wds == nil {
 		// Not a watchedDs, fallback to non-cache behavior
-		return d.db.FetchSeries(ds, from, to, maxPoints)
+		s, err := d.db.FetchSeries(ds, from, to, maxPoints)
+		if err != nil {
+			return nil, err
+		}
+		if ts := d.tailSeries(ds, ds.BestRRA(from, to, maxPoints), s); ts != nil {
+			sp.SetAttr("source", "tail")
+			return ts, nil
+		}
+		sp.SetAttr("source", "db")
+		return s, nil
 	}
+	sp.SetAttr("source", "lru")
 
 	wds.RLock()
 	defer wds.RUnlock()
@@ -273,6 +318,242 @@ func (d *dsLRU) FetchSeries(ds rrd.DataSourcer, from, to time.Time, maxPoints in
 		return nil, fmt.Errorf("FetchSeries (ds_lru.go): No adequate RRA found for DS from: %v to: %v maxPoints: %v", from, to, maxPoints)
 	}
 
+	return watchedSeries(wds, rra, from, to, maxPoints), nil
+}
+
+func (d *dsLRU) FetchFunctionSeries(ds rrd.DataSourcer, cf rrd.Consolidation, from, to time.Time, maxPoints int64) (series.Series, error) {
+	if tds, ok := ds.(*tailedDs); ok {
+		ds = tds.DataSourcer // the tail series is not of this cf
+	}
+
+	var wds *watchedDs
+	if wds, _ = ds.(*watchedDs); wds == nil {
+		// Not a watchedDs, fallback to non-cache behavior
+		fsf, ok := d.db.(serde.FunctionSeriesFetcher)
+		if !ok {
+			return nil, fmt.Errorf("FetchFunctionSeries (ds_lru.go): not supported by %T", d.db)
+		}
+		s, err := fsf.FetchFunctionSeries(ds, cf, from, to, maxPoints)
+		if err != nil {
+			return nil, err
+		}
+		if ts := d.tailSeries(ds, ds.BestRRAFunction(cf, from, to, maxPoints), s); ts != nil {
+			return ts, nil
+		}
+		return s, nil
+	}
+
+	wds.RLock()
+	defer wds.RUnlock()
+
+	rra := wds.BestRRAFunction(cf, from, to, maxPoints)
+	if rra == nil {
+		return nil, fmt.Errorf("FetchFunctionSeries (ds_lru.go): No adequate RRA found for DS from: %v to: %v maxPoints: %v", from, to, maxPoints)
+	}
+
+	return watchedSeries(wds, rra, from, to, maxPoints), nil
+}
+
+// tailSeries returns the series s of the rra data from the database
+// with the tail overlaid on it, or nil if there is no tail, in which
+// case the database has all there is.
+func (d *dsLRU) tailSeries(ds rrd.DataSourcer, rra rrd.RoundRobinArchiver, s series.Series) series.Series {
+	if d.tf == nil || rra == nil || s == nil {
+		return nil
+	}
+
+	n := -1
+	for i, r := range ds.RRAs() {
+		if r == rra {
+			n = i
+		}
+	}
+
+	tail, err := d.tf.FetchTail(ds)
+	if err != nil {
+		logger.Errorf("tailSeries: %v", err)
+		return nil
+	}
+	if n < 0 || n >= len(tail) || len(tail[n].DPs) == 0 && !tail[n].Latest.After(rra.Latest()) {
+		return nil
+	}
+
+	return newTailOverlay(s, rra, tail[n])
+}
+
+// At most this many tails are fetched at once by prefetchTails(), and
+// none are started after tailFetchDeadline, the DSs left out are read
+// from the database only.
+var (
+	maxConcurrentTailFetches = 16
+	tailFetchDeadline        = 5 * time.Second
+)
+
+// A tailedDs is a DS that is not watched, with its tail series
+// fetched in advance by prefetchTails().
+type tailedDs struct {
+	rrd.DataSourcer
+	series series.Series // nil if the database has all there is
+}
+
+// prefetchTails does tailSeries() concurrently for the DSs that are
+// not watched, rather than one at a time in FetchSeries(), returning
+// those as tailedDs.
+func (d *dsLRU) prefetchTails(dss []rrd.DataSourcer, from, to time.Time, maxPoints int64) []rrd.DataSourcer {
+	result := make([]rrd.DataSourcer, len(dss))
+	copy(result, dss)
+	if d.tf == nil {
+		return result
+	}
+
+	var (
+		wg       sync.WaitGroup
+		sem      = make(chan bool, maxConcurrentTailFetches)
+		deadline = time.Now().Add(tailFetchDeadline)
+		late     int
+	)
+	for i, ds := range dss {
+		if _, ok := ds.(*watchedDs); ok {
+			continue
+		}
+		sem <- true
+		if time.Now().After(deadline) {
+			<-sem
+			result[i] = &tailedDs{DataSourcer: ds}
+			late++
+			continue
+		}
+		wg.Add(1)
+		go func(i int, ds rrd.DataSourcer) {
+			defer wg.Done()
+			var ts series.Series
+			if s, err := d.db.FetchSeries(ds, from, to, maxPoints); err == nil {
+				ts = d.tailSeries(ds, ds.BestRRA(from, to, maxPoints), s)
+			}
+			result[i] = &tailedDs{DataSourcer: ds, series: ts}
+			<-sem
+		}(i, ds)
+	}
+	wg.Wait()
+	if late > 0 {
+		logger.Warnf("prefetchTails: tails of %d (of %d) DSs not fetched within %v, recent data may be missing.", late, len(dss), tailFetchDeadline)
+	}
+	return result
+}
+
+// tailOverlay is a series from the database with the tail (the
+// points not in the database yet) overlaid on it. The database
+// series is iterated one step at a time and grouped here, since a
+// group may contain slots of both.
+type tailOverlay struct {
+	series.Series
+	tail      rrd.RRASpec
+	size      int64
+	groupBy   time.Duration
+	maxPoints int64
+	value     float64
+	end       time.Time
+}
+
+func newTailOverlay(s series.Series, rra rrd.RoundRobinArchiver, tail rrd.RRASpec) *tailOverlay {
+	result := &tailOverlay{Series: s, tail: tail, size: rra.Size(), maxPoints: s.MaxPoints(), value: math.NaN()}
+	s.GroupBy(s.Step()) // grouping is done here
+	return result
+}
+
+func (s *tailOverlay) Next() bool {
+	moves := 1
+	if step, groupBy := s.Step(), s.GroupBy(); groupBy > step {
+		moves = int(groupBy.Seconds()/step.Seconds() + 0.5)
+	}
+	sum, cnt := float64(0), 0
+	for i := 0; i < moves; i++ {
+		if !s.Series.Next() {
+			if i == 0 {
+				s.value = math.NaN()
+				return false
+			}
+			break
+		}
+		s.end = s.Series.CurrentTime()
+		if val := s.slotValue(); !math.IsNaN(val) && !math.IsInf(val, 0) {
+			sum += val
+			cnt++
+		}
+	}
+	s.value = sum / float64(cnt)
+	return true
+}
+
+// slotValue is the value of the current slot of the database series,
+// unless the tail has it.
+func (s *tailOverlay) slotValue() float64 {
+	t, step := s.Series.CurrentTime(), s.Step()
+	if !t.After(s.tail.Latest) && t.After(s.tail.Latest.Add(-step*time.Duration(s.size))) {
+		if v, ok := s.tail.DPs[rrd.SlotIndex(t, step, s.size)]; ok {
+			return v
+		}
+	}
+	return s.Series.CurrentValue()
+}
+
+func (s *tailOverlay) CurrentValue() float64 {
+	return s.value
+}
+
+func (s *tailOverlay) CurrentTime() time.Time {
+	return s.end
+}
+
+func (s *tailOverlay) Close() error {
+	s.value, s.end = math.NaN(), time.Time{}
+	return s.Series.Close()
+}
+
+func (s *tailOverlay) GroupBy(td ...time.Duration) time.Duration {
+	if len(td) > 0 {
+		defer func() { s.groupBy = td[0] }()
+	}
+	if s.groupBy != 0 {
+		return s.groupBy
+	}
+	// groupBy trumps maxPoints, otherwise maxPoints sets groupBy
+	if from, to := s.Series.TimeRange(); s.maxPoints > 0 && to.After(from) {
+		if groupBy := to.Sub(from) / time.Duration(s.maxPoints); groupBy > s.Step() {
+			return groupBy
+		}
+	}
+	return s.Step()
+}
+
+func (s *tailOverlay) TimeRange(t ...time.Time) (time.Time, time.Time) {
+	from, to := s.Series.TimeRange(t...)
+	s.Series.GroupBy(s.Step())
+	return from, to
+}
+
+func (s *tailOverlay) Latest() time.Time {
+	if latest := s.Series.Latest(); latest.After(s.tail.Latest) {
+		return latest
+	}
+	return s.tail.Latest
+}
+
+func (s *tailOverlay) MaxPoints(n ...int64) int64 {
+	if len(n) > 0 {
+		defer func() { s.maxPoints = n[0] }()
+	}
+	return s.maxPoints
+}
+
+// Trace passes the span on to the database series.
+func (s *tailOverlay) Trace(sp *tracing.Span) {
+	if t, ok := s.Series.(traceable); ok {
+		t.Trace(sp)
+	}
+}
+
+func watchedSeries(wds *watchedDs, rra rrd.RoundRobinArchiver, from, to time.Time, maxPoints int64) series.Series {
 	// We pass the wds lock here, so that when whatever downstream locks the rra,
 	// it will actually end up locking the DS. Note that a locked DS cannot have
 	// data points added to it, so it is imperative that the lock isn't held for a long
@@ -281,7 +562,7 @@ func (d *dsLRU) FetchSeries(ds rrd.DataSourcer, from, to time.Time, maxPoints in
 	s.TimeRange(from, to)
 	s.MaxPoints(maxPoints)
 
-	return s, nil
+	return s
 }
 
 type watchedDs struct {
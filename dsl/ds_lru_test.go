@@ -0,0 +1,159 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dsl
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tgres/tgres/rrd"
+	"github.com/tgres/tgres/serde"
+	"github.com/tgres/tgres/series"
+)
+
+func Test_tailOverlay(t *testing.T) {
+	step := 10 * time.Second
+	rra := rrd.NewRoundRobinArchive(rrd.RRASpec{
+		Function: rrd.WMEAN, Step: step, Span: 10 * step,
+		Latest: time.Unix(1020, 0),
+		DPs:    map[int64]float64{1: 1, 2: 2},
+	})
+	tail := rrd.RRASpec{
+		Latest: time.Unix(1040, 0),
+		DPs:    map[int64]float64{2: 20, 3: 3, 4: 4},
+	}
+
+	// The "database" series, as FetchSeries would return it
+	db := series.NewRRASeries(rra)
+	db.TimeRange(time.Unix(1010, 0), time.Unix(1040, 0))
+
+	s := newTailOverlay(db, rra, tail)
+	if !s.Latest().Equal(tail.Latest) {
+		t.Errorf("tailOverlay: latest should come from the tail: %v", s.Latest())
+	}
+	expect := []float64{1, 20, 3, 4}
+	for i, v := range expect {
+		if !s.Next() || s.CurrentValue() != v || s.CurrentTime().Unix() != int64(1010+i*10) {
+			t.Errorf("tailOverlay: slot %d: expected %v, got %v at %v", i, v, s.CurrentValue(), s.CurrentTime())
+		}
+	}
+	if s.Next() {
+		t.Errorf("tailOverlay: expected %d points only", len(expect))
+	}
+	s.Close()
+
+	// grouped, the groups contain slots of both
+	db = series.NewRRASeries(rra)
+	db.TimeRange(time.Unix(1010, 0), time.Unix(1040, 0))
+	db.MaxPoints(2)
+	s = newTailOverlay(db, rra, tail)
+	if s.GroupBy() != 15*time.Second {
+		t.Errorf("tailOverlay: expected group by of 15s, got %v", s.GroupBy())
+	}
+	for _, exp := range []struct {
+		v float64
+		t int64
+	}{{10.5, 1020}, {3.5, 1040}} {
+		if !s.Next() || s.CurrentValue() != exp.v || s.CurrentTime().Unix() != exp.t {
+			t.Errorf("tailOverlay: expected %v at %v, got %v at %v", exp.v, exp.t, s.CurrentValue(), s.CurrentTime())
+		}
+	}
+	if s.Next() {
+		t.Errorf("tailOverlay: expected 2 groups only")
+	}
+}
+
+type fakeTailDb struct{}
+
+func (fakeTailDb) FetchOrCreateDataSource(ident serde.Ident, dsSpec *rrd.DSSpec) (rrd.DataSourcer, error) {
+	return nil, nil
+}
+func (fakeTailDb) FetchSeries(ds rrd.DataSourcer, from, to time.Time, maxPoints int64) (series.Series, error) {
+	return series.NewRRASeries(ds.RRAs()[0]), nil
+}
+
+type fakeTailFetcher struct {
+	sync.Mutex
+	delay          time.Duration
+	running, calls int
+	maxRunning     int
+}
+
+func (f *fakeTailFetcher) FetchTail(ds rrd.DataSourcer) ([]rrd.RRASpec, error) {
+	f.Lock()
+	f.calls++
+	if f.running++; f.running > f.maxRunning {
+		f.maxRunning = f.running
+	}
+	f.Unlock()
+	time.Sleep(f.delay)
+	f.Lock()
+	f.running--
+	f.Unlock()
+	latest := ds.RRAs()[0].Latest()
+	return []rrd.RRASpec{{Latest: latest.Add(time.Minute), DPs: map[int64]float64{0: 42}}}, nil
+}
+
+func Test_dsLRU_prefetchTails(t *testing.T) {
+	defer func(n int, dl time.Duration) {
+		maxConcurrentTailFetches, tailFetchDeadline = n, dl
+	}(maxConcurrentTailFetches, tailFetchDeadline)
+
+	when := time.Unix(1000, 0)
+	dss := make([]rrd.DataSourcer, 40)
+	for i := range dss {
+		dss[i] = rrd.NewDataSource(rrd.DSSpec{
+			Step:       time.Minute,
+			Heartbeat:  time.Hour,
+			LastUpdate: when,
+			RRAs:       []rrd.RRASpec{{Function: rrd.WMEAN, Step: time.Minute, Span: time.Hour, Latest: when}},
+		})
+	}
+	dss[0] = &watchedDs{DataSourcer: dss[0], RWMutex: &sync.RWMutex{}}
+
+	tf := &fakeTailFetcher{delay: 10 * time.Millisecond}
+	d := &dsLRU{db: fakeTailDb{}, tf: tf, Mutex: &sync.Mutex{}}
+	maxConcurrentTailFetches, tailFetchDeadline = 4, time.Minute
+
+	result := d.prefetchTails(dss, when.Add(-time.Hour), when, 100)
+	if _, ok := result[0].(*watchedDs); !ok {
+		t.Errorf("prefetchTails: a watched DS should be left alone")
+	}
+	for i, ds := range result[1:] {
+		if tds, ok := ds.(*tailedDs); !ok || tds.series == nil {
+			t.Errorf("prefetchTails: DS %d: expected a tail series, got %#v", i+1, ds)
+		}
+	}
+	if tf.calls != len(dss)-1 || tf.maxRunning > 4 {
+		t.Errorf("prefetchTails: expected %d calls, at most 4 at once, got %d, %d", len(dss)-1, tf.calls, tf.maxRunning)
+	}
+
+	// Past the deadline no more tails are fetched
+	tf.calls, tailFetchDeadline = 0, 15*time.Millisecond
+	result = d.prefetchTails(dss, when.Add(-time.Hour), when, 100)
+	late := 0
+	for _, ds := range result[1:] {
+		if tds, ok := ds.(*tailedDs); !ok {
+			t.Errorf("prefetchTails: expected a tailedDs, got %#v", ds)
+		} else if tds.series == nil {
+			late++
+		}
+	}
+	if late == 0 || tf.calls+late != len(dss)-1 {
+		t.Errorf("prefetchTails: expected some DSs without a tail after the deadline, got %d (%d calls)", late, tf.calls)
+	}
+}
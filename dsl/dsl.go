@@ -55,14 +55,24 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/tgres/tgres/logging"
+	"github.com/tgres/tgres/rrd"
+	"github.com/tgres/tgres/serde"
+	"github.com/tgres/tgres/tracing"
 )
 
+var logger = logging.New("dsl")
+
 type dslCtx struct {
 	src       string
 	escSrc    string
 	from, to  time.Time
 	maxPoints int64
 	ctxDSFetcher
+	vars  map[string]*macroVar // macro arguments, see macros.go
+	depth int                  // macro nesting depth
+	span  *tracing.Span        // nil unless traced, see trace.go
 }
 
 // Parse a DSL expression given by src and other params.
@@ -81,7 +91,16 @@ func newDslCtx(db ctxDSFetcher, src string, from, to time.Time, maxPoints int64)
 }
 
 // Parse a DSL context. Returns a SeriesMap or error.
-func (dc *dslCtx) parse() (SeriesMap, error) {
+func (dc *dslCtx) parse() (sm SeriesMap, err error) {
+	if sp := dc.span.Child("dsl.parse"); sp != nil {
+		sp.SetAttr("query", dc.src)
+		defer func(parent *tracing.Span) {
+			sp.SetError(err)
+			sp.End()
+			dc.span = parent
+		}(dc.span)
+		dc.span = sp
+	}
 
 	// parser.ParseExpr produces an AST in accordance with Go syntax,
 	// which is just fine in our case.
@@ -106,29 +125,53 @@ func (dc *dslCtx) seriesFromSeriesOrIdent(what interface{}) (SeriesMap, error) {
 	case SeriesMap:
 		return obj, nil
 	case string:
+		if mv, ok := dc.vars[obj]; ok {
+			return mv.series()
+		}
 		return dc.seriesFromPattern(obj, dc.from, dc.to)
 	}
 	return nil, fmt.Errorf("seriesFromSeriesOrIdent(): unknown type: %T of %v", what, what)
 }
 
+// A tailPrefetcher (i.e. dsLRU) can get the recent data of many DSs
+// at once, see dsLRU.prefetchTails().
+type tailPrefetcher interface {
+	prefetchTails(dss []rrd.DataSourcer, from, to time.Time, maxPoints int64) []rrd.DataSourcer
+}
+
 func (dc *dslCtx) seriesFromPattern(pattern string, from, to time.Time) (SeriesMap, error) {
-	idents := dc.identsFromPattern(pattern)
-	result := make(SeriesMap)
+	return dc.seriesFromIdents(dc.identsFromPattern(pattern), from, to)
+}
+
+// seriesFromIdents fetches the series of idents, which are keyed by
+// the resulting series names.
+func (dc *dslCtx) seriesFromIdents(idents map[string]serde.Ident, from, to time.Time) (SeriesMap, error) {
+	names := make([]string, 0, len(idents))
+	dss := make([]rrd.DataSourcer, 0, len(idents))
 	for name, ident := range idents {
-		ds, err := dc.FetchOrCreateDataSource(ident, nil)
+		ds, err := dc.fetchDataSource(ident)
 		if err != nil {
-			return nil, fmt.Errorf("seriesFromPattern(): Error %v", err)
+			return nil, fmt.Errorf("seriesFromIdents(): Error %v", err)
 		}
 		if ds == nil {
 			// Strange, it does not exist, ignore it
 			// TODO: The DSL should support warnings, this is a good case for it
 			continue
 		}
-		dps, err := dc.FetchSeries(ds, from, to, dc.maxPoints)
+		names = append(names, name)
+		dss = append(dss, ds)
+	}
+	if tp, ok := dc.ctxDSFetcher.(tailPrefetcher); ok {
+		dss = tp.prefetchTails(dss, from, to, dc.maxPoints)
+	}
+
+	result := make(SeriesMap)
+	for i, ds := range dss {
+		dps, err := dc.fetchSeries(ds, from, to)
 		if err != nil {
-			return nil, fmt.Errorf("seriesFromPattern(): Error %v", err)
+			return nil, fmt.Errorf("seriesFromIdents(): Error %v", err)
 		}
-		result[name] = &aliasSeries{Series: dps}
+		result[names[i]] = &aliasSeries{Series: dps}
 	}
 	return result, nil
 }
@@ -232,6 +275,10 @@ func (v *funcVisitor) processStack() ast.Visitor {
 			name = fn.Name
 		}
 
+		if isMacro(name) {
+			v.withArgSources(c)
+		}
+
 		ret, v.err = seriesFromFunction(v.dc, name, c.args)
 	}
 
@@ -263,20 +310,43 @@ func (v *funcVisitor) Visit(node ast.Node) ast.Visitor {
 	return v
 }
 
+// withArgSources replaces the series arguments of a macro call with
+// seriesArg, so that the macro can evaluate them again if need be.
+func (v *funcVisitor) withArgSources(c *funcCall) {
+	exprs := c.ast.Args
+	if fn, ok := c.ast.Fun.(*ast.SelectorExpr); ok {
+		exprs = append([]ast.Expr{fn.X}, exprs...) // chained
+	}
+	for i, arg := range c.args {
+		if sm, ok := arg.(SeriesMap); ok && i < len(exprs) {
+			src := v.dc.escSrc[exprs[i].Pos()-1 : exprs[i].End()-1]
+			c.args[i] = &seriesArg{sm: sm, src: unEscapeSrc(src)}
+		}
+	}
+}
+
 // Simple trick to avoid "*" which is not valid Go syntax
 
 func escapeBadChars(target string) string {
 	s := strings.Replace(target, "*", "__ASTERISK__", -1)
 	s = strings.Replace(s, "=", "__ASSIGN__", -1)
+	s = strings.Replace(s, "%", "__PERCENT__", -1) // escaped names, see misc.QueryName
 	return strings.Replace(s, "-", "__DASH__", -1)
 }
 
 func unEscapeBadChars(target string) string {
 	s := strings.Replace(target, "__ASTERISK__", "*", -1)
 	s = strings.Replace(s, "__ASSIGN__", "=", -1)
+	s = strings.Replace(s, "__PERCENT__", "%", -1)
 	return strings.Replace(s, "__DASH__", "-", -1)
 }
 
+// unEscapeSrc reverses escapeBadChars and fixBackSlashes, so that
+// the result can be parsed again.
+func unEscapeSrc(target string) string {
+	return strings.Replace(unEscapeBadChars(target), "\\\\", "\\", -1)
+}
+
 // Also - there are no single quoted strings in Go grammar
 func fixQuotes(target string) string {
 	// TODO if the string contains double quotes, they should be escaped
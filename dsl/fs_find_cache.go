@@ -23,12 +23,14 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/tgres/tgres/misc"
 	"github.com/tgres/tgres/serde"
 )
 
 // fsFindCache provides a way of searching dot-separated ident
 // elements using same rules as filepath.Match, as well as
 // comma-separated values in curly braces such as "foo.{bar,baz}".
+// Names are escaped as per misc.QueryName, the idents are unchanged.
 type fsFindCache struct {
 	*sync.RWMutex
 	db  serde.DataSourceSearcher
@@ -99,7 +101,7 @@ func (n *fsFindNode) search(pattern, key string, result map[string]*FsFindNode)
 
 func (f *fsFindCache) insert(ident serde.Ident) error {
 	if name := ident[f.key]; name != "" {
-		parts := strings.Split(name, ".")
+		parts := strings.Split(misc.QueryName(name), ".")
 		f.fsFindNode.insert(parts, 0, ident)
 	} else {
 		return fmt.Errorf("insert: '%s' tag missing for DS ident: %s", f.key, ident.String())
@@ -17,7 +17,6 @@ package dsl
 
 import (
 	"fmt"
-	"log"
 	"math"
 	"regexp"
 	"sort"
@@ -26,6 +25,8 @@ import (
 	"time"
 
 	"github.com/tgres/tgres/misc"
+	"github.com/tgres/tgres/rrd"
+	"github.com/tgres/tgres/serde"
 	"github.com/tgres/tgres/series"
 )
 
@@ -59,6 +60,11 @@ var dslCtxFuncs = dslCtxFuncMap{ // functions that require the dslCtx to do thei
 	"averageSeriesWithWildcards": dslAverageSeriesWithWildcards,
 	"groupByNode":                dslGroupByNode,
 	"timeStack":                  dslTimeStack,
+	"hwPredict":                  dslHWPredict,
+	"hwConfidenceBands":          dslHWConfidenceBands,
+	"hwFailures":                 dslHWFailures,
+	"applyByNode":                dslApplyByNode,
+	"seriesByTag":                dslSeriesByTag,
 }
 
 var preprocessArgFuncs = funcMap{
@@ -205,7 +211,7 @@ var preprocessArgFuncs = funcMap{
 		argDef{"value", argNumber, nil}}},
 	"countSeries": dslFuncType{dslCountSeries, true, []argDef{
 		argDef{"seriesList", argSeries, nil}}},
-	"hitcount": dslFuncType{dslHitcount, true, []argDef{
+	"hitcount": dslFuncType{dslHitcount, false, []argDef{
 		argDef{"seriesList", argSeries, nil},
 		argDef{"intervalString", argString, nil},
 		argDef{"alignToInterval", argBool, "false"}}},
@@ -249,6 +255,84 @@ var preprocessArgFuncs = funcMap{
 	"holtWintersAberration": dslFuncType{dslHoltWintersAberration, false, []argDef{
 		argDef{"seriesList", argSeries, nil},
 		argDef{"delta", argNumber, 3.0}}},
+	"rollingZScore": dslFuncType{dslRollingZScore, false, []argDef{
+		argDef{"seriesList", argSeries, nil},
+		argDef{"windowSize", argString, nil},
+		argDef{"threshold", argNumber, 3.0},
+		argDef{"show", argString, "bands,anomaly"}}}, // show score,bands,anomaly
+	"madOutliers": dslFuncType{dslMadOutliers, false, []argDef{
+		argDef{"seriesList", argSeries, nil},
+		argDef{"windowSize", argString, nil},
+		argDef{"threshold", argNumber, 3.5},
+		argDef{"show", argString, "bands,anomaly"}}}, // show median,score,bands,anomaly
+	"stlDecompose": dslFuncType{dslStlDecompose, false, []argDef{
+		argDef{"seriesList", argSeries, nil},
+		argDef{"seasonLen", argString, "1d"},
+		argDef{"threshold", argNumber, 3.0},
+		argDef{"robust", argBool, "true"},
+		argDef{"show", argString, "bands,anomaly"}}}, // show trend,seasonal,remainder,bands,anomaly
+	"cusum": dslFuncType{dslCusum, false, []argDef{
+		argDef{"seriesList", argSeries, nil},
+		argDef{"baseline", argString, "1h"},
+		argDef{"k", argNumber, 0.5},
+		argDef{"h", argNumber, 5.0},
+		argDef{"show", argString, "sums,anomaly"}}}, // show sums,anomaly
+	"aggregate": dslFuncType{dslAggregate, false, []argDef{
+		argDef{"seriesList", argSeries, nil},
+		argDef{"func", argString, nil},
+		argDef{"xFilesFactor", argNumber, 0.0}}},
+	"aggregateWithWildcards": dslFuncType{dslAggregateWithWildcards, true, []argDef{
+		argDef{"seriesList", argSeries, nil},
+		argDef{"func", argString, nil},
+		argDef{"positions", argNumber, nil}}},
+	"groupByNodes": dslFuncType{dslGroupByNodes, true, []argDef{
+		argDef{"seriesList", argSeries, nil},
+		argDef{"callback", argString, nil},
+		argDef{"nodes", argNumber, nil}}},
+	"groupByTags": dslFuncType{dslGroupByTags, true, []argDef{
+		argDef{"seriesList", argSeries, nil},
+		argDef{"callback", argString, nil},
+		argDef{"tags", argString, nil}}},
+	"aggregateSeriesLists": dslFuncType{dslAggregateSeriesLists, false, []argDef{
+		argDef{"seriesListFirstPos", argSeries, nil},
+		argDef{"seriesListSecondPos", argSeries, nil},
+		argDef{"func", argString, nil},
+		argDef{"xFilesFactor", argNumber, 0.0}}},
+	"sumSeriesLists": dslFuncType{dslSumSeriesLists, false, []argDef{
+		argDef{"seriesListFirstPos", argSeries, nil},
+		argDef{"seriesListSecondPos", argSeries, nil}}},
+	"sortByName": dslFuncType{dslSortByName, false, []argDef{
+		argDef{"seriesList", argSeries, nil},
+		argDef{"natural", argBool, "false"},
+		argDef{"reverse", argBool, "false"}}},
+	"sortByMaxima": dslFuncType{dslSortByMaxima, false, []argDef{
+		argDef{"seriesList", argSeries, nil}}},
+	"sortByMinima": dslFuncType{dslSortByMinima, false, []argDef{
+		argDef{"seriesList", argSeries, nil}}},
+	"sortByTotal": dslFuncType{dslSortByTotal, false, []argDef{
+		argDef{"seriesList", argSeries, nil}}},
+	"smartSummarize": dslFuncType{dslSmartSummarize, false, []argDef{
+		argDef{"seriesList", argSeries, nil},
+		argDef{"intervalString", argString, nil},
+		argDef{"func", argString, "sum"},
+		argDef{"alignTo", argString, ""}}},
+	"perSecond": dslFuncType{dslPerSecond, false, []argDef{
+		argDef{"seriesList", argSeries, nil},
+		argDef{"maxValue", argNumber, math.NaN()}}},
+	"delay": dslFuncType{dslDelay, false, []argDef{
+		argDef{"seriesList", argSeries, nil},
+		argDef{"steps", argNumber, nil}}},
+	"interpolate": dslFuncType{dslInterpolate, false, []argDef{
+		argDef{"seriesList", argSeries, nil},
+		argDef{"limit", argNumber, math.Inf(1)}}},
+	"linearRegression": dslFuncType{dslLinearRegression, false, []argDef{
+		argDef{"seriesList", argSeries, nil},
+		argDef{"startSourceAt", argString, ""},
+		argDef{"endSourceAt", argString, ""}}},
+	"timeSlice": dslFuncType{dslTimeSlice, false, []argDef{
+		argDef{"seriesList", argSeries, nil},
+		argDef{"startSliceAt", argString, nil},
+		argDef{"endSliceAt", argString, "now"}}},
 
 	// COMBINE
 	// ++ averageSeries
@@ -266,23 +350,30 @@ var preprocessArgFuncs = funcMap{
 	// ++ sumSeriesWithWildcards
 	// ++ averageSeriesWithWildcards
 	// ++ multiplySeries
+	// ++ aggregate
+	// ++ aggregateWithWildcards
+	// ++ aggregateSeriesLists
+	// ++ sumSeriesLists
 
 	// TRANSFORM
 	// ++ absolute()
 	// ++ derivative()
+	// ++ delay
 	// ++ hitcount()
+	// ++ interpolate
 	// ++ integral()
 	// ++ log()
 	// ++ nonNegativeDerivative
 	// ++ offset
 	// ++ offsetToZero // would require whole series min()
-	// -- perSecond // everything here is perSedond() already
+	// ++ perSecond // for counters, everything else here is per second already
 	// ++ scale()
 	// ++ scaleToSeconds()
-	// -- smartSummarize
+	// ++ smartSummarize
 	// ++ summarize
 	// ++ timeShift
 	// ++ timeStack
+	// ++ timeSlice
 	// ++ transformNull
 
 	// CALCULATE
@@ -292,6 +383,7 @@ var preprocessArgFuncs = funcMap{
 	// ** holtWintersAberration
 	// ** holtWintersConfidenceBands
 	// ** holtWintersForecast
+	// ++ linearRegression
 	// ++ nPercentile
 	// ?? stddevSeries
 
@@ -333,13 +425,15 @@ var preprocessArgFuncs = funcMap{
 	// ++ constantLine
 	// ++ countSeries
 	// -- cumulative // == consolidateBy
+	// ++ applyByNode
 	// ++ groupByNode
+	// ++ groupByNodes
 	// ++ keepLastValue
 	// ?? randomWalk // later?
-	// ?? sortByMaxima
-	// ?? sortByMinima
-	// ?? sortByName
-	// ?? sortByTotal
+	// ++ sortByMaxima
+	// ++ sortByMinima
+	// ++ sortByName
+	// ++ sortByTotal
 	// ?? stacked
 	// ?? substr
 }
@@ -525,7 +619,7 @@ func seriesFromFunction(dc *dslCtx, name string, args []interface{}) (SeriesMap,
 		return callPreprocessArgFunc(dc, name, &argFunc, args, argMap, argSlice)
 	} else {
 		// Try a dslCtxFunc
-		if dslCtxFunc, ok := dslCtxFuncs[name]; !ok {
+		if dslCtxFunc, ok := lookupDslCtxFunc(name); !ok {
 			return nil, fmt.Errorf("No such function: %v", name)
 		} else {
 			if series, err := dslCtxFunc(dc, args); err == nil {
@@ -2131,8 +2225,9 @@ func dslCountSeries(args map[string]interface{}) (SeriesMap, error) {
 }
 
 // hitCount()
-// This really boils down to Sum(Scale())
-// alignToInterval is ignored because I think we sort of do that anyway
+// This really boils down to Sum(Scale()), unless alignToInterval is
+// true, in which case the values are consolidated into buckets of
+// intervalString aligned to it, see smartSummarize().
 
 type seriesHitcount struct {
 	AliasSeries
@@ -2153,6 +2248,16 @@ func dslHitcount(args map[string]interface{}) (SeriesMap, error) {
 	}
 	factor := dur.Seconds()
 
+	if args["alignToInterval"].(bool) && dur > 0 {
+		begin := args["_from_"].(time.Time).Truncate(dur)
+		for name, s := range series {
+			ss := summarizeSeries(s, args, dur, begin, "sum", aggSum)
+			ss.Alias(fmt.Sprintf("hitcount(%v,%v,true)", name, is))
+			series[name] = ss
+		}
+		return series, nil
+	}
+
 	for name, s := range series {
 		s.Alias(fmt.Sprintf("hitcount(%v,%v)", name, factor))
 		series[name] = &seriesHitcount{s, factor}
@@ -2366,7 +2471,7 @@ func dslTimeStack(dc *dslCtx, args []interface{}) (SeriesMap, error) {
 	series := make(SeriesMap)
 	idents := dc.identsFromPattern(sspec)
 	for name, ident := range idents {
-		ds, err := dc.FetchOrCreateDataSource(ident, nil)
+		ds, err := dc.fetchDataSource(ident)
 		if err != nil {
 			return nil, fmt.Errorf("timeStack(): Error %v", err)
 		}
@@ -2376,7 +2481,7 @@ func dslTimeStack(dc *dslCtx, args []interface{}) (SeriesMap, error) {
 
 		for i := begin; i <= num; i++ {
 			// Give FS the "big" range, TimeRange later
-			dps, err := dc.FetchSeries(ds, from, to, dc.maxPoints)
+			dps, err := dc.fetchSeries(ds, from, to)
 			if err != nil {
 				return nil, fmt.Errorf("timeStack(): Error %v", err)
 			}
@@ -2520,7 +2625,7 @@ func dslHoltWintersForecast(args map[string]interface{}) (SeriesMap, error) {
 				if α == 0 {
 					var e int
 					smooth, dev, α, β, γ, _, e = series.HWMinimizeSSE(shw.data, shw.seasonPoints(), trend, seasonal, nPreds)
-					log.Printf("Nelder-Mead finished in %d evaluations, resulting in α: %f β: %f γ: %f", e, α, β, γ)
+					logger.Debugf("Nelder-Mead finished in %d evaluations, resulting in α: %f β: %f γ: %f", e, α, β, γ)
 				} else {
 					smooth, dev, _ = series.HWTripleExponentialSmoothing(shw.data, shw.seasonPoints(), trend, seasonal, nPreds, α, β, γ)
 				}
@@ -2620,3 +2725,1354 @@ func dslHoltWintersAberration(args map[string]interface{}) (SeriesMap, error) {
 	args["show"] = "aberr"
 	return dslHoltWintersForecast(args)
 }
+
+// hwPredict, hwConfidenceBands, hwFailures
+//
+// Unlike holtWinters* above, these do not compute anything, but
+// return the Holt-Winters RRAs maintained by the DS (see
+// rrd/holtwinters.go), which must be configured.
+
+func (dc *dslCtx) functionSeriesFromPattern(fname, pattern string, cf rrd.Consolidation) (map[string]series.Series, error) {
+	fsf, ok := dc.ctxDSFetcher.(serde.FunctionSeriesFetcher)
+	if !ok {
+		return nil, fmt.Errorf("%s(): not supported by this fetcher", fname)
+	}
+	result := make(map[string]series.Series)
+	idents := dc.identsFromPattern(pattern)
+	for name, ident := range idents {
+		ds, err := dc.fetchDataSource(ident)
+		if err != nil {
+			return nil, fmt.Errorf("%s(): Error %v", fname, err)
+		}
+		if ds == nil {
+			continue
+		}
+		if !hasRRAFunction(ds, cf) {
+			continue // no Holt-Winters RRAs, nothing to see here
+		}
+		sp := dc.span.Child("dsl.fetch_series")
+		sp.SetAttr("function", fname)
+		s, err := fsf.FetchFunctionSeries(ds, cf, dc.from, dc.to, dc.maxPoints)
+		traceSeries(sp, s, err)
+		if err != nil {
+			return nil, fmt.Errorf("%s(): Error %v", fname, err)
+		}
+		result[name] = s
+	}
+	return result, nil
+}
+
+func hasRRAFunction(ds rrd.DataSourcer, cf rrd.Consolidation) bool {
+	for _, rra := range ds.RRAs() {
+		if rra.Spec().Function == cf {
+			return true
+		}
+	}
+	return false
+}
+
+func hwPatternArg(args []interface{}, max int) (string, error) {
+	if len(args) < 1 || len(args) > max {
+		return "", fmt.Errorf("Expecting 1 to %d arguments, got %d", max, len(args))
+	}
+	sspec, ok := args[0].(string)
+	if !ok {
+		return "", fmt.Errorf("%v is not a string", args[0])
+	}
+	return sspec, nil
+}
+
+func dslHWSeries(dc *dslCtx, args []interface{}, fname string, cf rrd.Consolidation) (SeriesMap, error) {
+	sspec, err := hwPatternArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	ss, err := dc.functionSeriesFromPattern(fname, sspec, cf)
+	if err != nil {
+		return nil, err
+	}
+	result := make(SeriesMap, len(ss))
+	for name, s := range ss {
+		legend := fmt.Sprintf("%s(%s)", fname, name)
+		result[legend] = &aliasSeries{Series: s, alias: legend}
+	}
+	return result, nil
+}
+
+func dslHWPredict(dc *dslCtx, args []interface{}) (SeriesMap, error) {
+	return dslHWSeries(dc, args, "hwPredict", rrd.HWPREDICT)
+}
+
+func dslHWFailures(dc *dslCtx, args []interface{}) (SeriesMap, error) {
+	return dslHWSeries(dc, args, "hwFailures", rrd.FAILURES)
+}
+
+type seriesHWBand struct {
+	*aliasSeriesSlice
+	delta float64
+}
+
+func (sl *seriesHWBand) CurrentValue() float64 {
+	return sl.SeriesSlice[0].CurrentValue() + sl.delta*sl.SeriesSlice[1].CurrentValue()
+}
+
+func dslHWConfidenceBands(dc *dslCtx, args []interface{}) (SeriesMap, error) {
+	sspec, err := hwPatternArg(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	delta := rrd.DefaultHWParams.Delta
+	if len(args) > 1 {
+		switch v := args[1].(type) {
+		case float64:
+			delta = v
+		case string:
+			if delta, err = strconv.ParseFloat(v, 64); err != nil {
+				return nil, fmt.Errorf("second argument %v is not a number", args[1])
+			}
+		default:
+			return nil, fmt.Errorf("second argument %v is not a number", args[1])
+		}
+	}
+
+	result := make(SeriesMap)
+	for _, band := range []struct {
+		suffix string
+		sign   float64
+	}{{"upper", 1}, {"lower", -1}} {
+		// Each band needs its own series, they cannot be shared
+		predict, err := dc.functionSeriesFromPattern("hwConfidenceBands", sspec, rrd.HWPREDICT)
+		if err != nil {
+			return nil, err
+		}
+		dev, err := dc.functionSeriesFromPattern("hwConfidenceBands", sspec, rrd.DEVPREDICT)
+		if err != nil {
+			return nil, err
+		}
+		for name, p := range predict {
+			d, ok := dev[name]
+			if !ok {
+				continue
+			}
+			sl := &aliasSeriesSlice{SeriesSlice: series.SeriesSlice{p, d}}
+			sl.Align()
+			legend := fmt.Sprintf("hwConfidenceBands(%s).%s", name, band.suffix)
+			sl.Alias(legend)
+			result[legend] = &seriesHWBand{sl, band.sign * delta}
+		}
+	}
+	return result, nil
+}
+
+// rollingZScore, madOutliers, stlDecompose, cusum
+//
+// Anomaly detection. Each of these reads the entire series (with some
+// history preceding from where needed) and returns a combination of
+// bands, scores and anomaly flags (1 for an anomaly, 0 for none, NaN
+// for unknown) as series named name.upper, name.lower, name.score,
+// name.anomaly, etc. Which are returned is controlled by the show
+// argument.
+
+// anomalyInput is a series as a slice.
+type anomalyInput struct {
+	data  []float64
+	start time.Time
+	step  time.Duration
+	skip  int // number of points of history preceding from
+}
+
+// readAnomalyInput reads the series, extending its time range back
+// by history.
+func readAnomalyInput(s AliasSeries, args map[string]interface{}, history time.Duration) *anomalyInput {
+	from := args["_from_"].(time.Time)
+	to := args["_to_"].(time.Time)
+	maxPoints := args["_maxPoints_"].(int64)
+
+	if history > 0 && !from.IsZero() && to.After(from) {
+		adjustedFrom := from.Add(-history)
+		s.TimeRange(adjustedFrom, to)
+		if maxPoints > 0 {
+			if perPoint := to.Sub(from).Nanoseconds() / maxPoints; perPoint > 0 {
+				s.MaxPoints(to.Sub(adjustedFrom).Nanoseconds() / perPoint)
+			}
+		}
+	}
+
+	in := &anomalyInput{}
+	for s.Next() {
+		t := s.CurrentTime()
+		if in.start.IsZero() {
+			in.start = t
+		}
+		if history > 0 && !t.After(from) {
+			in.skip++
+		}
+		in.data = append(in.data, s.CurrentValue())
+	}
+	if in.step = s.GroupBy(); in.step == 0 {
+		in.step = s.Step()
+	}
+	s.Close()
+	return in
+}
+
+// output returns data (minus history) as a series
+func (in *anomalyInput) output(data []float64, alias string) AliasSeries {
+	start := in.start.Add(in.step * time.Duration(in.skip))
+	ss := series.NewSliceSeries(data[in.skip:], start, in.step)
+	ss.Alias(alias)
+	return ss
+}
+
+// parseWindow parses a window size, which is either a duration or a
+// number of points. The number of points needs the step, which is not
+// known until the series is read, thus the estimated duration is
+// returned for points based on _from_, _to_ and _maxPoints_.
+func parseWindow(window string, args map[string]interface{}) (time.Duration, int, error) {
+	if dur, err := misc.BetterParseDuration(window); err == nil {
+		return dur, 0, nil
+	}
+	points, err := strconv.ParseInt(window, 10, 64)
+	if err != nil || points < 2 {
+		return 0, 0, fmt.Errorf("invalid window size: %v", window)
+	}
+	from := args["_from_"].(time.Time)
+	to := args["_to_"].(time.Time)
+	var dur time.Duration
+	if maxPoints := args["_maxPoints_"].(int64); maxPoints > 0 {
+		dur = to.Sub(from) / time.Duration(maxPoints) * time.Duration(points)
+	}
+	return dur, int(points), nil
+}
+
+func windowPoints(dur time.Duration, points int, step time.Duration) int {
+	if points == 0 && step > 0 {
+		points = int(dur / step)
+	}
+	return points
+}
+
+// anomalyFlags returns 1 where |score| exceeds threshold, 0 where it
+// does not and NaN where score is NaN.
+func anomalyFlags(score []float64, threshold float64) []float64 {
+	result := make([]float64, len(score))
+	for i, z := range score {
+		switch {
+		case math.IsNaN(z):
+			result[i] = math.NaN()
+		case math.Abs(z) > threshold:
+			result[i] = 1
+		}
+	}
+	return result
+}
+
+// bands returns center ± width * spread
+func bands(center, spread []float64, width float64) (upper, lower []float64) {
+	upper, lower = make([]float64, len(center)), make([]float64, len(center))
+	for i := range center {
+		upper[i] = center[i] + width*spread[i]
+		lower[i] = center[i] - width*spread[i]
+	}
+	return upper, lower
+}
+
+func dslRollingZScore(args map[string]interface{}) (SeriesMap, error) {
+	ss := args["seriesList"].(SeriesMap)
+	window := args["windowSize"].(string)
+	threshold := args["threshold"].(float64)
+	show := args["show"].(string)
+
+	dur, points, err := parseWindow(window, args)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(SeriesMap)
+	for name, s := range ss {
+		in := readAnomalyInput(s, args, dur)
+		mean, dev, z := series.RollingZScore(in.data, windowPoints(dur, points, in.step))
+
+		legend := fmt.Sprintf("rollingZScore(%v,%v)", name, window)
+		if strings.Contains(show, "score") {
+			result[name+".score"] = in.output(z, legend)
+		}
+		if strings.Contains(show, "bands") {
+			upper, lower := bands(mean, dev, threshold)
+			result[name+".upper"] = in.output(upper, legend+".upper")
+			result[name+".lower"] = in.output(lower, legend+".lower")
+		}
+		if strings.Contains(show, "anomaly") {
+			result[name+".anomaly"] = in.output(anomalyFlags(z, threshold), legend+".anomaly")
+		}
+	}
+	return result, nil
+}
+
+func dslMadOutliers(args map[string]interface{}) (SeriesMap, error) {
+	ss := args["seriesList"].(SeriesMap)
+	window := args["windowSize"].(string)
+	threshold := args["threshold"].(float64)
+	show := args["show"].(string)
+
+	dur, points, err := parseWindow(window, args)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(SeriesMap)
+	for name, s := range ss {
+		in := readAnomalyInput(s, args, dur)
+		median, mad, z := series.RollingMAD(in.data, windowPoints(dur, points, in.step))
+
+		legend := fmt.Sprintf("madOutliers(%v,%v)", name, window)
+		if strings.Contains(show, "median") {
+			result[name+".median"] = in.output(median, legend+".median")
+		}
+		if strings.Contains(show, "score") {
+			result[name+".score"] = in.output(z, legend)
+		}
+		if strings.Contains(show, "bands") {
+			upper, lower := bands(median, mad, threshold*series.MADScale)
+			result[name+".upper"] = in.output(upper, legend+".upper")
+			result[name+".lower"] = in.output(lower, legend+".lower")
+		}
+		if strings.Contains(show, "anomaly") {
+			result[name+".anomaly"] = in.output(anomalyFlags(z, threshold), legend+".anomaly")
+		}
+	}
+	return result, nil
+}
+
+func dslStlDecompose(args map[string]interface{}) (SeriesMap, error) {
+	ss := args["seriesList"].(SeriesMap)
+	seasonLen := args["seasonLen"].(string)
+	threshold := args["threshold"].(float64)
+	robust := args["robust"].(bool)
+	show := args["show"].(string)
+
+	slen, err := misc.BetterParseDuration(seasonLen)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(SeriesMap)
+	for name, s := range ss {
+		// STL needs at least two seasons
+		from := args["_from_"].(time.Time)
+		to := args["_to_"].(time.Time)
+		var history time.Duration
+		if to.Sub(from) < 2*slen {
+			history = 2*slen - to.Sub(from)
+		}
+		in := readAnomalyInput(s, args, history)
+		if in.step == 0 {
+			continue
+		}
+
+		trend, seasonal, remainder, err := series.STLDecompose(in.data, int(slen/in.step), robust)
+		if err != nil {
+			return nil, err
+		}
+
+		// The remainder of a series without anomalies is noise,
+		// its spread is estimated with MAD.
+		center := series.Median(remainder)
+		spread := series.MAD(remainder) * series.MADScale
+		expected := make([]float64, len(trend))
+		score := make([]float64, len(remainder))
+		for i := range trend {
+			expected[i] = trend[i] + seasonal[i]
+			score[i] = math.NaN()
+			if spread > 0 {
+				score[i] = (remainder[i] - center) / spread
+			}
+		}
+
+		legend := fmt.Sprintf("stlDecompose(%v,%v)", name, seasonLen)
+		if strings.Contains(show, "trend") {
+			result[name+".trend"] = in.output(trend, legend+".trend")
+		}
+		if strings.Contains(show, "seasonal") {
+			result[name+".seasonal"] = in.output(seasonal, legend+".seasonal")
+		}
+		if strings.Contains(show, "remainder") {
+			result[name+".remainder"] = in.output(remainder, legend+".remainder")
+		}
+		if strings.Contains(show, "bands") {
+			upper, lower := make([]float64, len(expected)), make([]float64, len(expected))
+			for i, e := range expected {
+				upper[i] = e + center + threshold*spread
+				lower[i] = e + center - threshold*spread
+			}
+			result[name+".upper"] = in.output(upper, legend+".upper")
+			result[name+".lower"] = in.output(lower, legend+".lower")
+		}
+		if strings.Contains(show, "anomaly") {
+			result[name+".anomaly"] = in.output(anomalyFlags(score, threshold), legend+".anomaly")
+		}
+	}
+	return result, nil
+}
+
+func dslCusum(args map[string]interface{}) (SeriesMap, error) {
+	ss := args["seriesList"].(SeriesMap)
+	baseline := args["baseline"].(string)
+	k := args["k"].(float64)
+	h := args["h"].(float64)
+	show := args["show"].(string)
+
+	dur, points, err := parseWindow(baseline, args)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(SeriesMap)
+	for name, s := range ss {
+		// The target mean and sigma are estimated (robustly) from
+		// the baseline period preceding from.
+		in := readAnomalyInput(s, args, dur)
+		n := windowPoints(dur, points, in.step)
+		if n > len(in.data) {
+			n = len(in.data)
+		}
+		target := series.Median(in.data[:n])
+		sigma := series.MAD(in.data[:n]) * series.MADScale
+
+		upper, lower, changes := series.CUSUM(in.data, target, sigma, k, h)
+
+		legend := fmt.Sprintf("cusum(%v,%v)", name, baseline)
+		if strings.Contains(show, "sums") {
+			result[name+".upper"] = in.output(upper, legend+".upper")
+			result[name+".lower"] = in.output(lower, legend+".lower")
+		}
+		if strings.Contains(show, "anomaly") {
+			result[name+".anomaly"] = in.output(changes, legend+".anomaly")
+		}
+	}
+	return result, nil
+}
+
+// aggregate, aggregateWithWildcards, groupByNodes,
+// aggregateSeriesLists, sumSeriesLists
+
+// aggFuncs are the aggregation functions by their Graphite names. They
+// are only given the known (non-NaN) values.
+var aggFuncs = map[string]func([]float64) float64{
+	"average":  avgFloat64,
+	"avg":      avgFloat64,
+	"median":   series.Median,
+	"sum":      aggSum,
+	"total":    aggSum,
+	"min":      aggMin,
+	"max":      aggMax,
+	"diff":     aggDiff,
+	"stddev":   aggStdDev,
+	"count":    func(data []float64) float64 { return float64(len(data)) },
+	"range":    aggRange,
+	"rangeOf":  aggRange,
+	"multiply": aggMultiply,
+	"last":     aggLast,
+	"current":  aggLast,
+}
+
+// aggFunc looks up an aggregation function, the "Series" suffix is
+// allowed, e.g. "sumSeries" is same as "sum".
+func aggFunc(name string) (func([]float64) float64, error) {
+	if name == "averageSeries" {
+		name = "average"
+	}
+	if fn, ok := aggFuncs[strings.TrimSuffix(name, "Series")]; ok {
+		return fn, nil
+	}
+	return nil, fmt.Errorf("unknown aggregation function: %q", name)
+}
+
+func aggSum(data []float64) (result float64) {
+	for _, v := range data {
+		result += v
+	}
+	return
+}
+
+func aggMin(data []float64) float64 {
+	result := data[0]
+	for _, v := range data[1:] {
+		result = math.Min(result, v)
+	}
+	return result
+}
+
+func aggMax(data []float64) float64 {
+	result := data[0]
+	for _, v := range data[1:] {
+		result = math.Max(result, v)
+	}
+	return result
+}
+
+func aggDiff(data []float64) float64 {
+	return data[0] - aggSum(data[1:])
+}
+
+// Population standard deviation, same as Graphite
+func aggStdDev(data []float64) float64 {
+	avg := avgFloat64(data)
+	var sum float64
+	for _, v := range data {
+		sum += (v - avg) * (v - avg)
+	}
+	return math.Sqrt(sum / float64(len(data)))
+}
+
+func aggRange(data []float64) float64 {
+	return aggMax(data) - aggMin(data)
+}
+
+func aggMultiply(data []float64) float64 {
+	result := data[0]
+	for _, v := range data[1:] {
+		result *= v
+	}
+	return result
+}
+
+func aggLast(data []float64) float64 {
+	return data[len(data)-1]
+}
+
+type seriesAggregate struct {
+	*aliasSeriesSlice
+	fn  func([]float64) float64
+	xff float64
+}
+
+// CurrentValue is NaN when the ratio of known values is below xff.
+func (sl *seriesAggregate) CurrentValue() float64 {
+	known := make([]float64, 0, len(sl.SeriesSlice))
+	for _, s := range sl.SeriesSlice {
+		if v := s.CurrentValue(); !math.IsNaN(v) {
+			known = append(known, v)
+		}
+	}
+	if len(known) == 0 || float64(len(known))/float64(len(sl.SeriesSlice)) < sl.xff {
+		return math.NaN()
+	}
+	return sl.fn(known)
+}
+
+func newSeriesAggregate(sm SeriesMap, fn func([]float64) float64, xff float64) *seriesAggregate {
+	ss := sm.toAliasSeriesSlice()
+	ss.Align()
+	return &seriesAggregate{ss, fn, xff}
+}
+
+func dslAggregate(args map[string]interface{}) (SeriesMap, error) {
+	series := args["seriesList"].(SeriesMap)
+	fn, err := aggFunc(args["func"].(string))
+	if err != nil {
+		return nil, err
+	}
+	if len(series) == 0 {
+		return SeriesMap{}, nil
+	}
+	name := args["_legend_"].(string)
+	return SeriesMap{name: newSeriesAggregate(series, fn, args["xFilesFactor"].(float64))}, nil
+}
+
+// aggregateByNodes groups the series by the name made of the nodes
+// for which keep returns true, and aggregates every group.
+func aggregateByNodes(series SeriesMap, fname string, keep func(int) bool) (SeriesMap, error) {
+	fn, err := aggFunc(fname)
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[string]SeriesMap)
+	for name, s := range series {
+		var key []string
+		for i, part := range strings.Split(name, ".") {
+			if keep(i) {
+				key = append(key, part)
+			}
+		}
+		group := strings.Join(key, ".")
+		if groups[group] == nil {
+			groups[group] = make(SeriesMap)
+		}
+		groups[group][name] = s
+	}
+	result := make(SeriesMap, len(groups))
+	for name, group := range groups {
+		result[name] = newSeriesAggregate(group, fn, 0)
+	}
+	return result, nil
+}
+
+func nodePositions(nodes []interface{}) map[int]bool {
+	result := make(map[int]bool, len(nodes))
+	for _, n := range nodes {
+		result[int(n.(float64))] = true
+	}
+	return result
+}
+
+// aggregateWithWildcards removes the nodes at positions from the names
+// and aggregates the series whose names then match, e.g.
+// aggregateWithWildcards(a.*.c, sum, 1) is a single series named a.c.
+func dslAggregateWithWildcards(args map[string]interface{}) (SeriesMap, error) {
+	positions := nodePositions(args["positions"].([]interface{}))
+	return aggregateByNodes(args["seriesList"].(SeriesMap), args["func"].(string),
+		func(i int) bool { return !positions[i] })
+}
+
+// groupByNodes is like groupByNode, but the group is made of several
+// nodes, e.g. groupByNodes(a.*.*, sum, 0, 2) results in a series for
+// every unique a.*.X.
+func dslGroupByNodes(args map[string]interface{}) (SeriesMap, error) {
+	nodes := nodePositions(args["nodes"].([]interface{}))
+	return aggregateByNodes(args["seriesList"].(SeriesMap), args["callback"].(string),
+		func(i int) bool { return nodes[i] })
+}
+
+// Tagged series names are "name;tag1=value1;tag2=value2" as in
+// Graphite, with tags in sorted order and escaped as per
+// misc.QueryName.
+
+func taggedName(ident serde.Ident) string {
+	keys := make([]string, 0, len(ident))
+	for k := range ident {
+		if k != "name" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	parts := []string{misc.QueryName(ident["name"])}
+	for _, k := range keys {
+		parts = append(parts, misc.QueryName(k)+"="+misc.QueryName(ident[k]))
+	}
+	return strings.Join(parts, ";")
+}
+
+// parseTaggedName is the reverse of taggedName (without unescaping),
+// a name without tags has only the "name" tag.
+func parseTaggedName(name string) map[string]string {
+	parts := strings.Split(name, ";")
+	result := map[string]string{"name": parts[0]}
+	for _, part := range parts[1:] {
+		if kv := strings.SplitN(part, "=", 2); len(kv) == 2 {
+			result[kv[0]] = kv[1]
+		}
+	}
+	return result
+}
+
+// A tagExpr is a seriesByTag() expression: tag=value, tag!=value,
+// tag=~regex or tag!=~regex. A missing tag has the value "".
+type tagExpr struct {
+	tag, value string
+	re         *regexp.Regexp // for =~
+	neg        bool
+}
+
+func parseTagExpr(expr string) (*tagExpr, error) {
+	i := strings.Index(expr, "=")
+	if i < 1 || i == 1 && expr[0] == '!' {
+		return nil, fmt.Errorf("invalid tag expression: %q", expr)
+	}
+	te := &tagExpr{tag: expr[:i], value: expr[i+1:]}
+	if strings.HasSuffix(te.tag, "!") {
+		te.tag, te.neg = te.tag[:len(te.tag)-1], true
+	}
+	if strings.HasPrefix(te.value, "~") {
+		te.value = te.value[1:]
+		re, err := regexp.Compile("^(?:" + te.value + ")") // anchored at the start like Graphite
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag expression: %q: %v", expr, err)
+		}
+		te.re = re
+	}
+	return te, nil
+}
+
+// requiresValue tells whether the expression only matches series
+// with a (non-empty) value for the tag.
+func (te *tagExpr) requiresValue() bool {
+	if te.neg {
+		return false
+	}
+	if te.re != nil {
+		return !te.re.MatchString("")
+	}
+	return te.value != ""
+}
+
+// match matches the value of the tag, which can be the original or
+// as it appears in names (i.e. escaped).
+func (te *tagExpr) match(ident serde.Ident) bool {
+	v := ident[te.tag]
+	var ok bool
+	if te.re != nil {
+		ok = te.re.MatchString(v) || te.re.MatchString(misc.QueryName(v))
+	} else {
+		ok = v == te.value || misc.QueryName(v) == te.value
+	}
+	return ok != te.neg
+}
+
+// A tagSearcher (i.e. namedDsFetcher) can search DSs by tag values.
+type tagSearcher interface {
+	searchIdents(query serde.SearchQuery) ([]serde.Ident, error)
+}
+
+// seriesByTag
+//
+// Returns the series whose tags match all the expressions, e.g.
+// seriesByTag('name=cpu.load', 'dc=~east|west', 'host!=db1'). At
+// least one expression must require a non-empty value. The series
+// names are tagged, see taggedName().
+func dslSeriesByTag(dc *dslCtx, args []interface{}) (SeriesMap, error) {
+	ts, ok := dc.ctxDSFetcher.(tagSearcher)
+	if !ok {
+		return nil, fmt.Errorf("seriesByTag: tag search not supported by %T", dc.ctxDSFetcher)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("seriesByTag: at least one tag expression is required")
+	}
+
+	var (
+		exprs    []*tagExpr
+		query    = make(serde.SearchQuery)
+		required bool
+	)
+	for _, arg := range args {
+		s, ok := arg.(string)
+		if !ok {
+			return nil, fmt.Errorf("seriesByTag: %v is not a string", arg)
+		}
+		te, err := parseTagExpr(s)
+		if err != nil {
+			return nil, fmt.Errorf("seriesByTag: %v", err)
+		}
+		exprs = append(exprs, te)
+		if te.requiresValue() {
+			required = true
+			// The search narrows it down, the exprs decide. It is a
+			// case-insensitive regex match in the database.
+			if _, ok := query[te.tag]; !ok {
+				query[te.tag] = ".*"
+			}
+			if te.re == nil {
+				query[te.tag] = "^" + regexp.QuoteMeta(te.value) + "$"
+			}
+		}
+	}
+	if !required {
+		return nil, fmt.Errorf("seriesByTag: at least one tag expression must require a non-empty value")
+	}
+
+	found, err := ts.searchIdents(query)
+	if err != nil {
+		return nil, fmt.Errorf("seriesByTag: %v", err)
+	}
+	idents := make(map[string]serde.Ident)
+	for _, ident := range found {
+		matched := true
+		for _, te := range exprs {
+			if !te.match(ident) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			idents[taggedName(ident)] = ident
+		}
+	}
+	return dc.seriesFromIdents(idents, dc.from, dc.to)
+}
+
+// groupByTags aggregates the series grouped by the values of the
+// tags, e.g. groupByTags(seriesByTag('name=cpu'), 'sum', 'dc') results
+// in series named "sum;dc=east", "sum;dc=west". If "name" is one of
+// the tags, its value replaces the callback in the name.
+func dslGroupByTags(args map[string]interface{}) (SeriesMap, error) {
+	callback := args["callback"].(string)
+	fn, err := aggFunc(callback)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		tags    []string
+		useName bool
+	)
+	for _, t := range args["tags"].([]interface{}) {
+		if tag, ok := t.(string); !ok {
+			return nil, fmt.Errorf("groupByTags: %v is not a string", t)
+		} else if tag == "name" {
+			useName = true
+		} else {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+
+	groups := make(map[string]SeriesMap)
+	for name, s := range args["seriesList"].(SeriesMap) {
+		values := parseTaggedName(name)
+		parts := []string{callback}
+		if useName {
+			parts[0] = values["name"]
+		}
+		for _, tag := range tags {
+			parts = append(parts, tag+"="+values[tag])
+		}
+		group := strings.Join(parts, ";")
+		if groups[group] == nil {
+			groups[group] = make(SeriesMap)
+		}
+		groups[group][name] = s
+	}
+	result := make(SeriesMap, len(groups))
+	for name, group := range groups {
+		result[name] = newSeriesAggregate(group, fn, 0)
+	}
+	return result, nil
+}
+
+// aggregateSeriesLists aggregates the two lists pairwise, both must be
+// of the same length.
+func dslAggregateSeriesLists(args map[string]interface{}) (SeriesMap, error) {
+	fname := args["func"].(string)
+	fn, err := aggFunc(fname)
+	if err != nil {
+		return nil, err
+	}
+	first := args["seriesListFirstPos"].(SeriesMap)
+	second := args["seriesListSecondPos"].(SeriesMap)
+	if len(first) != len(second) {
+		return nil, fmt.Errorf("series lists must be of the same length, got %d and %d", len(first), len(second))
+	}
+	fkeys, skeys := first.SortedKeys(), second.SortedKeys()
+	result := make(SeriesMap, len(fkeys))
+	for i := range fkeys {
+		name := fmt.Sprintf("%sSeries(%s,%s)", fname, fkeys[i], skeys[i])
+		ss := &aliasSeriesSlice{SeriesSlice: series.SeriesSlice{first[fkeys[i]], second[skeys[i]]}}
+		ss.Align()
+		result[name] = &seriesAggregate{ss, fn, args["xFilesFactor"].(float64)}
+	}
+	return result, nil
+}
+
+func dslSumSeriesLists(args map[string]interface{}) (SeriesMap, error) {
+	args["func"] = "sum"
+	args["xFilesFactor"] = 0.0
+	return dslAggregateSeriesLists(args)
+}
+
+// applyByNode
+//
+// For every unique prefix of the names up to and including nodeNum,
+// evaluates templateFunction in which "%" is replaced with the
+// prefix. If newName is given, it (also with "%" replaced) becomes the
+// name of the result.
+func dslApplyByNode(dc *dslCtx, args []interface{}) (SeriesMap, error) {
+
+	if len(args) < 3 || len(args) > 4 {
+		return nil, fmt.Errorf("Expecting 3 or 4 arguments, got %d", len(args))
+	}
+
+	var names []string
+	switch arg := args[0].(type) {
+	case string:
+		for name, _ := range dc.identsFromPattern(arg) {
+			names = append(names, name)
+		}
+	case SeriesMap:
+		for name, s := range arg {
+			names = append(names, name)
+			s.Close() // we only need the names
+		}
+	default:
+		return nil, fmt.Errorf("first arg %v is not a string or a SeriesMap", args[0])
+	}
+
+	fnode, ok := args[1].(float64)
+	if !ok {
+		return nil, fmt.Errorf("second arg %v is not a number", args[1])
+	}
+	pos := int(fnode)
+
+	template, ok := args[2].(string)
+	if !ok {
+		return nil, fmt.Errorf("third arg %v is not a string", args[2])
+	}
+
+	var newName string
+	if len(args) > 3 {
+		if newName, ok = args[3].(string); !ok {
+			return nil, fmt.Errorf("forth arg %v is not a string", args[3])
+		}
+	}
+
+	prefixes := make(map[string]bool)
+	for _, name := range names {
+		parts := strings.Split(name, ".")
+		if pos >= len(parts) {
+			continue // ignore
+		}
+		prefixes[strings.Join(parts[:pos+1], ".")] = true
+	}
+
+	result := make(SeriesMap)
+	for prefix, _ := range prefixes {
+		expr := strings.Replace(template, "%", prefix, -1)
+		sub := newDslCtx(dc.ctxDSFetcher, expr, dc.from, dc.to, dc.maxPoints)
+		sub.span = dc.span
+		smap, err := sub.parse()
+		if err != nil {
+			return nil, fmt.Errorf("error in %q: %v", expr, err)
+		}
+		for name, s := range smap {
+			if newName != "" {
+				name = strings.Replace(newName, "%", prefix, -1)
+				s.Alias(name)
+			}
+			result[name] = s
+		}
+	}
+
+	return result, nil
+}
+
+// sortByName, sortByMaxima, sortByMinima, sortByTotal
+//
+// The series are given a position which SeriesMap.SortedKeys()
+// honors. The position is lost if the series is transformed further
+// (aliasing is fine), so these should be applied last.
+
+type seriesSorted struct {
+	AliasSeries
+	pos int
+}
+
+func (s *seriesSorted) position() int {
+	return s.pos
+}
+
+func sortSeriesMap(series SeriesMap, keys []string) SeriesMap {
+	result := make(SeriesMap, len(keys))
+	for i, name := range keys {
+		s := series[name]
+		if sorted, ok := s.(*seriesSorted); ok {
+			s = sorted.AliasSeries // resorting
+		}
+		result[name] = &seriesSorted{s, i}
+	}
+	return result
+}
+
+// naturalLess compares strings such that digits are compared
+// numerically, e.g. "a2" < "a10".
+func naturalLess(a, b string) bool {
+	for len(a) > 0 && len(b) > 0 {
+		ad, bd := digitPrefix(a), digitPrefix(b)
+		if len(ad) > 0 && len(bd) > 0 {
+			an, _ := strconv.ParseFloat(ad, 64)
+			bn, _ := strconv.ParseFloat(bd, 64)
+			if an != bn {
+				return an < bn
+			}
+			a, b = a[len(ad):], b[len(bd):]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func digitPrefix(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+type naturalKeys []string
+
+func (s naturalKeys) Len() int           { return len(s) }
+func (s naturalKeys) Less(i, j int) bool { return naturalLess(s[i], s[j]) }
+func (s naturalKeys) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func dslSortByName(args map[string]interface{}) (SeriesMap, error) {
+	series := args["seriesList"].(SeriesMap)
+	keys := make([]string, 0, len(series))
+	for name, _ := range series {
+		keys = append(keys, name)
+	}
+	if args["natural"].(bool) {
+		sort.Sort(naturalKeys(keys))
+	} else {
+		sort.Strings(keys)
+	}
+	if args["reverse"].(bool) {
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+	return sortSeriesMap(series, keys), nil
+}
+
+// sortBySummary sorts by the summary value computed by fn, reverse
+// means descending. NaN (e.g. an empty series) sorts last.
+func sortBySummary(series SeriesMap, fn func(*aliasSummarySeries) float64, reverse bool) SeriesMap {
+	values := make(map[string]float64, len(series))
+	for name, s := range series {
+		values[name] = fn(newAliasSummarySeries(s))
+	}
+	keys := make([]string, 0, len(series))
+	for name, _ := range series {
+		keys = append(keys, name)
+	}
+	sort.Strings(keys) // so that ties are resolved by name
+	sort.Stable(&summaryKeys{keys, values, reverse})
+	return sortSeriesMap(series, keys)
+}
+
+type summaryKeys struct {
+	keys    []string
+	values  map[string]float64
+	reverse bool
+}
+
+func (s *summaryKeys) Len() int {
+	return len(s.keys)
+}
+
+func (s *summaryKeys) Less(i, j int) bool {
+	vi, vj := s.values[s.keys[i]], s.values[s.keys[j]]
+	if math.IsNaN(vi) || math.IsNaN(vj) {
+		return !math.IsNaN(vi) && math.IsNaN(vj)
+	}
+	if s.reverse {
+		return vi > vj
+	}
+	return vi < vj
+}
+
+func (s *summaryKeys) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+func dslSortByMaxima(args map[string]interface{}) (SeriesMap, error) {
+	return sortBySummary(args["seriesList"].(SeriesMap),
+		func(s *aliasSummarySeries) float64 { return s.Max() }, true), nil
+}
+
+func dslSortByMinima(args map[string]interface{}) (SeriesMap, error) {
+	return sortBySummary(args["seriesList"].(SeriesMap),
+		func(s *aliasSummarySeries) float64 { return s.Min() }, false), nil
+}
+
+func dslSortByTotal(args map[string]interface{}) (SeriesMap, error) {
+	return sortBySummary(args["seriesList"].(SeriesMap),
+		func(s *aliasSummarySeries) float64 { return s.Sum() }, true), nil
+}
+
+// smartSummarize
+//
+// Unlike summarize(), which only scales the values, this actually
+// consolidates them into buckets of intervalString, starting at from
+// or, if alignTo is given (e.g. "1d"), at from truncated to it. As
+// everywhere else, values are per second, so "sum" is the total over
+// the bucket, not a sum of the values.
+
+// bucketValues returns data (the values of the slots ending at
+// start+step, start+2*step, ...) consolidated by fn into buckets of
+// interval beginning at begin.
+func bucketValues(data []float64, start time.Time, step time.Duration, begin time.Time, interval time.Duration,
+	fn func([]float64) float64, perSecond bool) []float64 {
+
+	buckets := make([][]float64, 0)
+	for i, v := range data {
+		slotBegin := start.Add(step * time.Duration(i-1))
+		if slotBegin.Before(begin) {
+			continue
+		}
+		n := int(slotBegin.Sub(begin) / interval)
+		for len(buckets) <= n {
+			buckets = append(buckets, nil)
+		}
+		if !math.IsNaN(v) {
+			if perSecond {
+				v *= step.Seconds()
+			}
+			buckets[n] = append(buckets[n], v)
+		}
+	}
+	result := make([]float64, len(buckets))
+	for i, values := range buckets {
+		if len(values) == 0 {
+			result[i] = math.NaN()
+		} else {
+			result[i] = fn(values)
+		}
+	}
+	return result
+}
+
+func summarizeSeries(s AliasSeries, args map[string]interface{}, interval time.Duration, begin time.Time,
+	fname string, fn func([]float64) float64) AliasSeries {
+
+	in := readAnomalyInput(s, args, 0)
+	perSecond := fname == "sum" || fname == "total"
+	data := bucketValues(in.data, in.start, in.step, begin, interval, fn, perSecond)
+	// internally we mark ends of slots, not beginnings
+	return series.NewSliceSeries(data, begin.Add(interval), interval)
+}
+
+func dslSmartSummarize(args map[string]interface{}) (SeriesMap, error) {
+	series := args["seriesList"].(SeriesMap)
+	is := args["intervalString"].(string)
+	fname := args["func"].(string)
+	alignTo := args["alignTo"].(string)
+
+	interval, err := misc.BetterParseDuration(is)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid interval: %v", is)
+	}
+	fn, err := aggFunc(fname)
+	if err != nil {
+		return nil, err
+	}
+	begin := args["_from_"].(time.Time)
+	if alignTo != "" {
+		unit, err := misc.BetterParseDuration(alignTo)
+		if err != nil {
+			return nil, err
+		}
+		begin = begin.Truncate(unit)
+	}
+
+	result := make(SeriesMap, len(series))
+	for name, s := range series {
+		ss := summarizeSeries(s, args, interval, begin, fname, fn)
+		ss.Alias(fmt.Sprintf("smartSummarize(%v,%v,%v)", name, is, fname))
+		result[name] = ss
+	}
+	return result, nil
+}
+
+// perSecond
+//
+// Only makes sense for series which store counter values as is
+// (e.g. with the "last" function), everything else is already per
+// second.
+
+type seriesPerSecond struct {
+	*seriesNonNegativeDerivative
+}
+
+func (f *seriesPerSecond) CurrentValue() float64 {
+	// An ungrouped series may have a GroupBy() of 0
+	interval := f.GroupBy()
+	if step := f.Step(); interval < step {
+		interval = step
+	}
+	return f.seriesNonNegativeDerivative.CurrentValue() / interval.Seconds()
+}
+
+func dslPerSecond(args map[string]interface{}) (SeriesMap, error) {
+	series := args["seriesList"].(SeriesMap)
+	maxValue := args["maxValue"].(float64)
+	for name, s := range series {
+		s.Alias(fmt.Sprintf("perSecond(%s)", name))
+		series[name] = &seriesPerSecond{&seriesNonNegativeDerivative{s, math.NaN(), maxValue}}
+	}
+	return series, nil
+}
+
+// delay
+//
+// Shifts the values (not the times, see timeShift) by steps points,
+// the values shifted in are NaN.
+
+func dslDelay(args map[string]interface{}) (SeriesMap, error) {
+	series := args["seriesList"].(SeriesMap)
+	steps := int(args["steps"].(float64))
+	result := make(SeriesMap, len(series))
+	for name, s := range series {
+		in := readAnomalyInput(s, args, 0)
+		data := make([]float64, len(in.data))
+		for i := range data {
+			if j := i - steps; j >= 0 && j < len(in.data) {
+				data[i] = in.data[j]
+			} else {
+				data[i] = math.NaN()
+			}
+		}
+		result[name] = in.output(data, fmt.Sprintf("delay(%s,%d)", name, steps))
+	}
+	return result, nil
+}
+
+// interpolate
+//
+// Fills gaps of up to limit NaNs between known values linearly.
+
+func dslInterpolate(args map[string]interface{}) (SeriesMap, error) {
+	series := args["seriesList"].(SeriesMap)
+	limit := args["limit"].(float64)
+	result := make(SeriesMap, len(series))
+	for name, s := range series {
+		in := readAnomalyInput(s, args, 0)
+		data := make([]float64, len(in.data))
+		copy(data, in.data)
+		last := -1 // last known
+		for i, v := range data {
+			if math.IsNaN(v) {
+				continue
+			}
+			if gap := i - last - 1; last >= 0 && gap > 0 && float64(gap) <= limit {
+				delta := (v - data[last]) / float64(i-last)
+				for j := last + 1; j < i; j++ {
+					data[j] = data[last] + delta*float64(j-last)
+				}
+			}
+			last = i
+		}
+		result[name] = in.output(data, fmt.Sprintf("interpolate(%s)", name))
+	}
+	return result, nil
+}
+
+// parseRelativeTime parses a time relative to to, "now" (which is
+// to), e.g. "-1h" or a Unix timestamp.
+func parseRelativeTime(s string, to time.Time) (time.Time, error) {
+	if s == "now" || s == "" {
+		return to, nil
+	}
+	if ts, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(ts, 0), nil
+	}
+	dur, err := parseTimeShift(s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return to.Add(dur), nil
+}
+
+// linearRegression
+//
+// A least squares line fitted to the values between startSourceAt and
+// endSourceAt (the whole series by default). The source can begin
+// before from, e.g. linearRegression(foo, "-7d") is the trend of the
+// last week.
+
+func linearFit(data []float64) (intercept, slope float64) {
+	var n, sx, sy, sxx, sxy float64
+	for i, y := range data {
+		if math.IsNaN(y) {
+			continue
+		}
+		x := float64(i)
+		n++
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+	}
+	if n == 0 {
+		return math.NaN(), math.NaN()
+	}
+	if denom := n*sxx - sx*sx; denom != 0 {
+		slope = (n*sxy - sx*sy) / denom
+	}
+	intercept = (sy - slope*sx) / n
+	return
+}
+
+func dslLinearRegression(args map[string]interface{}) (SeriesMap, error) {
+	series := args["seriesList"].(SeriesMap)
+	from := args["_from_"].(time.Time)
+	to := args["_to_"].(time.Time)
+
+	startSourceAt, endSourceAt := args["startSourceAt"].(string), args["endSourceAt"].(string)
+	srcFrom, srcTo := from, to
+	var err error
+	if startSourceAt != "" {
+		if srcFrom, err = parseRelativeTime(startSourceAt, to); err != nil {
+			return nil, err
+		}
+	}
+	if endSourceAt != "" {
+		if srcTo, err = parseRelativeTime(endSourceAt, to); err != nil {
+			return nil, err
+		}
+	}
+	var history time.Duration
+	if srcFrom.Before(from) {
+		history = from.Sub(srcFrom)
+	}
+
+	result := make(SeriesMap, len(series))
+	for name, s := range series {
+		in := readAnomalyInput(s, args, history)
+		src := make([]float64, len(in.data))
+		for i, v := range in.data {
+			t := in.start.Add(in.step * time.Duration(i))
+			if t.After(srcFrom) && !t.After(srcTo) {
+				src[i] = v
+			} else {
+				src[i] = math.NaN()
+			}
+		}
+		intercept, slope := linearFit(src)
+		data := make([]float64, len(in.data))
+		for i := range data {
+			data[i] = intercept + slope*float64(i)
+		}
+		result[name] = in.output(data, fmt.Sprintf("linearRegression(%s,%s,%s)", name, startSourceAt, endSourceAt))
+	}
+	return result, nil
+}
+
+// timeSlice
+//
+// Values outside of startSliceAt and endSliceAt become NaN.
+
+type seriesTimeSlice struct {
+	AliasSeries
+	start, end time.Time
+}
+
+func (f *seriesTimeSlice) CurrentValue() float64 {
+	if t := f.CurrentTime(); t.Before(f.start) || t.After(f.end) {
+		return math.NaN()
+	}
+	return f.AliasSeries.CurrentValue()
+}
+
+func dslTimeSlice(args map[string]interface{}) (SeriesMap, error) {
+	series := args["seriesList"].(SeriesMap)
+	to := args["_to_"].(time.Time)
+	startSliceAt, endSliceAt := args["startSliceAt"].(string), args["endSliceAt"].(string)
+	start, err := parseRelativeTime(startSliceAt, to)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseRelativeTime(endSliceAt, to)
+	if err != nil {
+		return nil, err
+	}
+	for name, s := range series {
+		s.Alias(fmt.Sprintf("timeSlice(%s,%s,%s)", name, startSliceAt, endSliceAt))
+		series[name] = &seriesTimeSlice{s, start, end}
+	}
+	return series, nil
+}
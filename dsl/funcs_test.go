@@ -7,8 +7,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/tgres/tgres/misc"
 	"github.com/tgres/tgres/rrd"
 	"github.com/tgres/tgres/serde"
+	"github.com/tgres/tgres/series"
 )
 
 // TODO: These are happy path tests, need more edge-case testing
@@ -1022,3 +1024,471 @@ func Test_dsl_summarize(t *testing.T) {
 		t.Errorf("Unexpected value: %v", unexpected)
 	}
 }
+
+// collect values of the series named name in sm
+func seriesValues(sm SeriesMap, name string) []float64 {
+	var result []float64
+	if s, ok := sm[name]; ok {
+		for s.Next() {
+			result = append(result, s.CurrentValue())
+		}
+	}
+	return result
+}
+
+// rollingZScore, madOutliers
+func Test_dsl_rollingZScore_madOutliers(t *testing.T) {
+	td := setupTestData()
+	step := td.to.Sub(td.from) / 100
+
+	// noise with a spike at 60, also on top of a large value (as
+	// e.g. a counter) where the variance is easily lost in rounding
+	data := make([]float64, 100)
+	for i := range data {
+		data[i] = float64(10 + i%2)
+	}
+	data[60] = 50
+	offset := make([]float64, len(data))
+	for i, v := range data {
+		offset[i] = 1e9 + v
+	}
+
+	for name, fn := range map[string]func(map[string]interface{}) (SeriesMap, error){
+		"rollingZScore":           dslRollingZScore,
+		"madOutliers":             dslMadOutliers,
+		"rollingZScore (offset)": dslRollingZScore,
+	} {
+		data := data
+		if strings.HasSuffix(name, "(offset)") {
+			data = offset
+		}
+		ss := series.NewSliceSeries(data, td.from.Add(step), step)
+		sm, err := fn(map[string]interface{}{
+			"seriesList":  SeriesMap{"foo": ss},
+			"windowSize":  "10",
+			"threshold":   3.0,
+			"show":        "bands,anomaly",
+			"_from_":      td.from,
+			"_to_":        td.to,
+			"_maxPoints_": int64(100),
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		anomaly := seriesValues(sm, "foo.anomaly")
+		if len(anomaly) != 100 {
+			t.Errorf("%s: expected 100 points, got %d", name, len(anomaly))
+		}
+		for i, v := range anomaly {
+			if i < 10 {
+				if !math.IsNaN(v) {
+					t.Errorf("%s: the first window (10) points should be NaN, got %v", name, v)
+				}
+			} else if (i == 60) != (v == 1) {
+				t.Errorf("%s: anomaly at %d is %v", name, i, v)
+			}
+		}
+		if len(seriesValues(sm, "foo.upper")) != 100 || len(seriesValues(sm, "foo.lower")) != 100 {
+			t.Errorf("%s: missing bands", name)
+		}
+	}
+}
+
+// stlDecompose
+func Test_dsl_stlDecompose(t *testing.T) {
+	td := setupTestData()
+	sm, err := ParseDsl(nil, "stlDecompose(sinusoid(), '18min', 3, true, 'trend,seasonal,remainder')", td.from, td.to, 100)
+	if err != nil {
+		t.Error(err)
+	}
+	trend := seriesValues(sm, "sinusoid().trend")
+	seasonal := seriesValues(sm, "sinusoid().seasonal")
+	remainder := seriesValues(sm, "sinusoid().remainder")
+	if len(trend) != 100 || len(seasonal) != 100 || len(remainder) != 100 {
+		t.Fatalf("stlDecompose: expected 100 points")
+	}
+	for i := 0; i < 100; i++ {
+		expect := math.Sin(2 * math.Pi / 100 * float64(i))
+		if got := trend[i] + seasonal[i] + remainder[i]; math.Abs(got-expect) > 1e-9 {
+			t.Errorf("stlDecompose: trend + seasonal + remainder (%v) != data (%v) at %d", got, expect, i)
+		}
+	}
+}
+
+// cusum
+func Test_dsl_cusum(t *testing.T) {
+	td := setupTestData()
+	sm, err := ParseDsl(nil, "cusum(sinusoid(), 10)", td.from, td.to, 100)
+	if err != nil {
+		t.Error(err)
+	}
+	changes := 0
+	for _, v := range seriesValues(sm, "sinusoid().anomaly") {
+		if v == 1 {
+			changes++
+		}
+	}
+	if changes == 0 {
+		t.Errorf("cusum: the sinusoid departure from baseline should have been detected")
+	}
+}
+
+// setupNamedTestData creates a DS with a constant value for every name
+// and returns a fetcher for them.
+func setupNamedTestData(t *testing.T, values map[string]float64) ctxDSFetcher {
+	idents := make(map[*serde.Ident]float64, len(values))
+	for name, value := range values {
+		idents[&serde.Ident{"name": name}] = value
+	}
+	return setupTaggedTestData(t, idents)
+}
+
+func setupTaggedTestData(t *testing.T, values map[*serde.Ident]float64) ctxDSFetcher {
+	td := setupTestData()
+
+	db := serde.NewMemSerDe()
+	for ident, value := range values {
+		rspec := rrd.RRASpec{
+			Function: rrd.WMEAN,
+			Step:     time.Minute,
+			Span:     time.Hour,
+			Latest:   td.when,
+			DPs:      make(map[int64]float64),
+		}
+		for i := int64(0); i < 60; i++ {
+			rspec.DPs[i] = value
+		}
+		spec := &rrd.DSSpec{Step: time.Second, RRAs: []rrd.RRASpec{rspec}}
+		if _, err := db.FetchOrCreateDataSource(*ident, spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewNamedDSFetcher(db.Fetcher(), nil, 0)
+}
+
+func checkSeriesValues(t *testing.T, sm SeriesMap, expected map[string]float64) {
+	if len(sm) != len(expected) {
+		t.Errorf("expected %d series, got %d: %v", len(expected), len(sm), sm.SortedKeys())
+	}
+	for name, value := range expected {
+		if ok, unexpected := checkEveryValueIs(SeriesMap{name: sm[name]}, value); !ok {
+			t.Errorf("%s: unexpected value: %v", name, unexpected)
+		}
+	}
+}
+
+// aggregate
+func Test_dsl_aggregate(t *testing.T) {
+	td := setupTestData()
+	for fn, expect := range map[string]float64{
+		"average": 20, "median": 20, "sum": 60, "min": 10, "max": 30,
+		"diff": -40, "count": 3, "range": 20, "multiply": 6000, "last": 30,
+	} {
+		expr := fmt.Sprintf("aggregate(group(constantLine(10), constantLine(20), constantLine(30)), '%s')", fn)
+		sm, err := ParseDsl(nil, expr, td.from, td.to, 100)
+		if err != nil {
+			t.Error(err)
+		}
+		if ok, unexpected := checkEveryValueIs(sm, expect); !ok {
+			t.Errorf("%s: unexpected value: %v", fn, unexpected)
+		}
+	}
+	if _, err := ParseDsl(nil, "aggregate(constantLine(10), 'bogus')", td.from, td.to, 100); err == nil {
+		t.Errorf("an unknown aggregation function should be an error")
+	}
+}
+
+// aggregateWithWildcards
+// groupByNodes
+// applyByNode
+// sumSeriesLists
+func Test_dsl_aggregateByNodes(t *testing.T) {
+	td := setupTestData()
+	db := setupNamedTestData(t, map[string]float64{
+		"agg.a.x": 1, "agg.a.y": 2, "agg.b.x": 4, "agg.b.y": 8,
+	})
+
+	for expr, expect := range map[string]map[string]float64{
+		`aggregateWithWildcards("agg.*.*", sum, 2)`:                        {"agg.a": 3, "agg.b": 12},
+		`aggregateWithWildcards("agg.*.*", max, 1, 2)`:                     {"agg": 8},
+		`groupByNodes("agg.*.*", sum, 0, 2)`:                               {"agg.x": 5, "agg.y": 10},
+		`groupByNodes("agg.*.*", averageSeries, 1)`:                        {"a": 1.5, "b": 6},
+		`applyByNode("agg.*.*", 1, "sumSeries(%.*)", "%.total")`:           {"agg.a.total": 3, "agg.b.total": 12},
+		`sumSeriesLists("agg.a.*", "agg.b.*")`:                             {"sumSeries(agg.a.x,agg.b.x)": 5, "sumSeries(agg.a.y,agg.b.y)": 10},
+		`aggregateSeriesLists("agg.a.*", "agg.b.*", 'diff')`:               {"diffSeries(agg.a.x,agg.b.x)": -3, "diffSeries(agg.a.y,agg.b.y)": -6},
+		`applyByNode(aliasByNode("agg.*.*", 0), 0, "maxSeries(%.*.*)")`:    {"maxSeries(agg.*.*)": 8},
+		`aggregateWithWildcards(scale("agg.a.*", 10), average, 0, 1)`:      {"x": 10, "y": 20},
+		`groupByNodes(sumSeriesWithWildcards("agg.*.*", 2), count, 0)`:     {"agg": 2},
+		`aggregateWithWildcards(exclude("agg.*.*", "agg.a"), multiply, 2)`: {"agg.b": 32},
+	} {
+		sm, err := ParseDsl(db, expr, td.from, td.to, 100)
+		if err != nil {
+			t.Errorf("%s: %v", expr, err)
+			continue
+		}
+		checkSeriesValues(t, sm, expect)
+	}
+
+	if _, err := ParseDsl(db, `sumSeriesLists("agg.a.*", "agg.b.x")`, td.from, td.to, 100); err == nil {
+		t.Errorf("sumSeriesLists of lists of different length should be an error")
+	}
+}
+
+// sortByName
+// sortByMaxima
+// sortByMinima
+// sortByTotal
+func Test_dsl_sortBy(t *testing.T) {
+	td := setupTestData()
+	db := setupNamedTestData(t, map[string]float64{
+		"sort.a": 3, "sort.b": 1, "sort.c": 2,
+	})
+
+	for expr, expect := range map[string][]string{
+		`sortByMaxima("sort.*")`:                      {"sort.a", "sort.c", "sort.b"},
+		`sortByTotal("sort.*")`:                       {"sort.a", "sort.c", "sort.b"},
+		`sortByMinima("sort.*")`:                      {"sort.b", "sort.c", "sort.a"},
+		`sortByName(sortByMinima("sort.*"))`:          {"sort.a", "sort.b", "sort.c"},
+		`sortByName("sort.*", false, true)`:           {"sort.c", "sort.b", "sort.a"},
+		`aliasByNode(sortByMaxima("sort.*"), 1)`:      {"sort.a", "sort.c", "sort.b"},
+		`group(sortByMinima("sort.{a,b}"), "sort.c")`: {"sort.b", "sort.a", "sort.c"},
+	} {
+		sm, err := ParseDsl(db, expr, td.from, td.to, 100)
+		if err != nil {
+			t.Errorf("%s: %v", expr, err)
+			continue
+		}
+		if keys := sm.SortedKeys(); strings.Join(keys, " ") != strings.Join(expect, " ") {
+			t.Errorf("%s: expected order %v, got %v", expr, expect, keys)
+		}
+	}
+
+	sm, err := ParseDsl(nil, "sortByName(group(constantLine(10), constantLine(9)), true)", td.from, td.to, 100)
+	if err != nil {
+		t.Error(err)
+	}
+	if keys := sm.SortedKeys(); keys[0] != "constantLine(9)" {
+		t.Errorf("natural sort: expected constantLine(9) first, got %v", keys)
+	}
+}
+
+// smartSummarize
+// hitcount (alignToInterval)
+func Test_dsl_smartSummarize(t *testing.T) {
+	td := setupTestData()
+	db := setupNamedTestData(t, map[string]float64{"summ.a": 2})
+
+	sm, err := ParseDsl(db, `smartSummarize("summ.a", "10min", "sum")`, td.from, td.to, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := seriesValues(sm, "summ.a")
+	if len(values) < 5 {
+		t.Fatalf("smartSummarize: expected at least 5 buckets, got %v", values)
+	}
+	for _, v := range values[:5] {
+		if v != 1200 {
+			t.Errorf("smartSummarize: expected every full bucket to be 1200, got %v", values)
+			break
+		}
+	}
+
+	sm, err = ParseDsl(db, `smartSummarize("summ.a", "10min", "avg")`, td.from, td.to, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, unexpected := checkEveryValueIs(sm, 2); !ok {
+		t.Errorf("smartSummarize avg: unexpected value: %v", unexpected)
+	}
+
+	sm, err = ParseDsl(db, `hitcount("summ.a", "15min", true)`, td.from, td.to, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := sm["summ.a"]
+	if !s.Next() || s.CurrentTime() != td.from.Truncate(15*time.Minute).Add(15*time.Minute) {
+		t.Errorf("hitcount: the first bucket should be aligned to the interval, got %v", s.CurrentTime())
+	}
+	if !s.Next() || s.CurrentValue() != 1800 {
+		t.Errorf("hitcount: expected 1800, got %v", s.CurrentValue())
+	}
+}
+
+// perSecond
+// delay
+// interpolate
+// linearRegression
+// timeSlice
+func Test_dsl_perSecond_delay_interpolate(t *testing.T) {
+	td := setupTestData()
+	step := time.Minute
+	nan := math.NaN()
+
+	args := func(data []float64) map[string]interface{} {
+		return map[string]interface{}{
+			"seriesList":  SeriesMap{"foo": series.NewSliceSeries(data, td.from.Add(step), step)},
+			"_from_":      td.from,
+			"_to_":        td.to,
+			"_maxPoints_": int64(60),
+		}
+	}
+
+	a := args([]float64{0, 60, 120, 30, 90})
+	a["maxValue"] = nan
+	sm, _ := dslPerSecond(a)
+	values := seriesValues(sm, "foo")
+	if !math.IsNaN(values[0]) || values[1] != 1 || values[2] != 1 || !math.IsNaN(values[3]) || values[4] != 1 {
+		t.Errorf("perSecond: unexpected %v", values)
+	}
+
+	// Without maxPoints an RRA series is not grouped
+	rra := rrd.NewRoundRobinArchive(rrd.RRASpec{Function: rrd.WMEAN, Step: step, Span: 10 * step, Latest: td.from.Add(3 * step)})
+	for i, v := range []float64{0, 60, 120} {
+		rra.DPs()[rrd.SlotIndex(td.from.Add(time.Duration(i+1)*step), step, rra.Size())] = v
+	}
+	rs := series.NewRRASeries(rra)
+	rs.TimeRange(td.from.Add(step), td.from.Add(3*step))
+	a["seriesList"] = SeriesMap{"foo": rs}
+	sm, _ = dslPerSecond(a)
+	values = seriesValues(sm, "foo")
+	if len(values) != 3 || !math.IsNaN(values[0]) || values[1] != 1 || values[2] != 1 {
+		t.Errorf("perSecond: without maxPoints: unexpected %v", values)
+	}
+
+	a = args([]float64{1, 2, 3, 4})
+	a["steps"] = 2.0
+	sm, _ = dslDelay(a)
+	values = seriesValues(sm, "foo")
+	if !math.IsNaN(values[0]) || !math.IsNaN(values[1]) || values[2] != 1 || values[3] != 2 {
+		t.Errorf("delay: unexpected %v", values)
+	}
+
+	a = args([]float64{nan, 1, nan, nan, 4, nan, nan, nan, 8, nan})
+	a["limit"] = 2.0
+	sm, _ = dslInterpolate(a)
+	values = seriesValues(sm, "foo")
+	expect := []float64{nan, 1, 2, 3, 4, nan, nan, nan, 8, nan}
+	for i := range expect {
+		if values[i] != expect[i] && !(math.IsNaN(values[i]) && math.IsNaN(expect[i])) {
+			t.Errorf("interpolate: expected %v, got %v", expect, values)
+			break
+		}
+	}
+
+	a = args([]float64{1, 3, nan, 7, 9, 50})
+	a["startSourceAt"] = ""
+	a["endSourceAt"] = fmt.Sprintf("%d", td.from.Add(5*step).Unix())
+	sm, err := dslLinearRegression(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values = seriesValues(sm, "foo")
+	for i, v := range values {
+		if math.Abs(v-float64(2*i+1)) > 1e-9 {
+			t.Errorf("linearRegression: expected 2x+1, got %v", values)
+			break
+		}
+	}
+
+	sm, err = ParseDsl(nil, "timeSlice(sinusoid(), '-30min')", td.from, td.to, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := sm["sinusoid()"]
+	for s.Next() {
+		if before := s.CurrentTime().Before(td.to.Add(-30 * time.Minute)); before != math.IsNaN(s.CurrentValue()) {
+			t.Errorf("timeSlice: unexpected %v at %v", s.CurrentValue(), s.CurrentTime())
+		}
+	}
+}
+
+// original names, escaped in queries as per misc.QueryName
+func Test_dsl_escapedNames(t *testing.T) {
+	defer func(p misc.NamePolicy) { misc.SanitizeNamePolicy = p }(misc.SanitizeNamePolicy)
+	td := setupTestData()
+
+	misc.SanitizeNamePolicy = misc.NameEscape
+	db := setupNamedTestData(t, map[string]float64{
+		"esc.a b.x": 1, "esc.a/b.x": 2, "esc.100%.x": 4, "esc.héllo.x": 8,
+	})
+	for expr, expect := range map[string]map[string]float64{
+		`group(esc.a%20b.x)`:      {"esc.a%20b.x": 1},
+		`group("esc.a%2Fb.x")`:    {"esc.a%2Fb.x": 2},
+		`group("esc.100%25.x")`:   {"esc.100%25.x": 4},
+		`group(esc.h%C3%A9llo.x)`: {"esc.h%C3%A9llo.x": 8},
+		`sumSeries(esc.a*.x)`:     {"sumSeries(esc.a*.x)": 3},
+		`sumSeries(esc.*%*.x)`:    {"sumSeries(esc.*%*.x)": 15},
+	} {
+		sm, err := ParseDsl(db, expr, td.from, td.to, 100)
+		if err != nil {
+			t.Errorf("%s: %v", expr, err)
+			continue
+		}
+		checkSeriesValues(t, sm, expect)
+	}
+
+	misc.SanitizeNamePolicy = misc.NameUTF8
+	db = setupNamedTestData(t, map[string]float64{
+		"utf.héllo.wörld": 1, "utf.日本.x": 2, "utf.日 本.x": 4,
+	})
+	for expr, expect := range map[string]map[string]float64{
+		`group(utf.héllo.wörld)`: {"utf.héllo.wörld": 1},
+		`group(utf.日本.*)`:        {"utf.日本.x": 2},
+		`group(utf.日%20本.x)`:     {"utf.日%20本.x": 4},
+		`sumSeries("utf.*.*")`:   {"sumSeries(utf.*.*)": 7},
+	} {
+		sm, err := ParseDsl(db, expr, td.from, td.to, 100)
+		if err != nil {
+			t.Errorf("%s: %v", expr, err)
+			continue
+		}
+		checkSeriesValues(t, sm, expect)
+	}
+}
+
+// seriesByTag, groupByTags
+func Test_dsl_seriesByTag(t *testing.T) {
+	td := setupTestData()
+	db := setupTaggedTestData(t, map[*serde.Ident]float64{
+		&serde.Ident{"name": "cpu", "dc": "east", "host": "a"}:       1,
+		&serde.Ident{"name": "cpu", "dc": "east", "host": "b"}:       2,
+		&serde.Ident{"name": "cpu", "dc": "west", "host": "c"}:       4,
+		&serde.Ident{"name": "cpu", "host": "d"}:                     8,
+		&serde.Ident{"name": "mem", "dc": "east", "host": "a"}:       16,
+		&serde.Ident{"name": "cpu", "dc": "north pole", "host": "e"}: 32,
+	})
+
+	for expr, expect := range map[string]map[string]float64{
+		`seriesByTag('name=cpu', 'host=a')`:              {"cpu;dc=east;host=a": 1},
+		`seriesByTag('name=cpu', 'dc=~ea')`:              {"cpu;dc=east;host=a": 1, "cpu;dc=east;host=b": 2},
+		`seriesByTag('dc=east', 'name!=cpu')`:            {"mem;dc=east;host=a": 16},
+		`seriesByTag('name=cpu', 'dc=')`:                 {"cpu;host=d": 8},
+		`seriesByTag('name=cpu', 'dc!=~east|west|no.*')`: {"cpu;host=d": 8},
+		`seriesByTag('dc=north pole')`:                   {"cpu;dc=north pole;host=e": 32},
+		`seriesByTag('host=x')`:                          {},
+		`groupByTags(seriesByTag('name=cpu', 'dc=~.+'), 'sum', 'dc')`: {
+			"sum;dc=east": 3, "sum;dc=west": 4, "sum;dc=north pole": 32},
+		`groupByTags(seriesByTag('host=a'), 'maxSeries', 'name')`: {"cpu": 1, "mem": 16},
+		`groupByTags(seriesByTag('host=~.'), 'sum', 'name', 'dc')`: {
+			"cpu;dc=east": 3, "cpu;dc=west": 4, "cpu;dc=": 8, "mem;dc=east": 16, "cpu;dc=north pole": 32},
+	} {
+		sm, err := ParseDsl(db, expr, td.from, td.to, 100)
+		if err != nil {
+			t.Errorf("%s: %v", expr, err)
+			continue
+		}
+		checkSeriesValues(t, sm, expect)
+	}
+
+	for _, expr := range []string{
+		`seriesByTag('dc!=east')`, // nothing required
+		`seriesByTag('dc=~.*')`,
+		`seriesByTag('=east')`,
+		`seriesByTag('dc=~(')`,
+		`groupByTags(seriesByTag('name=cpu'), 'foo', 'dc')`,
+	} {
+		if _, err := ParseDsl(db, expr, td.from, td.to, 100); err == nil {
+			t.Errorf("%s: expected an error", expr)
+		}
+	}
+}
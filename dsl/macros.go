@@ -0,0 +1,278 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dsl
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Macro is a user-defined DSL function, e.g.
+//
+//	errRate(svc) = divideSeries(sumSeries(svc.$svc.errors), sumSeries(svc.$svc.requests))
+//
+// Parameters are referenced in the body as $name. When the macro is
+// called, they are replaced by the text of the arguments, thus an
+// argument can be a part of a series name as above. An argument which
+// is a series (i.e. the result of a function) can only be used in
+// place of a whole argument, e.g. errRate(sumSeries(...)) requires a
+// body such as scale($svc, 100).
+type Macro struct {
+	Name   string   `json:"name"`
+	Params []string `json:"params"`
+	Body   string   `json:"body"`
+}
+
+func (m *Macro) String() string {
+	return fmt.Sprintf("%s(%s) = %s", m.Name, strings.Join(m.Params, ", "), m.Body)
+}
+
+// Macros calling macros are limited to this depth, which also breaks
+// recursion.
+const MaxMacroDepth = 16
+
+var (
+	// protects dslCtxFuncs, which macros are registered in, and macros
+	macrosLock sync.RWMutex
+	macros     = make(map[string]*Macro)
+
+	macroIdentRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	macroDefRe   = regexp.MustCompile(`^\s*([^\s(]+)\s*\(([^)]*)\)\s*=\s*(.+?)\s*$`)
+	macroParamRe = regexp.MustCompile(`\$([A-Za-z_][A-Za-z0-9_]*)`)
+)
+
+// ParseMacro parses a definition such as "name(a, b) = body". The
+// macro is not validated, see DefineMacro().
+func ParseMacro(def string) (*Macro, error) {
+	m := macroDefRe.FindStringSubmatch(def)
+	if m == nil {
+		return nil, fmt.Errorf("Invalid macro definition %q, expecting name(param, ...) = expression", def)
+	}
+	macro := &Macro{Name: m[1], Body: m[3]}
+	if params := strings.TrimSpace(m[2]); params != "" {
+		for _, p := range strings.Split(params, ",") {
+			macro.Params = append(macro.Params, strings.TrimSpace(p))
+		}
+	}
+	return macro, nil
+}
+
+// DefineMacro parses and validates the definition and makes it
+// available to all DSL expressions. An existing macro by the same
+// name is replaced, a built-in function cannot be.
+func DefineMacro(def string) (*Macro, error) {
+	macro, err := ParseMacro(def)
+	if err != nil {
+		return nil, err
+	}
+
+	macrosLock.Lock()
+	defer macrosLock.Unlock()
+
+	if err := macro.validate(); err != nil {
+		return nil, err
+	}
+	macros[macro.Name] = macro
+	dslCtxFuncs[macro.Name] = macro.call
+	return macro, nil
+}
+
+// UndefineMacro removes the macro.
+func UndefineMacro(name string) error {
+	macrosLock.Lock()
+	defer macrosLock.Unlock()
+
+	if _, ok := macros[name]; !ok {
+		return fmt.Errorf("No such macro: %q", name)
+	}
+	delete(macros, name)
+	delete(dslCtxFuncs, name)
+	return nil
+}
+
+// Macros returns all macros sorted by name.
+func Macros() []*Macro {
+	macrosLock.RLock()
+	defer macrosLock.RUnlock()
+
+	names := make([]string, 0, len(macros))
+	for name, _ := range macros {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := make([]*Macro, 0, len(names))
+	for _, name := range names {
+		m := *macros[name]
+		result = append(result, &m)
+	}
+	return result
+}
+
+func lookupDslCtxFunc(name string) (dslCtxFuncType, bool) {
+	macrosLock.RLock()
+	defer macrosLock.RUnlock()
+	fn, ok := dslCtxFuncs[name]
+	return fn, ok
+}
+
+// validate checks the name and parameters and that the body parses
+// and only calls functions that exist. Must be called with macrosLock
+// held.
+func (m *Macro) validate() error {
+	if !macroIdentRe.MatchString(m.Name) {
+		return fmt.Errorf("Invalid macro name: %q", m.Name)
+	}
+	if _, ok := preprocessArgFuncs[m.Name]; ok {
+		return fmt.Errorf("%q is a built-in function", m.Name)
+	}
+	if _, ok := dslCtxFuncs[m.Name]; ok && macros[m.Name] == nil {
+		return fmt.Errorf("%q is a built-in function", m.Name)
+	}
+
+	params := make(map[string]bool, len(m.Params))
+	for _, p := range m.Params {
+		if !macroIdentRe.MatchString(p) {
+			return fmt.Errorf("Invalid macro parameter name: %q", p)
+		}
+		if params[p] {
+			return fmt.Errorf("Duplicate macro parameter: %q", p)
+		}
+		params[p] = true
+	}
+
+	var unknown string
+	body := macroParamRe.ReplaceAllStringFunc(m.Body, func(ref string) string {
+		if !params[ref[1:]] && unknown == "" {
+			unknown = ref
+		}
+		return "x"
+	})
+	if unknown != "" {
+		return fmt.Errorf("Unknown macro parameter: %q", unknown)
+	}
+
+	tr, err := parser.ParseExpr(fixBackSlashes(fixQuotes(escapeBadChars(body))))
+	if err != nil {
+		return fmt.Errorf("Error parsing macro body %q: %v", m.Body, err)
+	}
+	if _, ok := tr.(*ast.CallExpr); !ok {
+		return fmt.Errorf("Macro body %q is not a function call", m.Body)
+	}
+	ast.Inspect(tr, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok || err != nil {
+			return err == nil
+		}
+		var name string
+		switch fn := call.Fun.(type) {
+		case *ast.Ident:
+			name = fn.Name
+		case *ast.SelectorExpr:
+			name = fn.Sel.Name
+		}
+		if name == m.Name {
+			err = fmt.Errorf("Macro %q cannot call itself", m.Name)
+		} else if _, ok := preprocessArgFuncs[name]; !ok {
+			if _, ok := dslCtxFuncs[name]; !ok {
+				err = fmt.Errorf("Macro %q calls unknown function %q", m.Name, name)
+			}
+		}
+		return err == nil
+	})
+	return err
+}
+
+// call expands the macro and evaluates the result in a new context.
+func (m *Macro) call(dc *dslCtx, args []interface{}) (SeriesMap, error) {
+	if len(args) != len(m.Params) {
+		return nil, fmt.Errorf("Expecting %d arguments, got %d", len(m.Params), len(args))
+	}
+	if dc.depth >= MaxMacroDepth {
+		return nil, fmt.Errorf("Macros nested too deep (%d), recursion?", MaxMacroDepth)
+	}
+
+	vars := make(map[string]*macroVar, len(dc.vars)+len(args))
+	for k, v := range dc.vars {
+		vars[k] = v
+	}
+	values := make(map[string]string, len(args))
+	for i, arg := range args {
+		switch a := arg.(type) {
+		case string:
+			values[m.Params[i]] = a
+		case float64:
+			values[m.Params[i]] = strconv.FormatFloat(a, 'f', -1, 64)
+		case *seriesArg:
+			// Series cannot be turned into text, they are passed
+			// by way of a variable instead.
+			name := fmt.Sprintf("_macro%d_%d_", dc.depth, i)
+			vars[name] = &macroVar{sm: a.sm, src: a.src, dc: dc}
+			values[m.Params[i]] = name
+		default:
+			return nil, fmt.Errorf("Invalid argument: %v", arg)
+		}
+	}
+	expr := macroParamRe.ReplaceAllStringFunc(m.Body, func(ref string) string {
+		return values[ref[1:]]
+	})
+
+	sub := newDslCtx(dc.ctxDSFetcher, expr, dc.from, dc.to, dc.maxPoints)
+	sub.vars, sub.depth, sub.span = vars, dc.depth+1, dc.span
+	return sub.parse()
+}
+
+// isMacro returns true if name is a macro.
+func isMacro(name string) bool {
+	macrosLock.RLock()
+	defer macrosLock.RUnlock()
+	_, ok := macros[name]
+	return ok
+}
+
+// seriesArg is a series argument of a macro call along with the
+// source of the expression which produced it.
+type seriesArg struct {
+	sm  SeriesMap
+	src string
+}
+
+func (a *seriesArg) String() string { return a.src }
+
+// macroVar is a series argument as a variable in the macro body.
+// Series are iterators, and every reference to the variable needs
+// its own, thus only the first one gets the series the argument
+// evaluated to, for the rest the argument is evaluated again.
+type macroVar struct {
+	sm  SeriesMap
+	src string
+	dc  *dslCtx // the context of the macro call
+}
+
+func (mv *macroVar) series() (SeriesMap, error) {
+	if sm := mv.sm; sm != nil {
+		mv.sm = nil
+		return sm, nil
+	}
+	sub := newDslCtx(mv.dc.ctxDSFetcher, mv.src, mv.dc.from, mv.dc.to, mv.dc.maxPoints)
+	sub.vars, sub.depth, sub.span = mv.dc.vars, mv.dc.depth, mv.dc.span
+	return sub.parse()
+}
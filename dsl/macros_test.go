@@ -0,0 +1,206 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dsl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tgres/tgres/rrd"
+	"github.com/tgres/tgres/serde"
+)
+
+func Test_ParseMacro(t *testing.T) {
+	m, err := ParseMacro(" errRate( svc ,x) = asPercent(svc.$svc.errors, $x) ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "errRate" || len(m.Params) != 2 || m.Params[0] != "svc" || m.Params[1] != "x" || m.Body != "asPercent(svc.$svc.errors, $x)" {
+		t.Errorf("ParseMacro: unexpected %#v", m)
+	}
+	if m.String() != "errRate(svc, x) = asPercent(svc.$svc.errors, $x)" {
+		t.Errorf("String(): unexpected %q", m.String())
+	}
+	if m, err = ParseMacro("noParams() = sinusoid()"); err != nil || len(m.Params) != 0 {
+		t.Errorf("ParseMacro: a macro without parameters should be fine: %v %#v", err, m)
+	}
+	if _, err = ParseMacro("bogus"); err == nil {
+		t.Errorf("ParseMacro: expected an error")
+	}
+}
+
+func Test_DefineMacro_errors(t *testing.T) {
+	for _, def := range []string{
+		"scale(x) = sinusoid()",       // built-in
+		"groupByNode(x) = sinusoid()", // built-in ctx func
+		"1bad(x) = sinusoid()",        // name
+		"dupe(x, x) = scale(sinusoid(), $x)",
+		"unknownParam(x) = scale(sinusoid(), $y)",
+		"unknownFunc(x) = bogus($x)",
+		"recursive(x) = recursive($x)",
+		"syntax(x) = scale(sinusoid(), $x",
+		"notACall(x) = $x",
+	} {
+		if _, err := DefineMacro(def); err == nil {
+			t.Errorf("DefineMacro(%q): expected an error", def)
+		}
+	}
+	if _, ok := lookupDslCtxFunc("dupe"); ok {
+		t.Errorf("an invalid macro should not be registered")
+	}
+}
+
+func Test_Macro_call(t *testing.T) {
+	td := setupTestData()
+	db := setupNamedTestData(t, map[string]float64{
+		"svc.web.errors": 5, "svc.web.requests": 50,
+	})
+
+	for _, def := range []string{
+		"errRate(svc) = asPercent(svc.$svc.errors, svc.$svc.requests)",
+		"double(s) = scale($s, 2)",
+		"doubleErrRate(svc) = double(errRate($svc))",
+		"times(s, factor) = scale($s, $factor)",
+		"loop1(s) = scale($s, 1)",
+		"loop2(s) = loop1($s)",
+	} {
+		if _, err := DefineMacro(def); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func() {
+		for _, m := range Macros() {
+			UndefineMacro(m.Name)
+		}
+	}()
+
+	for expr, expect := range map[string]float64{
+		`errRate(web)`:                    10,
+		`errRate("web")`:                  10,
+		`double(constantLine(3))`:         6,
+		`doubleErrRate(web)`:              20,
+		`times(constantLine(3), 1.5)`:     4.5,
+		`sumSeries(double(errRate(web)))`: 20,
+	} {
+		sm, err := ParseDsl(db, expr, td.from, td.to, 100)
+		if err != nil {
+			t.Errorf("%s: %v", expr, err)
+			continue
+		}
+		if ok, unexpected := checkEveryValueIs(sm, expect); !ok {
+			t.Errorf("%s: unexpected value: %v", expr, unexpected)
+		}
+	}
+
+	if _, err := ParseDsl(db, "errRate(web, 1)", td.from, td.to, 100); err == nil {
+		t.Errorf("wrong number of arguments should be an error")
+	}
+
+	// A cycle can be created by redefining a macro
+	if _, err := DefineMacro("loop1(s) = loop2($s)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseDsl(db, "loop1(constantLine(1))", td.from, td.to, 100); err == nil || !strings.Contains(err.Error(), "too deep") {
+		t.Errorf("recursion should be an error, got: %v", err)
+	}
+
+	if err := UndefineMacro("double"); err != nil {
+		t.Error(err)
+	}
+	if err := UndefineMacro("double"); err == nil {
+		t.Errorf("UndefineMacro: expected an error for an unknown macro")
+	}
+	if _, err := ParseDsl(db, "double(constantLine(3))", td.from, td.to, 100); err == nil {
+		t.Errorf("an undefined macro should not be callable")
+	}
+}
+
+// A series parameter referenced more than once must not share the
+// series (which is an iterator) between the references.
+func Test_Macro_seriesParamUsedTwice(t *testing.T) {
+	td := setupTestData()
+
+	db := serde.NewMemSerDe()
+	rspec := rrd.RRASpec{
+		Function: rrd.WMEAN,
+		Step:     time.Minute,
+		Span:     time.Hour,
+		Latest:   td.when,
+		DPs:      make(map[int64]float64),
+	}
+	for i := int64(0); i < 60; i++ {
+		rspec.DPs[i] = float64(i + 1)
+	}
+	spec := &rrd.DSSpec{Step: time.Second, RRAs: []rrd.RRASpec{rspec}}
+	if _, err := db.FetchOrCreateDataSource(serde.Ident{"name": "ramp.a"}, spec); err != nil {
+		t.Fatal(err)
+	}
+	rcache := NewNamedDSFetcher(db.Fetcher(), nil, 0)
+
+	for _, def := range []string{
+		"share(s) = divideSeries($s, sumSeries($s))",
+		"shareNested(s) = share(scale($s, 1))",
+	} {
+		if _, err := DefineMacro(def); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func() {
+		for _, m := range Macros() {
+			UndefineMacro(m.Name)
+		}
+	}()
+
+	ref, err := ParseDsl(rcache, "divideSeries(sumSeries(ramp.*), sumSeries(ramp.*))", td.from, td.to, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectN := countPoints(ref)
+	if expectN == 0 {
+		t.Fatalf("no points")
+	}
+
+	for _, expr := range []string{
+		`share(sumSeries(ramp.*))`,
+		`sumSeries(ramp.*).share()`,
+		`share(scale(ramp.a, 2))`,
+		`shareNested(sumSeries(ramp.*))`,
+	} {
+		sm, err := ParseDsl(rcache, expr, td.from, td.to, 100)
+		if err != nil {
+			t.Errorf("%s: %v", expr, err)
+			continue
+		}
+		if ok, unexpected := checkEveryValueIs(sm, 1); !ok {
+			t.Errorf("%s: unexpected value: %v", expr, unexpected)
+		}
+		if n := countPoints(sm); n != expectN {
+			t.Errorf("%s: expected %d points, got %d", expr, expectN, n)
+		}
+	}
+}
+
+func countPoints(sm SeriesMap) int {
+	n := 0
+	for _, s := range sm {
+		for s.Next() {
+			n++
+		}
+		s.Close()
+	}
+	return n
+}
@@ -95,6 +95,21 @@ func (r *namedDsFetcher) identsFromPattern(ident string) map[string]serde.Ident
 	return r.dsns.identsFromPattern(ident)
 }
 
+// searchIdents returns the idents of the DSs matching the query, see
+// serde.DataSourceSearcher.
+func (r *namedDsFetcher) searchIdents(query serde.SearchQuery) ([]serde.Ident, error) {
+	sr, err := r.dsns.db.Search(query)
+	if err != nil || sr == nil {
+		return nil, err
+	}
+	defer sr.Close()
+	var result []serde.Ident
+	for sr.Next() {
+		result = append(result, sr.Ident())
+	}
+	return result, nil
+}
+
 func (r *namedDsFetcher) Preload() {
 	r.Lock()
 	r.dsns.reload()
@@ -43,15 +43,48 @@ import "sort"
 //  - does not support duplicates - same series would need different names
 type SeriesMap map[string]AliasSeries
 
+// SortedKeys returns the keys sorted by name, unless the series were
+// ordered by one of the sortBy*() functions, in which case that order
+// comes first.
 func (sm SeriesMap) SortedKeys() []string {
 	keys := make([]string, 0, len(sm))
 	for k, _ := range sm {
 		keys = append(keys, k)
 	}
-	sort.Strings(keys)
+	sort.Sort(&seriesMapKeys{sm, keys})
 	return keys
 }
 
+// A series which has a position in the list, see sortBy*().
+type orderedSeries interface {
+	position() int
+}
+
+type seriesMapKeys struct {
+	sm   SeriesMap
+	keys []string
+}
+
+func (s *seriesMapKeys) Len() int {
+	return len(s.keys)
+}
+
+func (s *seriesMapKeys) Less(i, j int) bool {
+	oi, iok := s.sm[s.keys[i]].(orderedSeries)
+	oj, jok := s.sm[s.keys[j]].(orderedSeries)
+	if iok && jok && oi.position() != oj.position() {
+		return oi.position() < oj.position()
+	}
+	if iok != jok {
+		return iok
+	}
+	return s.keys[i] < s.keys[j]
+}
+
+func (s *seriesMapKeys) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
 func (sm SeriesMap) toAliasSeriesSlice() *aliasSeriesSlice {
 	result := &aliasSeriesSlice{}
 	for _, key := range sm.SortedKeys() {
@@ -0,0 +1,101 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dsl
+
+import (
+	"time"
+
+	"github.com/tgres/tgres/rrd"
+	"github.com/tgres/tgres/serde"
+	"github.com/tgres/tgres/series"
+	"github.com/tgres/tgres/tracing"
+)
+
+// ParseDslTraced is same as ParseDsl, but records the parsing and
+// every data source and series fetch as children of sp. A nil sp
+// means no tracing.
+func ParseDslTraced(db ctxDSFetcher, src string, from, to time.Time, maxPoints int64, sp *tracing.Span) (SeriesMap, error) {
+	dc := newDslCtx(db, src, from, to, maxPoints)
+	dc.span = sp
+	return dc.parse()
+}
+
+// A traceable series (i.e. one backed by the database) records its
+// queries as children of the span.
+type traceable interface {
+	Trace(sp *tracing.Span)
+}
+
+// A tracedFetcher (i.e. dsLRU) can tell the span whether the data
+// came from the cache.
+type tracedFetcher interface {
+	fetchDataSourceTraced(ident serde.Ident, sp *tracing.Span) (rrd.DataSourcer, error)
+	fetchSeriesTraced(ds rrd.DataSourcer, from, to time.Time, maxPoints int64, sp *tracing.Span) (series.Series, error)
+}
+
+func (dc *dslCtx) fetchDataSource(ident serde.Ident) (rrd.DataSourcer, error) {
+	sp := dc.span.Child("dsl.fetch_ds")
+	if sp == nil {
+		return dc.FetchOrCreateDataSource(ident, nil)
+	}
+	sp.SetAttr("ident", ident.String())
+
+	var (
+		ds  rrd.DataSourcer
+		err error
+	)
+	if tf, ok := dc.ctxDSFetcher.(tracedFetcher); ok {
+		ds, err = tf.fetchDataSourceTraced(ident, sp)
+	} else {
+		ds, err = dc.FetchOrCreateDataSource(ident, nil)
+	}
+	sp.SetAttr("found", ds != nil)
+	sp.SetError(err)
+	sp.End()
+	return ds, err
+}
+
+func (dc *dslCtx) fetchSeries(ds rrd.DataSourcer, from, to time.Time) (series.Series, error) {
+	sp := dc.span.Child("dsl.fetch_series")
+	if sp == nil {
+		return dc.FetchSeries(ds, from, to, dc.maxPoints)
+	}
+	sp.SetAttr("from", from)
+	sp.SetAttr("to", to)
+	sp.SetAttr("max_points", dc.maxPoints)
+
+	var (
+		s   series.Series
+		err error
+	)
+	if tf, ok := dc.ctxDSFetcher.(tracedFetcher); ok {
+		s, err = tf.fetchSeriesTraced(ds, from, to, dc.maxPoints, sp)
+	} else {
+		s, err = dc.FetchSeries(ds, from, to, dc.maxPoints)
+	}
+	traceSeries(sp, s, err)
+	return s, err
+}
+
+// traceSeries ends the fetch span, the series queries (if any) will
+// be its children.
+func traceSeries(sp *tracing.Span, s series.Series, err error) {
+	if t, ok := s.(traceable); ok && sp != nil {
+		t.Trace(sp)
+	}
+	sp.SetError(err)
+	sp.End()
+}
@@ -0,0 +1,71 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dsl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tgres/tgres/rrd"
+	"github.com/tgres/tgres/serde"
+	"github.com/tgres/tgres/tracing"
+)
+
+func Test_ParseDslTraced(t *testing.T) {
+	when := time.Unix(1489657260, 0)
+	db := serde.NewMemSerDe()
+	rcache := NewNamedDSFetcher(db.Fetcher(), nil, 0)
+	spec := &rrd.DSSpec{
+		Step: time.Second,
+		RRAs: []rrd.RRASpec{{Function: rrd.WMEAN, Step: 10 * time.Second, Span: time.Hour, Latest: when}},
+	}
+	if _, err := db.FetchOrCreateDataSource(serde.Ident{"name": "trace.foo"}, spec); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	e := tracing.NewWriterExporter(&buf)
+	tracing.SetExporter(e)
+	defer tracing.SetExporter(nil)
+
+	sp := tracing.Start("test", 1)
+	if _, err := ParseDslTraced(rcache, `scale("trace.foo", 2)`, when.Add(-time.Hour), when, 100, sp); err != nil {
+		t.Fatal(err)
+	}
+	sp.End()
+	e.Close()
+
+	out := buf.String()
+	for _, expect := range []string{
+		`"name":"dsl.fetch_ds"`,
+		`{"key":"ident","value":{"stringValue":"{\"name\": \"trace.foo\"}"}}`,
+		`{"key":"lru","value":{"stringValue":"disabled"}}`,
+		`{"key":"found","value":{"boolValue":true}}`,
+		`"name":"dsl.fetch_series"`,
+		`{"key":"source","value":{"stringValue":"db"}}`,
+		`"name":"dsl.parse"`,
+		`{"key":"query","value":{"stringValue":"scale(\"trace.foo\", 2)"}}`,
+	} {
+		if !strings.Contains(out, expect) {
+			t.Errorf("expected %s in:\n%s", expect, out)
+		}
+	}
+	if n := strings.Count(out, sp.TraceId()); n != 4 {
+		t.Errorf("expected 4 spans in trace %s, got %d", sp.TraceId(), n)
+	}
+}
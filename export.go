@@ -0,0 +1,545 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tgres/tgres/rrd"
+	"github.com/tgres/tgres/serde"
+)
+
+// Export and import of series, i.e. "tgres export" and "tgres
+// import". The export format is JSON lines, one DS per line,
+// containing its ident, spec, state (lastupdate, PDP in progress) and
+// every RRA with its state and all non-empty slots. If the file name
+// ends with .gz, it is gzipped. This is a logical backup, it does not
+// depend on the segment width, bundle ids or positions of the
+// database, and an RRA stores only the slots that have data, so it is
+// typically much smaller than a dump of the ts table.
+//
+// The export reads what is in the database, it does not know about
+// anything still cached by a running Tgres.
+//
+// Import creates the DSs (through the serde interfaces) and writes
+// the state and slots. Existing DSs are skipped unless -overwrite is
+// given, in which case their RRAs must match those of the export.
+
+type exportRecord struct {
+	Ident       serde.Ident `json:"ident"`
+	StepMs      int64       `json:"step_ms"`
+	HeartbeatMs int64       `json:"heartbeat_ms"`
+	LastUpdate  time.Time   `json:"last_update"`
+	Value       jsonFloat   `json:"value"`
+	DurationMs  int64       `json:"duration_ms"`
+	RRAs        []exportRRA `json:"rras"`
+}
+
+type exportRRA struct {
+	Function   string            `json:"function"`
+	StepMs     int64             `json:"step_ms"`
+	Size       int64             `json:"size"`
+	Xff        float32           `json:"xff"`
+	HW         *rrd.HWParams     `json:"hw,omitempty"` // Holt-Winters only
+	Latest     time.Time         `json:"latest"`
+	Value      jsonFloat         `json:"value"`
+	DurationMs int64             `json:"duration_ms"`
+	DPs        map[int64]float64 `json:"dps,omitempty"` // slot index: value
+}
+
+// jsonFloat is a float64 which is null in JSON when NaN.
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+		return []byte("null"), nil
+	}
+	return []byte(strconv.FormatFloat(float64(f), 'g', -1, 64)), nil
+}
+
+func (f *jsonFloat) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*f = jsonFloat(math.NaN())
+		return nil
+	}
+	v, err := strconv.ParseFloat(string(b), 64)
+	*f = jsonFloat(v)
+	return err
+}
+
+func ms(d time.Duration) int64 { return d.Nanoseconds() / 1e6 }
+
+// newExportRecord creates a record from ds and rras, which must
+// contain all the data (see serde LoadRRAData()).
+func newExportRecord(ident serde.Ident, ds rrd.DataSourcer, rras []rrd.RoundRobinArchiver) *exportRecord {
+	rec := &exportRecord{
+		Ident:       ident,
+		StepMs:      ms(ds.Step()),
+		HeartbeatMs: ms(ds.Heartbeat()),
+		LastUpdate:  ds.LastUpdate(),
+		Value:       jsonFloat(ds.Value()),
+		DurationMs:  ms(ds.Duration()),
+	}
+	for _, rra := range rras {
+		spec := rra.Spec()
+		erra := exportRRA{
+			Function:   spec.Function.String(),
+			StepMs:     ms(rra.Step()),
+			Size:       rra.Size(),
+			Xff:        spec.Xff,
+			Latest:     rra.Latest(),
+			Value:      jsonFloat(rra.Value()),
+			DurationMs: ms(rra.Duration()),
+		}
+		if rrd.IsHoltWinters(spec.Function) {
+			hw := spec.HW
+			erra.HW = &hw
+		}
+		for i, v := range rra.DPs() {
+			if !math.IsNaN(v) {
+				if erra.DPs == nil {
+					erra.DPs = make(map[int64]float64)
+				}
+				erra.DPs[i] = v
+			}
+		}
+		rec.RRAs = append(rec.RRAs, erra)
+	}
+	return rec
+}
+
+// spec returns the DSSpec to create the DS, without state or data.
+func (rec *exportRecord) spec() (*rrd.DSSpec, error) {
+	if len(rec.Ident) == 0 {
+		return nil, fmt.Errorf("missing ident")
+	}
+	if rec.StepMs <= 0 {
+		return nil, fmt.Errorf("%v: invalid step: %d", rec.Ident, rec.StepMs)
+	}
+	spec := &rrd.DSSpec{
+		Step:      time.Duration(rec.StepMs) * time.Millisecond,
+		Heartbeat: time.Duration(rec.HeartbeatMs) * time.Millisecond,
+	}
+	for _, erra := range rec.RRAs {
+		cf, err := rrd.ParseConsolidation(erra.Function)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", rec.Ident, err)
+		}
+		if erra.StepMs <= 0 || erra.Size <= 0 {
+			return nil, fmt.Errorf("%v: invalid RRA step/size: %d/%d", rec.Ident, erra.StepMs, erra.Size)
+		}
+		step := time.Duration(erra.StepMs) * time.Millisecond
+		rspec := rrd.RRASpec{
+			Function: cf,
+			Step:     step,
+			Span:     step * time.Duration(erra.Size),
+			Xff:      erra.Xff,
+		}
+		if erra.HW != nil {
+			rspec.HW = *erra.HW
+		}
+		spec.RRAs = append(spec.RRAs, rspec)
+	}
+	return spec, nil
+}
+
+// matches checks that the RRAs of ds are those of the record.
+func (rec *exportRecord) matches(ds rrd.DataSourcer) error {
+	rras := ds.RRAs()
+	if len(rras) != len(rec.RRAs) {
+		return fmt.Errorf("%v: DS has %d RRAs, export has %d", rec.Ident, len(rras), len(rec.RRAs))
+	}
+	for j, rra := range rras {
+		erra := rec.RRAs[j]
+		if !strings.EqualFold(rra.Spec().Function.String(), erra.Function) || ms(rra.Step()) != erra.StepMs || rra.Size() != erra.Size {
+			return fmt.Errorf("%v: RRA %d is %s %v/%d, export has %s %dms/%d", rec.Ident, j,
+				rra.Spec().Function, rra.Step(), rra.Size(), erra.Function, erra.StepMs, erra.Size)
+		}
+	}
+	return nil
+}
+
+// exportDb is what the export needs from the serde, the pg serde
+// satisfies it.
+type exportDb interface {
+	serde.Fetcher
+	LoadRRAData(rra rrd.RoundRobinArchiver) (rrd.RoundRobinArchiver, error)
+}
+
+// exportSeries writes every DS matching query to w, returning the
+// number of DSs and slots written.
+func exportSeries(db exportDb, query serde.SearchQuery, w io.Writer) (count, slots int, err error) {
+	sr, err := db.Search(query)
+	if err != nil {
+		return 0, 0, err
+	}
+	var idents []serde.Ident
+	for sr.Next() {
+		idents = append(idents, sr.Ident())
+	}
+	sr.Close()
+
+	enc := json.NewEncoder(w)
+	for _, ident := range idents {
+		ds, err := db.FetchOrCreateDataSource(ident, nil)
+		if err != nil {
+			return count, slots, fmt.Errorf("%v: %v", ident, err)
+		}
+		if ds == nil {
+			continue // deleted since the search
+		}
+		rras := make([]rrd.RoundRobinArchiver, 0, len(ds.RRAs()))
+		for _, rra := range ds.RRAs() {
+			loaded, err := db.LoadRRAData(rra)
+			if err != nil {
+				return count, slots, fmt.Errorf("%v: %v", ident, err)
+			}
+			rras = append(rras, loaded)
+		}
+		rec := newExportRecord(ident, ds, rras)
+		if err := enc.Encode(rec); err != nil {
+			return count, slots, err
+		}
+		count++
+		for _, erra := range rec.RRAs {
+			slots += len(erra.DPs)
+		}
+	}
+	return count, slots, nil
+}
+
+type importSegment struct {
+	dps, vers                map[int64]map[int64]interface{} // by slot, then idx
+	latests, value, duration map[int64]interface{}           // by idx
+}
+
+type importDSStates struct {
+	lastupdate, value, duration map[int64]interface{} // by idx
+}
+
+// importBatch accumulates the data of several DSs so that it can be
+// written a database row at a time, much like the vertical cache of
+// the receiver.
+type importBatch struct {
+	segs  map[[2]int64]*importSegment // by bundle id and seg
+	dss   map[int64]*importDSStates   // by seg
+	count int
+}
+
+func newImportBatch() *importBatch {
+	return &importBatch{
+		segs: make(map[[2]int64]*importSegment),
+		dss:  make(map[int64]*importDSStates),
+	}
+}
+
+// add the record data for ds. If clear is true, slots that are empty
+// in the record are cleared.
+func (b *importBatch) add(rec *exportRecord, ds serde.DbDataSourcer, clear bool) error {
+	for j, rra := range ds.RRAs() {
+		dbrra, ok := rra.(serde.DbRoundRobinArchiver)
+		if !ok {
+			return fmt.Errorf("%v: RRA must be a serde.DbRoundRobinArchiver", rec.Ident)
+		}
+		erra := rec.RRAs[j]
+		key := [2]int64{dbrra.BundleId(), dbrra.Seg()}
+		seg := b.segs[key]
+		if seg == nil {
+			seg = &importSegment{
+				dps:      make(map[int64]map[int64]interface{}),
+				vers:     make(map[int64]map[int64]interface{}),
+				latests:  make(map[int64]interface{}),
+				value:    make(map[int64]interface{}),
+				duration: make(map[int64]interface{}),
+			}
+			b.segs[key] = seg
+		}
+
+		idx := dbrra.Idx()
+		seg.latests[idx] = erra.Latest
+		seg.value[idx] = float64(erra.Value)
+		seg.duration[idx] = erra.DurationMs
+
+		if erra.Latest.IsZero() {
+			continue // no data
+		}
+		latestI, latestVer := slotVersion(erra.Latest, erra.StepMs, erra.Size)
+		for i := int64(0); i < erra.Size; i++ {
+			v, ok := erra.DPs[i]
+			if !ok {
+				if !clear {
+					continue
+				}
+				v = math.NaN()
+			}
+			if seg.dps[i] == nil {
+				seg.dps[i] = make(map[int64]interface{})
+				seg.vers[i] = make(map[int64]interface{})
+			}
+			ver := latestVer
+			if i > latestI {
+				ver--
+			}
+			seg.dps[i][idx] = v
+			seg.vers[i][idx] = ver
+		}
+	}
+
+	st := b.dss[ds.Seg()]
+	if st == nil {
+		st = &importDSStates{
+			lastupdate: make(map[int64]interface{}),
+			value:      make(map[int64]interface{}),
+			duration:   make(map[int64]interface{}),
+		}
+		b.dss[ds.Seg()] = st
+	}
+	st.lastupdate[ds.Idx()] = rec.LastUpdate
+	st.value[ds.Idx()] = float64(rec.Value)
+	st.duration[ds.Idx()] = rec.DurationMs
+
+	b.count++
+	return nil
+}
+
+// slotVersion returns the slot index of latest and its version, see
+// the ts table.
+func slotVersion(latest time.Time, stepMs, size int64) (int64, int) {
+	i := rrd.SlotIndex(latest, time.Duration(stepMs)*time.Millisecond, size)
+	latestMs := latest.UnixNano() / 1e6
+	return i, int((latestMs / (stepMs * size)) % 32767)
+}
+
+// flush writes the batch, data points first, then RRA and DS states,
+// returning the number of SQL operations.
+func (b *importBatch) flush(db serde.Flusher) (int, error) {
+	ops := 0
+	for key, seg := range b.segs {
+		for i, dps := range seg.dps {
+			n, err := db.FlushDataPoints(key[0], key[1], i, dps, seg.vers[i])
+			if err != nil {
+				return ops, fmt.Errorf("error flushing segment %v:%v slot %d: %v", key[0], key[1], i, err)
+			}
+			ops += n
+		}
+		n, err := db.FlushRRAStates(key[0], key[1], seg.latests, seg.value, seg.duration)
+		if err != nil {
+			return ops, fmt.Errorf("error flushing RRA state %v:%v: %v", key[0], key[1], err)
+		}
+		ops += n
+	}
+	for s, st := range b.dss {
+		n, err := db.FlushDSStates(s, st.lastupdate, st.value, st.duration)
+		if err != nil {
+			return ops, fmt.Errorf("error flushing DS state %v: %v", s, err)
+		}
+		ops += n
+	}
+	return ops, nil
+}
+
+type importStats struct {
+	imported, skipped, failed, ops int
+}
+
+// importSeries reads an export from r and creates the DSs in db. If
+// overwrite is false, existing DSs are skipped. DSs are written
+// batchSize at a time.
+func importSeries(db serde.SerDe, r io.Reader, overwrite bool, batchSize int) (importStats, error) {
+	var st importStats
+
+	batch := newImportBatch()
+	flush := func() error {
+		n, err := batch.flush(db.Flusher())
+		st.ops += n
+		batch = newImportBatch()
+		return err
+	}
+
+	dec := json.NewDecoder(r)
+	for {
+		var rec exportRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return st, err
+		}
+
+		spec, err := rec.spec()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping: %v\n", err)
+			st.failed++
+			continue
+		}
+		ds, err := db.Fetcher().FetchOrCreateDataSource(rec.Ident, spec)
+		if err != nil {
+			return st, fmt.Errorf("%v: %v", rec.Ident, err)
+		}
+		dbds, ok := ds.(serde.DbDataSourcer)
+		if !ok {
+			return st, fmt.Errorf("%v: DS must be a serde.DbDataSourcer", rec.Ident)
+		}
+		if !dbds.Created() {
+			if !overwrite {
+				st.skipped++
+				continue
+			}
+			if err := rec.matches(dbds); err != nil {
+				fmt.Fprintf(os.Stderr, "Skipping: %v\n", err)
+				st.failed++
+				continue
+			}
+		}
+		if err := batch.add(&rec, dbds, !dbds.Created()); err != nil {
+			return st, err
+		}
+		st.imported++
+
+		if batch.count >= batchSize {
+			if err := flush(); err != nil {
+				return st, err
+			}
+			fmt.Printf("Imported %d series so far.\n", st.imported)
+		}
+	}
+	return st, flush()
+}
+
+func defaultDbConnect() string {
+	if s := os.Getenv("TGRES_DB_CONNECT"); s != "" {
+		return s
+	}
+	return "host=/var/run/postgresql dbname=tgres sslmode=disable"
+}
+
+// parseSearchQuery parses tag=regex arguments.
+func parseSearchQuery(args []string) (serde.SearchQuery, error) {
+	query := make(serde.SearchQuery)
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("expecting tag=regex, got %q", arg)
+		}
+		query[parts[0]] = parts[1]
+	}
+	return query, nil
+}
+
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	dbConnect := fs.String("dbconnect", defaultDbConnect(), "db connect string (default from TGRES_DB_CONNECT)")
+	out := fs.String("o", "-", "output file, - for stdout, gzipped if it ends with .gz")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s export [flags] [tag=regex ...]\n\nExports matching series (all if none given).\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	query, err := parseSearchQuery(fs.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	db, err := serde.InitDb(*dbConnect, os.Getenv("TGRES_DB_PREFIX"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error connecting to database: %v\n", err)
+		return 1
+	}
+
+	var w io.WriteCloser = os.Stdout
+	if *out != "-" {
+		if w, err = os.Create(*out); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	f := w
+	if strings.HasSuffix(*out, ".gz") {
+		w = gzip.NewWriter(f)
+	}
+
+	count, slots, err := exportSeries(db, query, w)
+	if err == nil && w != f {
+		err = w.Close()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "DONE: exported %d series, %d slots.\n", count, slots)
+	return 0
+}
+
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dbConnect := fs.String("dbconnect", defaultDbConnect(), "db connect string (default from TGRES_DB_CONNECT)")
+	overwrite := fs.Bool("overwrite", false, "overwrite existing series (their RRAs must match), otherwise they are skipped")
+	batchSize := fs.Int("batch", 1000, "number of series written to the database at once")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s import [flags] [file]\n\nImports series exported with export from file (or stdin), gunzipping if it ends with .gz.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var r io.Reader = os.Stdin
+	if path := fs.Arg(0); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		r = f
+		if strings.HasSuffix(path, ".gz") {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s: %v\n", path, err)
+				return 1
+			}
+			r = gz
+		}
+	}
+
+	db, err := serde.InitDb(*dbConnect, os.Getenv("TGRES_DB_PREFIX"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error connecting to database: %v\n", err)
+		return 1
+	}
+
+	st, err := importSeries(db, r, *overwrite, *batchSize)
+	fmt.Printf("DONE: imported %d series, skipped %d existing, %d failed, in %d SQL ops.\n", st.imported, st.skipped, st.failed, st.ops)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if st.failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,123 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tgres/tgres/rrd"
+	"github.com/tgres/tgres/serde"
+)
+
+func Test_exportRecord(t *testing.T) {
+	spec := rrd.DSSpec{
+		Step:      10 * time.Second,
+		Heartbeat: time.Hour,
+		RRAs: []rrd.RRASpec{
+			{Function: rrd.WMEAN, Step: 10 * time.Second, Span: 100 * time.Second, Xff: 0.5},
+			{Function: rrd.MAX, Step: 50 * time.Second, Span: 500 * time.Second},
+		},
+	}
+	ds := rrd.NewDataSource(spec)
+	start := time.Unix(1000, 0)
+	for i := 1; i <= 7; i++ {
+		ds.ProcessDataPoint(float64(i), start.Add(time.Duration(i)*10*time.Second+time.Second))
+	}
+
+	ident := serde.Ident{"name": "foo.bar"}
+	rec := newExportRecord(ident, ds, ds.RRAs())
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec2 exportRecord
+	if err := json.Unmarshal(b, &rec2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rec2.Ident, ident) {
+		t.Errorf("ident: %v != %v", rec2.Ident, ident)
+	}
+	if !rec2.LastUpdate.Equal(ds.LastUpdate()) || float64(rec2.Value) != ds.Value() || rec2.DurationMs != ms(ds.Duration()) {
+		t.Errorf("DS state mismatch: %v %v %v", rec2.LastUpdate, rec2.Value, rec2.DurationMs)
+	}
+	for j, rra := range ds.RRAs() {
+		erra := rec2.RRAs[j]
+		if !erra.Latest.Equal(rra.Latest()) {
+			t.Errorf("RRA %d latest: %v != %v", j, erra.Latest, rra.Latest())
+		}
+		if len(rra.DPs()) == 0 || !reflect.DeepEqual(erra.DPs, rra.DPs()) {
+			t.Errorf("RRA %d DPs: %v != %v", j, erra.DPs, rra.DPs())
+		}
+	}
+
+	spec2, err := rec2.spec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec2.Step != spec.Step || spec2.Heartbeat != spec.Heartbeat || len(spec2.RRAs) != len(spec.RRAs) {
+		t.Fatalf("spec mismatch: %#v", spec2)
+	}
+	for j, rs := range spec2.RRAs {
+		if rs.Function != spec.RRAs[j].Function || rs.Step != spec.RRAs[j].Step || rs.Span != spec.RRAs[j].Span || rs.Xff != spec.RRAs[j].Xff {
+			t.Errorf("RRA spec %d mismatch: %#v", j, rs)
+		}
+	}
+
+	if err := rec2.matches(ds); err != nil {
+		t.Errorf("matches: %v", err)
+	}
+	rec2.RRAs[1].Size = 11
+	if err := rec2.matches(ds); err == nil {
+		t.Errorf("matches: expected an error for a different size")
+	}
+
+	rec2.RRAs[1].Function = "BOGUS"
+	if _, err := rec2.spec(); err == nil {
+		t.Errorf("spec: expected an error for an invalid function")
+	}
+}
+
+func Test_jsonFloat(t *testing.T) {
+	b, _ := json.Marshal(jsonFloat(math.NaN()))
+	if string(b) != "null" {
+		t.Errorf("NaN should be null, got %s", b)
+	}
+	var f jsonFloat
+	if err := json.Unmarshal(b, &f); err != nil || !math.IsNaN(float64(f)) {
+		t.Errorf("null should be NaN, got %v (%v)", f, err)
+	}
+	if err := json.Unmarshal([]byte("1.5"), &f); err != nil || f != 1.5 {
+		t.Errorf("expected 1.5, got %v (%v)", f, err)
+	}
+}
+
+func Test_parseSearchQuery(t *testing.T) {
+	q, err := parseSearchQuery([]string{"name=foo.*", "host=a=b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q["name"] != "foo.*" || q["host"] != "a=b" {
+		t.Errorf("unexpected query: %v", q)
+	}
+	if _, err := parseSearchQuery([]string{"foo"}); err == nil {
+		t.Errorf("expected an error")
+	}
+}
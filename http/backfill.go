@@ -0,0 +1,148 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tgres/tgres/misc"
+	"github.com/tgres/tgres/receiver"
+	"github.com/tgres/tgres/serde"
+)
+
+// ParseBackfillLine parses a line of backfill input, which is either
+// CSV:
+//
+//	name,time,value
+//
+// or JSON:
+//
+//	{"name": "foo.bar", "time": 1483228800, "value": 1.5}
+//
+// Time is seconds since the epoch (fractions allowed) or RFC3339. An
+// empty name means the line is blank or a comment (begins with #).
+func ParseBackfillLine(line string) (name string, p receiver.BackfillPoint, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", p, nil
+	}
+
+	var timeStr string
+	if strings.HasPrefix(line, "{") {
+		var jp struct {
+			Name  string
+			Time  json.RawMessage
+			Value *float64
+		}
+		if err = json.Unmarshal([]byte(line), &jp); err != nil {
+			return "", p, err
+		}
+		if jp.Value == nil {
+			return "", p, fmt.Errorf("missing value")
+		}
+		name, timeStr, p.Value = jp.Name, strings.Trim(string(jp.Time), `"`), *jp.Value
+	} else {
+		parts := strings.Split(line, ",")
+		if len(parts) != 3 {
+			return "", p, fmt.Errorf("expecting name,time,value")
+		}
+		name, timeStr = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if p.Value, err = strconv.ParseFloat(strings.TrimSpace(parts[2]), 64); err != nil {
+			return "", p, err
+		}
+	}
+
+	if name == "" {
+		return "", p, fmt.Errorf("missing name")
+	}
+	if p.Time, err = parseBackfillTime(timeStr); err != nil {
+		return "", p, err
+	}
+	return name, p, nil
+}
+
+func parseBackfillTime(s string) (time.Time, error) {
+	if ut, err := strconv.ParseFloat(s, 64); err == nil {
+		nsec := int64(ut*1000000000) % 1000000000
+		return time.Unix(int64(ut), nsec), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, fmt.Errorf("invalid time: %q", s)
+	}
+	return t, nil
+}
+
+// BackfillHandler loads historical data points POSTed as CSV or JSON
+// lines (see ParseBackfillLine and receiver.Backfill). All points of
+// a series should be in the same request. Responds with the
+// receiver.BackfillResult as JSON.
+func BackfillHandler(rcvr *receiver.Receiver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var series []*receiver.BackfillSeries
+		byName := make(map[string]*receiver.BackfillSeries)
+
+		scanner := bufio.NewScanner(r.Body)
+		lineNo := 0
+		for scanner.Scan() {
+			lineNo++
+			name, p, err := ParseBackfillLine(scanner.Text())
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("line %d: %v", lineNo, err)})
+				return
+			}
+			if name == "" {
+				continue
+			}
+			name = misc.SanitizeName(name)
+			s := byName[name]
+			if s == nil {
+				s = &receiver.BackfillSeries{Ident: serde.Ident{"name": name}}
+				byName[name] = s
+				series = append(series, s)
+			}
+			s.Points = append(s.Points, p)
+		}
+		if err := scanner.Err(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+
+		result, err := rcvr.Backfill(series)
+		if err != nil {
+			logger.Errorf("BackfillHandler: %v", err)
+			result.Errors = append(result.Errors, err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		logger.Infof("BackfillHandler: %d series from %s: %d stored (%d slots), %d queued, %d errors",
+			result.Series, r.RemoteAddr, result.Stored, result.Slots, result.Queued, len(result.Errors))
+		json.NewEncoder(w).Encode(result)
+	}
+}
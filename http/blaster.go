@@ -17,7 +17,6 @@ package http
 
 import (
 	"fmt"
-	"log"
 	"net/http"
 
 	"github.com/tgres/tgres/blaster"
@@ -27,7 +26,7 @@ func BlasterSetHandler(blstr *blaster.Blaster) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if rc := recover(); rc != nil {
-				log.Printf("BlasterSetHandler: Recovered (this request is dropped): %v", rc)
+				logger.Warnf("BlasterSetHandler: Recovered (this request is dropped): %v", rc)
 			}
 		}()
 
@@ -39,7 +38,7 @@ func BlasterSetHandler(blstr *blaster.Blaster) http.HandlerFunc {
 						var rate int
 						n, _ := fmt.Sscanf(valStr, "%d", &rate)
 						if n < 1 {
-							log.Printf("BlasterSetHandler: error parsing %q", valStr)
+							logger.Warnf("BlasterSetHandler: error parsing %q", valStr)
 							w.WriteHeader(http.StatusInternalServerError)
 							fmt.Fprintf(w, "Error\n")
 							return
@@ -52,7 +51,7 @@ func BlasterSetHandler(blstr *blaster.Blaster) http.HandlerFunc {
 						var ns int
 						n, _ := fmt.Sscanf(valStr, "%d", &ns)
 						if n < 1 {
-							log.Printf("BlasterSetHandler: error parsing %q", valStr)
+							logger.Warnf("BlasterSetHandler: error parsing %q", valStr)
 							w.WriteHeader(http.StatusInternalServerError)
 							fmt.Fprintf(w, "Error\n")
 							return
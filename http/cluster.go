@@ -0,0 +1,123 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/tgres/tgres/cluster"
+	"github.com/tgres/tgres/receiver"
+)
+
+type clusterStatus struct {
+	Members     []cluster.NodeStatus      `json:"members"`
+	Transitions []cluster.TransitionStats `json:"transitions"`
+	ForwardedTo map[string]int64          `json:"forwarded_to"`
+}
+
+// clusterOrError returns the cluster of the receiver, or writes an
+// error and returns nil if there is no cluster (yet).
+func clusterOrError(w http.ResponseWriter, rcvr *receiver.Receiver) *cluster.Cluster {
+	c := rcvr.Cluster()
+	if c == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"error": "not clustered (yet)"})
+	}
+	return c
+}
+
+// ClusterHandler reports the cluster members with their readiness
+// and meta data, the most recent transitions and the number of data
+// points this node forwarded to others.
+func ClusterHandler(rcvr *receiver.Receiver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		c := clusterOrError(w, rcvr)
+		if c == nil {
+			return
+		}
+
+		members, err := c.NodeStatuses()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(&clusterStatus{
+			Members:     members,
+			Transitions: c.Transitions(),
+			ForwardedTo: rcvr.ForwardedTo(),
+		})
+	}
+}
+
+// ClusterAssignmentsHandler lists the DistDatums known to this node
+// and the nodes they are assigned to, the lead node first.
+func ClusterAssignmentsHandler(rcvr *receiver.Receiver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if c := clusterOrError(w, rcvr); c != nil {
+			json.NewEncoder(w).Encode(c.Assignments())
+		}
+	}
+}
+
+// ClusterActionHandler performs an action on this node, which must be
+// requested with POST. The actions are:
+//
+//	drain      - mark the node as not ready, its data moves to other nodes
+//	ready      - mark the node as ready again (undo drain)
+//	transition - force a transition
+//	leave      - leave the cluster gracefully, which also stops the process
+//
+// leave is provided by the caller since it involves more than the
+// cluster and receiver.
+func ClusterActionHandler(rcvr *receiver.Receiver, action string, leave func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		c := clusterOrError(w, rcvr)
+		if c == nil {
+			return
+		}
+
+		logger.Infof("ClusterActionHandler: %s requested by %s", action, r.RemoteAddr)
+		var err error
+		switch action {
+		case "drain":
+			err = c.Ready(false)
+		case "ready":
+			err = c.Ready(true)
+		case "transition":
+			c.ForceTransition()
+		case "leave":
+			go leave()
+		default:
+			err = fmt.Errorf("unknown action: %q", action)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		fmt.Fprintf(w, "{}\n")
+	}
+}
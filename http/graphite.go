@@ -21,7 +21,6 @@ import (
 	"compress/gzip"
 	"fmt"
 	"io"
-	"log"
 	"math"
 	"net/http"
 	"regexp"
@@ -31,11 +30,20 @@ import (
 	"time"
 
 	"github.com/tgres/tgres/dsl"
+	"github.com/tgres/tgres/logging"
 	"github.com/tgres/tgres/misc"
+	"github.com/tgres/tgres/tracing"
 )
 
 const BATCH_LIMIT = 64
 
+var logger = logging.New("http")
+
+// RenderTraceSampleRatio is the fraction of render requests that are
+// traced when tracing is enabled (see tracing.SetExporter). The trace
+// id of a traced request is returned in the X-Tgres-Trace-Id header.
+var RenderTraceSampleRatio = 1.0
+
 func GraphiteMetricsFindHandler(rcache dsl.NamedDSFetcher) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -70,7 +78,7 @@ func GraphiteMetricsFindHandler(rcache dsl.NamedDSFetcher) http.HandlerFunc {
 			}
 		}
 		fmt.Fprintf(w, "\n]\n")
-		log.Printf("GraphiteMetricsFindHandler: finished in %v", time.Now().Sub(start))
+		logger.Infof("GraphiteMetricsFindHandler: finished in %v", time.Now().Sub(start))
 	}
 }
 
@@ -81,16 +89,23 @@ func GraphiteRenderHandler(rcache dsl.NamedDSFetcher) http.HandlerFunc {
 			w.Header().Set("Content-Type", "application/json")
 
 			start := time.Now()
+			sp := tracing.Start("http.render", RenderTraceSampleRatio)
+			defer sp.End()
+			if sp != nil {
+				sp.SetKind(tracing.KindServer)
+				w.Header().Set("X-Tgres-Trace-Id", sp.TraceId())
+			}
+
 			from, err := parseTime(r.FormValue("from"))
 			if err != nil {
-				log.Printf("RenderHandler(): (from) %v", err)
+				logger.Warnf("RenderHandler(): (from) %v", err)
 				w.Header().Set("X-Tgres-DSL-Error", fmt.Sprintf("from: %v", err))
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
 			to, err := parseTime(r.FormValue("until"))
 			if err != nil {
-				log.Printf("RenderHandler(): (unitl) %v", err)
+				logger.Warnf("RenderHandler(): (unitl) %v", err)
 				w.Header().Set("X-Tgres-DSL-Error", fmt.Sprintf("to: %v", err))
 				w.WriteHeader(http.StatusBadRequest)
 				return
@@ -104,13 +119,18 @@ func GraphiteRenderHandler(rcache dsl.NamedDSFetcher) http.HandlerFunc {
 			if mdp != "" {
 				points, err = strconv.Atoi(mdp)
 				if err != nil {
-					log.Printf("RenderHandler(): (maxDataPoints) %v", err)
+					logger.Warnf("RenderHandler(): (maxDataPoints) %v", err)
 					w.Header().Set("X-Tgres-DSL-Error", fmt.Sprintf("maxDataPoints: %v", err))
 					w.WriteHeader(http.StatusBadRequest)
 					return
 				}
 			}
 
+			sp.SetAttr("targets", len(r.Form["target"]))
+			sp.SetAttr("from", *from)
+			sp.SetAttr("until", *to)
+			sp.SetAttr("max_data_points", points)
+
 			var wg sync.WaitGroup
 
 			targets := make([][]*graphiteSeries, len(r.Form["target"]))
@@ -119,17 +139,21 @@ func GraphiteRenderHandler(rcache dsl.NamedDSFetcher) http.HandlerFunc {
 				wg.Add(1)
 				batchSize++
 				go func(wg *sync.WaitGroup, target string, targets [][]*graphiteSeries, n int) {
-					if sm, err := processTarget(rcache, target, from.Unix(), to.Unix(), int64(points)); err == nil {
+					tsp := sp.Child("render.target")
+					tsp.SetAttr("target", target)
+					if sm, err := processTarget(rcache, target, from.Unix(), to.Unix(), int64(points), tsp); err == nil {
 						// sm may contain locked watched RRAs,
 						// readDataPoints unlocks them in
 						// series.Close() It's important to not do
 						// anything that could interrupt this, we MUST
 						// run readDataPoints.
-						targets[n] = readDataPoints(sm)
+						targets[n] = readDataPoints(sm, tsp)
 					} else {
 						w.Header().Set("X-Tgres-DSL-Error", fmt.Sprintf("%v", err))
-						log.Printf("RenderHandler() %q: %v", target, err)
+						logger.Warnf("RenderHandler() %q: %v", target, err)
+						tsp.SetError(err)
 					}
+					tsp.End()
 					wg.Done()
 				}(&wg, target, targets, n)
 				if batchSize > BATCH_LIMIT { // limit concurrent processing
@@ -139,6 +163,7 @@ func GraphiteRenderHandler(rcache dsl.NamedDSFetcher) http.HandlerFunc {
 			}
 			wg.Wait()
 
+			wsp := sp.Child("render.write")
 			fmt.Fprintf(w, "[")
 
 			for tn, target := range targets {
@@ -179,8 +204,9 @@ func GraphiteRenderHandler(rcache dsl.NamedDSFetcher) http.HandlerFunc {
 				}
 			}
 			fmt.Fprintf(w, "]\n")
+			wsp.End()
 
-			log.Printf("GraphiteRenderHandler: finished in %v", time.Now().Sub(start))
+			logger.Infof("GraphiteRenderHandler: finished in %v", time.Now().Sub(start))
 		},
 	)
 }
@@ -256,11 +282,11 @@ func quoteIdentifiers(target string) string {
 	return result
 }
 
-func processTarget(rcache dsl.NamedDSFetcher, target string, from, to, maxPoints int64) (dsl.SeriesMap, error) {
+func processTarget(rcache dsl.NamedDSFetcher, target string, from, to, maxPoints int64, sp *tracing.Span) (dsl.SeriesMap, error) {
 	target = quoteIdentifiers(target)
 	// In our DSL everything must be a function call, so we wrap everything in group()
 	query := fmt.Sprintf("group(%s)", target)
-	return dsl.ParseDsl(rcache, query, time.Unix(from, 0), time.Unix(to, 0), maxPoints)
+	return dsl.ParseDslTraced(rcache, query, time.Unix(from, 0), time.Unix(to, 0), maxPoints, sp)
 }
 
 // Graphite data points
@@ -273,7 +299,7 @@ type graphiteSeries struct {
 	name string
 }
 
-func readDataPoints(sm dsl.SeriesMap) []*graphiteSeries {
+func readDataPoints(sm dsl.SeriesMap, sp *tracing.Span) []*graphiteSeries {
 	names := sm.SortedKeys()
 	result := make([]*graphiteSeries, len(names))
 	var (
@@ -289,12 +315,16 @@ func readDataPoints(sm dsl.SeriesMap) []*graphiteSeries {
 		wg.Add(1)
 		batchSize++
 		go func(wg *sync.WaitGroup, result []*graphiteSeries, n int, name string) {
+			ssp := sp.Child("render.read_series")
+			ssp.SetAttr("name", name)
 			gs := &graphiteSeries{make([]*dataPoint, 0), name}
 			for series.Next() {
 				gs.dps = append(gs.dps, &dataPoint{series.CurrentTime().Unix(), series.CurrentValue()})
 			}
 			result[n] = gs
 			series.Close()
+			ssp.SetAttr("points", len(gs.dps))
+			ssp.End()
 			wg.Done()
 		}(&wg, result, n, name)
 		if batchSize > BATCH_LIMIT {
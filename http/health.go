@@ -0,0 +1,53 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/tgres/tgres/receiver"
+)
+
+// HealthLiveHandler is a liveness probe: it responds with 503 if the
+// receiver is wedged (and should be restarted), 200 otherwise. The
+// database is not checked, an outage only affects readiness. The
+// body is the receiver.Health as JSON.
+func HealthLiveHandler(rcvr *receiver.Receiver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := rcvr.LiveHealth()
+		writeHealth(w, h, h.Live)
+	}
+}
+
+// HealthReadyHandler is a readiness probe: it responds with 503
+// unless the receiver is ready to accept data, 200 otherwise. The
+// body is the receiver.Health as JSON.
+func HealthReadyHandler(rcvr *receiver.Receiver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := rcvr.Health()
+		writeHealth(w, h, h.Ready)
+	}
+}
+
+func writeHealth(w http.ResponseWriter, h *receiver.Health, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(h)
+}
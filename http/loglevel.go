@@ -0,0 +1,91 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/tgres/tgres/logging"
+)
+
+type logLevels struct {
+	Format  string            `json:"format"`
+	Default string            `json:"default"`
+	Levels  map[string]string `json:"levels"`
+}
+
+func currentLogLevels() *logLevels {
+	result := &logLevels{Format: "text", Default: logging.DefaultLevel().String(), Levels: make(map[string]string)}
+	if logging.JSON() {
+		result.Format = "json"
+	}
+	for name, lv := range logging.Levels() {
+		result.Levels[name] = lv.String()
+	}
+	return result
+}
+
+// LogLevelHandler lists (GET) and changes (POST or PUT) log
+// levels. To change a level, specify "level" and optionally
+// "subsystem", without a subsystem (or with subsystem "default") the
+// default level is changed. A level of "default" makes the subsystem
+// use the default level. Changes only last until restart, use the
+// log-level and log-levels config settings to make them permanent.
+func LogLevelHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.Method {
+		case "GET", "HEAD":
+		case "POST", "PUT":
+			subsystem, level := r.FormValue("subsystem"), r.FormValue("level")
+			if err := setLogLevel(subsystem, level); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+			if subsystem == "" {
+				subsystem = "default"
+			}
+			logger.Infof("LogLevelHandler: log level of %q set to %q", subsystem, level)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		json.NewEncoder(w).Encode(currentLogLevels())
+	}
+}
+
+func setLogLevel(subsystem, level string) error {
+	if subsystem != "" && subsystem != "default" && level == "default" {
+		lg := logging.Get(subsystem)
+		if lg == nil {
+			return logging.SetLevel(subsystem, logging.Info) // for the error
+		}
+		lg.ResetLevel()
+		return nil
+	}
+	lv, err := logging.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	if subsystem == "" || subsystem == "default" {
+		logging.SetDefaultLevel(lv)
+		return nil
+	}
+	return logging.SetLevel(subsystem, lv)
+}
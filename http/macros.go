@@ -0,0 +1,60 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/tgres/tgres/dsl"
+)
+
+// MacrosHandler lists (GET), defines (POST or PUT with the
+// definition in the "macro" parameter) and removes (DELETE with the
+// "name" parameter) DSL macros. Macros defined this way only last
+// until restart, use the dsl-macros config setting to make them
+// permanent.
+func MacrosHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.Method {
+		case "GET", "HEAD":
+			json.NewEncoder(w).Encode(dsl.Macros())
+		case "POST", "PUT":
+			macro, err := dsl.DefineMacro(r.FormValue("macro"))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+			logger.Infof("MacrosHandler: DSL macro defined: %v", macro)
+			json.NewEncoder(w).Encode(macro)
+		case "DELETE":
+			name := r.FormValue("name")
+			if err := dsl.UndefineMacro(name); err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+			logger.Infof("MacrosHandler: DSL macro removed: %v", name)
+			fmt.Fprintf(w, "{}\n")
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -17,7 +17,6 @@ package http
 
 import (
 	"fmt"
-	"log"
 	"net/http"
 	"time"
 
@@ -44,7 +43,7 @@ func PixelHandler(rcvr *receiver.Receiver) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if rc := recover(); rc != nil {
-				log.Printf("PixelHandler: Recovered (this request is dropped): %v", rc)
+				logger.Warnf("PixelHandler: Recovered (this request is dropped): %v", rc)
 			}
 		}()
 
@@ -52,7 +51,7 @@ func PixelHandler(rcvr *receiver.Receiver) http.HandlerFunc {
 
 		err := r.ParseForm()
 		if err != nil {
-			log.Printf("PixelHandler: error from ParseForm(): %v", err)
+			logger.Warnf("PixelHandler: error from ParseForm(): %v", err)
 			return
 		}
 
@@ -65,7 +64,7 @@ func PixelHandler(rcvr *receiver.Receiver) http.HandlerFunc {
 				var val, ut float64
 				n, _ := fmt.Sscanf(valStr, "%f@%f", &val, &ut)
 				if n < 1 {
-					log.Printf("PixelHandler: error parsing %q", valStr)
+					logger.Warnf("PixelHandler: error parsing %q", valStr)
 					return
 				}
 
@@ -77,7 +76,9 @@ func PixelHandler(rcvr *receiver.Receiver) http.HandlerFunc {
 					ts = time.Unix(int64(ut), nsec)
 				}
 
-				rcvr.QueueDataPoint(serde.Ident{"name": misc.SanitizeName(name)}, ts, val)
+				if ident, ok := rcvr.ApplyRules(serde.Ident{"name": misc.SanitizeName(name)}); ok {
+					rcvr.QueueDataPoint(ident, ts, val)
+				}
 			}
 		}
 
@@ -90,6 +91,18 @@ func PixelAddHandler(rcvr *receiver.Receiver) http.HandlerFunc {
 	}
 }
 
+func PixelAddEWMAHandler(rcvr *receiver.Receiver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pixelAggHandler(r, w, rcvr, aggregator.CmdAddEWMA)
+	}
+}
+
+func PixelAddWindowHandler(rcvr *receiver.Receiver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pixelAggHandler(r, w, rcvr, aggregator.CmdAddWindow)
+	}
+}
+
 func PixelAddGaugeHandler(rcvr *receiver.Receiver) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		pixelAggHandler(r, w, rcvr, aggregator.CmdAddGauge)
@@ -111,7 +124,7 @@ func PixelAppendHandler(rcvr *receiver.Receiver) http.HandlerFunc {
 func pixelAggHandler(r *http.Request, w http.ResponseWriter, rcvr *receiver.Receiver, cmd aggregator.AggCmd) {
 	defer func() {
 		if rc := recover(); rc != nil {
-			log.Printf("pixelAggHandler: Recovered (this request is dropped): %v", rc)
+			logger.Warnf("pixelAggHandler: Recovered (this request is dropped): %v", rc)
 		}
 	}()
 
@@ -119,7 +132,7 @@ func pixelAggHandler(r *http.Request, w http.ResponseWriter, rcvr *receiver.Rece
 
 	err := r.ParseForm()
 	if err != nil {
-		log.Printf("pixelAggHandler: error from ParseForm(): %v", err)
+		logger.Warnf("pixelAggHandler: error from ParseForm(): %v", err)
 		return
 	}
 
@@ -132,12 +145,14 @@ func pixelAggHandler(r *http.Request, w http.ResponseWriter, rcvr *receiver.Rece
 			var val float64
 			n, _ := fmt.Sscanf(valStr, "%f", &val)
 			if n < 1 {
-				log.Printf("PixelAddHandler: error parsing %q", valStr)
+				logger.Warnf("PixelAddHandler: error parsing %q", valStr)
 				return
 			}
 
 			// TODO Should use Ident
-			rcvr.QueueAggregatorCommand(aggregator.NewCommand(cmd, serde.Ident{"name": misc.SanitizeName(name)}, val))
+			if ident, ok := rcvr.ApplyRules(serde.Ident{"name": misc.SanitizeName(name)}); ok {
+				rcvr.QueueAggregatorCommand(aggregator.NewCommand(cmd, ident, val))
+			}
 		}
 	}
 
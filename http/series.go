@@ -0,0 +1,199 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/tgres/tgres/receiver"
+	"github.com/tgres/tgres/serde"
+)
+
+// parseIdentParam parses an ident given either as a JSON object, e.g.
+// {"name":"foo.bar","host":"a"}, or just the name.
+func parseIdentParam(s string) (serde.Ident, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, fmt.Errorf("missing ident")
+	}
+	if !strings.HasPrefix(s, "{") {
+		return serde.Ident{"name": s}, nil
+	}
+	var ident serde.Ident
+	if err := json.Unmarshal([]byte(s), &ident); err != nil {
+		return nil, fmt.Errorf("invalid ident: %v", err)
+	}
+	if len(ident) == 0 {
+		return nil, fmt.Errorf("missing ident")
+	}
+	return ident, nil
+}
+
+// parseSearchParams returns the search query, which is either in the
+// "query" parameter as a JSON object of tag names to regular
+// expressions, or just the "name" regular expression.
+func parseSearchParams(r *http.Request) (serde.SearchQuery, error) {
+	if q := r.FormValue("query"); q != "" {
+		var query serde.SearchQuery
+		if err := json.Unmarshal([]byte(q), &query); err != nil {
+			return nil, fmt.Errorf("invalid query: %v", err)
+		}
+		return query, nil
+	}
+	if name := r.FormValue("name"); name != "" {
+		return serde.SearchQuery{"name": name}, nil
+	}
+	return nil, fmt.Errorf("query or name parameter required")
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// SeriesInspectHandler describes the DS given by the "ident"
+// parameter (see receiver.DSInfo).
+func SeriesInspectHandler(rcvr *receiver.Receiver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		ident, err := parseIdentParam(r.FormValue("ident"))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		info, err := rcvr.InspectDataSource(ident)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if info == nil {
+			writeJSONError(w, http.StatusNotFound, fmt.Errorf("no such DS: %v", ident))
+			return
+		}
+		json.NewEncoder(w).Encode(info)
+	}
+}
+
+// SeriesSearchHandler lists the idents of DSs matching the "query" or
+// "name" parameter (see parseSearchParams).
+func SeriesSearchHandler(rcvr *receiver.Receiver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		query, err := parseSearchParams(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		idents, err := rcvr.SearchDataSources(query)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		if idents == nil {
+			idents = []serde.Ident{}
+		}
+		json.NewEncoder(w).Encode(idents)
+	}
+}
+
+// SeriesDeleteHandler deletes (POST or DELETE) the DSs matching the
+// "query" or "name" parameter (see parseSearchParams) along with
+// their data. With "dry_run=true" nothing is deleted, but the
+// response lists what would be. Responds with the
+// receiver.DeleteResult.
+func SeriesDeleteHandler(rcvr *receiver.Receiver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != "POST" && r.Method != "DELETE" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		query, err := parseSearchParams(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		var dryRun bool
+		if s := r.FormValue("dry_run"); s != "" {
+			if dryRun, err = strconv.ParseBool(s); err != nil {
+				writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid dry_run: %q", s))
+				return
+			}
+		}
+		result, err := rcvr.DeleteDataSources(query, dryRun)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		if !dryRun {
+			logger.Infof("SeriesDeleteHandler: %v from %s: %d matched, %d deleted, %d errors",
+				query, r.RemoteAddr, len(result.Matched), result.Deleted, len(result.Errors))
+		}
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// SeriesRenameHandler changes (POST) the ident of the DS given by the
+// "from" parameter to the "to" parameter keeping its data, which is
+// also how tags are changed.
+func SeriesRenameHandler(rcvr *receiver.Receiver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		from, err := parseIdentParam(r.FormValue("from"))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("from: %v", err))
+			return
+		}
+		to, err := parseIdentParam(r.FormValue("to"))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("to: %v", err))
+			return
+		}
+		if err := rcvr.RenameDataSource(from, to); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		logger.Infof("SeriesRenameHandler: %v renamed to %v from %s", from, to, r.RemoteAddr)
+		json.NewEncoder(w).Encode(map[string]serde.Ident{"from": from, "to": to})
+	}
+}
+
+// SeriesCardinalityHandler reports the number of series, rejections
+// due to series limits and the name prefixes with the most series,
+// the "top" parameter limits how many (default 20, 0 means all). See
+// receiver.CardinalityReport.
+func SeriesCardinalityHandler(rcvr *receiver.Receiver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		top := 20
+		if s := r.FormValue("top"); s != "" {
+			var err error
+			if top, err = strconv.Atoi(s); err != nil || top < 0 {
+				writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid top: %q", s))
+				return
+			}
+		}
+		json.NewEncoder(w).Encode(rcvr.Cardinality(top))
+	}
+}
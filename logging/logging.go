@@ -0,0 +1,229 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logging is a leveled logger with a level per subsystem
+// (typically a package) and text or JSON output.
+//
+// Output goes to wherever the standard log package writes
+// (log.SetOutput), so log file cycling and the like keep working. In
+// text mode lines are formatted by the standard log package exactly
+// as log.Printf would. In JSON mode every line is an object:
+//
+//	{"time":"2017-03-16T09:41:00.123Z","level":"info","subsystem":"receiver","pid":123,"msg":"..."}
+//
+// Levels can be changed at any time, e.g. via HTTP.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Level int32
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+	Off
+)
+
+var levelNames = []string{"debug", "info", "warn", "error", "off"}
+
+func (l Level) String() string {
+	if l >= Debug && l <= Off {
+		return levelNames[l]
+	}
+	return fmt.Sprintf("Level(%d)", l)
+}
+
+// ParseLevel converts a level name (case insensitive, "warning" is
+// accepted too) to a Level.
+func ParseLevel(s string) (Level, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "warning" {
+		return Warn, nil
+	}
+	for i, name := range levelNames {
+		if s == name {
+			return Level(i), nil
+		}
+	}
+	return Info, fmt.Errorf("invalid log level: %q (must be one of %s)", s, strings.Join(levelNames, ", "))
+}
+
+// Logger logs for a subsystem. Its level is the default level unless
+// set with SetLevel.
+type Logger struct {
+	name  string
+	level int32 // atomic, noLevel means default
+}
+
+const noLevel = -1
+
+var (
+	mu           sync.RWMutex
+	loggers      = make(map[string]*Logger)
+	defaultLevel = int32(Info) // atomic
+	jsonOutput   int32         // atomic, 1 if JSON
+	pid          = os.Getpid()
+)
+
+// New returns the Logger for subsystem, creating it if necessary.
+func New(subsystem string) *Logger {
+	mu.Lock()
+	defer mu.Unlock()
+	if l, ok := loggers[subsystem]; ok {
+		return l
+	}
+	l := &Logger{name: subsystem, level: noLevel}
+	loggers[subsystem] = l
+	return l
+}
+
+// Get returns the Logger for subsystem or nil if there isn't one.
+func Get(subsystem string) *Logger {
+	mu.RLock()
+	defer mu.RUnlock()
+	return loggers[subsystem]
+}
+
+// Subsystems returns the names of all subsystems, sorted.
+func Subsystems() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(loggers))
+	for name := range loggers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetDefaultLevel sets the level of all subsystems which do not have
+// their own.
+func SetDefaultLevel(l Level) {
+	atomic.StoreInt32(&defaultLevel, int32(l))
+}
+
+func DefaultLevel() Level {
+	return Level(atomic.LoadInt32(&defaultLevel))
+}
+
+// SetLevel sets the level of a subsystem, which must exist.
+func SetLevel(subsystem string, l Level) error {
+	lg := Get(subsystem)
+	if lg == nil {
+		return fmt.Errorf("unknown log subsystem: %q (must be one of %s)", subsystem, strings.Join(Subsystems(), ", "))
+	}
+	lg.SetLevel(l)
+	return nil
+}
+
+// Levels returns the effective level of every subsystem.
+func Levels() map[string]Level {
+	mu.RLock()
+	defer mu.RUnlock()
+	result := make(map[string]Level, len(loggers))
+	for name, l := range loggers {
+		result[name] = l.Level()
+	}
+	return result
+}
+
+// SetJSON switches between JSON (true) and text output.
+func SetJSON(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&jsonOutput, v)
+}
+
+func JSON() bool {
+	return atomic.LoadInt32(&jsonOutput) == 1
+}
+
+func (l *Logger) Subsystem() string {
+	return l.name
+}
+
+// SetLevel sets the level of this logger.
+func (l *Logger) SetLevel(lv Level) {
+	atomic.StoreInt32(&l.level, int32(lv))
+}
+
+// ResetLevel makes this logger use the default level.
+func (l *Logger) ResetLevel() {
+	atomic.StoreInt32(&l.level, noLevel)
+}
+
+// Level returns the effective level.
+func (l *Logger) Level() Level {
+	if lv := atomic.LoadInt32(&l.level); lv != noLevel {
+		return Level(lv)
+	}
+	return DefaultLevel()
+}
+
+// Enabled returns true if messages of level lv are logged. Useful to
+// avoid expensive formatting.
+func (l *Logger) Enabled(lv Level) bool {
+	return lv >= l.Level() && lv < Off
+}
+
+func (l *Logger) Debugf(format string, v ...interface{}) { l.output(Debug, format, v...) }
+func (l *Logger) Infof(format string, v ...interface{})  { l.output(Info, format, v...) }
+func (l *Logger) Warnf(format string, v ...interface{})  { l.output(Warn, format, v...) }
+func (l *Logger) Errorf(format string, v ...interface{}) { l.output(Error, format, v...) }
+
+type jsonLine struct {
+	Time      string `json:"time"`
+	Level     string `json:"level"`
+	Subsystem string `json:"subsystem"`
+	Pid       int    `json:"pid"`
+	Msg       string `json:"msg"`
+}
+
+var outMu sync.Mutex
+
+func (l *Logger) output(lv Level, format string, v ...interface{}) {
+	if !l.Enabled(lv) {
+		return
+	}
+	msg := fmt.Sprintf(format, v...)
+	if !JSON() {
+		log.Output(3, msg)
+		return
+	}
+	b, _ := json.Marshal(&jsonLine{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		Level:     lv.String(),
+		Subsystem: l.name,
+		Pid:       pid,
+		Msg:       strings.TrimRight(msg, "\n"),
+	})
+	outMu.Lock()
+	log.Writer().Write(append(b, '\n'))
+	outMu.Unlock()
+}
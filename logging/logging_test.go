@@ -0,0 +1,89 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_ParseLevel(t *testing.T) {
+	for s, expect := range map[string]Level{"debug": Debug, "INFO": Info, "warning": Warn, " warn": Warn, "error": Error, "off": Off} {
+		if l, err := ParseLevel(s); err != nil || l != expect {
+			t.Errorf("ParseLevel(%q): %v %v", s, l, err)
+		}
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Errorf("ParseLevel: expected error")
+	}
+}
+
+func Test_Logger(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	l := New("test")
+	if New("test") != l {
+		t.Errorf("New: should return the same logger")
+	}
+	if err := SetLevel("nonexistent", Debug); err == nil {
+		t.Errorf("SetLevel: expected error for unknown subsystem")
+	}
+
+	// default level is info
+	l.Debugf("hidden")
+	l.Infof("shown %d", 1)
+	if out := buf.String(); strings.Contains(out, "hidden") || !strings.HasSuffix(out, "shown 1\n") {
+		t.Errorf("text output: %q", out)
+	}
+
+	buf.Reset()
+	SetLevel("test", Debug)
+	if Levels()["test"] != Debug {
+		t.Errorf("Levels: %v", Levels())
+	}
+	l.Debugf("now shown")
+	if !strings.Contains(buf.String(), "now shown") {
+		t.Errorf("debug not shown after SetLevel")
+	}
+
+	buf.Reset()
+	SetJSON(true)
+	defer SetJSON(false)
+	l.Warnf("careful: %s", "x")
+	var line jsonLine
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("%v: %q", err, buf.String())
+	}
+	if line.Level != "warn" || line.Subsystem != "test" || line.Msg != "careful: x" || line.Pid == 0 || line.Time == "" {
+		t.Errorf("bad json line: %+v", line)
+	}
+
+	buf.Reset()
+	l.ResetLevel()
+	SetDefaultLevel(Error)
+	defer SetDefaultLevel(Info)
+	l.Warnf("hidden")
+	l.Errorf("shown")
+	if out := buf.String(); strings.Contains(out, "hidden") || !strings.Contains(out, `"level":"error"`) {
+		t.Errorf("default level: %q", out)
+	}
+}
@@ -59,6 +59,15 @@ func printVersion() {
 
 func main() {
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "export":
+			os.Exit(runExport(os.Args[2:]))
+		case "import":
+			os.Exit(runImport(os.Args[2:]))
+		}
+	}
+
 	textCfgPath, gracefulProtos, join, bg, version := parseFlags() // TODO remove gracefulProtos from this line
 	if gp := os.Getenv("TGRES_PROTOS"); gp != "" {
 		gracefulProtos = gp
@@ -22,6 +22,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 var (
@@ -30,12 +32,109 @@ var (
 	sanitizeRegexNonAlphaNum = regexp.MustCompile("[^a-zA-Z_\\-0-9\\.]")
 )
 
+// NamePolicy determines what happens to metric names containing
+// characters other than ASCII letters, digits, "_", "-" and ".".
+type NamePolicy int
+
+const (
+	// Whitespace becomes "_", "/" becomes "-", the rest is removed.
+	NameStrict NamePolicy = iota
+	// Names are stored as is and every other byte is escaped as %XX
+	// in queries, e.g. "a b/c" is "a%20b%2Fc" in the DSL.
+	NameEscape
+	// Like NameEscape, but letters and digits of any script are not
+	// escaped in queries.
+	NameUTF8
+)
+
+var namePolicyNames = []string{"strict", "escape", "utf8"}
+
+func (p NamePolicy) String() string {
+	if p >= NameStrict && p <= NameUTF8 {
+		return namePolicyNames[p]
+	}
+	return fmt.Sprintf("NamePolicy(%d)", p)
+}
+
+// ParseNamePolicy converts a policy name to a NamePolicy.
+func ParseNamePolicy(s string) (NamePolicy, error) {
+	for i, name := range namePolicyNames {
+		if strings.ToLower(s) == name {
+			return NamePolicy(i), nil
+		}
+	}
+	return NameStrict, fmt.Errorf("invalid name policy: %q (must be one of %s)", s, strings.Join(namePolicyNames, ", "))
+}
+
+// SanitizeNamePolicy is the policy used by SanitizeName and QueryName.
+var SanitizeNamePolicy = NameStrict
+
+// SanitizeName makes a metric name safe for use as a series name
+// according to SanitizeNamePolicy. Other than with NameStrict the
+// name is kept, except for invalid UTF-8 and control characters,
+// which cannot be stored and are escaped as %XX.
 func SanitizeName(name string) string {
+	if SanitizeNamePolicy != NameStrict {
+		return escapeName(name, func(r rune) bool {
+			return r != utf8.RuneError && !unicode.IsControl(r)
+		})
+	}
 	name = sanitizeRegexSpace.ReplaceAllString(name, "_")
 	name = sanitizeRegexSlash.ReplaceAllString(name, "-")
 	return sanitizeRegexNonAlphaNum.ReplaceAllString(name, "")
 }
 
+// QueryName returns the name (or a tag value) as it appears in DSL
+// queries and in the find API according to SanitizeNamePolicy, i.e.
+// with the characters not valid there escaped as %XX.
+func QueryName(name string) string {
+	switch SanitizeNamePolicy {
+	case NameEscape:
+		return escapeName(name, func(r rune) bool { return r < utf8.RuneSelf && isNameChar(byte(r)) })
+	case NameUTF8:
+		return escapeName(name, isNameRuneUTF8)
+	}
+	return name
+}
+
+func isNameChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '-' || c == '.'
+}
+
+func isNameRuneUTF8(r rune) bool {
+	if r < utf8.RuneSelf {
+		return isNameChar(byte(r))
+	}
+	return r != utf8.RuneError && (unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r))
+}
+
+// escapeName escapes the bytes of every rune for which keep is false
+// (invalid UTF-8 is utf8.RuneError) as %XX.
+func escapeName(name string, keep func(rune) bool) string {
+	const hex = "0123456789ABCDEF"
+	var buf []byte
+	for i := 0; i < len(name); {
+		r, size := utf8.DecodeRuneInString(name[i:])
+		if keep(r) {
+			if buf != nil {
+				buf = append(buf, name[i:i+size]...)
+			}
+		} else {
+			if buf == nil {
+				buf = append(make([]byte, 0, len(name)+8), name[:i]...)
+			}
+			for _, c := range []byte(name[i : i+size]) {
+				buf = append(buf, '%', hex[c>>4], hex[c&15])
+			}
+		}
+		i += size
+	}
+	if buf == nil {
+		return name
+	}
+	return string(buf)
+}
+
 func BetterParseDuration(s string) (time.Duration, error) {
 
 	if strings.HasSuffix(s, "min") {
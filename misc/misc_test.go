@@ -0,0 +1,95 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package misc
+
+import "testing"
+
+func Test_SanitizeName(t *testing.T) {
+	defer func(p NamePolicy) { SanitizeNamePolicy = p }(SanitizeNamePolicy)
+
+	for policy, cases := range map[NamePolicy]map[string]string{
+		NameStrict: {
+			"foo.bar-1_x":  "foo.bar-1_x",
+			"a b/c":        "a_b-c",
+			"héllo\x00\n!": "hllo_",
+			"bad\xffutf8":  "badutf8",
+		},
+		NameEscape: {
+			"foo.bar-1_x":   "foo.bar-1_x",
+			"a b/c%":        "a b/c%",
+			"héllo":         "héllo",
+			"nul\x00\ttab":  "nul%00%09tab",
+			"line\u2028sep": "line\u2028sep",
+			"bad\xffutf8":   "bad%FFutf8",
+			"\xef\xbf\xbd":  "%EF%BF%BD", // U+FFFD
+		},
+	} {
+		SanitizeNamePolicy = policy
+		for name, expect := range cases {
+			if s := SanitizeName(name); s != expect {
+				t.Errorf("%v: SanitizeName(%q): expected %q, got %q", policy, name, expect, s)
+			}
+		}
+	}
+	SanitizeNamePolicy = NameUTF8
+	if s := SanitizeName("日 本\x01"); s != "日 本%01" {
+		t.Errorf("utf8: SanitizeName(): got %q", s)
+	}
+}
+
+func Test_QueryName(t *testing.T) {
+	defer func(p NamePolicy) { SanitizeNamePolicy = p }(SanitizeNamePolicy)
+
+	for policy, cases := range map[NamePolicy]map[string]string{
+		NameStrict: {
+			"a b": "a b",
+		},
+		NameEscape: {
+			"foo.bar-1_x":   "foo.bar-1_x",
+			"a b/c":         "a%20b%2Fc",
+			"100%":          "100%25",
+			"héllo":         "h%C3%A9llo",
+			"line\u2028sep": "line%E2%80%A8sep",
+			"nul\x00":       "nul%00",
+		},
+		NameUTF8: {
+			"héllo.wörld":   "héllo.wörld",
+			"日本":            "日本",
+			"日 本":           "日%20本",
+			"line\u2028sep": "line%E2%80%A8sep",
+			"½":             "%C2%BD", // a number, but not a digit
+			"bad\xffutf8":   "bad%FFutf8",
+		},
+	} {
+		SanitizeNamePolicy = policy
+		for name, expect := range cases {
+			if s := QueryName(name); s != expect {
+				t.Errorf("%v: QueryName(%q): expected %q, got %q", policy, name, expect, s)
+			}
+		}
+	}
+}
+
+func Test_ParseNamePolicy(t *testing.T) {
+	for _, p := range []NamePolicy{NameStrict, NameEscape, NameUTF8} {
+		if got, err := ParseNamePolicy(p.String()); err != nil || got != p {
+			t.Errorf("ParseNamePolicy(%q): %v %v", p.String(), got, err)
+		}
+	}
+	if _, err := ParseNamePolicy("lax"); err == nil {
+		t.Errorf("ParseNamePolicy(lax): expected an error")
+	}
+}
@@ -0,0 +1,240 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package receiver
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/tgres/tgres/serde"
+)
+
+// Series administration: inspecting, deleting and renaming data
+// sources. Deleting and renaming require the SerDe Fetcher to be a
+// serde.DataSourceAdmin. The DS is removed from the cache of this
+// node right away and from other nodes once the database notifies
+// them (see serde.EventListener).
+//
+// Renaming or deleting a DS which is still receiving data points
+// under its old ident simply creates a new DS with that ident.
+
+// DSInfo describes a DS for inspection. Values which are NaN are nil.
+type DSInfo struct {
+	Ident      serde.Ident `json:"ident"`
+	Id         int64       `json:"id"`
+	Seg        int64       `json:"seg"`
+	Idx        int64       `json:"idx"`
+	Step       string      `json:"step"`
+	Heartbeat  string      `json:"heartbeat"`
+	LastUpdate time.Time   `json:"lastUpdate"`
+	Value      *float64    `json:"value"`    // PDP value
+	Duration   string      `json:"duration"` // PDP duration
+	RRAs       []*RRAInfo  `json:"rras"`
+	Cached     bool        `json:"cached"` // the state is as of the cache, not the database
+	Nodes      []string    `json:"nodes,omitempty"`
+}
+
+// RRAInfo describes an RRA of a DS.
+type RRAInfo struct {
+	Id       int64    `json:"id,omitempty"`
+	Function string   `json:"function"`
+	Step     string   `json:"step"`
+	Size     int64    `json:"size"`
+	Xff      float32  `json:"xff"`
+	Latest   string   `json:"latest"`
+	Value    *float64 `json:"value"`    // PDP value
+	Duration string   `json:"duration"` // PDP duration
+	BundleId int64    `json:"bundleId,omitempty"`
+	Seg      int64    `json:"seg"`
+	Idx      int64    `json:"idx"`
+}
+
+func nanToNil(f float64) *float64 {
+	if math.IsNaN(f) {
+		return nil
+	}
+	return &f
+}
+
+// InspectDataSource returns the description of a DS or nil if there
+// is no such DS. If the DS is in the cache, its state is that of the
+// cache, which may be more recent than the database.
+func (r *Receiver) InspectDataSource(ident serde.Ident) (*DSInfo, error) {
+	var (
+		dbds   serde.DbDataSourcer
+		cached bool
+	)
+	if cds := r.dsc.getByIdent(newCachedIdent(ident)); cds != nil {
+		cds.mu.Lock()
+		if cds.DbDataSourcer != nil && cds.Id() != 0 {
+			dbds, cached = cds.Copy().(serde.DbDataSourcer), true
+		}
+		cds.mu.Unlock()
+	}
+	if dbds == nil {
+		ds, err := r.serde.Fetcher().FetchOrCreateDataSource(ident, nil)
+		if err != nil || ds == nil {
+			return nil, err
+		}
+		var ok bool
+		if dbds, ok = ds.(serde.DbDataSourcer); !ok {
+			return nil, fmt.Errorf("InspectDataSource: DS must be a serde.DbDataSourcer")
+		}
+	}
+
+	info := &DSInfo{
+		Ident:      dbds.Ident(),
+		Id:         dbds.Id(),
+		Seg:        dbds.Seg(),
+		Idx:        dbds.Idx(),
+		Step:       dbds.Step().String(),
+		Heartbeat:  dbds.Heartbeat().String(),
+		LastUpdate: dbds.LastUpdate(),
+		Value:      nanToNil(dbds.Value()),
+		Duration:   dbds.Duration().String(),
+		Cached:     cached,
+	}
+	for _, rra := range dbds.RRAs() {
+		spec := rra.Spec()
+		ri := &RRAInfo{
+			Function: spec.Function.String(),
+			Step:     rra.Step().String(),
+			Size:     rra.Size(),
+			Xff:      spec.Xff,
+			Latest:   rra.Latest().Format(time.RFC3339),
+			Value:    nanToNil(rra.Value()),
+			Duration: rra.Duration().String(),
+		}
+		if dbrra, ok := rra.(serde.DbRoundRobinArchiver); ok {
+			ri.Id, ri.BundleId, ri.Seg, ri.Idx = dbrra.Id(), dbrra.BundleId(), dbrra.Seg(), dbrra.Idx()
+		}
+		info.RRAs = append(info.RRAs, ri)
+	}
+	if r.cluster != nil {
+		for _, node := range r.cluster.NodesForDistDatum(&distDs{DbDataSourcer: dbds, dsc: r.dsc}) {
+			info.Nodes = append(info.Nodes, node.Name())
+		}
+	}
+	return info, nil
+}
+
+func (r *Receiver) dsAdmin() (serde.DataSourceAdmin, error) {
+	if admin, ok := r.serde.Fetcher().(serde.DataSourceAdmin); ok {
+		return admin, nil
+	}
+	return nil, fmt.Errorf("deleting or renaming is not supported by this database")
+}
+
+// SearchDataSources returns the idents of all DSs matching the query,
+// sorted. The query is a map of tag names to regular expressions,
+// which are case insensitive and must all match. An empty query is
+// an error, to avoid matching everything by accident.
+func (r *Receiver) SearchDataSources(query serde.SearchQuery) ([]serde.Ident, error) {
+	if len(query) == 0 {
+		return nil, fmt.Errorf("empty query")
+	}
+	res := make(map[string]*regexp.Regexp, len(query))
+	for tag, expr := range query {
+		re, err := regexp.Compile("(?i)" + expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression for %q: %v", tag, err)
+		}
+		res[tag] = re
+	}
+
+	sr, err := r.serde.Fetcher().Search(query)
+	if err != nil {
+		return nil, err
+	}
+	defer sr.Close()
+
+	var result []serde.Ident
+	for sr.Next() {
+		ident := sr.Ident()
+		// Not all serdes honor the query, double check.
+		match := true
+		for tag, re := range res {
+			if val, ok := ident[tag]; !ok || !re.MatchString(val) {
+				match = false
+				break
+			}
+		}
+		if match {
+			result = append(result, ident)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].String() < result[j].String() })
+	return result, nil
+}
+
+// DeleteResult summarizes a DeleteDataSources().
+type DeleteResult struct {
+	DryRun  bool          `json:"dryRun"`
+	Matched []serde.Ident `json:"matched"`
+	Deleted int           `json:"deleted"`
+	Errors  []string      `json:"errors,omitempty"`
+}
+
+// DeleteDataSources deletes all DSs matching the query (see
+// SearchDataSources). With dryRun nothing is deleted, but the result
+// lists what would be.
+func (r *Receiver) DeleteDataSources(query serde.SearchQuery, dryRun bool) (*DeleteResult, error) {
+	idents, err := r.SearchDataSources(query)
+	if err != nil {
+		return nil, err
+	}
+	result := &DeleteResult{DryRun: dryRun, Matched: idents}
+	if result.Matched == nil {
+		result.Matched = []serde.Ident{}
+	}
+	if dryRun || len(idents) == 0 {
+		return result, nil
+	}
+
+	admin, err := r.dsAdmin()
+	if err != nil {
+		return nil, err
+	}
+	for _, ident := range idents {
+		if err := admin.DeleteDataSource(ident); err != nil {
+			result.Errors = append(result.Errors, err.Error())
+			continue
+		}
+		r.dsc.delete(ident)
+		result.Deleted++
+	}
+	return result, nil
+}
+
+// RenameDataSource changes the ident of a DS keeping its data. This
+// can also be used to change the tags of a DS.
+func (r *Receiver) RenameDataSource(from, to serde.Ident) error {
+	if to["name"] == "" {
+		return fmt.Errorf("name tag is required")
+	}
+	admin, err := r.dsAdmin()
+	if err != nil {
+		return err
+	}
+	if err := admin.RenameDataSource(from, to); err != nil {
+		return err
+	}
+	r.dsc.delete(from)
+	return nil
+}
@@ -0,0 +1,99 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package receiver
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tgres/tgres/rrd"
+	"github.com/tgres/tgres/serde"
+)
+
+// memSerde makes the memory serde a serde.SerDe
+type memSerde struct{ f serde.Fetcher }
+
+func (m *memSerde) Fetcher() serde.Fetcher             { return m.f }
+func (m *memSerde) Flusher() serde.Flusher             { return nil }
+func (m *memSerde) EventListener() serde.EventListener { return nil }
+
+func Test_Receiver_admin(t *testing.T) {
+	db := serde.NewMemSerDe()
+	spec := &rrd.DSSpec{
+		Step: 10 * time.Second,
+		RRAs: []rrd.RRASpec{{Function: rrd.MAX, Step: time.Minute, Span: time.Hour}},
+	}
+	for _, name := range []string{"foo.a", "foo.b", "bar"} {
+		if _, err := db.FetchOrCreateDataSource(serde.Ident{"name": name}, spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := New(&memSerde{db}, nil)
+
+	info, err := r.InspectDataSource(serde.Ident{"name": "foo.a"})
+	if err != nil || info == nil {
+		t.Fatalf("InspectDataSource: %v %v", info, err)
+	}
+	if info.Cached || len(info.RRAs) != 1 || info.RRAs[0].Function != "MAX" || info.RRAs[0].Size != 60 || info.Value != nil {
+		t.Errorf("InspectDataSource: unexpected %+v %+v", info, info.RRAs[0])
+	}
+	if info, err := r.InspectDataSource(serde.Ident{"name": "nonexistent"}); info != nil || err != nil {
+		t.Errorf("InspectDataSource: expected nil, nil for nonexistent DS: %v %v", info, err)
+	}
+
+	if _, err := r.SearchDataSources(nil); err == nil {
+		t.Errorf("SearchDataSources: expected error on empty query")
+	}
+	idents, err := r.SearchDataSources(serde.SearchQuery{"name": "^FOO"})
+	if err != nil || len(idents) != 2 || idents[0]["name"] != "foo.a" {
+		t.Errorf("SearchDataSources: %v %v", idents, err)
+	}
+
+	res, err := r.DeleteDataSources(serde.SearchQuery{"name": "^foo"}, true)
+	if err != nil || !res.DryRun || len(res.Matched) != 2 || res.Deleted != 0 {
+		t.Errorf("DeleteDataSources (dry run): %+v %v", res, err)
+	}
+	if ds, _ := db.FetchOrCreateDataSource(serde.Ident{"name": "foo.a"}, nil); ds == nil {
+		t.Errorf("DeleteDataSources: dry run deleted something")
+	}
+	res, err = r.DeleteDataSources(serde.SearchQuery{"name": "^foo"}, false)
+	if err != nil || res.Deleted != 2 || len(res.Errors) != 0 {
+		t.Errorf("DeleteDataSources: %+v %v", res, err)
+	}
+	if info, _ := r.InspectDataSource(serde.Ident{"name": "foo.b"}); info != nil {
+		t.Errorf("DeleteDataSources: foo.b not deleted")
+	}
+
+	// rename evicts the old ident from the cache
+	ds, _ := db.FetchOrCreateDataSource(serde.Ident{"name": "bar"}, nil)
+	r.dsc.insert(&cachedDs{DbDataSourcer: ds.(serde.DbDataSourcer), mu: &sync.Mutex{}})
+	if err := r.RenameDataSource(serde.Ident{"name": "bar"}, serde.Ident{"name": "baz", "host": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if r.dsc.getByIdent(newCachedIdent(serde.Ident{"name": "bar"})) != nil {
+		t.Errorf("RenameDataSource: old ident still cached")
+	}
+	if info, _ := r.InspectDataSource(serde.Ident{"name": "baz", "host": "a"}); info == nil || info.Id != ds.(serde.DbDataSourcer).Id() {
+		t.Errorf("RenameDataSource: renamed DS not found or id changed: %+v", info)
+	}
+	if err := r.RenameDataSource(serde.Ident{"name": "nonexistent"}, serde.Ident{"name": "x"}); err == nil {
+		t.Errorf("RenameDataSource: expected error for nonexistent DS")
+	}
+	if err := r.RenameDataSource(serde.Ident{"name": "baz", "host": "a"}, serde.Ident{"host": "a"}); err == nil {
+		t.Errorf("RenameDataSource: expected error for missing name")
+	}
+}
@@ -17,11 +17,13 @@ package receiver
 
 import (
 	"fmt"
-	"log"
+	"hash/fnv"
+	"sync"
 	"time"
 
 	"github.com/tgres/tgres/aggregator"
 	"github.com/tgres/tgres/cluster"
+	"github.com/tgres/tgres/serde"
 	"github.com/tgres/tgres/statsd"
 )
 
@@ -37,13 +39,13 @@ var aggWorkerIncomingAggCmds = func(ident string, rcv chan *cluster.Msg, aggCh c
 		// To get an event back:
 		var ac aggregator.Command
 		if err := m.Decode(&ac); err != nil {
-			log.Printf("%s: msg <- rcv aggreagator.Command decoding FAILED, ignoring this command.", ident)
+			logger.Errorf("%s: msg <- rcv aggreagator.Command decoding FAILED, ignoring this command.", ident)
 			continue
 		}
 
 		maxHops := 2
 		if ac.Hops > maxHops {
-			log.Printf("%s: dropping command, max hops (%d) reached", ident, maxHops)
+			logger.Warnf("%s: dropping command, max hops (%d) reached", ident, maxHops)
 			continue
 		}
 
@@ -63,7 +65,7 @@ var aggWorkerPeriodicFlushSignal = func(ident string, flushCh chan time.Time, du
 		if len(flushCh) == 0 {
 			flushCh <- time.Now()
 		} else {
-			log.Printf("%s: dropping aggreagator flush timer on the floor - busy system?", ident)
+			logger.Warnf("%s: dropping aggreagator flush timer on the floor - busy system?", ident)
 		}
 	}
 }
@@ -82,12 +84,18 @@ var aggWorkerForwardACToNode = func(ac *aggregator.Command, node *cluster.Node,
 }
 
 var aggWorkerProcessOrForward = func(ac *aggregator.Command, aggDd *distDatumAggregator, clstr clusterer, snd chan *cluster.Msg) (forwarded int) {
-	for _, node := range clstr.NodesForDistDatum(aggDd) {
+	// Aggregator state is not copied, only the lead node aggregates,
+	// otherwise the aggregated points would be counted more than once.
+	nodes := clstr.NodesForDistDatum(aggDd)
+	if len(nodes) > 1 {
+		nodes = nodes[:1]
+	}
+	for _, node := range nodes {
 		if node.Name() == clstr.LocalNode().Name() {
 			aggDd.ProcessCmd(ac)
 		} else {
 			if err := aggWorkerForwardACToNode(ac, node, snd); err != nil {
-				log.Printf("aggworker: Error forwarding aggregator command: %v", err)
+				logger.Errorf("aggworker: Error forwarding aggregator command: %v", err)
 				continue
 			}
 			forwarded++
@@ -96,6 +104,34 @@ var aggWorkerProcessOrForward = func(ac *aggregator.Command, aggDd *distDatumAgg
 	return forwarded
 }
 
+// aggWorkerHandOff sends the rates that aggDd had when it was
+// relinquished to its new lead node, where they carry on. If this
+// node is the lead again, they are restored right here.
+var aggWorkerHandOff = func(aggDd *distDatumAggregator, clstr clusterer, snd chan *cluster.Msg) {
+	cmds := aggDd.takeHandOff()
+	if len(cmds) == 0 {
+		return
+	}
+	nodes := clstr.NodesForDistDatum(aggDd)
+	if len(nodes) == 0 {
+		logger.Warnf("aggworker: no node to hand %d rates of %s over to, dropping them", len(cmds), aggDd.GetName())
+		return
+	}
+	if nodes[0].Name() == clstr.LocalNode().Name() {
+		for _, ac := range cmds {
+			aggDd.ProcessCmd(ac)
+		}
+		return
+	}
+	for _, ac := range cmds {
+		if err := aggWorkerForwardACToNode(ac, nodes[0], snd); err != nil {
+			logger.Errorf("aggworker: Error handing %d rates of %s over to %s: %v", len(cmds), aggDd.GetName(), nodes[0].Name(), err)
+			return
+		}
+	}
+	logger.Infof("aggworker: handed %d rates of %s over to %s", len(cmds), aggDd.GetName(), nodes[0].Name())
+}
+
 var aggWorker = func(wc wController, aggCh chan *aggregator.Command, clstr clusterer, statFlushDuration time.Duration, statsNamePrefix string, sr statReporter, dpq *Receiver) {
 
 	wc.onEnter()
@@ -114,18 +150,42 @@ var aggWorker = func(wc wController, aggCh chan *aggregator.Command, clstr clust
 	flushCh := make(chan time.Time, 1)
 	go aggWorkerPeriodicFlushSignal(wc.ident(), flushCh, statFlushDuration)
 
-	log.Printf("%s: started.", wc.ident())
+	logger.Infof("%s: started.", wc.ident())
 	wc.onStarted()
 
 	statsd.Prefix = statsNamePrefix
 
-	agg := aggregator.NewAggregator(dpq) // aggregator.dataPointQueuer
-	agg.AppendAttr = "name"
-	aggDd := &distDatumAggregator{agg}
+	// The aggregation is sharded by ident so that in a cluster it
+	// is spread across the nodes.
+	nShards := dpq.AggregatorShards
+	if nShards <= 0 {
+		nShards = DefaultAggregatorShards
+	}
+	shards := make([]*distDatumAggregator, nShards)
+	for i := range shards {
+		agg := aggregator.NewAggregator(dpq) // aggregator.dataPointQueuer
+		agg.AppendAttr = "name"
+		if len(dpq.StatRateWindows) > 0 {
+			agg.RateWindows = dpq.StatRateWindows
+		}
+		shards[i] = &distDatumAggregator{Aggregator: agg, shard: i}
+	}
+	flush := func(now time.Time) {
+		for _, aggDd := range shards {
+			if clstr != nil {
+				aggWorkerHandOff(aggDd, clstr, snd)
+			}
+			aggDd.Flush(now)
+		}
+	}
 	if clstr != nil {
 		clstr.LoadDistData(func() ([]cluster.DistDatum, error) {
-			log.Printf("%s: adding the aggregator.Aggregator DistDatum to the cluster", wc.ident())
-			return []cluster.DistDatum{aggDd}, nil
+			logger.Infof("%s: adding %d aggregator.Aggregator DistDatums to the cluster", wc.ident(), len(shards))
+			dds := make([]cluster.DistDatum, len(shards))
+			for i, aggDd := range shards {
+				dds[i] = aggDd
+			}
+			return dds, nil
 		})
 	}
 
@@ -135,21 +195,22 @@ var aggWorker = func(wc wController, aggCh chan *aggregator.Command, clstr clust
 		// always process flushCh even if there is stuff in the stCh.
 		select {
 		case now := <-flushCh:
-			agg.Flush(now)
+			flush(now)
 		default:
 		}
 
 		select {
 		case now := <-flushCh:
-			agg.Flush(now)
+			flush(now)
 		case ac, ok := <-aggCh:
 			if !ok {
-				log.Printf("%s: channel closed, performing last flush", wc.ident())
-				agg.Flush(time.Now())
+				logger.Infof("%s: channel closed, performing last flush", wc.ident())
+				flush(time.Now())
 				close(flushCh)
 				return
 			}
 
+			aggDd := shards[aggShard(ac.Ident(), len(shards))]
 			if clstr == nil {
 				aggDd.ProcessCmd(ac)
 			} else {
@@ -160,17 +221,73 @@ var aggWorker = func(wc wController, aggCh chan *aggregator.Command, clstr clust
 	}
 }
 
-// Implement cluster.DistDatum for stats
+// aggShard returns the shard of n for ident.
+func aggShard(ident serde.Ident, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(ident.String()))
+	return int(h.Sum32() % uint32(n))
+}
+
+// Implement cluster.DistDatum for stats, one per shard
 
+// The aggregator is used by both the aggWorker and the cluster
+// Transition (which calls Relinquish()), so every call to it takes
+// the lock.
 type distDatumAggregator struct {
 	aggregator.Aggregator
+	shard int
+
+	sync.Mutex
+	handOff []*aggregator.Command // rates to hand over to the new lead
+}
+
+func (d *distDatumAggregator) ProcessCmd(cmd *aggregator.Command) {
+	d.Lock()
+	defer d.Unlock()
+	d.Aggregator.ProcessCmd(cmd)
+}
+
+func (d *distDatumAggregator) Flush(now time.Time) {
+	d.Lock()
+	defer d.Unlock()
+	d.Aggregator.Flush(now)
+}
+
+func (d *distDatumAggregator) Reset() {
+	d.Lock()
+	defer d.Unlock()
+	d.Aggregator.Reset()
+}
+
+// rateCommander is an Aggregator whose rates can be handed over to
+// another node, see aggregator.State.RateCommands().
+type rateCommander interface {
+	RateCommands() []*aggregator.Command
+}
+
+func (d *distDatumAggregator) takeHandOff() []*aggregator.Command {
+	d.Lock()
+	defer d.Unlock()
+	cmds := d.handOff
+	d.handOff = nil
+	return cmds
 }
 
-func (d *distDatumAggregator) Id() int64       { return 1 }
+func (d *distDatumAggregator) Id() int64       { return int64(d.shard) + 1 }
 func (d *distDatumAggregator) Type() string    { return "aggregator.Aggregator" }
-func (d *distDatumAggregator) GetName() string { return "TheAggregator" }
+func (d *distDatumAggregator) GetName() string { return fmt.Sprintf("TheAggregator:%d", d.shard) }
 func (d *distDatumAggregator) Relinquish() error {
-	d.Flush(time.Now())
+	d.Lock()
+	defer d.Unlock()
+	d.Aggregator.Flush(time.Now())
+	// The rates are handed over to the node acquiring us on the
+	// next flush (only then is it known which node that is), and
+	// the state is cleared, if we kept it we would continue to
+	// flush decaying rates for idents we no longer own.
+	if rc, ok := d.Aggregator.(rateCommander); ok {
+		d.handOff = append(d.handOff, rc.RateCommands()...)
+	}
+	d.Aggregator.Reset()
 	return nil
 }
 func (d *distDatumAggregator) Acquire() error { return nil }
@@ -183,11 +183,12 @@ func Test_aggworkerForwardACToNode(t *testing.T) {
 }
 
 type fakeAggregatorer struct {
-	pcCalled, flushCalled int
+	pcCalled, flushCalled, resetCalled int
 }
 
 func (f *fakeAggregatorer) ProcessCmd(cmd *aggregator.Command) { f.pcCalled++ }
 func (f *fakeAggregatorer) Flush(_ time.Time)                  { f.flushCalled++ }
+func (f *fakeAggregatorer) Reset()                             { f.resetCalled++ }
 
 func Test_aggworkerProcessOrForward(t *testing.T) {
 
@@ -200,7 +201,7 @@ func Test_aggworkerProcessOrForward(t *testing.T) {
 
 	ac := aggregator.NewCommand(aggregator.CmdAdd, serde.Ident{"name": "foo"}, 123)
 	agg := &fakeAggregatorer{}
-	aggDd := &distDatumAggregator{agg}
+	aggDd := &distDatumAggregator{Aggregator: agg}
 
 	// cluster
 	clstr := &fakeCluster{}
@@ -352,7 +353,7 @@ func Test_aggworker_theAggworker(t *testing.T) {
 
 func Test_aggworker_distDatumAggregator(t *testing.T) {
 	agg := &fakeAggregatorer{}
-	aggDd := &distDatumAggregator{agg}
+	aggDd := &distDatumAggregator{Aggregator: agg}
 
 	if aggDd.Id() != 1 {
 		t.Errorf("distDatumAggregator.Id() != 1")
@@ -360,15 +361,109 @@ func Test_aggworker_distDatumAggregator(t *testing.T) {
 	if aggDd.Type() != "aggregator.Aggregator" {
 		t.Errorf("distDatumAggregator.Type() != 'aggregator.Aggregator'")
 	}
-	if aggDd.GetName() != "TheAggregator" {
-		t.Errorf("distDatumAggregator.GetName() != 'TheAggregator'")
+	if aggDd.GetName() != "TheAggregator:0" {
+		t.Errorf("distDatumAggregator.GetName() != 'TheAggregator:0'")
+	}
+	if dd := (&distDatumAggregator{Aggregator: agg, shard: 3}); dd.Id() != 4 || dd.GetName() != "TheAggregator:3" {
+		t.Errorf("distDatumAggregator: shard 3 Id() != 4 or GetName() != 'TheAggregator:3'")
 	}
 	aggDd.Relinquish()
 	if agg.flushCalled == 0 {
 		t.Errorf("distDatumAggregator: Flush not called on Relinquish()")
 	}
+	if agg.resetCalled == 0 {
+		t.Errorf("distDatumAggregator: Reset not called on Relinquish()")
+	}
 	if aggDd.Acquire() != nil {
 		t.Errorf("distDatumAggregator.Acquire() != nil")
 	}
 
 }
+
+func Test_aggworkerHandOff(t *testing.T) {
+	saveFn := aggWorkerForwardACToNode
+	defer func() { aggWorkerForwardACToNode = saveFn }()
+	forward := 0
+	aggWorkerForwardACToNode = func(ac *aggregator.Command, node *cluster.Node, snd chan *cluster.Msg) error {
+		forward++
+		return nil
+	}
+
+	agg := aggregator.NewAggregator(&fakeDataPointQueuer{})
+	agg.ProcessCmd(aggregator.NewCommand(aggregator.CmdAddEWMA, serde.Ident{"name": "foo"}, 1))
+	agg.ProcessCmd(aggregator.NewCommand(aggregator.CmdAddWindow, serde.Ident{"name": "bar"}, 1))
+	aggDd := &distDatumAggregator{Aggregator: agg}
+	aggDd.Relinquish()
+	if len(aggDd.handOff) != 2 {
+		t.Fatalf("Relinquish: expected 2 rates to hand over, got %d", len(aggDd.handOff))
+	}
+
+	md := make([]byte, 20)
+	md[0] = 1 // Ready
+	remote := &cluster.Node{Node: &memberlist.Node{Meta: md, Name: "remote"}}
+	clstr := &fakeCluster{ln: &cluster.Node{Node: &memberlist.Node{Meta: md, Name: "local"}}}
+	clstr.nodesForDd = []*cluster.Node{remote}
+	aggWorkerHandOff(aggDd, clstr, nil)
+	if forward != 2 || aggDd.handOff != nil {
+		t.Errorf("aggWorkerHandOff: expected 2 rates forwarded, got %d", forward)
+	}
+	aggWorkerHandOff(aggDd, clstr, nil)
+	if forward != 2 {
+		t.Errorf("aggWorkerHandOff: rates should be handed over only once")
+	}
+
+	// the shard came back to us before the hand over
+	fake := &fakeAggregatorer{}
+	aggDd = &distDatumAggregator{Aggregator: fake}
+	aggDd.handOff = []*aggregator.Command{aggregator.NewCommand(aggregator.CmdAdd, serde.Ident{"name": "foo"}, 1)}
+	clstr.nodesForDd = []*cluster.Node{clstr.ln}
+	aggWorkerHandOff(aggDd, clstr, nil)
+	if fake.pcCalled != 1 || forward != 2 {
+		t.Errorf("aggWorkerHandOff: rates should be restored locally")
+	}
+}
+
+func Test_aggworker_distDatumAggregator_concurrent(t *testing.T) {
+	agg := aggregator.NewAggregator(&fakeDataPointQueuer{})
+	aggDd := &distDatumAggregator{Aggregator: agg}
+
+	// A Transition relinquishing the shard while the aggWorker is
+	// using it, meant to be run with -race.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			aggDd.Relinquish()
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		aggDd.ProcessCmd(aggregator.NewCommand(aggregator.CmdAddEWMA, serde.Ident{"name": fmt.Sprintf("foo.%d", i%10)}, 1))
+		if i%100 == 0 {
+			aggDd.Flush(time.Now())
+		}
+	}
+	wg.Wait()
+	if len(aggDd.takeHandOff()) == 0 {
+		t.Errorf("distDatumAggregator: expected rates to hand over")
+	}
+}
+
+func Test_aggworker_aggShard(t *testing.T) {
+	n := 16
+	seen := make(map[int]bool)
+	for i := 0; i < 1000; i++ {
+		ident := serde.Ident{"name": fmt.Sprintf("foo.bar.%d", i)}
+		shard := aggShard(ident, n)
+		if shard < 0 || shard >= n {
+			t.Fatalf("aggShard: %d out of range", shard)
+		}
+		if aggShard(ident, n) != shard {
+			t.Errorf("aggShard: not deterministic for %v", ident)
+		}
+		seen[shard] = true
+	}
+	if len(seen) != n {
+		t.Errorf("aggShard: only %d of %d shards used", len(seen), n)
+	}
+}
@@ -0,0 +1,334 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package receiver
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tgres/tgres/rrd"
+	"github.com/tgres/tgres/serde"
+)
+
+// Backfilling is the loading of historical data points, i.e. those
+// not newer than the lastUpdate of a DS, which ProcessDataPoint()
+// would reject. The points of a series are processed by a fresh copy
+// of the DS which is then used to populate the RRAs, much like
+// cmd/whisper_import does. The resulting RRA slots are written
+// directly to the database, but only those that are within the RRA
+// as of its latest in the database and, if the database can tell
+// (see rraDataLoader), have no data there yet, the rest is
+// dropped. A slot with data (e.g. a coarse one the live data points
+// are in too) is left as is, the backfilled points would only be a
+// part of it.
+//
+// Points newer than lastUpdate are not backfilled, they are queued
+// like any other data point. Thus a DS that does not exist yet
+// (lastUpdate is zero) is created and populated the usual way.
+//
+// All points of a series should be backfilled at once, because the
+// last (partially covered) slot of every RRA is written with only
+// the data at hand.
+
+// A serde which can load the data of an RRA, Backfill() uses it to
+// find the slots which have data already.
+type rraDataLoader interface {
+	LoadRRAData(rra rrd.RoundRobinArchiver) (rrd.RoundRobinArchiver, error)
+}
+
+// BackfillPoint is a historical data point.
+type BackfillPoint struct {
+	Time  time.Time
+	Value float64
+}
+
+// BackfillSeries is a series of historical data points, they need not
+// be sorted.
+type BackfillSeries struct {
+	Ident  serde.Ident
+	Points []BackfillPoint
+}
+
+// BackfillResult summarizes a Backfill().
+type BackfillResult struct {
+	Series int      `json:"series"`
+	Stored int      `json:"stored"` // points backfilled into at least one RRA
+	Queued int      `json:"queued"` // points newer than lastUpdate, queued as usual
+	Slots  int      `json:"slots"`  // RRA slots written to the database
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Backfill loads historical data. A failure in one series does not
+// affect others and is listed in the result Errors, the returned
+// error is for database write failures.
+func (r *Receiver) Backfill(series []*BackfillSeries) (*BackfillResult, error) {
+	result := &BackfillResult{}
+	segs := make(map[bundleKey]*backfillSegment)
+	dl, _ := r.serde.Fetcher().(rraDataLoader)
+
+	for _, s := range series {
+		if len(s.Points) == 0 {
+			continue
+		}
+		result.Series++
+
+		sort.Slice(s.Points, func(i, j int) bool { return s.Points[i].Time.Before(s.Points[j].Time) })
+
+		ds, err := r.backfillDS(s.Ident)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%v: %v", s.Ident, err))
+			continue
+		}
+
+		lu := r.backfillLastUpdate(s.Ident, ds)
+		n := 0
+		if !lu.IsZero() {
+			n = sort.Search(len(s.Points), func(i int) bool { return s.Points[i].Time.After(lu) })
+		}
+		for _, p := range s.Points[n:] {
+			r.QueueDataPoint(s.Ident, p.Time, p.Value)
+		}
+		result.Queued += len(s.Points) - n
+
+		if n > 0 {
+			stored, slots, skipped, kept, err := backfillRRAs(ds, s.Points[:n], segs, dl)
+			if err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("%v: %v", s.Ident, err))
+				continue
+			}
+			result.Stored += stored
+			result.Slots += slots
+			if skipped > 0 {
+				result.Errors = append(result.Errors, fmt.Sprintf("%v: %d of %d RRAs skipped, they have no data in the database yet", s.Ident, skipped, len(ds.RRAs())))
+			}
+			if kept > 0 {
+				result.Errors = append(result.Errors, fmt.Sprintf("%v: %d RRA slots left as is, they have data in the database already", s.Ident, kept))
+			}
+		}
+	}
+
+	if len(segs) == 0 {
+		return result, nil
+	}
+	if err := flushBackfill(r.serde.Flusher(), segs); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+func (r *Receiver) backfillDS(ident serde.Ident) (serde.DbDataSourcer, error) {
+	if ident["name"] == "" {
+		return nil, fmt.Errorf("name tag is required")
+	}
+	db := r.serde.Fetcher()
+	ds, err := db.FetchOrCreateDataSource(ident, nil)
+	if err != nil {
+		return nil, err
+	}
+	created := false
+	if ds == nil {
+		var spec *rrd.DSSpec
+		if r.dsc.finder != nil {
+			spec = r.dsc.finder.FindMatchingDSSpec(ident)
+		}
+		if spec == nil {
+			return nil, fmt.Errorf("no such DS and no matching DS spec to create it")
+		}
+		// Unless the cache has it (not yet saved), this is a new
+		// series, subject to the series limits.
+		if r.dsc.getByIdent(newCachedIdent(ident)) == nil {
+			r.dsc.RLock()
+			allow := r.dsc.limiter.allow(ident)
+			r.dsc.RUnlock()
+			if !allow {
+				return nil, fmt.Errorf("series limit reached, not creating DS")
+			}
+			created = true
+		}
+		if ds, err = db.FetchOrCreateDataSource(ident, spec); err != nil {
+			return nil, err
+		}
+	}
+	dbds, ok := ds.(serde.DbDataSourcer)
+	if !ok {
+		return nil, fmt.Errorf("DS must be a serde.DbDataSourcer")
+	}
+	if created {
+		// Count it and have the points newer than lastUpdate use it.
+		r.dsc.insert(&cachedDs{DbDataSourcer: dbds, mu: &sync.Mutex{}, lastProcess: time.Now()})
+		r.dsc.register(dbds)
+	}
+	return dbds, nil
+}
+
+// backfillLastUpdate is the lastUpdate of the DS in the database, or
+// in the cache if that is later.
+func (r *Receiver) backfillLastUpdate(ident serde.Ident, ds rrd.DataSourcer) time.Time {
+	lu := ds.LastUpdate()
+	if cds := r.dsc.getByIdent(newCachedIdent(ident)); cds != nil {
+		cds.mu.Lock()
+		if cds.DbDataSourcer != nil && cds.LastUpdate().After(lu) {
+			lu = cds.LastUpdate()
+		}
+		cds.mu.Unlock()
+	}
+	return lu
+}
+
+// Like verticalCacheSegment, only much simpler.
+type backfillSegment struct {
+	rows    map[int64]crossRRAPoints
+	latests map[int64]time.Time // as of the database, for versions
+	step    time.Duration
+	size    int64
+}
+
+// backfillRRAs processes points with a fresh copy of ds and adds
+// resulting RRA slots to segs. It returns the number of points in
+// those slots, the number of slots, the number of RRAs skipped
+// because their latest is unknown and the number of slots left as
+// is because they have data in the database (which dl, if not nil,
+// loads).
+func backfillRRAs(ds serde.DbDataSourcer, points []BackfillPoint, segs map[bundleKey]*backfillSegment, dl rraDataLoader) (stored, slots, skipped, kept int, err error) {
+	spec := ds.Spec()
+	for i := range spec.RRAs {
+		spec.RRAs[i].Xff = 0 // historical data is often sparse
+	}
+
+	// The first point covers its entire PDP
+	first, last := points[0].Time, points[len(points)-1].Time
+	begin := first.Truncate(spec.Step)
+	if begin.Equal(first) {
+		begin = begin.Add(-spec.Step)
+	}
+	spec.LastUpdate = begin
+
+	fresh := rrd.NewDataSource(spec)
+	for _, p := range points {
+		if p.Time.After(fresh.LastUpdate()) { // skip duplicates
+			fresh.ProcessDataPoint(p.Value, p.Time)
+		}
+	}
+
+	// Complete the last slot of every RRA with what we have. NaN
+	// does not count in consolidation.
+	end := last
+	for _, rra := range fresh.RRAs() {
+		if e := last.Truncate(rra.Step()); e.Before(last) && e.Add(rra.Step()).After(end) {
+			end = e.Add(rra.Step())
+		}
+	}
+	if end.After(last) {
+		fresh.ProcessDataPoint(math.NaN(), end)
+	}
+
+	// The slots which have data in the database, loaded before
+	// anything is added to segs.
+	dbRRAs := ds.RRAs()
+	have := make([]map[int64]float64, len(dbRRAs))
+	for j, rra := range dbRRAs {
+		if dl != nil && !rra.Latest().IsZero() {
+			loaded, err := dl.LoadRRAData(rra)
+			if err != nil {
+				return 0, 0, 0, 0, err
+			}
+			have[j] = loaded.DPs()
+		}
+	}
+
+	type span struct{ from, to time.Time } // (from, to] written
+	var spans []span
+	for j, rra := range fresh.RRAs() {
+		dbrra, ok := dbRRAs[j].(serde.DbRoundRobinArchiver)
+		if !ok {
+			continue
+		}
+		latest := dbrra.Latest()
+		if latest.IsZero() {
+			skipped++ // nothing to align to (yet)
+			continue
+		}
+		step, size := rra.Step(), rra.Size()
+		oldest := latest.Add(-step * time.Duration(size))
+
+		var sp span
+		key := bundleKey{dbrra.BundleId(), dbrra.Seg()}
+		for i, v := range rra.DPs() {
+			t := rrd.SlotTime(i, rra.Latest(), step, size)
+			if !t.After(begin) || !t.Add(-step).Before(last) {
+				continue // no data of ours in this slot
+			}
+			if t.After(latest) || !t.After(oldest) {
+				continue // not within the RRA in the db
+			}
+			if _, ok := have[j][i]; ok {
+				kept++
+				continue
+			}
+			seg := segs[key]
+			if seg == nil {
+				seg = &backfillSegment{
+					rows:    make(map[int64]crossRRAPoints),
+					latests: make(map[int64]time.Time),
+					step:    step,
+					size:    size,
+				}
+				segs[key] = seg
+			}
+			if seg.rows[i] == nil {
+				seg.rows[i] = make(crossRRAPoints)
+			}
+			seg.rows[i][dbrra.Idx()] = v
+			seg.latests[dbrra.Idx()] = latest
+			slots++
+			if sp.to.IsZero() || t.After(sp.to) {
+				sp.to = t
+			}
+			if sp.from.IsZero() || t.Add(-step).Before(sp.from) {
+				sp.from = t.Add(-step)
+			}
+		}
+		if !sp.to.IsZero() {
+			spans = append(spans, sp)
+		}
+	}
+
+	for _, p := range points {
+		for _, sp := range spans {
+			if p.Time.After(sp.from) && !p.Time.After(sp.to) {
+				stored++
+				break
+			}
+		}
+	}
+	return stored, slots, skipped, kept, nil
+}
+
+func flushBackfill(db serde.Flusher, segs map[bundleKey]*backfillSegment) error {
+	for key, seg := range segs {
+		ivers := latestIVers(seg.latests, seg.step, seg.size)
+		for i, row := range seg.rows {
+			dps, vers := dataPointsWithVersions(row, i, ivers)
+			if _, err := db.FlushDataPoints(key.bundleId, key.seg, i, dps, vers); err != nil {
+				return fmt.Errorf("Backfill: error flushing segment %v:%v slot %d: %v", key.bundleId, key.seg, i, err)
+			}
+		}
+	}
+	return nil
+}
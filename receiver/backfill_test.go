@@ -0,0 +1,214 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package receiver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tgres/tgres/rrd"
+	"github.com/tgres/tgres/serde"
+)
+
+type fakeDbRRA struct {
+	rrd.RoundRobinArchiver
+	idx int64
+}
+
+func (f *fakeDbRRA) Id() int64                { return f.idx }
+func (f *fakeDbRRA) Width() int64             { return 200 }
+func (f *fakeDbRRA) SlotRow(slot int64) int64 { return slot }
+func (f *fakeDbRRA) Seg() int64               { return 0 }
+func (f *fakeDbRRA) Idx() int64               { return f.idx }
+func (f *fakeDbRRA) BundleId() int64          { return 1 }
+
+type fakeBackfillSerde struct {
+	fakeSerde
+	ds      rrd.DataSourcer
+	flushed map[int64]map[int64]interface{} // i -> idx -> value
+	have    map[int64]map[int64]float64     // idx -> i -> value, in the db
+}
+
+func (f *fakeBackfillSerde) Fetcher() serde.Fetcher { return f }
+func (f *fakeBackfillSerde) Flusher() serde.Flusher { return f }
+func (f *fakeBackfillSerde) FetchOrCreateDataSource(ident serde.Ident, dsSpec *rrd.DSSpec) (rrd.DataSourcer, error) {
+	return f.ds, nil
+}
+func (f *fakeBackfillSerde) LoadRRAData(rra rrd.RoundRobinArchiver) (rrd.RoundRobinArchiver, error) {
+	spec := rra.Spec()
+	spec.Latest = rra.Latest()
+	spec.DPs = f.have[rra.(*fakeDbRRA).idx]
+	return rrd.NewRoundRobinArchive(spec), nil
+}
+func (f *fakeBackfillSerde) FlushDataPoints(bundleId, seg, i int64, dps, vers map[int64]interface{}) (int, error) {
+	if f.flushed == nil {
+		f.flushed = make(map[int64]map[int64]interface{})
+	}
+	f.flushed[i] = dps
+	return 1, nil
+}
+func (f *fakeBackfillSerde) FlushDSStates(seg int64, lastupdate, value, duration map[int64]interface{}) (int, error) {
+	return 0, nil
+}
+func (f *fakeBackfillSerde) FlushRRAStates(bundle_id, seg int64, latests, value, duration map[int64]interface{}) (int, error) {
+	return 0, nil
+}
+
+func Test_Receiver_Backfill(t *testing.T) {
+	lu := time.Unix(1000, 0)
+	ds := rrd.NewDataSource(rrd.DSSpec{
+		Step:       10 * time.Second,
+		Heartbeat:  time.Hour,
+		LastUpdate: lu,
+		RRAs: []rrd.RRASpec{
+			{Function: rrd.WMEAN, Step: 10 * time.Second, Span: 100 * time.Second, Latest: lu},
+			{Function: rrd.WMEAN, Step: time.Minute, Span: 10 * time.Minute, Latest: time.Unix(960, 0)},
+		},
+	})
+	rras := ds.RRAs()
+	ident := serde.Ident{"name": "foo"}
+	dbds := serde.NewDbDataSource(7, ident, 0, 0, ds)
+	dbds.SetRRAs([]rrd.RoundRobinArchiver{&fakeDbRRA{rras[0], 1}, &fakeDbRRA{rras[1], 2}})
+
+	db := &fakeBackfillSerde{ds: dbds}
+	ch := make(chan interface{}, 10)
+	r := &Receiver{serde: db, dsc: newDsCache(db, nil, nil), dpChIn: ch}
+
+	res, err := r.Backfill([]*BackfillSeries{
+		{Ident: ident, Points: []BackfillPoint{
+			{time.Unix(1010, 0), 4}, // newer than lu
+			{time.Unix(960, 0), 2},
+			{time.Unix(950, 0), 1},
+			{time.Unix(970, 0), 3},
+		}},
+		{Ident: serde.Ident{}, Points: []BackfillPoint{{time.Unix(950, 0), 1}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Series != 2 || res.Stored != 3 || res.Queued != 1 || len(res.Errors) != 1 {
+		t.Errorf("Backfill: unexpected result: %+v", res)
+	}
+	if len(ch) != 1 {
+		t.Errorf("Backfill: expected 1 point queued, got %d", len(ch))
+	}
+
+	// 10s RRA (idx 1): slots ending 950, 960, 970
+	for i, exp := range map[int64]float64{5: 1, 6: 2, 7: 3} {
+		if v := db.flushed[i][1]; v != exp {
+			t.Errorf("Backfill: 10s slot %d: expected %v, got %v", i, exp, v)
+		}
+	}
+	if _, ok := db.flushed[8][1]; ok {
+		t.Errorf("Backfill: 10s slot 8 (after the last point) should not be written")
+	}
+	// 60s RRA (idx 2): slot ending at 960 is the mean of 1 and 2,
+	// the one ending at 1020 is past its latest.
+	if v := db.flushed[int64(6)][2]; v != 1.5 {
+		t.Errorf("Backfill: 60s slot 6: expected 1.5, got %v", v)
+	}
+	if _, ok := db.flushed[7][2]; ok {
+		t.Errorf("Backfill: 60s slot 7 is past RRA latest and should not be written")
+	}
+	if res.Slots != 4 {
+		t.Errorf("Backfill: expected 4 slots, got %d", res.Slots)
+	}
+}
+
+func Test_Receiver_Backfill_skipped(t *testing.T) {
+	lu := time.Unix(1000, 0)
+	ds := rrd.NewDataSource(rrd.DSSpec{
+		Step:       10 * time.Second,
+		Heartbeat:  time.Hour,
+		LastUpdate: lu,
+		RRAs: []rrd.RRASpec{
+			{Function: rrd.WMEAN, Step: 10 * time.Second, Span: 100 * time.Second, Latest: lu},
+			{Function: rrd.WMEAN, Step: time.Minute, Span: 10 * time.Minute}, // never flushed
+		},
+	})
+	rras := ds.RRAs()
+	ident := serde.Ident{"name": "foo"}
+	dbds := serde.NewDbDataSource(7, ident, 0, 0, ds)
+	dbds.SetRRAs([]rrd.RoundRobinArchiver{&fakeDbRRA{rras[0], 1}, &fakeDbRRA{rras[1], 2}})
+
+	db := &fakeBackfillSerde{ds: dbds}
+	r := &Receiver{serde: db, dsc: newDsCache(db, nil, nil), dpChIn: make(chan interface{}, 10)}
+
+	res, err := r.Backfill([]*BackfillSeries{
+		{Ident: ident, Points: []BackfillPoint{
+			{time.Unix(500, 0), 1}, // older than the 10s RRA
+			{time.Unix(950, 0), 2},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The 950 point covers 910..950 (within the heartbeat), the 500
+	// one is in none of the RRA slots.
+	if res.Stored != 1 || res.Slots != 5 || len(res.Errors) != 1 {
+		t.Errorf("Backfill: expected 1 point stored in 5 slots and 1 RRA skipped, got %+v", res)
+	}
+	if _, ok := db.flushed[5][2]; ok {
+		t.Errorf("Backfill: the 60s RRA should not be written")
+	}
+}
+
+func Test_Receiver_Backfill_existing(t *testing.T) {
+	lu := time.Unix(1000, 0)
+	ds := rrd.NewDataSource(rrd.DSSpec{
+		Step:       10 * time.Second,
+		Heartbeat:  time.Hour,
+		LastUpdate: lu,
+		RRAs: []rrd.RRASpec{
+			{Function: rrd.WMEAN, Step: 10 * time.Second, Span: 100 * time.Second, Latest: lu},
+			{Function: rrd.WMEAN, Step: time.Minute, Span: 10 * time.Minute, Latest: time.Unix(960, 0)},
+		},
+	})
+	rras := ds.RRAs()
+	ident := serde.Ident{"name": "foo"}
+	dbds := serde.NewDbDataSource(7, ident, 0, 0, ds)
+	dbds.SetRRAs([]rrd.RoundRobinArchiver{&fakeDbRRA{rras[0], 1}, &fakeDbRRA{rras[1], 2}})
+
+	// Live data since 955: the 10s slots ending 960 through 1000 and
+	// the 60s slot ending 960 have data.
+	db := &fakeBackfillSerde{ds: dbds, have: map[int64]map[int64]float64{
+		1: {6: 5, 7: 5, 8: 5, 9: 5, 0: 5},
+		2: {6: 5},
+	}}
+	r := &Receiver{serde: db, dsc: newDsCache(db, nil, nil), dpChIn: make(chan interface{}, 10)}
+
+	res, err := r.Backfill([]*BackfillSeries{
+		{Ident: ident, Points: []BackfillPoint{
+			{time.Unix(930, 0), 1},
+			{time.Unix(940, 0), 2},
+			{time.Unix(950, 0), 3},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stored != 3 || res.Slots != 3 || len(res.Errors) != 1 {
+		t.Errorf("Backfill: expected 3 points in 3 slots and the 60s slot reported, got %+v", res)
+	}
+	for i, exp := range map[int64]float64{3: 1, 4: 2, 5: 3} {
+		if v := db.flushed[i][1]; v != exp {
+			t.Errorf("Backfill: 10s slot %d: expected %v, got %v", i, exp, v)
+		}
+	}
+	if _, ok := db.flushed[6][2]; ok {
+		t.Errorf("Backfill: the 60s slot ending 960 has live data and should be left as is")
+	}
+}
@@ -17,15 +17,19 @@ package receiver
 
 import (
 	"fmt"
-	"log"
 	"math"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/tgres/tgres/cluster"
+	"github.com/tgres/tgres/logging"
 )
 
+// How long the director waits for a cluster transition to complete,
+// it processes nothing in the meantime.
+var directorTransitionTimeout = 15 * time.Second
+
 var directorIncomingDPMessages = func(rcv chan *cluster.Msg, dpCh chan<- interface{}) {
 	defer func() { recover() }() // if we're writing to a closed channel below
 
@@ -38,13 +42,13 @@ var directorIncomingDPMessages = func(rcv chan *cluster.Msg, dpCh chan<- interfa
 		// To get an event back:
 		var dp incomingDP
 		if err := m.Decode(&dp); err != nil {
-			log.Printf("director: msg <- rcv data point decoding FAILED, ignoring this data point.")
+			logger.Errorf("director: msg <- rcv data point decoding FAILED, ignoring this data point.")
 			continue
 		}
 
 		maxHops := 2
 		if dp.Hops > maxHops {
-			log.Printf("director: dropping data point, max hops (%d) reached", maxHops)
+			logger.Warnf("director: dropping data point, max hops (%d) reached", maxHops)
 			continue
 		}
 
@@ -55,8 +59,11 @@ var directorIncomingDPMessages = func(rcv chan *cluster.Msg, dpCh chan<- interfa
 var directorForwardDPToNode = func(dp *incomingDP, node *cluster.Node, snd chan *cluster.Msg) error {
 	if dp.Hops == 0 { // we do not forward more than once
 		if node.Ready() {
-			dp.Hops++
-			msg, _ := cluster.NewMsg(node, dp) // can't possibly error
+			// dp itself is left alone, it may need to go to
+			// other nodes which have a copy.
+			fwd := *dp
+			fwd.Hops++
+			msg, _ := cluster.NewMsg(node, &fwd) // can't possibly error
 			snd <- msg
 		} else {
 			return fmt.Errorf("directorForwardDPToNode: Node is not ready")
@@ -70,7 +77,7 @@ var directorProcessDataPoint = func(cds *cachedDs, dsf dsFlusherBlocking) (int,
 	cnt, blk, err := cds.processIncoming()
 	if err != nil {
 		if !strings.Contains(err.Error(), "not greater than data source") {
-			log.Printf("directorProcessDataPoint [%v] error: %v", cds.Ident(), err)
+			logger.Errorf("directorProcessDataPoint [%v] error: %v", cds.Ident(), err)
 		}
 	}
 
@@ -79,9 +86,24 @@ var directorProcessDataPoint = func(cds *cachedDs, dsf dsFlusherBlocking) (int,
 	// datapoint, it can still result in ds.PointCount() of 0, but
 	// lastupdate/value/dur of the DS may have changed.
 	if (cnt > 0 || cds.PointCount() > 0) && cds.lastFlush.Before(time.Now().Add(-cds.Step())) {
-		dsf.flushToVCache(cds.DbDataSourcer)
+		if cds.replica {
+			// A copy is never persisted, the lead node does
+			// that. Points the lead has persisted are discarded,
+			// see replica.go.
+			if p := dsf.persistedRRAs(); p != nil {
+				p.clearPersisted(cds.DbDataSourcer)
+			}
+			cds.traceEnd("replica")
+		} else {
+			cds.traceStage("vcache.flush")
+			dsf.flushToVCache(cds.DbDataSourcer)
+			cds.traceEnd("ok")
+		}
 		cds.lastFlush = time.Now()
 	}
+	// Points not flushed yet are in the DS, they will be moved to
+	// vcache by a subsequent flush.
+	cds.traceEnd("pending")
 	cds.mu.Unlock()
 	return cnt, blk
 }
@@ -92,28 +114,47 @@ var directorProcessOrForward = func(dsc *dsCache, cds *cachedDs, workerCh chan *
 		return
 	}
 
-	for _, node := range clstr.NodesForDistDatum(&distDs{DbDataSourcer: cds.DbDataSourcer, dsc: dsc}) {
-		if node.Name() == clstr.LocalNode().Name() {
-			workerCh <- cds
-		} else {
-			for _, dp := range cds.incoming {
-				if err := directorForwardDPToNode(dp, node, snd); err != nil {
-					log.Printf("director: Error forwarding a data point: %v", err)
-					// TODO For not ready error - sleep and return the dp to the channel?
-					continue
-				}
-				stats.forwarded++
-				stats.forwarded_to[node.SanitizedAddr()]++
+	// The first node is the lead which persists the DS, the rest
+	// (if any) keep a copy in memory so that they can take over
+	// without loss. Data points are sent to every one of them.
+	local := -1
+	ln := clstr.LocalNode()
+	for i, node := range clstr.NodesForDistDatum(&distDs{DbDataSourcer: cds.DbDataSourcer, dsc: dsc}) {
+		if node.Name() == ln.Name() {
+			local = i
+			continue
+		}
+		for _, dp := range cds.incoming {
+			if dp.Hops > 0 {
+				continue // forwarded to us, the sender takes care of the rest
 			}
-			cds.incoming = nil
-			// Always clear RRAs to prevent it from being saved
-			if pc := cds.PointCount(); pc > 0 {
-				log.Printf("director: WARNING: Clearing DS with PointCount > 0: %v", pc)
+			if err := directorForwardDPToNode(dp, node, snd); err != nil {
+				logger.Errorf("director: Error forwarding a data point: %v", err)
+				// TODO For not ready error - sleep and return the dp to the channel?
+				continue
 			}
-			cds.ClearRRAs()
+			stats.forwarded++
+			stats.forwarded_to[node.SanitizedAddr()]++
 		}
 	}
-	return
+
+	if local >= 0 {
+		cds.mu.Lock()
+		cds.replica = local > 0
+		cds.mu.Unlock()
+		workerCh <- cds
+		return
+	}
+
+	for _, dp := range cds.incoming {
+		dp.traceEnd("forwarded")
+	}
+	cds.incoming = nil
+	// Always clear RRAs to prevent it from being saved
+	if pc := cds.PointCount(); pc > 0 {
+		logger.Warnf("director: Clearing DS with PointCount > 0: %v", pc)
+	}
+	cds.ClearRRAs()
 }
 
 var directorProcessIncomingDP = func(dp *incomingDP, dsc *dsCache, loaderCh chan interface{}, workerCh chan *cachedDs, clstr clusterer, snd chan *cluster.Msg, stats *dpStats) {
@@ -123,18 +164,25 @@ var directorProcessIncomingDP = func(dp *incomingDP, dsc *dsCache, loaderCh chan
 		// registering a NaN". Or it means that "for certain it is
 		// offline", but that is not part of our scope. You can
 		// only get a NaN by exceeding HB. Silently ignore it.
+		dp.traceEnd("nan")
 		return
 	}
 
 	cds := dsc.getByIdentOrCreateEmpty(dp.cachedIdent)
 	if cds == nil {
 		stats.unknown++
-		if debug {
-			log.Printf("director: No spec matched ident: %#v, ignoring data point", dp.cachedIdent.String())
+		if logger.Enabled(logging.Debug) {
+			logger.Debugf("director: No spec matched ident: %#v, ignoring data point", dp.cachedIdent.String())
 		}
+		dp.traceEnd("unknown")
 		return
 	}
 
+	if cds.Id() == 0 {
+		dp.traceStage("receiver.load")
+	} else {
+		dp.traceStage("worker.queue")
+	}
 	cds.appendIncoming(dp)
 
 	if cds.Id() == 0 { // this DS needs to be loaded.
@@ -175,17 +223,28 @@ var loader = func(loaderCh chan interface{}, dpCh chan<- interface{}, dsc *dsCac
 	for {
 		x, ok := <-loaderCh
 		if !ok {
-			log.Printf("loader: channel closed, closing director channel and exiting...")
+			logger.Infof("loader: channel closed, closing director channel and exiting...")
 			close(dpCh)
-			log.Printf("loader: exiting.")
+			logger.Infof("loader: exiting.")
 			return
 		}
 
 		cds := x.(*cachedDs)
 
 		if cds.spec != nil { // nil spec means it's been loaded already
-			if err := dsc.fetchOrCreateByIdent(cds); err != nil {
-				log.Printf("loader: database error: %v", err)
+			rejected, err := dsc.fetchOrCreateByIdent(cds)
+			if err != nil {
+				logger.Errorf("loader: database error: %v", err)
+				continue
+			}
+			if rejected { // series limits
+				cds.mu.Lock()
+				for _, dp := range cds.incoming {
+					dp.traceEnd("rejected")
+				}
+				sr.reportStatCount("receiver.datapoints.rejected", float64(len(cds.incoming)))
+				cds.incoming = nil
+				cds.mu.Unlock()
 				continue
 			}
 		}
@@ -198,6 +257,11 @@ var loader = func(loaderCh chan interface{}, dpCh chan<- interface{}, dsc *dsCac
 	}
 }
 
+// The Receiver keeps cumulative forwarding stats, see ForwardedTo()
+type forwardCounter interface {
+	countForwarded(map[string]int)
+}
+
 type dpStats struct {
 	total, forwarded, unknown, dropped int
 	forwarded_to                       map[string]int
@@ -217,8 +281,10 @@ var director = func(wc wController, dpChIn chan<- interface{}, dpChOut <-chan in
 	if clstr != nil {
 		clusterChgCh = clstr.NotifyClusterChanges() // Monitor Cluster changes
 		snd, rcv = clstr.RegisterMsgType()          // Channel for event forwards to other nodes and us
+		dsc.registerTailRequests(clstr)
+		dsc.registerPersistedRequests(clstr)
 		go directorIncomingDPMessages(rcv, dpChIn)
-		log.Printf("director: marking cluster node as Ready.")
+		logger.Infof("director: marking cluster node as Ready.")
 		clstr.Ready(true)
 	}
 
@@ -234,7 +300,7 @@ var director = func(wc wController, dpChIn chan<- interface{}, dpChOut <-chan in
 
 	var workerWg sync.WaitGroup
 	workerCh := make(chan *cachedDs, 128)
-	log.Printf("director: starting %d workers.", nWorkers)
+	logger.Infof("director: starting %d workers.", nWorkers)
 	for i := 0; i < nWorkers; i++ {
 		workerWg.Add(1)
 		go worker(&workerWg, workerCh, dsf, sr, i)
@@ -247,6 +313,10 @@ var director = func(wc wController, dpChIn chan<- interface{}, dpChOut <-chan in
 
 	stats := dpStats{forwarded_to: make(map[string]int), last: time.Now()}
 
+	hb, _ := sr.(heartbeater)
+	beat := time.NewTicker(directorBeatInterval)
+	defer beat.Stop()
+
 	for {
 		var (
 			x   interface{}
@@ -258,11 +328,16 @@ var director = func(wc wController, dpChIn chan<- interface{}, dpChOut <-chan in
 		case _, ok = <-clusterChgCh:
 			if ok {
 				// See distDs.Relinquish() for some documentation
-				if err := clstr.Transition(15 * time.Second); err != nil {
-					log.Printf("director: Transition error: %v", err)
+				if err := clstr.Transition(directorTransitionTimeout); err != nil {
+					logger.Errorf("director: Transition error: %v", err)
 				}
 			}
 			continue
+		case <-beat.C:
+			if hb != nil {
+				hb.directorBeat()
+			}
+			continue
 		case x, ok = <-dpChOut:
 			switch x := x.(type) {
 			case *incomingDP:
@@ -270,19 +345,20 @@ var director = func(wc wController, dpChIn chan<- interface{}, dpChOut <-chan in
 			case *cachedDs:
 				cds = x
 			case nil:
-				log.Printf("director(): chanel close signal (nil) received")
+				logger.Infof("director(): chanel close signal (nil) received")
 			default:
-				log.Printf("director(): unknown type: %T", x)
+				logger.Warnf("director(): unknown type: %T", x)
 			}
 		}
 
 		if !ok {
-			log.Printf("director: exiting the director goroutine.")
+			logger.Infof("director: exiting the director goroutine.")
 			return
 		}
 
 		if dp != nil {
 			stats.total++
+			dp.traceStage("director.process")
 
 			if maxMem > 0 && memoryChecked.Before(time.Now().Add(-100*time.Millisecond)) {
 				currentMemory = runtimeMemory()
@@ -291,6 +367,7 @@ var director = func(wc wController, dpChIn chan<- interface{}, dpChOut <-chan in
 
 			if (maxMem > 0 && currentMemory > maxMem) || (queue != nil && maxQLen > 0 && queue.size() > maxQLen) {
 				stats.dropped++
+				dp.traceEnd("dropped")
 				// this data poind goes to /dev/null
 			} else {
 				// if the dp ident is not found, it will be submitted to
@@ -303,25 +380,25 @@ var director = func(wc wController, dpChIn chan<- interface{}, dpChOut <-chan in
 			directorProcessOrForward(dsc, cds, workerCh, clstr, snd, &stats)
 		} else {
 			// wait for worker and loader channels to empty
-			log.Printf("director: channel closed, waiting for loader and workers to empty...")
+			logger.Infof("director: channel closed, waiting for loader and workers to empty...")
 			for {
 				w, l := len(workerCh), len(loaderCh)
 				if w == 0 && l == 0 {
 					break
 				}
-				log.Printf("  -  worker: %d loader: %d", w, l)
+				logger.Infof("  -  worker: %d loader: %d", w, l)
 				time.Sleep(100 * time.Millisecond)
 				w, l = len(workerCh), len(loaderCh)
 			}
-			log.Printf("director: loader and worker channels empty.")
+			logger.Infof("director: loader and worker channels empty.")
 
 			// signal to exit
-			log.Printf("director: closing worker channels, waiting for workers to finish....")
+			logger.Infof("director: closing worker channels, waiting for workers to finish....")
 			close(workerCh)
 			workerWg.Wait()
-			log.Printf("director: closing worker channels Done.")
+			logger.Infof("director: closing worker channels Done.")
 
-			log.Printf("director: closing loader channel.")
+			logger.Infof("director: closing loader channel.")
 			close(loaderCh)
 		}
 
@@ -333,7 +410,11 @@ var director = func(wc wController, dpChIn chan<- interface{}, dpChOut <-chan in
 			for dest, cnt := range stats.forwarded_to {
 				sr.reportStatCount(fmt.Sprintf("receiver.forwarded_to.%s", dest), float64(cnt))
 			}
+			if fc, ok := sr.(forwardCounter); ok {
+				fc.countForwarded(stats.forwarded_to)
+			}
 			sr.reportStatCount("receiver.created", 0)
+			sr.reportStatCount("receiver.datapoints.rejected", 0)
 			stats = dpStats{forwarded_to: make(map[string]int), last: time.Now()}
 
 			st := dsc.stats()
@@ -344,14 +425,14 @@ var director = func(wc wController, dpChIn chan<- interface{}, dpChOut <-chan in
 }
 
 var worker = func(wg *sync.WaitGroup, workerCh chan *cachedDs, dsf dsFlusherBlocking, sr statReporter, n int) {
-	log.Printf("worker %d: starting.", n)
+	logger.Infof("worker %d: starting.", n)
 	defer wg.Done()
 	lastStat := time.Now()
 	accepted, watchBlk := 0, 0
 	for {
 		cds, ok := <-workerCh
 		if !ok {
-			log.Printf("worker %d: exiting.", n)
+			logger.Infof("worker %d: exiting.", n)
 			return
 		}
 		cnt, blk := directorProcessDataPoint(cds, dsf)
@@ -145,6 +145,9 @@ func Test_directorForwardDPToNode(t *testing.T) {
 	if count < 1 {
 		t.Errorf("Data point not sent to channel?")
 	}
+	if dp.Hops != 0 {
+		t.Errorf("directorForwardDPToNode: the original data point should not be modified")
+	}
 
 	// mark node not Ready
 	md[0] = 0
@@ -248,6 +251,60 @@ func Test_directorProcessOrForward(t *testing.T) {
 	directorForwardDPToNode = saveFn
 }
 
+func Test_directorProcessOrForward_copies(t *testing.T) {
+
+	saveFn := directorForwardDPToNode
+	forward := 0
+	directorForwardDPToNode = func(dp *incomingDP, node *cluster.Node, snd chan *cluster.Msg) error {
+		forward++
+		return nil
+	}
+	defer func() { directorForwardDPToNode = saveFn }()
+
+	st := &dpStats{forwarded_to: make(map[string]int), last: time.Now()}
+	db := &fakeSerde{}
+	dsc := newDsCache(db, &SimpleDSFinder{DftDSSPec}, &dsFlusher{db: db.Flusher(), sr: &fakeSr{}})
+
+	md := make([]byte, 20)
+	md[0] = 1 // Ready
+	local := &cluster.Node{Node: &memberlist.Node{Meta: md, Name: "local"}}
+	remote := &cluster.Node{Node: &memberlist.Node{Meta: md, Name: "remote"}}
+	clstr := &fakeCluster{ln: local}
+
+	workerCh := make(chan *cachedDs, 1)
+
+	foo := serde.Ident{"name": "foo"}
+	ds := serde.NewDbDataSource(0, foo, 0, 0, rrd.NewDataSource(*DftDSSPec))
+	cds := &cachedDs{DbDataSourcer: ds, mu: &sync.Mutex{}}
+
+	// we are a copy
+	clstr.nodesForDd = []*cluster.Node{remote, local}
+	cds.appendIncoming(&incomingDP{cachedIdent: newCachedIdent(foo), timeStamp: time.Unix(1000, 0), value: 123})
+	directorProcessOrForward(dsc, cds, workerCh, clstr, nil, st)
+	if forward != 1 {
+		t.Errorf("directorProcessOrForward: data point not forwarded to the lead node")
+	}
+	if len(workerCh) != 1 || !(<-workerCh).replica {
+		t.Errorf("directorProcessOrForward: a copy should be sent to the worker marked as replica")
+	}
+	if len(cds.incoming) != 1 {
+		t.Errorf("directorProcessOrForward: incoming should be left to the worker")
+	}
+
+	// we are the lead, the point was forwarded to us and goes no further
+	clstr.nodesForDd = []*cluster.Node{local, remote}
+	forward = 0
+	cds.incoming = nil
+	cds.appendIncoming(&incomingDP{cachedIdent: newCachedIdent(foo), timeStamp: time.Unix(1000, 0), value: 123, Hops: 1})
+	directorProcessOrForward(dsc, cds, workerCh, clstr, nil, st)
+	if forward != 0 {
+		t.Errorf("directorProcessOrForward: a forwarded data point should not be forwarded again")
+	}
+	if len(workerCh) != 1 || (<-workerCh).replica {
+		t.Errorf("directorProcessOrForward: the lead should be sent to the worker not marked as replica")
+	}
+}
+
 func Test_directorProcessIncomingDP(t *testing.T) {
 
 	saveFn := directorProcessOrForward
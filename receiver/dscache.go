@@ -36,6 +36,8 @@ type dsCache struct {
 	finder   MatchingDSSpecFinder
 	clstr    clusterer
 	rraCount int
+	tailReq  int            // cluster request id, see FetchTail()
+	limiter  *seriesLimiter // series counts and limits
 }
 
 // Returns a new dsCache object.
@@ -45,6 +47,8 @@ func newDsCache(db serde.Fetcher, finder MatchingDSSpecFinder, dsf dsFlusherBloc
 		db:      db,
 		finder:  finder,
 		dsf:     dsf,
+		tailReq: -1,
+		limiter: newSeriesLimiter(SeriesLimits{}),
 	}
 }
 
@@ -64,7 +68,28 @@ func (d *dsCache) insert(cds *cachedDs) {
 	} else if ds, ok := cds.DbDataSourcer.(rrd.DataSourcer); ok && ds != nil {
 		d.rraCount += len(ds.RRAs())
 	}
-	d.byIdent[cds.Ident().String()] = cds
+	// Only loaded DSs count towards the series limits, an empty one
+	// may yet turn out to be over the limit (see fetchOrCreateByIdent).
+	key := cds.Ident().String()
+	old, ok := d.byIdent[key]
+	if counted := ok && old.spec == nil; !counted && cds.spec == nil {
+		d.limiter.add(cds.Ident())
+	} else if counted && cds.spec != nil {
+		d.limiter.remove(cds.Ident())
+	}
+	d.byIdent[key] = cds
+}
+
+// setLimits replaces the series limiter, recounting the series.
+func (d *dsCache) setLimits(limits SeriesLimits) {
+	d.Lock()
+	defer d.Unlock()
+	d.limiter = newSeriesLimiter(limits)
+	for _, cds := range d.byIdent {
+		if cds.spec == nil {
+			d.limiter.add(cds.Ident())
+		}
+	}
 }
 
 // Delete a DS
@@ -73,8 +98,15 @@ func (d *dsCache) delete(ident serde.Ident) {
 	defer d.Unlock()
 	s := ident.String()
 	if cds := d.byIdent[s]; cds != nil {
-		d.rraCount -= len(cds.RRAs())
+		if cds.spec != nil {
+			d.rraCount -= len(cds.spec.RRAs)
+		} else if ds, ok := cds.DbDataSourcer.(rrd.DataSourcer); ok && ds != nil {
+			d.rraCount -= len(ds.RRAs())
+		}
 		delete(d.byIdent, s)
+		if cds.spec == nil {
+			d.limiter.remove(ident)
+		}
 	}
 }
 
@@ -110,20 +142,42 @@ func (d *dsCache) getByIdentOrCreateEmpty(ident *cachedIdent) *cachedDs {
 	return result
 }
 
-// load (or create) via the SerDe given an empty cachedDs with ident and spec
-func (d *dsCache) fetchOrCreateByIdent(cds *cachedDs) error {
-	ds, err := d.db.FetchOrCreateDataSource(cds.Ident(), cds.spec)
+// load (or create) via the SerDe given an empty cachedDs with ident
+// and spec. Only the creation of a DS is subject to the series
+// limits, one that exists (e.g. was dropped from the cache in a
+// cluster transition) is always loaded. If the creation is rejected,
+// the cachedDs is deleted from the cache and the first return value
+// is true.
+func (d *dsCache) fetchOrCreateByIdent(cds *cachedDs) (bool, error) {
+	ds, err := d.db.FetchOrCreateDataSource(cds.Ident(), nil)
 	if err != nil {
-		return err
+		return false, err
+	}
+	if ds == nil {
+		d.RLock()
+		allow := d.limiter.allow(cds.Ident())
+		d.RUnlock()
+		if !allow {
+			d.delete(cds.Ident())
+			return true, nil
+		}
+		if ds, err = d.db.FetchOrCreateDataSource(cds.Ident(), cds.spec); err != nil {
+			return false, err
+		}
 	}
 	dbds, ok := ds.(serde.DbDataSourcer)
 	if !ok {
-		return fmt.Errorf("fetchOrCreateByIdent: ds must be a serde.DbDataSourcer")
+		return false, fmt.Errorf("fetchOrCreateByIdent: ds must be a serde.DbDataSourcer")
 	}
+	d.Lock()
 	cds.DbDataSourcer = dbds
+	if cds.spec != nil && d.byIdent[cds.Ident().String()] == cds {
+		d.limiter.add(cds.Ident())
+	}
 	cds.spec = nil
+	d.Unlock()
 	d.register(dbds)
-	return nil
+	return false, nil
 }
 
 // register the rds as a DistDatum with the cluster
@@ -172,6 +226,19 @@ func (d *dsCache) Watch(ident serde.Ident, ch chan dsl.DataPoint) rrd.DataSource
 		return nil
 	}
 
+	// In a cluster only the nodes that receive the data points of
+	// this DS can watch it, for others they never arrive.
+	if d.clstr != nil && cds.Id() != 0 {
+		local := false
+		ln := d.clstr.LocalNode()
+		for _, node := range d.clstr.NodesForDistDatum(&distDs{DbDataSourcer: cds.DbDataSourcer, dsc: d}) {
+			local = local || node.Name() == ln.Name()
+		}
+		if !local {
+			return nil
+		}
+	}
+
 	if cds.watchCh == nil {
 		cds.watchCh = ch
 	}
@@ -201,9 +268,11 @@ type cachedDs struct {
 	incoming     sortableIncomingDPs
 	spec         *rrd.DSSpec // for when DS needs to be created
 	sentToLoader bool
+	replica      bool // a copy, the lead node persists it
 	lastProcess  time.Time
 	lastFlush    time.Time
 	watchCh      chan dsl.DataPoint
+	traced       []*incomingDP // sampled points processed but not yet in vcache
 	mu           *sync.Mutex
 }
 
@@ -238,6 +307,11 @@ func (cds *cachedDs) processIncoming() (int, int, error) {
 
 	blocked := 0 // watched ch blocked
 	for _, dp := range cds.incoming {
+		if dp.span != nil {
+			dp.traceStage("worker.process")
+			cds.traced = append(cds.traced, dp)
+		}
+
 		// continue on errors
 		err = cds.ProcessDataPoint(dp.value, dp.timeStamp)
 
@@ -320,6 +394,17 @@ func (ds *distDs) Relinquish() error {
 }
 
 func (ds *distDs) Acquire() error {
+	// A copy has processed the same data points as the previous lead
+	// and can take over as is, otherwise we need to (re)load the DS
+	// from the database.
+	if cds := ds.dsc.getByIdent(newCachedIdent(ds.Ident())); cds != nil {
+		cds.mu.Lock()
+		defer cds.mu.Unlock()
+		if cds.replica {
+			cds.replica = false
+			return nil
+		}
+	}
 	ds.dsc.delete(ds.Ident())
 	return nil
 }
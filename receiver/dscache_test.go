@@ -16,6 +16,7 @@ package receiver
 
 import (
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -169,7 +170,7 @@ func Test_dscache_fetchOrCreateByIdent(t *testing.T) {
 	d = newDsCache(db, df, dsf)
 	db.fakeErr = true
 	cds = d.getByIdentOrCreateEmpty(newCachedIdent(serde.Ident{"name": "foo"}))
-	if err := d.fetchOrCreateByIdent(cds); err == nil {
+	if _, err := d.fetchOrCreateByIdent(cds); err == nil {
 		t.Errorf("fetchOrCreateByIdent: db error should error")
 	}
 
@@ -180,7 +181,7 @@ func Test_dscache_fetchOrCreateByIdent(t *testing.T) {
 	db.returnDss = []rrd.DataSourcer{nds}
 	d = newDsCache(db, df, dsf)
 	cds = d.getByIdentOrCreateEmpty(newCachedIdent(serde.Ident{"name": "foo"}))
-	if err := d.fetchOrCreateByIdent(cds); err == nil {
+	if _, err := d.fetchOrCreateByIdent(cds); err == nil {
 		t.Errorf("fetchOrCreateByIdent: non-DbDataSource should error")
 	}
 }
@@ -231,6 +232,20 @@ func Test_dscache_cachedDs_Relinquish(t *testing.T) {
 		t.Errorf("Acquire: should not call flush")
 	}
 
+	// a copy is kept and becomes the lead
+	cds := &cachedDs{DbDataSourcer: ds, mu: &sync.Mutex{}, replica: true}
+	dsc.insert(cds)
+	if err = rds.Acquire(); err != nil {
+		t.Errorf("Acquire (replica): err != nil")
+	}
+	if dsc.getByIdent(newCachedIdent(foo)) != cds || cds.replica {
+		t.Errorf("Acquire: a replica should remain in the cache and no longer be a replica")
+	}
+	rds.Acquire()
+	if dsc.getByIdent(newCachedIdent(foo)) != nil {
+		t.Errorf("Acquire: a non-replica should be removed from the cache")
+	}
+
 	// receiverDs methods
 	if rds.Type() != "DataSource" {
 		t.Errorf(`rds.Type() != "DataSource"`)
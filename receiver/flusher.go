@@ -17,7 +17,6 @@ package receiver
 
 import (
 	"fmt"
-	"log"
 	"sync"
 	"time"
 
@@ -25,10 +24,11 @@ import (
 )
 
 type dsFlusher struct {
-	db     serde.Flusher
-	vcache *verticalCache
-	sr     statReporter
-	dbCh   chan *vDpFlushRequest
+	db        serde.Flusher
+	vcache    *verticalCache
+	sr        statReporter
+	dbCh      chan *vDpFlushRequest
+	persisted *persistedRRAs
 }
 
 // There are 3 types of flush requests:
@@ -50,6 +50,7 @@ func (f *dsFlusher) start(flusherWg, startWg *sync.WaitGroup, minStep time.Durat
 	// it means the db most definitely cannot keep up, and it's
 	// okay for whatever upstream to be blocked by it.
 	f.dbCh = make(chan *vDpFlushRequest, 10240)
+	f.persisted = newPersistedRRAs()
 	f.vcache = &verticalCache{
 		Mutex:   &sync.Mutex{},
 		dps:     make(map[bundleKey]*verticalCacheSegment),
@@ -57,24 +58,24 @@ func (f *dsFlusher) start(flusherWg, startWg *sync.WaitGroup, minStep time.Durat
 		minStep: minStep,
 	}
 
-	log.Printf(" -- vertical db flusher...")
+	logger.Infof(" -- vertical db flusher...")
 	for i := 0; i < n; i++ {
 		startWg.Add(1)
-		go dbFlusher(&wrkCtl{wg: flusherWg, startWg: startWg, id: fmt.Sprintf("vdbflusher_%d", i)}, f.db, f.dbCh, f.sr)
+		go dbFlusher(&wrkCtl{wg: flusherWg, startWg: startWg, id: fmt.Sprintf("vdbflusher_%d", i)}, f.db, f.dbCh, f.sr, f.persisted)
 	}
 	// TODO Consider making this nap time configurable?
 	go vcacheFlusher(f.vcache, f.dbCh, 100*time.Millisecond, f.sr)
 
 	if tdb, ok := f.db.(tsTableSizer); ok {
-		log.Printf(" -- ts table size reporter")
+		logger.Infof(" -- ts table size reporter")
 		go reportTsTableSize(tdb, f.sr)
 	}
 }
 
 func (f *dsFlusher) stop() {
-	log.Printf("flusher.stop(): performing full vcache flush...")
+	logger.Infof("flusher.stop(): performing full vcache flush...")
 	f.vcache.flush(f.dbCh, true)
-	log.Printf("flusher.stop(): performing full vcache flush done.")
+	logger.Infof("flusher.stop(): performing full vcache flush done.")
 
 	if f.db != nil {
 		close(f.dbCh)
@@ -86,14 +87,14 @@ func (f *dsFlusher) verticalFlush(ds serde.DbDataSourcer) {
 	if _ds, ok := ds.(*serde.DbDataSource); ok {
 		f.vcache.updateDss(_ds)
 	} else {
-		log.Printf("verticalFlush: ERROR: ds not a *serde.DbDataSource!")
+		logger.Errorf("verticalFlush: ds not a *serde.DbDataSource!")
 	}
 
 	for _, rra := range ds.RRAs() {
 		if _rra, ok := rra.(*serde.DbRoundRobinArchive); ok {
 			f.vcache.updateDps(_rra)
 		} else {
-			log.Printf("verticalFlush: ERROR: rra not a *serde.DbRoundRobinArchive!")
+			logger.Errorf("verticalFlush: rra not a *serde.DbRoundRobinArchive!")
 		}
 	}
 }
@@ -108,22 +109,39 @@ func (f *dsFlusher) flushToVCache(ds serde.DbDataSourcer) {
 	return
 }
 
+// vcachedDps returns the points of the RRA that are in the vcache
+// and have not been written to the db yet.
+func (f *dsFlusher) vcachedDps(rra serde.DbRoundRobinArchiver) map[int64]float64 {
+	if f.vcache == nil {
+		return nil
+	}
+	return f.vcache.rraDps(rra)
+}
+
 func (f *dsFlusher) statReporter() statReporter {
 	return f.sr
 }
 
+// persistedRRAs returns the persisted RRA latests, nil if the flusher
+// has not been started.
+func (f *dsFlusher) persistedRRAs() *persistedRRAs {
+	return f.persisted
+}
+
 type dsFlusherBlocking interface {
 	flushToVCache(serde.DbDataSourcer)
+	vcachedDps(serde.DbRoundRobinArchiver) map[int64]float64
 	statReporter() statReporter
+	persistedRRAs() *persistedRRAs
 	start(flusherWg, startWg *sync.WaitGroup, minStep time.Duration, n int)
 	stop()
 }
 
-var dbFlusher = func(wc wController, db serde.Flusher, ch chan *vDpFlushRequest, sr statReporter) {
+var dbFlusher = func(wc wController, db serde.Flusher, ch chan *vDpFlushRequest, sr statReporter, pst *persistedRRAs) {
 	wc.onEnter()
 	defer wc.onExit()
 
-	log.Printf("  - %s started.", wc.ident())
+	logger.Infof("  - %s started.", wc.ident())
 	wc.onStarted()
 
 	type stats struct {
@@ -136,11 +154,12 @@ var dbFlusher = func(wc wController, db serde.Flusher, ch chan *vDpFlushRequest,
 	}
 
 	st := &stats{start: time.Now()}
+	hb, _ := sr.(heartbeater)
 
 	for {
 		dpr, ok := <-ch
 		if !ok {
-			log.Printf("%s: exiting", wc.ident())
+			logger.Infof("%s: exiting", wc.ident())
 			return
 		}
 
@@ -151,12 +170,19 @@ var dbFlusher = func(wc wController, db serde.Flusher, ch chan *vDpFlushRequest,
 
 		if len(dpr.lastupdate) > 0 {
 			// DS state Flush
+			sp := traceFlush("serde.flush_ds_state", dpr, len(dpr.lastupdate))
 			start := time.Now()
 			sqlOps, err := db.FlushDSStates(dpr.seg, dpr.lastupdate, dpr.value, dpr.duration)
+			traceFlushEnd(sp, sqlOps, err)
 			if err != nil {
-				log.Printf("vdbflusher: ERROR in VerticalFlushDSs: %v", err)
+				logger.Errorf("vdbflusher: error in VerticalFlushDSs: %v", err)
+			} else if hb != nil {
+				hb.flushBeat()
 			}
-			st.dsDur += time.Now().Sub(start)
+			dur := time.Now().Sub(start)
+			sr.reportStatHistogram("serde.flush_ds_state.duration_seconds", dur.Seconds())
+			sr.reportStatHistogram("serde.flush_ds_state.sql_ops", float64(sqlOps))
+			st.dsDur += dur
 			st.dsCount += len(dpr.lastupdate)
 			st.dsSqlOps += sqlOps
 			st.dsFlushes++
@@ -164,24 +190,47 @@ var dbFlusher = func(wc wController, db serde.Flusher, ch chan *vDpFlushRequest,
 		} else if len(dpr.dps) > 0 {
 			// Datapoints flush
 			idps, vers := dataPointsWithVersions(dpr.dps, dpr.i, dpr.ivers)
+			sp := traceFlush("serde.flush_dps", dpr, len(dpr.dps))
 			start := time.Now()
 			sqlOps, err := db.FlushDataPoints(dpr.bundleId, dpr.seg, dpr.i, idps, vers)
+			traceFlushEnd(sp, sqlOps, err)
 			if err != nil {
-				log.Printf("vdbflusher: ERROR in VerticalFlushDps: %v", err)
+				logger.Errorf("vdbflusher: error in VerticalFlushDps: %v", err)
+			} else if hb != nil {
+				hb.flushBeat()
 			}
-			st.dpsDur += time.Now().Sub(start)
+			dur := time.Now().Sub(start)
+			sr.reportStatHistogram("serde.flush_dps.duration_seconds", dur.Seconds())
+			sr.reportStatHistogram("serde.flush_dps.sql_ops", float64(sqlOps))
+			st.dpsDur += dur
 			st.dpsCount += len(dpr.dps)
 			st.dpsSqlOps += sqlOps
 			st.dpsFlushes++
 
 		} else if (len(dpr.latests) + len(dpr.value) + len(dpr.duration)) > 0 {
 			// RRA State flush
+			sp := traceFlush("serde.flush_rra_state", dpr, len(dpr.latests))
 			start := time.Now()
 			sqlOps, err := db.FlushRRAStates(dpr.bundleId, dpr.seg, dpr.latests, dpr.value, dpr.duration)
+			traceFlushEnd(sp, sqlOps, err)
 			if err != nil {
-				log.Printf("verticalCache: ERROR in VerticalFlushRRAs: %v", err)
+				logger.Errorf("verticalCache: error in VerticalFlushRRAs: %v", err)
+			} else {
+				if hb != nil {
+					hb.flushBeat()
+				}
+				if pst != nil && len(dpr.latests) > 0 {
+					latests := make(map[int64]time.Time, len(dpr.latests))
+					for idx, l := range dpr.latests {
+						latests[idx] = l.(time.Time)
+					}
+					pst.add(dpr.bundleId, dpr.seg, latests, true)
+				}
 			}
-			st.rraDur += time.Now().Sub(start)
+			dur := time.Now().Sub(start)
+			sr.reportStatHistogram("serde.flush_rra_state.duration_seconds", dur.Seconds())
+			sr.reportStatHistogram("serde.flush_rra_state.sql_ops", float64(sqlOps))
+			st.rraDur += dur
 			st.rraCount += len(dpr.latests)
 			st.rraSqlOps += sqlOps
 			st.rraFlushes++
@@ -227,6 +276,11 @@ var vcacheFlusher = func(vcache *verticalCache, dbCh chan *vDpFlushRequest, nap
 		// NB: All this does is create flush requests, no DB I/O happens here.
 		st := vcache.flush(dbCh, false)
 
+		// Nothing to flush counts as a successful flush
+		if hb, ok := sr.(heartbeater); ok && st.dpPoints == 0 && len(dbCh) == 0 {
+			hb.flushBeat()
+		}
+
 		sr.reportStatGauge("receiver.vcache.segments", float64(st.dpSegments))
 		sr.reportStatGauge("receiver.vcache.segment_rows", float64(st.dpRows))
 		sr.reportStatGauge("receiver.vcache.points", float64(st.dpPoints))
@@ -30,12 +30,14 @@ type fakeDsFlusher struct {
 	sr     statReporter
 }
 
-func (f *fakeDsFlusher) flushDS(ds serde.DbDataSourcer, block bool)         { f.called++ }
-func (f *fakeDsFlusher) flushToVCache(serde.DbDataSourcer)                  {}
-func (f *fakeDsFlusher) flusher() serde.Flusher                             { return f }
-func (f *fakeDsFlusher) statReporter() statReporter                         { return f.sr }
-func (f *fakeDsFlusher) start(_, _ *sync.WaitGroup, _ time.Duration, n int) {}
-func (f *fakeDsFlusher) stop()                                              {}
+func (f *fakeDsFlusher) flushDS(ds serde.DbDataSourcer, block bool)              { f.called++ }
+func (f *fakeDsFlusher) flushToVCache(serde.DbDataSourcer)                       {}
+func (f *fakeDsFlusher) vcachedDps(serde.DbRoundRobinArchiver) map[int64]float64 { return nil }
+func (f *fakeDsFlusher) flusher() serde.Flusher                                  { return f }
+func (f *fakeDsFlusher) statReporter() statReporter                              { return f.sr }
+func (f *fakeDsFlusher) persistedRRAs() *persistedRRAs                           { return nil }
+func (f *fakeDsFlusher) start(_, _ *sync.WaitGroup, _ time.Duration, n int)      {}
+func (f *fakeDsFlusher) stop()                                                   {}
 func (f *fakeDsFlusher) FlushDataPoints(bunlde_id, seg, i int64, dps, vers map[int64]interface{}) (int, error) {
 	return 0, nil
 }
@@ -64,6 +66,10 @@ func (f *fakeSr) reportStatGauge(string, float64) {
 	f.called++
 }
 
+func (f *fakeSr) reportStatHistogram(string, float64) {
+	f.called++
+}
+
 func Test_flusher_methods(t *testing.T) {
 	db := &fakeSerde{}
 	sr := &fakeSr{}
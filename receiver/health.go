@@ -0,0 +1,186 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package receiver
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// The director beats every directorBeatInterval, if it has not
+	// for this long, it is considered wedged. It does not beat
+	// during a cluster transition, which is not held against it,
+	// but this should be longer than directorTransitionTimeout
+	// regardless.
+	HealthMaxDirectorAge = 30 * time.Second
+	// If there is data waiting to be flushed and nothing was
+	// written to the database for this long, the receiver is not
+	// ready. This is usually the database being down, which a
+	// restart would not fix, thus it does not affect liveness.
+	HealthMaxFlushAge = 5 * time.Minute
+	// How long to wait for the database to respond.
+	HealthDbTimeout = 2 * time.Second
+)
+
+const directorBeatInterval = time.Second
+
+// receiverHealth is updated by the workers, all atomic.
+type receiverHealth struct {
+	started      int32
+	directorBeat int64 // unix nanos
+	flushBeat    int64 // unix nanos, last db write or when nothing to flush
+}
+
+// heartbeater is implemented by the Receiver, the director and
+// flushers use it (if their statReporter is one) to signal that they
+// are making progress.
+type heartbeater interface {
+	directorBeat()
+	flushBeat()
+}
+
+func (r *Receiver) directorBeat() {
+	atomic.StoreInt64(&r.health.directorBeat, time.Now().UnixNano())
+}
+
+func (r *Receiver) flushBeat() {
+	atomic.StoreInt64(&r.health.flushBeat, time.Now().UnixNano())
+}
+
+// A cluster which can tell whether a transition is in progress.
+type transitioner interface {
+	Transitioning() bool
+}
+
+type dbPinger interface {
+	Ping(timeout time.Duration) error
+}
+
+// Health is the status of the receiver, see Receiver.Health().
+type Health struct {
+	Live     bool     `json:"live"`
+	Ready    bool     `json:"ready"`
+	Problems []string `json:"problems,omitempty"`
+
+	Started          bool    `json:"started"`
+	Stopping         bool    `json:"stopping"`
+	DirectorRunning  bool    `json:"director_running"`
+	DirectorAge      float64 `json:"director_age_seconds"`
+	Clustered        bool    `json:"clustered"`
+	ClusterReady     bool    `json:"cluster_ready"`
+	Transitioning    bool    `json:"transitioning"`
+	DbReachable      *bool   `json:"db_reachable,omitempty"` // nil if not checked
+	DbError          string  `json:"db_error,omitempty"`
+	QueueLen         int     `json:"queue_len"`
+	MaxQueueLen      int     `json:"max_queue_len"`
+	LastFlushAge     float64 `json:"last_flush_age_seconds"`
+	FlushersProgress bool    `json:"flushers_progressing"`
+}
+
+func age(now time.Time, nanos int64) time.Duration {
+	if nanos == 0 {
+		return 0
+	}
+	return now.Sub(time.Unix(0, nanos))
+}
+
+// Health checks the receiver. It is live unless started and the
+// director is wedged. It is ready when live, started and not
+// stopping, the flushers are making progress, the cluster node (if
+// clustered) is ready and not in a transition, the database is
+// reachable and the queue is not over MaxReceiverQueueSize.
+func (r *Receiver) Health() *Health {
+	return r.checkHealth(true)
+}
+
+// LiveHealth is Health without the database check, which is not
+// needed for liveness, thus Ready is not meaningful.
+func (r *Receiver) LiveHealth() *Health {
+	return r.checkHealth(false)
+}
+
+func (r *Receiver) checkHealth(pingDb bool) *Health {
+	h := &Health{
+		Started:     atomic.LoadInt32(&r.health.started) == 1,
+		Stopping:    r.stopped(),
+		MaxQueueLen: r.MaxReceiverQueueSize,
+	}
+	now := time.Now()
+
+	problem := func(format string, args ...interface{}) {
+		h.Problems = append(h.Problems, fmt.Sprintf(format, args...))
+	}
+
+	if h.Started {
+		dAge := age(now, atomic.LoadInt64(&r.health.directorBeat))
+		h.DirectorAge = dAge.Seconds()
+		// The director does not beat while it is busy with a
+		// transition, see directorTransitionTimeout.
+		t, ok := r.cluster.(transitioner)
+		h.DirectorRunning = dAge < HealthMaxDirectorAge || (ok && t.Transitioning())
+		if !h.DirectorRunning {
+			problem("director has not run for %v", dAge)
+		}
+
+		fAge := age(now, atomic.LoadInt64(&r.health.flushBeat))
+		h.LastFlushAge = fAge.Seconds()
+		h.FlushersProgress = fAge < HealthMaxFlushAge
+		if !h.FlushersProgress {
+			problem("nothing flushed to the database for %v", fAge)
+		}
+	} else {
+		problem("receiver not started")
+	}
+	h.Live = !h.Started || h.DirectorRunning
+
+	if h.Stopping {
+		problem("receiver stopping")
+	}
+
+	if c := r.Cluster(); c != nil {
+		h.Clustered = true
+		if ln := c.LocalNode(); ln != nil {
+			h.ClusterReady = ln.Ready()
+		}
+		if !h.ClusterReady {
+			problem("cluster node not ready")
+		}
+		if h.Transitioning = c.Transitioning(); h.Transitioning {
+			problem("cluster transition in progress")
+		}
+	}
+
+	if p, ok := r.serde.(dbPinger); ok && pingDb {
+		reachable := true
+		if err := p.Ping(HealthDbTimeout); err != nil {
+			reachable, h.DbError = false, err.Error()
+			problem("database unreachable: %v", err)
+		}
+		h.DbReachable = &reachable
+	}
+
+	if r.queue != nil {
+		h.QueueLen = r.queue.size()
+	}
+	if h.MaxQueueLen > 0 && h.QueueLen > h.MaxQueueLen {
+		problem("queue length %d over %d", h.QueueLen, h.MaxQueueLen)
+	}
+
+	h.Ready = h.Live && h.Started && pingDb && len(h.Problems) == 0
+	return h
+}
@@ -0,0 +1,91 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package receiver
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakePingSerde struct {
+	fakeSerde
+	err error
+}
+
+func (f *fakePingSerde) Ping(time.Duration) error { return f.err }
+
+func Test_Receiver_Health(t *testing.T) {
+	db := &fakePingSerde{}
+	r := &Receiver{serde: db, queue: &fifoQueue{}}
+
+	h := r.Health()
+	if !h.Live || h.Ready {
+		t.Errorf("not started: expected live and not ready: %+v", h)
+	}
+
+	atomic.StoreInt32(&r.health.started, 1)
+	r.directorBeat()
+	r.flushBeat()
+	h = r.Health()
+	if !h.Live || !h.Ready || len(h.Problems) > 0 {
+		t.Errorf("started: expected live and ready: %+v", h)
+	}
+
+	db.err = fmt.Errorf("connection refused")
+	h = r.Health()
+	if !h.Live || h.Ready || h.DbReachable == nil || *h.DbReachable || h.DbError == "" {
+		t.Errorf("db down: expected live and not ready: %+v", h)
+	}
+	if h = r.LiveHealth(); !h.Live || h.DbReachable != nil || h.DbError != "" {
+		t.Errorf("db down: LiveHealth should not check the db: %+v", h)
+	}
+	db.err = nil
+
+	r.MaxReceiverQueueSize = 1
+	r.queue.push(1)
+	r.queue.push(2)
+	h = r.Health()
+	if !h.Live || h.Ready || h.QueueLen != 2 {
+		t.Errorf("queue over max: expected live and not ready: %+v", h)
+	}
+	r.MaxReceiverQueueSize = 0
+
+	atomic.StoreInt64(&r.health.directorBeat, time.Now().Add(-HealthMaxDirectorAge-time.Second).UnixNano())
+	h = r.Health()
+	if h.Live || h.Ready || h.DirectorRunning {
+		t.Errorf("director wedged: expected not live: %+v", h)
+	}
+	r.cluster = &fakeCluster{inTrans: true}
+	if h = r.LiveHealth(); !h.Live || !h.DirectorRunning {
+		t.Errorf("director in a transition: expected live: %+v", h)
+	}
+	r.cluster = nil
+	r.directorBeat()
+
+	atomic.StoreInt64(&r.health.flushBeat, time.Now().Add(-HealthMaxFlushAge-time.Second).UnixNano())
+	h = r.Health()
+	if !h.Live || h.Ready || h.FlushersProgress {
+		t.Errorf("flushers stalled: expected live and not ready: %+v", h)
+	}
+	r.flushBeat()
+
+	atomic.StoreInt32(&r.stop, 1)
+	if h = r.Health(); !h.Live || h.Ready {
+		t.Errorf("stopping: expected live and not ready: %+v", h)
+	}
+}
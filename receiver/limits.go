@@ -0,0 +1,228 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package receiver
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tgres/tgres/serde"
+)
+
+// SeriesLimits guard against runaway series creation, e.g. by a
+// client which puts request ids in metric names. Data points (and
+// backfills) which would create a series beyond a limit are rejected
+// (and counted as such), existing series are not affected.
+//
+// Series are counted as of the DS cache of this node, which in a
+// non-clustered set up (and in a cluster at start) contains all
+// series. A zero limit means unlimited.
+type SeriesLimits struct {
+	MaxSeries          int // total number of series
+	MaxSeriesPerPrefix int // number of series per name prefix
+	MaxNewPerMinute    int // rate at which new series can be created
+	PrefixDepth        int // dot-separated name components in a prefix (default 2)
+}
+
+// DefaultPrefixDepth is the PrefixDepth used when it is zero.
+const DefaultPrefixDepth = 2
+
+// Rejected series are tracked for at most this many prefixes.
+const maxRejectedPrefixes = 10000
+
+// seriesPrefix returns the first depth dot-separated components of
+// the name tag.
+func seriesPrefix(ident serde.Ident, depth int) string {
+	name := ident["name"]
+	for i := 0; i < len(name); i++ {
+		if name[i] == '.' {
+			if depth--; depth == 0 {
+				return name[:i]
+			}
+		}
+	}
+	return name
+}
+
+// seriesLimiter keeps series counts by prefix and enforces the
+// SeriesLimits. A nil seriesLimiter allows everything.
+type seriesLimiter struct {
+	mu       sync.Mutex
+	limits   SeriesLimits
+	total    int
+	prefixes map[string]int
+	rejected map[string]int // by prefix
+	reasons  map[string]int // rejections by reason
+	tokens   float64        // new series rate token bucket
+	last     time.Time      // last token refill
+	lastWarn time.Time
+}
+
+func newSeriesLimiter(limits SeriesLimits) *seriesLimiter {
+	if limits.PrefixDepth <= 0 {
+		limits.PrefixDepth = DefaultPrefixDepth
+	}
+	return &seriesLimiter{
+		limits:   limits,
+		prefixes: make(map[string]int),
+		rejected: make(map[string]int),
+		reasons:  make(map[string]int),
+		tokens:   float64(limits.MaxNewPerMinute),
+		last:     time.Now(),
+	}
+}
+
+// add counts a series.
+func (l *seriesLimiter) add(ident serde.Ident) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total++
+	l.prefixes[seriesPrefix(ident, l.limits.PrefixDepth)]++
+}
+
+// remove uncounts a series.
+func (l *seriesLimiter) remove(ident serde.Ident) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total--
+	prefix := seriesPrefix(ident, l.limits.PrefixDepth)
+	if l.prefixes[prefix]--; l.prefixes[prefix] <= 0 {
+		delete(l.prefixes, prefix)
+	}
+}
+
+// allow returns true if a new series with this ident may be
+// created. It does not count it, add() does.
+func (l *seriesLimiter) allow(ident serde.Ident) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	prefix := seriesPrefix(ident, l.limits.PrefixDepth)
+
+	var reason string
+	if l.limits.MaxSeries > 0 && l.total >= l.limits.MaxSeries {
+		reason = "max_series"
+	} else if l.limits.MaxSeriesPerPrefix > 0 && l.prefixes[prefix] >= l.limits.MaxSeriesPerPrefix {
+		reason = "max_series_per_prefix"
+	} else if l.limits.MaxNewPerMinute > 0 {
+		now := time.Now()
+		rate := float64(l.limits.MaxNewPerMinute)
+		l.tokens += now.Sub(l.last).Minutes() * rate
+		if l.tokens > rate {
+			l.tokens = rate
+		}
+		l.last = now
+		if l.tokens < 1 {
+			reason = "max_new_per_minute"
+		} else {
+			l.tokens--
+		}
+	}
+	if reason == "" {
+		return true
+	}
+
+	l.reasons[reason]++
+	if _, ok := l.rejected[prefix]; ok || len(l.rejected) < maxRejectedPrefixes {
+		l.rejected[prefix]++
+	}
+	if l.lastWarn.Before(time.Now().Add(-time.Minute)) {
+		logger.Warnf("Series limit %s reached, rejecting new series such as %v (this message is logged at most once a minute).", reason, ident)
+		l.lastWarn = time.Now()
+	}
+	return false
+}
+
+// PrefixCardinality is the number of series with a name prefix.
+type PrefixCardinality struct {
+	Prefix   string `json:"prefix"`
+	Series   int    `json:"series"`
+	Rejected int    `json:"rejected"` // new series rejected since start
+}
+
+// CardinalityReport shows the number of series and the prefixes with
+// the most series (or rejections).
+type CardinalityReport struct {
+	Limits   SeriesLimits         `json:"limits"`
+	Series   int                  `json:"series"`
+	Rejected map[string]int       `json:"rejected"` // by reason, since start
+	Top      []*PrefixCardinality `json:"top"`
+}
+
+func (l *seriesLimiter) report(top int) *CardinalityReport {
+	if l == nil {
+		return &CardinalityReport{Rejected: map[string]int{}, Top: []*PrefixCardinality{}}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	result := &CardinalityReport{
+		Limits:   l.limits,
+		Series:   l.total,
+		Rejected: make(map[string]int, len(l.reasons)),
+	}
+	for reason, n := range l.reasons {
+		result.Rejected[reason] = n
+	}
+
+	byPrefix := make(map[string]*PrefixCardinality, len(l.prefixes))
+	for prefix, n := range l.prefixes {
+		byPrefix[prefix] = &PrefixCardinality{Prefix: prefix, Series: n}
+	}
+	for prefix, n := range l.rejected {
+		if pc := byPrefix[prefix]; pc != nil {
+			pc.Rejected = n
+		} else {
+			byPrefix[prefix] = &PrefixCardinality{Prefix: prefix, Rejected: n}
+		}
+	}
+	all := make([]*PrefixCardinality, 0, len(byPrefix))
+	for _, pc := range byPrefix {
+		all = append(all, pc)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		a, b := all[i], all[j]
+		if a.Series+a.Rejected != b.Series+b.Rejected {
+			return a.Series+a.Rejected > b.Series+b.Rejected
+		}
+		return strings.Compare(a.Prefix, b.Prefix) < 0
+	})
+	if top > 0 && len(all) > top {
+		all = all[:top]
+	}
+	result.Top = all
+	return result
+}
+
+// Cardinality returns the CardinalityReport with top prefixes, all if
+// top is 0.
+func (r *Receiver) Cardinality(top int) *CardinalityReport {
+	r.dsc.RLock()
+	l := r.dsc.limiter
+	r.dsc.RUnlock()
+	return l.report(top)
+}
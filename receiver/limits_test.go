@@ -0,0 +1,206 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package receiver
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tgres/tgres/serde"
+)
+
+func Test_seriesPrefix(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		depth  int
+		prefix string
+	}{
+		{"a.b.c", 1, "a"},
+		{"a.b.c", 2, "a.b"},
+		{"a.b.c", 3, "a.b.c"},
+		{"a.b.c", 5, "a.b.c"},
+		{"abc", 2, "abc"},
+	} {
+		if p := seriesPrefix(serde.Ident{"name": c.name}, c.depth); p != c.prefix {
+			t.Errorf("seriesPrefix(%q, %d): expected %q, got %q", c.name, c.depth, c.prefix, p)
+		}
+	}
+}
+
+func Test_seriesLimiter(t *testing.T) {
+	var nl *seriesLimiter
+	if !nl.allow(serde.Ident{"name": "foo"}) {
+		t.Errorf("nil limiter should allow")
+	}
+
+	l := newSeriesLimiter(SeriesLimits{MaxSeries: 5, MaxSeriesPerPrefix: 3})
+	for i := 0; i < 4; i++ {
+		ident := serde.Ident{"name": fmt.Sprintf("app.req.%d", i)}
+		if ok := l.allow(ident); ok != (i < 3) {
+			t.Errorf("allow %d: %v", i, ok)
+		} else if ok {
+			l.add(ident)
+		}
+	}
+	for _, name := range []string{"a.x", "b.x", "c.x"} {
+		if l.allow(serde.Ident{"name": name}) {
+			l.add(serde.Ident{"name": name})
+		}
+	}
+	rep := l.report(0)
+	if rep.Series != 5 || rep.Rejected["max_series_per_prefix"] != 1 || rep.Rejected["max_series"] != 1 {
+		t.Errorf("report: %+v", rep)
+	}
+	if len(rep.Top) != 4 || rep.Top[0].Prefix != "app.req" || rep.Top[0].Series != 3 || rep.Top[0].Rejected != 1 {
+		t.Errorf("report top: %+v", rep.Top[0])
+	}
+	if rep := l.report(1); len(rep.Top) != 1 {
+		t.Errorf("report(1): expected 1 prefix, got %d", len(rep.Top))
+	}
+
+	l.remove(serde.Ident{"name": "app.req.0"})
+	if !l.allow(serde.Ident{"name": "app.req.9"}) {
+		t.Errorf("allow after remove should be true")
+	}
+
+	l = newSeriesLimiter(SeriesLimits{MaxNewPerMinute: 2})
+	n := 0
+	for i := 0; i < 5; i++ {
+		if l.allow(serde.Ident{"name": fmt.Sprintf("foo.%d", i)}) {
+			n++
+		}
+	}
+	if n != 2 {
+		t.Errorf("MaxNewPerMinute: expected 2 allowed, got %d", n)
+	}
+}
+
+func Test_dsCache_limits(t *testing.T) {
+	d := newDsCache(serde.NewMemSerDe(), &SimpleDSFinder{DftDSSPec}, nil)
+	d.setLimits(SeriesLimits{MaxSeries: 1})
+
+	load := func(name string) (*cachedDs, bool) {
+		cds := d.getByIdentOrCreateEmpty(newCachedIdent(serde.Ident{"name": name}))
+		if cds == nil {
+			t.Fatalf("getByIdentOrCreateEmpty: nil for %q", name)
+		}
+		if cds.spec == nil {
+			return cds, false
+		}
+		rejected, err := d.fetchOrCreateByIdent(cds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cds, rejected
+	}
+
+	cds, rejected := load("foo")
+	if rejected {
+		t.Fatalf("first series should be allowed")
+	}
+	if _, rejected := load("bar"); !rejected {
+		t.Errorf("second series should be rejected")
+	}
+	if d.getByIdent(newCachedIdent(serde.Ident{"name": "bar"})) != nil {
+		t.Errorf("rejected series should not be cached")
+	}
+	if cds2, rejected := load("foo"); cds2 != cds || rejected {
+		t.Errorf("existing series should not be rejected")
+	}
+	d.delete(serde.Ident{"name": "foo"})
+	if _, rejected := load("bar"); rejected {
+		t.Errorf("series should be allowed after delete")
+	}
+}
+
+func Test_dsCache_limits_relinquish(t *testing.T) {
+	d := newDsCache(serde.NewMemSerDe(), &SimpleDSFinder{DftDSSPec}, nil)
+	d.setLimits(SeriesLimits{MaxSeries: 1, MaxNewPerMinute: 1})
+
+	// Process a point the way the director and the loader do
+	process := func(ident serde.Ident) (*cachedDs, bool) {
+		loaderCh := make(chan interface{}, 1)
+		dp := &incomingDP{cachedIdent: newCachedIdent(ident), timeStamp: time.Unix(1000, 0), value: 1}
+		directorProcessIncomingDP(dp, d, loaderCh, nil, nil, nil, &dpStats{})
+		cds := (<-loaderCh).(*cachedDs)
+		rejected, err := d.fetchOrCreateByIdent(cds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cds, rejected
+	}
+
+	foo := serde.Ident{"name": "foo"}
+	cds, rejected := process(foo)
+	if rejected {
+		t.Fatalf("first series should be allowed")
+	}
+
+	// Both limits are reached now, a cluster transition drops foo
+	// from the cache.
+	dd := &distDs{DbDataSourcer: cds.DbDataSourcer, dsc: d}
+	if err := dd.Relinquish(); err != nil {
+		t.Fatal(err)
+	}
+	if d.getByIdent(newCachedIdent(foo)) != nil {
+		t.Fatalf("Relinquish: foo should be dropped from the cache")
+	}
+
+	// It exists though, so it is loaded rather than created.
+	if cds, rejected := process(foo); rejected || cds.Id() != dd.Id() {
+		t.Errorf("a relinquished series should not be rejected (rejected: %v)", rejected)
+	}
+	if _, rejected := process(serde.Ident{"name": "bar"}); !rejected {
+		t.Errorf("a new series should still be rejected")
+	}
+}
+
+type memBackfillSerde struct {
+	fakeSerde
+	mem serde.Fetcher
+}
+
+func (m *memBackfillSerde) Fetcher() serde.Fetcher { return m.mem }
+
+func Test_Receiver_Backfill_limits(t *testing.T) {
+	mem := serde.NewMemSerDe()
+	db := &memBackfillSerde{mem: mem}
+	r := &Receiver{serde: db, dsc: newDsCache(mem, &SimpleDSFinder{DftDSSPec}, nil), dpChIn: make(chan interface{}, 10)}
+	r.dsc.setLimits(SeriesLimits{MaxSeries: 1})
+
+	points := func() []BackfillPoint { return []BackfillPoint{{time.Unix(1000, 0), 1}} }
+	res, err := r.Backfill([]*BackfillSeries{{Ident: serde.Ident{"name": "foo"}, Points: points()}})
+	if err != nil || len(res.Errors) != 0 || res.Queued != 1 {
+		t.Fatalf("first series should be created: %+v %v", res, err)
+	}
+	if r.dsc.getByIdent(newCachedIdent(serde.Ident{"name": "foo"})) == nil {
+		t.Errorf("created series should be in the cache")
+	}
+
+	res, _ = r.Backfill([]*BackfillSeries{{Ident: serde.Ident{"name": "bar"}, Points: points()}})
+	if len(res.Errors) != 1 || res.Queued != 0 {
+		t.Errorf("second series should be rejected: %+v", res)
+	}
+	if ds, _ := mem.FetchOrCreateDataSource(serde.Ident{"name": "bar"}, nil); ds != nil {
+		t.Errorf("rejected series should not be created")
+	}
+
+	res, _ = r.Backfill([]*BackfillSeries{{Ident: serde.Ident{"name": "foo"}, Points: points()}})
+	if len(res.Errors) != 0 {
+		t.Errorf("existing series should not be rejected: %+v", res)
+	}
+}
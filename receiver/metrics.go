@@ -0,0 +1,220 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package receiver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metrics keeps the internal stats (everything reported with
+// reportStatCount, reportStatGauge and reportStatHistogram) so that
+// they can be scraped by Prometheus, see WritePrometheus(). Unlike the
+// stats fed back into Tgres as series, this does not depend on the
+// receiver being healthy. Counts accumulate, gauges keep the last
+// value. A nil *Metrics is valid and does nothing.
+type Metrics struct {
+	mu         sync.Mutex
+	counters   map[string]float64
+	gauges     map[string]float64
+	histograms map[string]*histogram
+}
+
+// Metric names are prefixed with this.
+const metricsNamespace = "tgres"
+
+var (
+	// Buckets for durations in seconds, i.e. flush latency.
+	latencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	// Buckets for counts, i.e. SQL ops per flush.
+	countBuckets = []float64{1, 2, 4, 8, 16, 32, 64, 128, 256}
+)
+
+type histogram struct {
+	buckets []float64 // upper bounds
+	counts  []uint64  // not cumulative, +Inf is the last one
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v) // first bucket >= v
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		counters:   make(map[string]float64),
+		gauges:     make(map[string]float64),
+		histograms: make(map[string]*histogram),
+	}
+}
+
+func (m *Metrics) count(name string, v float64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.counters[name] += v
+	m.mu.Unlock()
+}
+
+func (m *Metrics) gauge(name string, v float64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.gauges[name] = v
+	m.mu.Unlock()
+}
+
+// observe adds v to a histogram. Names ending with "sql_ops" use
+// countBuckets, all others are durations in seconds.
+func (m *Metrics) observe(name string, v float64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	h := m.histograms[name]
+	if h == nil {
+		buckets := latencyBuckets
+		if strings.HasSuffix(name, "sql_ops") {
+			buckets = countBuckets
+		}
+		h = newHistogram(buckets)
+		m.histograms[name] = h
+	}
+	h.observe(v)
+	m.mu.Unlock()
+}
+
+// Stats whose names end with a variable part, which is exported to
+// Prometheus as a label rather than as part of the metric name, e.g.
+// "receiver.forwarded_to.10_0_0_1:1234" becomes
+// tgres_receiver_forwarded_to_total{node="10_0_0_1:1234"}.
+var labeledStats = []struct{ prefix, label string }{
+	{"receiver.forwarded_to.", "node"},
+}
+
+// promNameLabels is promName, but also returns the labels (including
+// the braces) for labeledStats, or "".
+func promNameLabels(name string) (string, string) {
+	for _, ls := range labeledStats {
+		if strings.HasPrefix(name, ls.prefix) && len(name) > len(ls.prefix) {
+			value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(name[len(ls.prefix):])
+			return promName(strings.TrimSuffix(ls.prefix, ".")), fmt.Sprintf(`{%s="%s"}`, ls.label, value)
+		}
+	}
+	return promName(name), ""
+}
+
+// promName converts a Tgres stat name (e.g. "serde.flush_dps.count")
+// to a Prometheus metric name (e.g. "tgres_serde_flush_dps_count").
+func promName(name string) string {
+	b := []byte(metricsNamespace + "_" + name)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+func promFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WritePrometheus writes all metrics in the Prometheus text
+// exposition format. Counters get the conventional _total suffix.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+
+	typed := make(map[string]bool) // one TYPE line per labeled metric
+	for _, name := range sortedKeys(m.counters) {
+		pn, labels := promNameLabels(name)
+		if !strings.HasSuffix(pn, "_total") {
+			pn += "_total"
+		}
+		if !typed[pn] {
+			fmt.Fprintf(bw, "# TYPE %s counter\n", pn)
+			typed[pn] = true
+		}
+		fmt.Fprintf(bw, "%s%s %s\n", pn, labels, promFloat(m.counters[name]))
+	}
+
+	for _, name := range sortedKeys(m.gauges) {
+		pn, labels := promNameLabels(name)
+		if !typed[pn] {
+			fmt.Fprintf(bw, "# TYPE %s gauge\n", pn)
+			typed[pn] = true
+		}
+		fmt.Fprintf(bw, "%s%s %s\n", pn, labels, promFloat(m.gauges[name]))
+	}
+
+	names := make([]string, 0, len(m.histograms))
+	for name := range m.histograms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		h, pn := m.histograms[name], promName(name)
+		fmt.Fprintf(bw, "# TYPE %s histogram\n", pn)
+		var cum uint64
+		for i, le := range h.buckets {
+			cum += h.counts[i]
+			fmt.Fprintf(bw, "%s_bucket{le=\"%s\"} %d\n", pn, promFloat(le), cum)
+		}
+		fmt.Fprintf(bw, "%s_bucket{le=\"+Inf\"} %d\n", pn, h.count)
+		fmt.Fprintf(bw, "%s_sum %s\n%s_count %d\n", pn, promFloat(h.sum), pn, h.count)
+	}
+
+	return bw.Flush()
+}
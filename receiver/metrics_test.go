@@ -0,0 +1,73 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package receiver
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_Metrics_WritePrometheus(t *testing.T) {
+	r := &Receiver{Metrics: NewMetrics()} // ReportStats is false
+
+	r.reportStatCount("serde.flush_dps.count", 3)
+	r.reportStatCount("serde.flush_dps.count", 2)
+	r.reportStatCount("receiver.datapoints.total", 7)
+	r.reportStatCount("receiver.forwarded_to.10_0_0_1:1234", 1)
+	r.reportStatCount("receiver.forwarded_to.10_0_0_2:1234", 2)
+	r.reportStatGauge("receiver.queue_len", 10)
+	r.reportStatGauge("receiver.queue_len", 4)
+	r.reportStatHistogram("serde.flush_dps.duration_seconds", 0.003)
+	r.reportStatHistogram("serde.flush_dps.duration_seconds", 20)
+	r.reportStatHistogram("serde.flush_dps.sql_ops", 1)
+
+	var buf bytes.Buffer
+	if err := r.Metrics.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, expect := range []string{
+		"# TYPE tgres_serde_flush_dps_count_total counter\ntgres_serde_flush_dps_count_total 5\n",
+		"# TYPE tgres_receiver_datapoints_total counter\ntgres_receiver_datapoints_total 7\n",
+		"# TYPE tgres_receiver_forwarded_to_total counter\n" +
+			"tgres_receiver_forwarded_to_total{node=\"10_0_0_1:1234\"} 1\n" +
+			"tgres_receiver_forwarded_to_total{node=\"10_0_0_2:1234\"} 2\n",
+		"# TYPE tgres_receiver_queue_len gauge\ntgres_receiver_queue_len 4\n",
+		"# TYPE tgres_serde_flush_dps_duration_seconds histogram\n",
+		"tgres_serde_flush_dps_duration_seconds_bucket{le=\"0.0025\"} 0\n",
+		"tgres_serde_flush_dps_duration_seconds_bucket{le=\"0.005\"} 1\n",
+		"tgres_serde_flush_dps_duration_seconds_bucket{le=\"10\"} 1\n",
+		"tgres_serde_flush_dps_duration_seconds_bucket{le=\"+Inf\"} 2\n",
+		"tgres_serde_flush_dps_duration_seconds_sum 20.003\n",
+		"tgres_serde_flush_dps_duration_seconds_count 2\n",
+		"tgres_serde_flush_dps_sql_ops_bucket{le=\"1\"} 1\n",
+	} {
+		if !strings.Contains(out, expect) {
+			t.Errorf("expected %q in output:\n%s", expect, out)
+		}
+	}
+
+	// nil is a noop
+	var m *Metrics
+	m.count("foo", 1)
+	m.gauge("foo", 1)
+	m.observe("foo", 1)
+	if err := m.WritePrometheus(&buf); err != nil {
+		t.Errorf("nil Metrics: %v", err)
+	}
+}
@@ -16,7 +16,6 @@
 package receiver
 
 import (
-	"log"
 	"math"
 	"time"
 
@@ -69,7 +68,7 @@ var pacedMetricPeriodicFlushSignal = func(flushCh chan bool, frequency time.Dura
 		if len(flushCh) == 0 {
 			flushCh <- true
 		} else {
-			log.Printf("%s: dropping flush timer on the floor - busy system?", ident)
+			logger.Warnf("%s: dropping flush timer on the floor - busy system?", ident)
 		}
 	}
 }
@@ -84,7 +83,7 @@ var pacedMetricWorker = func(wc wController, pacedMetricCh chan *pacedMetric, ac
 	var flushCh = make(chan bool, 1)
 	go pacedMetricPeriodicFlushSignal(flushCh, frequency, wc.ident())
 
-	log.Printf("%s: started.", wc.ident())
+	logger.Infof("%s: started.", wc.ident())
 	wc.onStarted()
 
 	for {
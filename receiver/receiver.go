@@ -23,18 +23,33 @@ import (
 	"encoding/gob"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tgres/tgres/aggregator"
 	"github.com/tgres/tgres/blaster"
 	"github.com/tgres/tgres/cluster"
+	"github.com/tgres/tgres/logging"
+	"github.com/tgres/tgres/rules"
 	"github.com/tgres/tgres/serde"
+	"github.com/tgres/tgres/tracing"
 )
 
-var debug bool
+var (
+	debug  bool
+	logger = logging.New("receiver")
+)
+
+// DefaultAggregatorShards is the number of aggregator shards unless
+// specified otherwise. Every node in a cluster must use the same
+// number.
+const DefaultAggregatorShards = 16
 
 func init() {
 	debug = os.Getenv("TGRES_RCVR_DEBUG") != ""
+	if debug {
+		logger.SetLevel(logging.Debug)
+	}
 }
 
 // Receiver receives and directs incoming datapoints to one of n
@@ -48,10 +63,11 @@ func init() {
 // The Receiver is cluster-aware. In a clustered set up points are
 // forwarded to the node responsible for a particular DS.
 //
-// The Receiver also creates an Aggregator which can aggregate metrics
-// and send as aggregated data points periodically. In a clustered set
-// up there is one Aggregator per cluster. Default aggregation period
-// is 10 seconds.
+// The Receiver also creates Aggregators which can aggregate metrics
+// and send as aggregated data points periodically. Aggregation is
+// sharded by metric ident into AggregatorShards Aggregators, in a
+// clustered set up each shard is handled by one node. Default
+// aggregation period is 10 seconds.
 //
 // Receiver also handles paced metrics. A paced metric is a metric
 // that can come in at a very fast rate (e.g. counting function calls
@@ -75,12 +91,24 @@ type Receiver struct {
 	// and approximate, but better than nothing.
 	MaxMemoryBytes uint64
 
-	StatFlushDuration time.Duration // Period after which stats are flushed
-	StatsNamePrefix   string        // Stat names are prefixed with this
+	// SeriesLimits limit the creation of new series, see
+	// Cardinality() for the current counts.
+	SeriesLimits SeriesLimits
+
+	// Rules rewrite and filter incoming data points, see ApplyRules().
+	Rules rules.Rules
+
+	StatFlushDuration time.Duration   // Period after which stats are flushed
+	StatsNamePrefix   string          // Stat names are prefixed with this
+	StatRateWindows   []time.Duration // Sliding windows for aggregator.CmdAddWindow (default 1m)
+	AggregatorShards  int             // Number of aggregator shards, must be same on all nodes (default 16)
 
 	ReportStats       bool   // report internal stats?
 	ReportStatsPrefix string // prefix for internal stats
 
+	// Internal stats for Prometheus, kept regardless of ReportStats.
+	Metrics *Metrics
+
 	// Number of workers and flushers
 	NWorkers int
 
@@ -107,7 +135,12 @@ type Receiver struct {
 	directorWg    sync.WaitGroup
 	pacedMetricWg sync.WaitGroup
 
-	stopped bool
+	fwdLock     sync.Mutex
+	forwardedTo map[string]int64 // data points forwarded, by node (cumulative)
+
+	stop int32 // atomic, see stopped()
+
+	health receiverHealth
 }
 
 // Create a Receiver. The first argument is a SerDe, the second is a
@@ -139,6 +172,7 @@ func NewWithMaxQueue(db serde.SerDe, finder MatchingDSSpecFinder, maxQueue int)
 		MinStep:           10 * time.Second,
 		StatFlushDuration: 10 * time.Second,
 		StatsNamePrefix:   "stats",
+		AggregatorShards:  DefaultAggregatorShards,
 		dpChIn:            dpChIn,
 		dpChOut:           dpChOut,
 		queue:             queue,
@@ -146,6 +180,7 @@ func NewWithMaxQueue(db serde.SerDe, finder MatchingDSSpecFinder, maxQueue int)
 		pacedMetricCh:     make(chan *pacedMetric, 256),
 		ReportStats:       false,
 		ReportStatsPrefix: "tgres",
+		Metrics:           NewMetrics(),
 		NWorkers:          1,
 	}
 
@@ -171,16 +206,20 @@ func (r *Receiver) Start() {
 
 // Marks the receiver as stopped and waits for the channel to empty
 func (r *Receiver) Drain() {
-	r.stopped = true
+	atomic.StoreInt32(&r.stop, 1)
 	for len(r.dpChIn) > 0 || len(r.dpChOut) > 0 || r.queue.size() > 0 {
 		time.Sleep(500 * time.Millisecond)
 	}
 }
 
+func (r *Receiver) stopped() bool {
+	return atomic.LoadInt32(&r.stop) != 0
+}
+
 // Stops processing, waits for everything to finish and shuts down all
 // workers/flushers.
 func (r *Receiver) Stop() {
-	r.stopped = true
+	atomic.StoreInt32(&r.stop, 1)
 	doStop(r, r.cluster)
 }
 
@@ -207,26 +246,74 @@ func (r *Receiver) SetCluster(c clusterer) {
 	}
 }
 
+// Cluster returns the cluster, or nil if the receiver is not
+// clustered.
+func (r *Receiver) Cluster() *cluster.Cluster {
+	c, _ := r.cluster.(*cluster.Cluster)
+	return c
+}
+
+// ForwardedTo returns the number of data points forwarded to other
+// nodes since start, keyed by node address.
+func (r *Receiver) ForwardedTo() map[string]int64 {
+	r.fwdLock.Lock()
+	defer r.fwdLock.Unlock()
+	result := make(map[string]int64, len(r.forwardedTo))
+	for k, v := range r.forwardedTo {
+		result[k] = v
+	}
+	return result
+}
+
+func (r *Receiver) countForwarded(fwd map[string]int) {
+	r.fwdLock.Lock()
+	defer r.fwdLock.Unlock()
+	if r.forwardedTo == nil {
+		r.forwardedTo = make(map[string]int64)
+	}
+	for k, v := range fwd {
+		r.forwardedTo[k] += int64(v)
+	}
+}
+
 // Return a pointer to dsCache
 func (r *Receiver) DsCache() *dsCache {
 	return r.dsc
 }
 
+// ApplyRules applies the Rules to the ident of an incoming data point
+// (or aggregator command) and returns the resulting ident, or false
+// if the data point should be dropped. Listeners call it before
+// queueing, internally generated data points are not subject to the
+// rules.
+func (r *Receiver) ApplyRules(ident serde.Ident) (serde.Ident, bool) {
+	if len(r.Rules) == 0 {
+		return ident, true
+	}
+	ident, ok := r.Rules.Apply(ident)
+	if !ok {
+		r.reportStatCount("receiver.datapoints.filtered", 1)
+	}
+	return ident, ok
+}
+
 // Sends a data point to the receiver channel. A Data Source PDP
 // always treats incoming data as a rate, it is the responsibility of
 // the caller to present non-rate values such as counters as a
 // rate. Consider using the Aggregator (QueueAggregatorCommand) or
 // paced metrics (QueueSum/QueueGauge) for non-rate data.
 func (r *Receiver) QueueDataPoint(ident serde.Ident, ts time.Time, v float64) {
-	if !r.stopped {
-		r.dpChIn <- &incomingDP{cachedIdent: newCachedIdent(ident), timeStamp: ts, value: v}
+	if !r.stopped() {
+		dp := &incomingDP{cachedIdent: newCachedIdent(ident), timeStamp: ts, value: v}
+		dp.traceStart()
+		r.dpChIn <- dp
 	}
 }
 
 // Sends a data point (in the form of an aggregator.Command) to the
 // aggregator.
 func (r *Receiver) QueueAggregatorCommand(agg *aggregator.Command) {
-	if !r.stopped {
+	if !r.stopped() {
 		r.aggCh <- agg
 	}
 }
@@ -235,32 +322,49 @@ func (r *Receiver) QueueAggregatorCommand(agg *aggregator.Command) {
 // be passed to the aggregator and from the aggregator to the data
 // source as a rate.
 func (r *Receiver) QueueSum(ident serde.Ident, v float64) {
-	if !r.stopped {
+	if !r.stopped() {
 		r.pacedMetricCh <- &pacedMetric{kind: pacedSum, ident: ident, value: v}
 	}
 }
 
 // Send a gauge (i.e. a rate). This is a paced metric.
 func (r *Receiver) QueueGauge(ident serde.Ident, v float64) {
-	if !r.stopped {
+	if !r.stopped() {
 		r.pacedMetricCh <- &pacedMetric{kind: pacedGauge, ident: ident, value: v}
 	}
 }
 
 // Reporting internal to Tgres: count
 func (r *Receiver) reportStatCount(name string, f float64) {
-	if r != nil && r.ReportStats {
+	if r == nil {
+		return
+	}
+	r.Metrics.count(name, f)
+	if r.ReportStats {
 		r.QueueSum(serde.Ident{"name": r.ReportStatsPrefix + "." + name}, f)
 	}
 }
 
 // Reporting internal to Tgres: gauge
 func (r *Receiver) reportStatGauge(name string, f float64) {
-	if r != nil && r.ReportStats {
+	if r == nil {
+		return
+	}
+	r.Metrics.gauge(name, f)
+	if r.ReportStats {
 		r.QueueGauge(serde.Ident{"name": r.ReportStatsPrefix + "." + name}, f)
 	}
 }
 
+// Reporting internal to Tgres: histogram. These are individual
+// observations (e.g. the duration of every flush), too many to be
+// stored as a series, so they only go to Metrics.
+func (r *Receiver) reportStatHistogram(name string, f float64) {
+	if r != nil {
+		r.Metrics.observe(name, f)
+	}
+}
+
 type dataPointQueuer interface {
 	QueueDataPoint(serde.Ident, time.Time, float64)
 }
@@ -272,6 +376,7 @@ type aggregatorCommandQueuer interface {
 type statReporter interface {
 	reportStatCount(string, float64)
 	reportStatGauge(string, float64)
+	reportStatHistogram(string, float64)
 }
 
 type clusterer interface {
@@ -281,6 +386,8 @@ type clusterer interface {
 	NodesForDistDatum(cluster.DistDatum) []*cluster.Node
 	LocalNode() *cluster.Node
 	NotifyClusterChanges() chan bool
+	RegisterRequestType(cluster.RequestHandler) int
+	Request(*cluster.Node, int, interface{}, time.Duration) (*cluster.Msg, error)
 	Transition(time.Duration) error
 	Ready(bool) error
 	Leave(timeout time.Duration) error
@@ -299,6 +406,8 @@ type incomingDP struct {
 	timeStamp   time.Time
 	value       float64
 	Hops        int
+
+	span, stage *tracing.Span // nil unless sampled, see trace.go
 }
 
 func (dp *incomingDP) GobEncode() ([]byte, error) {
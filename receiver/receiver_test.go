@@ -22,6 +22,7 @@ import (
 	"net"
 	"os"
 	"reflect"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -29,6 +30,7 @@ import (
 	"github.com/hashicorp/memberlist"
 	"github.com/tgres/tgres/aggregator"
 	"github.com/tgres/tgres/cluster"
+	"github.com/tgres/tgres/rules"
 	"github.com/tgres/tgres/serde"
 )
 
@@ -96,6 +98,25 @@ func Test_Receiver_SetCluster(t *testing.T) {
 	}
 }
 
+func Test_Receiver_Cluster(t *testing.T) {
+	r := &Receiver{cluster: &fakeCluster{}}
+	if r.Cluster() != nil {
+		t.Errorf("Cluster: expected nil for a non-*cluster.Cluster")
+	}
+}
+
+func Test_Receiver_ForwardedTo(t *testing.T) {
+	r := &Receiver{}
+	if len(r.ForwardedTo()) != 0 {
+		t.Errorf("ForwardedTo: expected empty")
+	}
+	r.countForwarded(map[string]int{"a": 1, "b": 2})
+	r.countForwarded(map[string]int{"a": 3})
+	if fwd := r.ForwardedTo(); fwd["a"] != 4 || fwd["b"] != 2 {
+		t.Errorf("ForwardedTo: unexpected: %v", fwd)
+	}
+}
+
 func Test_Receiver_QueueDataPoint(t *testing.T) {
 	ch := make(chan interface{})
 	r := &Receiver{dpChIn: ch, dpChOut: ch}
@@ -146,6 +167,27 @@ func Test_Receiver_reportStatCount(t *testing.T) {
 	}
 }
 
+func Test_Receiver_ApplyRules(t *testing.T) {
+	r := &Receiver{Metrics: NewMetrics()}
+	ident := serde.Ident{"name": "foo.bar"}
+	if out, ok := r.ApplyRules(ident); !ok || !reflect.DeepEqual(out, ident) {
+		t.Errorf("ApplyRules: no rules should not change anything: %v %v", out, ok)
+	}
+	r.Rules = rules.Rules{
+		{Action: rules.Drop, Match: regexp.MustCompile(`^junk\.`)},
+		{Action: rules.Tag, Set: map[string]string{"dc": "east"}},
+	}
+	if out, ok := r.ApplyRules(ident); !ok || out["dc"] != "east" {
+		t.Errorf("ApplyRules: expected dc tag: %v %v", out, ok)
+	}
+	if _, ok := r.ApplyRules(serde.Ident{"name": "junk.foo"}); ok {
+		t.Errorf("ApplyRules: junk should be dropped")
+	}
+	if n := r.Metrics.counters["receiver.datapoints.filtered"]; n != 1 {
+		t.Errorf("ApplyRules: expected 1 filtered, got %v", n)
+	}
+}
+
 // fake cluster
 type fakeCluster struct {
 	n, nLeave, nShutdown, nReady int
@@ -154,8 +196,12 @@ type fakeCluster struct {
 	ln                           *cluster.Node
 	cChange                      chan bool
 	tErr                         bool
+	reqHdlrs                     []cluster.RequestHandler
+	inTrans                      bool
 }
 
+func (c *fakeCluster) Transitioning() bool { return c.inTrans }
+
 func (c *fakeCluster) RegisterMsgType() (chan *cluster.Msg, chan *cluster.Msg) {
 	c.nReg++
 	return nil, nil
@@ -164,6 +210,23 @@ func (_ *fakeCluster) NumMembers() int
 func (_ *fakeCluster) LoadDistData(f func() ([]cluster.DistDatum, error)) error { f(); return nil }
 func (c *fakeCluster) NodesForDistDatum(cluster.DistDatum) []*cluster.Node      { return c.nodesForDd }
 func (c *fakeCluster) LocalNode() *cluster.Node                                 { return c.ln }
+func (c *fakeCluster) RegisterRequestType(h cluster.RequestHandler) int {
+	c.reqHdlrs = append(c.reqHdlrs, h)
+	return len(c.reqHdlrs) - 1
+}
+func (c *fakeCluster) Request(dst *cluster.Node, id int, payload interface{}, _ time.Duration) (*cluster.Msg, error) {
+	// handle it locally
+	req, err := cluster.NewMsg(dst, payload)
+	if err != nil {
+		return nil, err
+	}
+	req.Src, req.Id = c.ln, id
+	result, err := c.reqHdlrs[id](req)
+	if err != nil {
+		return nil, err
+	}
+	return cluster.NewMsg(c.ln, result)
+}
 func (c *fakeCluster) NotifyClusterChanges() chan bool {
 	return c.cChange
 }
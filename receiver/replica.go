@@ -0,0 +1,184 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package receiver
+
+import (
+	"sync"
+	"time"
+
+	"github.com/tgres/tgres/cluster"
+	"github.com/tgres/tgres/serde"
+)
+
+// A copy of a DS (see cachedDs.replica) is never persisted, but it
+// has to keep its points until the lead node has persisted them, or
+// they would be lost should the lead die. Whenever the lead flushes
+// the RRA state (which is when the RRA latest is written), it records
+// the latest slot of every RRA in the flush, and periodically sends
+// these to the other nodes. The copies then discard their points up
+// to and including it. RRAs are identified by bundle, segment and
+// position within the segment (see verticalCache), which is the same
+// on every node since they all load DSs from the same database.
+
+// How often the persisted latests are sent to the other nodes.
+var persistedShareInterval = time.Second
+
+// How long to wait for another node to accept the persisted latests.
+var persistedRequestTimeout = 2 * time.Second
+
+// rraLatests are the latest persisted slots of RRAs in a segment of
+// a bundle, keyed by position within the segment.
+type rraLatests struct {
+	BundleId, Seg int64
+	Latests       map[int64]time.Time
+}
+
+// persistedLatests is the payload of a persisted latests request.
+type persistedLatests struct {
+	Segs []*rraLatests
+}
+
+// persistedRRAs keeps the latest persisted slot of RRAs, both the
+// ones persisted by this node and by the lead nodes of DSs that this
+// node has copies of.
+type persistedRRAs struct {
+	sync.Mutex
+	share   bool // record what this node persists to send it on
+	latests map[bundleKey]map[int64]time.Time
+	pending map[bundleKey]map[int64]time.Time // not sent yet
+}
+
+func newPersistedRRAs() *persistedRRAs {
+	return &persistedRRAs{
+		latests: make(map[bundleKey]map[int64]time.Time),
+		pending: make(map[bundleKey]map[int64]time.Time),
+	}
+}
+
+// Record the latests persisted in segment seg of bundle bundleId, the
+// ones persisted by this node (local) are also queued to be sent to
+// the other nodes. Nothing is recorded for this node unless sharing
+// was enabled, no other node would need it.
+func (p *persistedRRAs) add(bundleId, seg int64, latests map[int64]time.Time, local bool) {
+	p.Lock()
+	defer p.Unlock()
+	if local && !p.share {
+		return
+	}
+	key := bundleKey{bundleId, seg}
+	merge := func(m map[bundleKey]map[int64]time.Time) {
+		if m[key] == nil {
+			m[key] = make(map[int64]time.Time, len(latests))
+		}
+		for idx, latest := range latests {
+			if m[key][idx].Before(latest) {
+				m[key][idx] = latest
+			}
+		}
+	}
+	merge(p.latests)
+	if local {
+		merge(p.pending)
+	}
+}
+
+// takePending returns the latests persisted by this node since the
+// last call.
+func (p *persistedRRAs) takePending() []*rraLatests {
+	p.Lock()
+	defer p.Unlock()
+	if len(p.pending) == 0 {
+		return nil
+	}
+	result := make([]*rraLatests, 0, len(p.pending))
+	for key, latests := range p.pending {
+		result = append(result, &rraLatests{BundleId: key.bundleId, Seg: key.seg, Latests: latests})
+	}
+	p.pending = make(map[bundleKey]map[int64]time.Time)
+	return result
+}
+
+// clearPersisted discards the points of the DS which are known to be
+// persisted.
+func (p *persistedRRAs) clearPersisted(ds serde.DbDataSourcer) {
+	rras := ds.RRAs()
+	ts := make([]time.Time, len(rras))
+	p.Lock()
+	for i, rra := range rras {
+		if dbrra, ok := rra.(serde.DbRoundRobinArchiver); ok {
+			ts[i] = p.latests[bundleKey{dbrra.BundleId(), dbrra.Seg()}][dbrra.Idx()]
+		}
+	}
+	p.Unlock()
+	ds.ClearRRAsThrough(ts)
+}
+
+// A cluster which keeps copies of DSs and can list its members.
+type copiesKeeper interface {
+	Copies(...int) int
+	Members() []*cluster.Node
+}
+
+// registerPersistedRequests makes this node accept persisted latests
+// from other nodes and, if there are copies of DSs in the cluster,
+// starts sending its own to them.
+func (d *dsCache) registerPersistedRequests(clstr clusterer) {
+	p := d.dsf.persistedRRAs()
+	// NB: Registered regardless, request ids must be the same on
+	// every node.
+	id := clstr.RegisterRequestType(func(m *cluster.Msg) (interface{}, error) {
+		var req persistedLatests
+		if err := m.Decode(&req); err != nil {
+			logger.Errorf("dsCache: persisted latests request decoding FAILED: %v", err)
+			return nil, err
+		}
+		if p == nil {
+			return false, nil
+		}
+		for _, seg := range req.Segs {
+			p.add(seg.BundleId, seg.Seg, seg.Latests, false)
+		}
+		return true, nil
+	})
+	if ck, ok := clstr.(copiesKeeper); ok && p != nil && ck.Copies() > 1 {
+		p.Lock()
+		p.share = true
+		p.Unlock()
+		go sharePersisted(clstr, ck.Members, id, p, persistedShareInterval)
+	}
+}
+
+// sharePersisted periodically sends the latests persisted by this
+// node to all the other nodes, any of which may have copies.
+var sharePersisted = func(clstr clusterer, members func() []*cluster.Node, id int, p *persistedRRAs, interval time.Duration) {
+	for range time.NewTicker(interval).C {
+		segs := p.takePending()
+		if len(segs) == 0 {
+			continue
+		}
+		ln := clstr.LocalNode()
+		for _, node := range members() {
+			if node.Name() == ln.Name() {
+				continue
+			}
+			if _, err := clstr.Request(node, id, &persistedLatests{Segs: segs}, persistedRequestTimeout); err != nil {
+				// The next ones will supersede these, the copies
+				// just hold on to their points a while longer.
+				logger.Warnf("sharePersisted: request to node %s failed: %v", node.Name(), err)
+			}
+		}
+	}
+}
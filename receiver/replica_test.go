@@ -0,0 +1,142 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package receiver
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/tgres/tgres/cluster"
+	"github.com/tgres/tgres/rrd"
+	"github.com/tgres/tgres/serde"
+)
+
+func newReplicaTestDs(ident serde.Ident) *cachedDs {
+	ds := rrd.NewDataSource(rrd.DSSpec{
+		Step:      10 * time.Second,
+		Heartbeat: time.Hour,
+		RRAs:      []rrd.RRASpec{{Function: rrd.WMEAN, Step: 10 * time.Second, Span: 100 * time.Second}},
+	})
+	rra := ds.RRAs()[0]
+	ds.SetRRAs([]rrd.RoundRobinArchiver{&fakeDbRRA{RoundRobinArchiver: rra, idx: 5}})
+	return &cachedDs{DbDataSourcer: serde.NewDbDataSource(7, ident, 0, 0, ds), mu: &sync.Mutex{}}
+}
+
+func Test_persistedRRAs(t *testing.T) {
+	p := newPersistedRRAs()
+	t0 := time.Unix(1000, 0)
+
+	p.add(1, 2, map[int64]time.Time{3: t0}, true)
+	if len(p.latests) != 0 || p.takePending() != nil {
+		t.Errorf("add: nothing should be recorded for this node unless shared")
+	}
+
+	p.share = true
+	p.add(1, 2, map[int64]time.Time{3: t0}, true)
+	p.add(1, 2, map[int64]time.Time{3: t0.Add(-time.Minute), 4: t0}, true)
+	p.add(1, 5, map[int64]time.Time{3: t0}, false)
+	if l := p.latests[bundleKey{1, 2}][3]; !l.Equal(t0) {
+		t.Errorf("add: an earlier latest should not replace a later one: %v", l)
+	}
+	segs := p.takePending()
+	if len(segs) != 1 || segs[0].BundleId != 1 || segs[0].Seg != 2 || len(segs[0].Latests) != 2 {
+		t.Errorf("takePending: only this node's latests expected: %v", segs)
+	}
+	if p.takePending() != nil || len(p.latests) != 2 {
+		t.Errorf("takePending: pending should be cleared, latests kept")
+	}
+}
+
+func Test_replica_leadDies(t *testing.T) {
+	md := make([]byte, 20)
+	md[0] = 1 // Ready
+	replicaNode := &cluster.Node{Node: &memberlist.Node{Meta: md, Name: "replica"}}
+
+	foo := serde.Ident{"name": "foo"}
+	sr := &fakeSr{}
+
+	// lead
+	leadDsf := &dsFlusher{sr: sr, persisted: newPersistedRRAs()}
+	leadDsf.persisted.share = true
+	lead := newReplicaTestDs(foo)
+
+	// replica
+	replicaDsf := &dsFlusher{sr: sr, persisted: newPersistedRRAs()}
+	replicaDsc := newDsCache(&fakeSerde{}, &SimpleDSFinder{DftDSSPec}, replicaDsf)
+	replicaClstr := &fakeCluster{ln: replicaNode}
+	replicaDsc.registerPersistedRequests(replicaClstr)
+	replica := newReplicaTestDs(foo)
+	replica.replica = true
+	replicaDsc.insert(replica)
+
+	// Both get the same points, in slots 1010 through 1060
+	for ts := int64(1000); ts <= 1060; ts += 10 {
+		for cds, dsf := range map[*cachedDs]*dsFlusher{lead: leadDsf, replica: replicaDsf} {
+			cds.appendIncoming(&incomingDP{cachedIdent: newCachedIdent(foo), timeStamp: time.Unix(ts, 0), value: float64(ts)})
+			cds.lastProcess, cds.lastFlush = time.Time{}, time.Time{}
+			directorProcessDataPoint(cds, dsf)
+		}
+	}
+	if n := replica.PointCount(); n != 6 {
+		t.Fatalf("replica: expected 6 points before anything is persisted, got %d", n)
+	}
+
+	// The lead persists the RRA state through 1030
+	var wg, startWg sync.WaitGroup
+	startWg.Add(1)
+	ch := make(chan *vDpFlushRequest, 1)
+	ch <- &vDpFlushRequest{bundleId: 1, seg: 0, latests: map[int64]interface{}{5: time.Unix(1030, 0)}}
+	close(ch)
+	dbFlusher(&wrkCtl{wg: &wg, startWg: &startWg, id: "test"}, &fakeDsFlusher{}, ch, sr, leadDsf.persisted)
+	wg.Wait()
+
+	// ... and tells the replica
+	segs := leadDsf.persisted.takePending()
+	if len(segs) != 1 {
+		t.Fatalf("dbFlusher: expected persisted latests to share, got %v", segs)
+	}
+	if _, err := replicaClstr.Request(replicaNode, 0, &persistedLatests{Segs: segs}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// which discards the persisted points on its next flush
+	replica.lastFlush = time.Time{}
+	directorProcessDataPoint(replica, replicaDsf)
+	dps := replica.RRAs()[0].DPs()
+	if len(dps) != 3 {
+		t.Errorf("replica: expected only the 3 unpersisted points, got %v", dps)
+	}
+
+	// The lead dies with 1040 through 1060 not persisted, the replica
+	// takes over and has them.
+	dd := &distDs{DbDataSourcer: replica.DbDataSourcer, dsc: replicaDsc}
+	if err := dd.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if replica.replica || replicaDsc.getByIdent(newCachedIdent(foo)) != replica {
+		t.Errorf("Acquire: the replica should become the lead as is")
+	}
+	rra := replica.RRAs()[0]
+	leadDps := lead.RRAs()[0].DPs()
+	for _, ts := range []int64{1040, 1050, 1060} {
+		i := rrd.SlotIndex(time.Unix(ts, 0), rra.Step(), rra.Size())
+		if v, ok := dps[i]; !ok || v != leadDps[i] {
+			t.Errorf("replica: the unpersisted point at %d is lost: %v (lead has %v)", ts, v, leadDps[i])
+		}
+	}
+}
@@ -16,8 +16,8 @@
 package receiver
 
 import (
-	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tgres/tgres/aggregator"
@@ -47,22 +47,25 @@ var startAllWorkers = func(r *Receiver, startWg *sync.WaitGroup) {
 }
 
 var doStart = func(r *Receiver) {
-	log.Printf("Receiver: Caching data source definitions...")
+	logger.Infof("Receiver: Caching data source definitions...")
 	start := time.Now()
+	r.dsc.setLimits(r.SeriesLimits)
 	if err := r.dsc.preLoad(); err != nil {
-		log.Printf("Receiver: error caching data sources: %v", err)
+		logger.Errorf("Receiver: error caching data sources: %v", err)
 	}
 	dur := time.Now().Sub(start)
-	log.Printf("Receiver: Cached %d data sources in %v.", len(r.dsc.byIdent), dur)
+	logger.Infof("Receiver: Cached %d data sources in %v.", len(r.dsc.byIdent), dur)
 
-	log.Printf("Receiver: starting...")
+	logger.Infof("Receiver: starting...")
+	r.directorBeat()
+	r.flushBeat()
 
 	var startWg sync.WaitGroup
 	startAllWorkers(r, &startWg)
 
 	// Wait for workers/flushers to start correctly
 	startWg.Wait()
-	log.Printf("Receiver: All workers running, starting director.")
+	logger.Infof("Receiver: All workers running, starting director.")
 
 	startWg.Add(1)
 	go director(&wrkCtl{wg: &r.directorWg, startWg: &startWg, id: "director"}, r.dpChIn,
@@ -70,53 +73,55 @@ var doStart = func(r *Receiver) {
 		r.MaxReceiverQueueSize, r.MaxMemoryBytes)
 	startWg.Wait()
 
-	log.Printf("Receiver: Starting runtime cpu/mem reporter.")
+	logger.Infof("Receiver: Starting runtime cpu/mem reporter.")
 	go reportRuntime(r)
 
-	log.Printf("Receiver: Ready.")
+	atomic.StoreInt32(&r.health.started, 1)
+	logger.Infof("Receiver: Ready.")
 }
 
 var stopDirector = func(r *Receiver) {
-	log.Printf("Closing director channel...")
+	logger.Infof("Closing director channel...")
 	r.dpChIn <- nil // signal to close
 	r.directorWg.Wait()
-	log.Printf("Director finished.")
+	logger.Infof("Director finished.")
 }
 
 var doStop = func(r *Receiver, clstr clusterer) {
 	// Order matters here
+	atomic.StoreInt32(&r.health.started, 0)
 	stopPacedMetricWorker(r.pacedMetricCh, &r.pacedMetricWg)
 	stopAggWorker(r.aggCh, &r.aggWg)
 	stopDirector(r)
 	stopFlushers(r.flusher, &r.flusherWg)
-	log.Printf("Leaving cluster...")
+	logger.Infof("Leaving cluster...")
 	clstr.Leave(1 * time.Second)
 	clstr.Shutdown()
-	log.Printf("Left cluster.")
+	logger.Infof("Left cluster.")
 }
 
 var stopFlushers = func(flusher dsFlusherBlocking, flusherWg *sync.WaitGroup) {
-	log.Printf("stopFlushers(): stopping flusher(s)...")
+	logger.Infof("stopFlushers(): stopping flusher(s)...")
 	flusher.stop()
-	log.Printf("stopFlushers(): waiting for flushers to finish...")
+	logger.Infof("stopFlushers(): waiting for flushers to finish...")
 	flusherWg.Wait()
-	log.Printf("stopFlushers(): all flushers finished.")
+	logger.Infof("stopFlushers(): all flushers finished.")
 }
 
 var stopAggWorker = func(aggCh chan *aggregator.Command, aggWg *sync.WaitGroup) {
-	log.Printf("stopAggWorker(): closing agg channel...")
+	logger.Infof("stopAggWorker(): closing agg channel...")
 	close(aggCh)
-	log.Printf("stopAggWorker(): waiting for agg worker to finish...")
+	logger.Infof("stopAggWorker(): waiting for agg worker to finish...")
 	aggWg.Wait()
-	log.Printf("stopAggWorker(): agg worker finished.")
+	logger.Infof("stopAggWorker(): agg worker finished.")
 }
 
 var stopPacedMetricWorker = func(pacedMetricCh chan *pacedMetric, pacedMetricWg *sync.WaitGroup) {
-	log.Printf("stopPacedMetricWorker(): closing paced metric channel...")
+	logger.Infof("stopPacedMetricWorker(): closing paced metric channel...")
 	close(pacedMetricCh)
-	log.Printf("stopPacedMetricWorker(): waiting for paced metric worker to finish...")
+	logger.Infof("stopPacedMetricWorker(): waiting for paced metric worker to finish...")
 	pacedMetricWg.Wait()
-	log.Printf("stopPacedMetricWorker(): paced metric worker finished.")
+	logger.Infof("stopPacedMetricWorker(): paced metric worker finished.")
 }
 
 var startFlushers = func(r *Receiver, startWg *sync.WaitGroup) {
@@ -125,18 +130,18 @@ var startFlushers = func(r *Receiver, startWg *sync.WaitGroup) {
 	// 	return
 	// }
 
-	log.Printf("Starting flusher(s)...")
+	logger.Infof("Starting flusher(s)...")
 	r.flusher.start(&r.flusherWg, startWg, r.MinStep, r.NWorkers*2)
 }
 
 var startAggWorker = func(r *Receiver, startWg *sync.WaitGroup) {
-	log.Printf("Starting aggWorker...")
+	logger.Infof("Starting aggWorker...")
 	startWg.Add(1)
 	go aggWorker(&wrkCtl{wg: &r.aggWg, startWg: startWg, id: "aggWorker"}, r.aggCh, r.cluster, r.StatFlushDuration, r.StatsNamePrefix, r, r)
 }
 
 var startPacedMetricWorker = func(r *Receiver, startWg *sync.WaitGroup) {
-	log.Printf("Starting pacedMetricWorker...")
+	logger.Infof("Starting pacedMetricWorker...")
 	startWg.Add(1)
 	go pacedMetricWorker(&wrkCtl{wg: &r.pacedMetricWg, startWg: startWg, id: "pacedMetricWorker"}, r.pacedMetricCh, r, r, time.Second, r)
 }
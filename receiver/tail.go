@@ -0,0 +1,128 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package receiver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tgres/tgres/cluster"
+	"github.com/tgres/tgres/rrd"
+	"github.com/tgres/tgres/serde"
+)
+
+// The "tail" of a DS is the data which is in memory and not yet in
+// the database, i.e. the RRA points of the cachedDs plus whatever is
+// in the vcache. In a cluster only the lead node for the DS has it,
+// other nodes request it over the cluster RPC.
+
+// How long to wait for another node to respond with a tail.
+var tailRequestTimeout = 2 * time.Second
+
+type dsTailRequest struct {
+	Ident serde.Ident
+}
+
+type dsTail struct {
+	RRAs []rrd.RRASpec
+}
+
+// FetchTail returns the in-memory, not yet persisted, part of every
+// RRA of the DS as an RRASpec (with Latest, Value, Duration and DPs),
+// in the same order as ds.RRAs(). Nil means there is nothing beyond
+// what is in the database.
+func (d *dsCache) FetchTail(ds rrd.DataSourcer) ([]rrd.RRASpec, error) {
+	dbds, ok := ds.(serde.DbDataSourcer)
+	if !ok || dbds.Id() == 0 {
+		return nil, nil
+	}
+
+	if d.clstr != nil {
+		nodes := d.clstr.NodesForDistDatum(&distDs{DbDataSourcer: dbds, dsc: d})
+		if len(nodes) > 0 && nodes[0].Name() != d.clstr.LocalNode().Name() {
+			return d.remoteTail(nodes[0], dbds.Ident())
+		}
+	}
+	return d.localTail(dbds.Ident()), nil
+}
+
+func (d *dsCache) localTail(ident serde.Ident) []rrd.RRASpec {
+	cds := d.getByIdent(newCachedIdent(ident))
+	if cds == nil {
+		return nil
+	}
+
+	cds.mu.Lock()
+	defer cds.mu.Unlock()
+
+	if cds.Id() == 0 {
+		return nil // not loaded
+	}
+
+	var result []rrd.RRASpec
+	for _, rra := range cds.RRAs() {
+		spec := rra.Spec()
+		spec.Latest = rra.Latest()
+		spec.Value = rra.Value()
+		spec.Duration = rra.Duration()
+		spec.DPs = make(map[int64]float64)
+		if dbrra, ok := rra.(serde.DbRoundRobinArchiver); ok {
+			for i, v := range d.dsf.vcachedDps(dbrra) {
+				spec.DPs[i] = v
+			}
+		}
+		for i, v := range rra.DPs() {
+			spec.DPs[i] = v
+		}
+		result = append(result, spec)
+	}
+	return result
+}
+
+func (d *dsCache) remoteTail(node *cluster.Node, ident serde.Ident) ([]rrd.RRASpec, error) {
+	d.RLock()
+	id := d.tailReq
+	d.RUnlock()
+	if id < 0 {
+		return nil, fmt.Errorf("FetchTail: tail requests not registered with the cluster")
+	}
+
+	msg, err := d.clstr.Request(node, id, &dsTailRequest{Ident: ident}, tailRequestTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("FetchTail: request to node %s failed: %v", node.Name(), err)
+	}
+	var tail dsTail
+	if err := msg.Decode(&tail); err != nil {
+		return nil, err
+	}
+	return tail.RRAs, nil
+}
+
+// registerTailRequests makes this node respond to tail requests from
+// other nodes.
+func (d *dsCache) registerTailRequests(clstr clusterer) {
+	id := clstr.RegisterRequestType(func(m *cluster.Msg) (interface{}, error) {
+		var req dsTailRequest
+		if err := m.Decode(&req); err != nil {
+			logger.Errorf("dsCache: tail request decoding FAILED: %v", err)
+			return nil, err
+		}
+		return &dsTail{RRAs: d.localTail(req.Ident)}, nil
+	})
+	d.Lock()
+	d.tailReq = id
+	d.Unlock()
+}
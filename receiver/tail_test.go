@@ -0,0 +1,84 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package receiver
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/tgres/tgres/cluster"
+	"github.com/tgres/tgres/rrd"
+	"github.com/tgres/tgres/serde"
+)
+
+func Test_tail_FetchTail(t *testing.T) {
+	dsc := newDsCache(nil, nil, &fakeDsFlusher{})
+
+	foo := serde.Ident{"name": "foo"}
+	ds := serde.NewDbDataSource(7, foo, 0, 0, rrd.NewDataSource(rrd.DSSpec{
+		Step: 10 * time.Second,
+		RRAs: []rrd.RRASpec{
+			rrd.RRASpec{Function: rrd.WMEAN, Step: 10 * time.Second, Span: 100 * time.Second},
+		},
+	}))
+	ds.ProcessDataPoint(1, time.Unix(1000, 0))
+	ds.ProcessDataPoint(2, time.Unix(1010, 0))
+	ds.ProcessDataPoint(3, time.Unix(1020, 0))
+	dsc.insert(&cachedDs{DbDataSourcer: ds, mu: &sync.Mutex{}})
+
+	check := func(what string, tail []rrd.RRASpec, err error) {
+		if err != nil {
+			t.Errorf("%s: %v", what, err)
+			return
+		}
+		if len(tail) != 1 || len(tail[0].DPs) != ds.PointCount() || !tail[0].Latest.Equal(ds.RRAs()[0].Latest()) {
+			t.Errorf("%s: unexpected tail: %#v", what, tail)
+		}
+	}
+
+	// not clustered
+	tail, err := dsc.FetchTail(ds)
+	check("FetchTail (local)", tail, err)
+
+	// not a db ds or not saved
+	if tail, _ := dsc.FetchTail(serde.NewDbDataSource(0, foo, 0, 0, nil)); tail != nil {
+		t.Errorf("FetchTail: expected nil for a DS with id 0")
+	}
+
+	// clustered, the lead is another node (the fake cluster
+	// handles the request locally)
+	md := make([]byte, 20)
+	md[0] = 1 // Ready
+	clstr := &fakeCluster{
+		ln:         &cluster.Node{Node: &memberlist.Node{Meta: md, Name: "local"}},
+		nodesForDd: []*cluster.Node{&cluster.Node{Node: &memberlist.Node{Meta: md, Name: "remote"}}},
+	}
+	dsc.clstr = clstr
+	if _, err := dsc.FetchTail(ds); err == nil {
+		t.Errorf("FetchTail: expected an error when tail requests are not registered")
+	}
+	dsc.registerTailRequests(clstr)
+	tail, err = dsc.FetchTail(ds)
+	check("FetchTail (remote)", tail, err)
+
+	// an unknown DS has no tail
+	bar := serde.NewDbDataSource(8, serde.Ident{"name": "bar"}, 0, 0, rrd.NewDataSource(*DftDSSPec))
+	if tail, err := dsc.FetchTail(bar); err != nil || tail != nil {
+		t.Errorf("FetchTail: expected nil for an unknown DS: %v %v", tail, err)
+	}
+}
@@ -0,0 +1,100 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package receiver
+
+import "github.com/tgres/tgres/tracing"
+
+// TraceSampleRatio is the fraction of incoming data points (and
+// database flushes) that are traced when tracing is enabled (see
+// tracing.SetExporter).
+//
+// A sampled data point gets a "receiver.ingest" root span which lasts
+// until the point is in the vertical cache (or is dropped, forwarded
+// to another node, etc, see the "outcome" attribute). Its child spans
+// are the stages it goes through:
+//
+//	receiver.queue    - QueueDataPoint() until the director picks it up
+//	director.process  - DS lookup, cluster forwarding
+//	receiver.load     - waiting for the DS to be loaded/created
+//	worker.queue      - waiting for a worker
+//	worker.process    - updating the in-memory RRD
+//	vcache.flush      - moving the points to the vertical cache
+//
+// Database writes happen later in batches, each is traced in its own
+// "serde.flush_dps", "serde.flush_rra_state" or "serde.flush_ds_state"
+// root span.
+var TraceSampleRatio = 0.001
+
+func (dp *incomingDP) traceStart() {
+	if dp.span = tracing.Start("receiver.ingest", TraceSampleRatio); dp.span != nil {
+		dp.span.SetKind(tracing.KindServer)
+		dp.span.SetAttr("ident", dp.cachedIdent.String())
+		dp.stage = dp.span.Child("receiver.queue")
+	}
+}
+
+// traceStage ends the current stage and begins the next one.
+func (dp *incomingDP) traceStage(name string) {
+	if dp.span == nil {
+		return
+	}
+	dp.stage.End()
+	dp.stage = dp.span.Child(name)
+}
+
+// traceEnd ends the current stage and the trace.
+func (dp *incomingDP) traceEnd(outcome string) {
+	if dp.span == nil {
+		return
+	}
+	dp.stage.End()
+	dp.span.SetAttr("outcome", outcome)
+	dp.span.End()
+	dp.span, dp.stage = nil, nil
+}
+
+// Trace stages of points processed by a worker, cds.mu must be held.
+func (cds *cachedDs) traceStage(name string) {
+	for _, dp := range cds.traced {
+		dp.traceStage(name)
+	}
+}
+
+func (cds *cachedDs) traceEnd(outcome string) {
+	for _, dp := range cds.traced {
+		dp.traceEnd(outcome)
+	}
+	cds.traced = nil
+}
+
+// traceFlush begins a root span for a database flush of count items.
+func traceFlush(name string, dpr *vDpFlushRequest, count int) *tracing.Span {
+	sp := tracing.Start(name, TraceSampleRatio)
+	if sp != nil {
+		sp.SetKind(tracing.KindClient)
+		sp.SetAttr("db.system", "postgresql")
+		sp.SetAttr("bundle_id", dpr.bundleId)
+		sp.SetAttr("seg", dpr.seg)
+		sp.SetAttr("count", count)
+	}
+	return sp
+}
+
+func traceFlushEnd(sp *tracing.Span, sqlOps int, err error) {
+	sp.SetAttr("sql_ops", sqlOps)
+	sp.SetError(err)
+	sp.End()
+}
@@ -0,0 +1,71 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package receiver
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tgres/tgres/serde"
+	"github.com/tgres/tgres/tracing"
+)
+
+func Test_incomingDP_trace(t *testing.T) {
+	// not enabled
+	dp := &incomingDP{cachedIdent: newCachedIdent(serde.Ident{"name": "foo"}), timeStamp: time.Now(), value: 1}
+	dp.traceStart()
+	dp.traceStage("director.process")
+	dp.traceEnd("ok")
+	if dp.span != nil || dp.stage != nil {
+		t.Errorf("span should be nil when tracing is not enabled")
+	}
+
+	var buf bytes.Buffer
+	e := tracing.NewWriterExporter(&buf)
+	tracing.SetExporter(e)
+	defer tracing.SetExporter(nil)
+	save := TraceSampleRatio
+	TraceSampleRatio = 1
+	defer func() { TraceSampleRatio = save }()
+
+	dp.traceStart()
+	if dp.span == nil || dp.stage == nil {
+		t.Fatalf("traceStart: span or stage is nil")
+	}
+	dp.traceStage("director.process")
+	cds := &cachedDs{traced: []*incomingDP{dp}}
+	cds.traceStage("vcache.flush")
+	cds.traceEnd("ok")
+	if dp.span != nil || dp.stage != nil || cds.traced != nil {
+		t.Errorf("traceEnd: span, stage or traced not cleared")
+	}
+	e.Close()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 spans, got %d:\n%s", len(lines), buf.String())
+	}
+	for i, name := range []string{"receiver.queue", "director.process", "vcache.flush", "receiver.ingest"} {
+		if !strings.Contains(lines[i], `"name":"`+name+`"`) {
+			t.Errorf("span %d: expected %q: %s", i, name, lines[i])
+		}
+	}
+	if !strings.Contains(lines[3], `"key":"outcome","value":{"stringValue":"ok"}`) {
+		t.Errorf("root span missing outcome: %s", lines[3])
+	}
+}
@@ -147,6 +147,29 @@ func (vc *verticalCache) updateDss(ds serde.DbDataSourcer) {
 	segment.Unlock()
 }
 
+// Data points of the RRA that are in the cache, i.e. not yet in the
+// database, keyed by slot.
+func (vc *verticalCache) rraDps(rra serde.DbRoundRobinArchiver) map[int64]float64 {
+	vc.Lock()
+	segment := vc.dps[bundleKey{rra.BundleId(), rra.Seg()}]
+	vc.Unlock()
+
+	result := make(map[int64]float64)
+	if segment == nil {
+		return result
+	}
+
+	idx := rra.Idx()
+	segment.Lock()
+	for i, dps := range segment.rows {
+		if v, ok := dps[idx]; ok {
+			result[i] = v
+		}
+	}
+	segment.Unlock()
+	return result
+}
+
 type vcStats struct {
 	// Currently in Vcache
 	dpSegments int
@@ -24,17 +24,19 @@ import (
 // DataSource contains a time series and its parameters, RRAs and
 // intermediate state (PDP). The DS PDP is the smallest unit of
 // accumulation for this series, all RRAs should have PDPs that are a
-// multiple of the DS PDP. The DS PDP supports only weighted mean as
-// its consolidation function. The reason for not supporting
-// min/max/last/avg (at least initially) is that additional state is
-// required to maintain those, while it seems like that is better done
-// in other places, e.g. the Aggregator anyhow.
+// multiple of the DS PDP. The DS PDP is a weighted mean. If any RRAs
+// are MAX, MIN or LAST, the DS also keeps a PDP for each of these
+// functions alongside, so that e.g. a MAX RRA records the true
+// maximum of the incoming data rather than the maximum of the
+// weighted means. These additional PDPs are not persisted, when a DS
+// is loaded they start out with the value of the weighted mean PDP.
 type DataSource struct {
 	Pdp
-	step       time.Duration        // Step (PDP) size
-	heartbeat  time.Duration        // Heartbeat is inactivity period longer than this causes NaN values. 0 -> no heartbeat.
-	lastUpdate time.Time            // Last time we received an update (series time - can be in the past or future)
-	rras       []RoundRobinArchiver // Array of Round Robin Archives
+	cfPdps     map[Consolidation]*Pdp // MAX, MIN and LAST PDPs, if RRAs need them
+	step       time.Duration          // Step (PDP) size
+	heartbeat  time.Duration          // Heartbeat is inactivity period longer than this causes NaN values. 0 -> no heartbeat.
+	lastUpdate time.Time              // Last time we received an update (series time - can be in the past or future)
+	rras       []RoundRobinArchiver   // Array of Round Robin Archives
 }
 
 // DataSourcer is a DataSource as an interface.
@@ -47,8 +49,10 @@ type DataSourcer interface {
 	SetRRAs(rras []RoundRobinArchiver)
 	Copy() DataSourcer
 	BestRRA(start, end time.Time, points int64) RoundRobinArchiver
+	BestRRAFunction(cf Consolidation, start, end time.Time, points int64) RoundRobinArchiver
 	PointCount() int
 	ClearRRAs()
+	ClearRRAsThrough(ts []time.Time)
 	ProcessDataPoint(value float64, ts time.Time) error
 	Spec() DSSpec
 }
@@ -70,10 +74,73 @@ func NewDataSource(spec DSSpec) *DataSource {
 		rra := NewRoundRobinArchive(rspec)
 		result.rras = append(result.rras, rra)
 	}
+	linkHWModels(result.rras)
+	result.initCfPdps()
 
 	return result
 }
 
+// initCfPdps makes sure there is a PDP for every MAX, MIN or LAST
+// consolidation the RRAs use (and none for those no longer used). A
+// new PDP is a copy of the weighted mean PDP, which is the best guess
+// we have. These PDPs are not persisted, so this is also what happens
+// to a partial step after a restart or a cluster transition, e.g. a
+// spike in it is averaged out of the MAX RRAs.
+func (ds *DataSource) initCfPdps() {
+	var pdps map[Consolidation]*Pdp
+	for _, rra := range ds.rras {
+		cf := rra.base().cf
+		if cf != MAX && cf != MIN && cf != LAST {
+			continue
+		}
+		if pdps == nil {
+			pdps = make(map[Consolidation]*Pdp)
+		}
+		if pdps[cf] != nil {
+			continue
+		}
+		if p := ds.cfPdps[cf]; p != nil {
+			pdps[cf] = p
+		} else {
+			pdps[cf] = &Pdp{value: ds.value, duration: ds.duration}
+		}
+	}
+	ds.cfPdps = pdps
+}
+
+// AddValue adds a value to the DS PDP as well as MAX, MIN and LAST
+// PDPs, if any.
+func (ds *DataSource) AddValue(val float64, dur time.Duration) {
+	ds.Pdp.AddValue(val, dur)
+	for cf, p := range ds.cfPdps {
+		switch cf {
+		case MAX:
+			p.AddValueMax(val, dur)
+		case MIN:
+			p.AddValueMin(val, dur)
+		case LAST:
+			p.AddValueLast(val, dur)
+		}
+	}
+}
+
+// SetValue sets the value and duration of all the DS PDPs.
+func (ds *DataSource) SetValue(val float64, dur time.Duration) {
+	ds.Pdp.SetValue(val, dur)
+	for _, p := range ds.cfPdps {
+		p.SetValue(val, dur)
+	}
+}
+
+// Reset resets all the DS PDPs and returns the weighted mean value
+// before Reset.
+func (ds *DataSource) Reset() float64 {
+	for _, p := range ds.cfPdps {
+		p.Reset()
+	}
+	return ds.Pdp.Reset()
+}
+
 // Step returns the step, i.e. the size of the PDP. All RRAs this DS
 // has must have steps that are a multiple of this Step.
 func (ds *DataSource) Step() time.Duration { return ds.step }
@@ -113,6 +180,8 @@ func (ds *DataSource) RRAs() []RoundRobinArchiver { return ds.rras }
 // SetRRAs provides a way to set the RRAs (which may contain data)
 func (ds *DataSource) SetRRAs(rras []RoundRobinArchiver) {
 	ds.rras = rras
+	linkHWModels(ds.rras)
+	ds.initCfPdps()
 	ds.checkLastUpdate()
 }
 
@@ -125,19 +194,49 @@ func (ds *DataSource) Copy() DataSourcer {
 		lastUpdate: ds.lastUpdate,
 		rras:       make([]RoundRobinArchiver, len(ds.rras)),
 	}
+	if ds.cfPdps != nil {
+		newDs.cfPdps = make(map[Consolidation]*Pdp, len(ds.cfPdps))
+		for cf, p := range ds.cfPdps {
+			newDs.cfPdps[cf] = &Pdp{value: p.value, duration: p.duration}
+		}
+	}
 	for n, rra := range ds.rras {
 		newDs.rras[n] = rra.Copy()
 	}
+	linkHWModels(newDs.rras)
 	return newDs
 }
 
 // BestRRA examines the RRAs and returns the one that best matches the
-// given start, end and resolution (as number of points).
+// given start, end and resolution (as number of points). Holt-Winters
+// RRAs are not considered, see BestRRAFunction().
 func (ds *DataSource) BestRRA(start, end time.Time, points int64) RoundRobinArchiver {
+	var rras []RoundRobinArchiver
+	for _, rra := range ds.rras {
+		if !IsHoltWinters(rra.Spec().Function) {
+			rras = append(rras, rra)
+		}
+	}
+	return bestRRA(rras, start, end, points)
+}
+
+// BestRRAFunction is same as BestRRA, but only considers RRAs with
+// the consolidation function cf.
+func (ds *DataSource) BestRRAFunction(cf Consolidation, start, end time.Time, points int64) RoundRobinArchiver {
+	var rras []RoundRobinArchiver
+	for _, rra := range ds.rras {
+		if rra.Spec().Function == cf {
+			rras = append(rras, rra)
+		}
+	}
+	return bestRRA(rras, start, end, points)
+}
+
+func bestRRA(rras []RoundRobinArchiver, start, end time.Time, points int64) RoundRobinArchiver {
 	var result []RoundRobinArchiver
 
 	// Any RRA include start?
-	for _, rra := range ds.rras {
+	for _, rra := range rras {
 		// We need to include RRAs that were last updated before start too
 		// or we end up with nothing, then the lowest resolution RRA
 		if rra.includes(start) || rra.Latest().Before(start) {
@@ -147,7 +246,7 @@ func (ds *DataSource) BestRRA(start, end time.Time, points int64) RoundRobinArch
 
 	if len(result) == 0 { // if we found nothing above, simply select the longest RRA
 		var longest RoundRobinArchiver
-		for _, rra := range ds.rras {
+		for _, rra := range rras {
 			if longest == nil || longest.Size()*int64(longest.Step()) < rra.Size()*int64(rra.Step()) {
 				longest = rra
 			}
@@ -336,16 +435,21 @@ func (ds *DataSource) ProcessDataPoint(value float64, ts time.Time) error {
 
 func (ds *DataSource) updateRRAs(periodBegin, periodEnd time.Time) {
 	for _, rra := range ds.rras {
+		// MAX, MIN and LAST RRAs get their own PDP
+		pdp := &ds.Pdp
+		if p := ds.cfPdps[rra.base().cf]; p != nil {
+			pdp = p
+		}
 		// If this is a multi ds.step update and the step of the RRA
 		// exceeds the interval, we cheat and send a larger duration
 		// once instead of iterating and updating in ds.step
 		// increments.
-		duration := ds.duration
+		duration := pdp.duration
 		span := periodEnd.Sub(periodBegin)
 		if span > ds.step && rra.Step() >= span {
 			duration = span
 		}
-		rra.update(periodBegin, periodEnd, ds.value, duration)
+		rra.update(periodBegin, periodEnd, pdp.value, duration)
 	}
 }
 
@@ -357,6 +461,18 @@ func (ds *DataSource) ClearRRAs() {
 	}
 }
 
+// ClearRRAsThrough clears the data points of every RRA in slots which
+// end at or before the time at the same position in ts (one per RRA,
+// in the order of RRAs()), leaving the more recent ones in place. A
+// zero time leaves the RRA as is.
+func (ds *DataSource) ClearRRAsThrough(ts []time.Time) {
+	for i, rra := range ds.rras {
+		if i < len(ts) && !ts[i].IsZero() {
+			rra.clearThrough(ts[i])
+		}
+	}
+}
+
 // Make sure that lastUpdated is not before the latest in RRAs. This
 // should never happen, but it is possible if we're loading a DS from
 // a database that somehow didn't get saved correctly.
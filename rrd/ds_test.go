@@ -384,6 +384,60 @@ func Test_DataSource_ProcessDataPoint_HB0(t *testing.T) {
 	}
 }
 
+func Test_DataSource_ProcessDataPoint_cfPdps(t *testing.T) {
+
+	ds := NewDataSource(DSSpec{
+		Step:      10 * time.Second,
+		Heartbeat: time.Hour,
+		RRAs: []RRASpec{
+			RRASpec{Function: WMEAN, Step: 10 * time.Second, Span: 100 * time.Second},
+			RRASpec{Function: MAX, Step: 10 * time.Second, Span: 100 * time.Second},
+			RRASpec{Function: MIN, Step: 10 * time.Second, Span: 100 * time.Second},
+			RRASpec{Function: LAST, Step: 10 * time.Second, Span: 100 * time.Second},
+			RRASpec{Function: MAX, Step: 20 * time.Second, Span: 200 * time.Second},
+		},
+	})
+	if len(ds.cfPdps) != 3 {
+		t.Errorf("expected 3 cfPdps, got %d", len(ds.cfPdps))
+	}
+
+	// 1 for 2s, a spike of 10 for 2s, then 2 for 6s
+	ds.ProcessDataPoint(0, time.Unix(100, 0))
+	ds.ProcessDataPoint(1, time.Unix(102, 0))
+	ds.ProcessDataPoint(10, time.Unix(104, 0))
+	ds.ProcessDataPoint(2, time.Unix(110, 0))
+	ds.ProcessDataPoint(3, time.Unix(113, 0))
+
+	for i, exp := range []float64{3.4, 10, 1, 2} {
+		if v := ds.rras[i].DPs()[1]; math.Abs(v-exp) > 1e-9 {
+			t.Errorf("%v RRA: expected %v, got %v", ds.rras[i].Spec().Function, exp, v)
+		}
+	}
+	// 20s MAX RRA PDP has the first step, the DS PDPs the 3s after
+	if ds.rras[4].Value() != 10 || ds.rras[4].Duration() != 10*time.Second {
+		t.Errorf("20s MAX RRA: expected PDP 10 for 10s, got %v for %v", ds.rras[4].Value(), ds.rras[4].Duration())
+	}
+	if ds.Value() != 3 || ds.cfPdps[MAX].Value() != 3 || ds.cfPdps[MAX].Duration() != 3*time.Second {
+		t.Errorf("unexpected DS PDPs: %v %v", ds.Value(), ds.cfPdps[MAX])
+	}
+
+	// cfPdps survive SetRRAs, new ones start out as WMEAN
+	max := ds.cfPdps[MAX]
+	ds.SetRRAs(ds.rras[1:2])
+	if len(ds.cfPdps) != 1 || ds.cfPdps[MAX] != max {
+		t.Errorf("SetRRAs: unexpected cfPdps: %v", ds.cfPdps)
+	}
+	ds.SetRRAs([]RoundRobinArchiver{&RoundRobinArchive{cf: MIN, step: 10 * time.Second, size: 10}})
+	if p := ds.cfPdps[MIN]; p == nil || p.value != ds.value || p.duration != ds.duration {
+		t.Errorf("SetRRAs: new MIN PDP should be a copy of DS PDP: %v", p)
+	}
+
+	cpy := ds.Copy().(*DataSource)
+	if !reflect.DeepEqual(ds.cfPdps, cpy.cfPdps) || cpy.cfPdps[MIN] == ds.cfPdps[MIN] {
+		t.Errorf("Copy: cfPdps not copied")
+	}
+}
+
 func Test_DataSource_ClearRRAs(t *testing.T) {
 
 	ds := &DataSource{step: 10 * time.Second}
@@ -409,6 +463,26 @@ func Test_DataSource_ClearRRAs(t *testing.T) {
 	}
 }
 
+func Test_DataSource_ClearRRAsThrough(t *testing.T) {
+
+	ds := &DataSource{step: 10 * time.Second}
+	// slot 0 ends at 100s, 9 at 90s and 8 at 80s
+	ds.SetRRAs([]RoundRobinArchiver{
+		&RoundRobinArchive{step: 10 * time.Second, size: 10, latest: time.Unix(100, 0),
+			dps: map[int64]float64{0: 1, 9: 2, 8: 3}},
+		&RoundRobinArchive{step: 10 * time.Second, size: 10, latest: time.Unix(100, 0),
+			dps: map[int64]float64{0: 1, 9: 2, 8: 3}},
+	})
+	ds.ClearRRAsThrough([]time.Time{time.Unix(80, 0)})
+	dps := ds.rras[0].DPs()
+	if len(dps) != 2 || dps[0] != 1 || dps[9] != 2 {
+		t.Errorf("ClearRRAsThrough: unexpected dps: %v", dps)
+	}
+	if dps := ds.rras[1].DPs(); len(dps) != 3 {
+		t.Errorf("ClearRRAsThrough: an RRA without a time should be left alone: %v", dps)
+	}
+}
+
 func Test_DataSource_Copy(t *testing.T) {
 
 	ds := &DataSource{
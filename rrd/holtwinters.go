@@ -0,0 +1,267 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rrd
+
+import (
+	"math"
+	"time"
+)
+
+// Holt-Winters aberration detection, modeled after RRDTool (see
+// rrdcreate(1) and Brutlag, "Aberrant Behavior Detection in Time
+// Series for Network Monitoring").
+//
+// Unlike the Holt-Winters DSL functions, which compute the forecast
+// from scratch every time they are called, this model is updated
+// incrementally as data arrives and its output is stored in RRAs,
+// which makes it cheap to query. A model consists of five RRAs of the
+// same step:
+//
+//   HWPREDICT   - the forecast for every slot, the span is arbitrary
+//   SEASONAL    - the seasonal coefficients, the span is the season
+//   DEVSEASONAL - the seasonal deviations, the span is the season
+//   DEVPREDICT  - the deviation forecast, the span is arbitrary
+//   FAILURES    - 1 where an aberration was detected, 0 otherwise
+//
+// The model is additive: for observation y, intercept a, slope b,
+// seasonal coefficient c and deviation d
+//
+//   prediction = a + b + c
+//   a' = alpha * (y - c) + (1 - alpha) * (a + b)
+//   b' = beta * (a' - a) + (1 - beta) * b
+//   c' = gamma * (y - a') + (1 - gamma) * c
+//   d' = gamma * |y - prediction| + (1 - gamma) * d
+//
+// An observation is a violation if it falls outside of prediction ±
+// delta * d. If at least threshold of the last window observations
+// are violations, it is a failure.
+//
+// The model state other than the seasonal arrays is kept in the
+// (otherwise unused) PDPs of the RRAs, so that it is persisted along
+// with the rest of the RRA state: the intercept is in SEASONAL, the
+// slope in DEVSEASONAL and the recent violations, as a bit mask, in
+// FAILURES.
+
+// HWParams are the Holt-Winters model parameters. Zero values are
+// replaced by defaults.
+type HWParams struct {
+	Alpha     float64 `json:"alpha"`     // Intercept smoothing
+	Beta      float64 `json:"beta"`      // Slope smoothing
+	Gamma     float64 `json:"gamma"`     // Seasonal and deviation smoothing
+	Delta     float64 `json:"delta"`     // Confidence band width in deviations
+	Threshold int     `json:"threshold"` // Number of violations in window that is a failure
+	Window    int     `json:"window"`    // Failure window in steps
+}
+
+// Default Holt-Winters parameters.
+var DefaultHWParams = HWParams{
+	Alpha:     0.1,
+	Beta:      0.0035,
+	Gamma:     0.1,
+	Delta:     2,
+	Threshold: 7,
+	Window:    9,
+}
+
+// Longest possible failure window, same as RRDTool.
+const MaxHWWindow = 28
+
+func (p HWParams) withDefaults() HWParams {
+	if p.Alpha == 0 {
+		p.Alpha = DefaultHWParams.Alpha
+	}
+	if p.Beta == 0 {
+		p.Beta = DefaultHWParams.Beta
+	}
+	if p.Gamma == 0 {
+		p.Gamma = DefaultHWParams.Gamma
+	}
+	if p.Delta == 0 {
+		p.Delta = DefaultHWParams.Delta
+	}
+	if p.Window == 0 {
+		p.Window = DefaultHWParams.Window
+	}
+	if p.Window > MaxHWWindow {
+		p.Window = MaxHWWindow
+	}
+	if p.Threshold == 0 {
+		p.Threshold = DefaultHWParams.Threshold
+	}
+	if p.Threshold > p.Window {
+		p.Threshold = p.Window
+	}
+	return p
+}
+
+// IsHoltWinters tells whether cf is one of the Holt-Winters functions.
+func IsHoltWinters(cf Consolidation) bool {
+	return cf >= HWPREDICT && cf <= FAILURES
+}
+
+// HWRRASpecs returns the specs of all the RRAs comprising a
+// Holt-Winters model. The forecast, deviation forecast and failures
+// span is span, the seasonal RRAs span is season.
+func HWRRASpecs(step, span, season time.Duration, params HWParams) []RRASpec {
+	return []RRASpec{
+		{Function: HWPREDICT, Step: step, Span: span, HW: params},
+		{Function: SEASONAL, Step: step, Span: season, HW: params},
+		{Function: DEVSEASONAL, Step: step, Span: season, HW: params},
+		{Function: DEVPREDICT, Step: step, Span: span, HW: params},
+		{Function: FAILURES, Step: step, Span: span, HW: params},
+	}
+}
+
+type hwModel struct {
+	predict, seasonal, devSeasonal, devPredict, failures *RoundRobinArchive
+}
+
+// linkHWModels (re)creates the Holt-Winters models from the RRAs. A
+// model needs all five of its RRAs of the same step, the RRAs of an
+// incomplete model are left alone and never updated.
+func linkHWModels(rras []RoundRobinArchiver) {
+	models := make(map[time.Duration]*hwModel)
+	for _, r := range rras {
+		rra := r.base()
+		if !IsHoltWinters(rra.cf) {
+			continue
+		}
+		rra.model = nil
+		m := models[rra.step]
+		if m == nil {
+			m = &hwModel{}
+			models[rra.step] = m
+		}
+		switch rra.cf {
+		case HWPREDICT:
+			m.predict = rra
+		case SEASONAL:
+			m.seasonal = rra
+		case DEVSEASONAL:
+			m.devSeasonal = rra
+		case DEVPREDICT:
+			m.devPredict = rra
+		case FAILURES:
+			m.failures = rra
+		}
+	}
+	for _, m := range models {
+		if m.predict != nil && m.seasonal != nil && m.devSeasonal != nil && m.devPredict != nil && m.failures != nil {
+			m.predict.model = m
+		}
+	}
+}
+
+// observe passes the HWPREDICT PDP to the model and resets it.
+func (rra *RoundRobinArchive) observe(endOfSlot time.Time) {
+	// Check XFF
+	known := float64(rra.duration) / float64(rra.step)
+	if known < float64(rra.xff) {
+		rra.SetValue(math.NaN(), 0)
+	}
+	if rra.model != nil {
+		rra.model.update(endOfSlot, rra.Value())
+	} else {
+		rra.latest = endOfSlot
+	}
+	rra.Reset()
+}
+
+// update the model with observation y in the slot ending at t.
+func (m *hwModel) update(t time.Time, y float64) {
+	p := m.predict.hw
+	s, ds := m.seasonal, m.devSeasonal
+	pos := SlotIndex(t, s.step, s.size)
+
+	if s.duration == 0 { // the model has never seen any data
+		if math.IsNaN(y) {
+			m.predict.latest = t
+			return
+		}
+		s.SetValue(y, s.step)
+		ds.SetValue(0, ds.step)
+	}
+
+	a, b := s.value, ds.value
+	c, haveC := s.coef[pos]
+	d, haveD := ds.coef[pos]
+
+	prediction := math.NaN()
+	if haveC {
+		prediction = a + b + c
+	}
+	devPrediction := math.NaN()
+	if haveD {
+		devPrediction = d
+	}
+
+	violation := false
+	if math.IsNaN(y) {
+		// Nothing to learn from, but the trend continues
+		s.value = a + b
+	} else {
+		if !haveC {
+			// First season: the coefficient is the difference
+			// from the trend
+			c = y - (a + b)
+		}
+		na := p.Alpha*(y-c) + (1-p.Alpha)*(a+b)
+		nb := p.Beta*(na-a) + (1-p.Beta)*b
+		nc := p.Gamma*(y-na) + (1-p.Gamma)*c
+		s.value, ds.value = na, nb
+		s.coef[pos] = nc
+		s.setDP(t, nc)
+
+		if haveC {
+			dev := math.Abs(y - prediction)
+			if haveD {
+				violation = dev > p.Delta*d
+				dev = p.Gamma*dev + (1-p.Gamma)*d
+			}
+			ds.coef[pos] = dev
+			ds.setDP(t, dev)
+		}
+	}
+
+	m.predict.setDP(t, prediction)
+	m.devPredict.setDP(t, devPrediction)
+
+	// The failures PDP is the bit mask of recent violations
+	f := m.failures
+	mask := int64(f.value)
+	if f.duration == 0 {
+		mask = 0
+	}
+	mask <<= 1
+	if violation {
+		mask |= 1
+	}
+	mask &= 1<<uint(p.Window) - 1
+	f.SetValue(float64(mask), f.step)
+	failed := 0.0
+	if bitCount(mask) >= p.Threshold {
+		failed = 1
+	}
+	f.setDP(t, failed)
+}
+
+func bitCount(n int64) int {
+	count := 0
+	for ; n != 0; n &= n - 1 {
+		count++
+	}
+	return count
+}
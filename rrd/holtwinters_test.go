@@ -0,0 +1,136 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rrd
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func newHWTestDs() *DataSource {
+	rras := []RRASpec{{Function: WMEAN, Step: time.Second, Span: 100 * time.Second}}
+	rras = append(rras, HWRRASpecs(time.Second, 100*time.Second, 4*time.Second, HWParams{})...)
+	return NewDataSource(DSSpec{
+		Step:      time.Second,
+		Heartbeat: time.Hour,
+		RRAs:      rras,
+	})
+}
+
+func hwTestRRA(ds *DataSource, cf Consolidation) *RoundRobinArchive {
+	for _, rra := range ds.rras {
+		if rra.Spec().Function == cf {
+			return rra.base()
+		}
+	}
+	return nil
+}
+
+func Test_HWParams_withDefaults(t *testing.T) {
+	p := HWParams{Alpha: 0.5, Window: 100, Threshold: 50}.withDefaults()
+	if p.Alpha != 0.5 || p.Beta != DefaultHWParams.Beta || p.Delta != DefaultHWParams.Delta {
+		t.Errorf("withDefaults: unexpected %#v", p)
+	}
+	if p.Window != MaxHWWindow || p.Threshold != MaxHWWindow {
+		t.Errorf("withDefaults: window and threshold should be capped at %d: %#v", MaxHWWindow, p)
+	}
+}
+
+func Test_HW_model(t *testing.T) {
+	ds := newHWTestDs()
+
+	predict := hwTestRRA(ds, HWPREDICT)
+	if predict.model == nil {
+		t.Fatalf("HWPREDICT RRA should have a model")
+	}
+
+	season := []float64{10, 20, 30, 20}
+	start := time.Unix(1000, 0)
+	var ts time.Time
+	for i := 0; i < 400; i++ {
+		// the value is for the step ending at ts
+		ts = start.Add(time.Duration(i) * time.Second)
+		ds.ProcessDataPoint(season[i%len(season)], ts)
+	}
+
+	slot := SlotIndex(predict.latest, predict.step, predict.size)
+	expect := season[399%len(season)]
+	if got := predict.dps[slot]; math.Abs(got-expect) > 1 {
+		t.Errorf("prediction %v too far from %v", got, expect)
+	}
+
+	seasonal := hwTestRRA(ds, SEASONAL)
+	if len(seasonal.coef) != len(season) {
+		t.Errorf("expected %d seasonal coefficients, got %d", len(season), len(seasonal.coef))
+	}
+
+	failures := hwTestRRA(ds, FAILURES)
+	if failures.dps[slot] != 0 {
+		t.Errorf("there should be no failure on regular data")
+	}
+
+	// Coefficients must survive clearing, the DPs do not
+	ds.ClearRRAs()
+	if len(seasonal.coef) != len(season) || len(seasonal.dps) != 0 {
+		t.Errorf("ClearRRAs: coef should stay (%d), dps should be cleared (%d)", len(seasonal.coef), len(seasonal.dps))
+	}
+
+	// An aberration
+	for i := 1; i <= 10; i++ {
+		ds.ProcessDataPoint(1000, ts.Add(time.Duration(i)*time.Second))
+	}
+	slot = SlotIndex(failures.latest, failures.step, failures.size)
+	if failures.dps[slot] != 1 {
+		t.Errorf("a failure should have been detected, mask: %v", failures.value)
+	}
+}
+
+func Test_HW_incomplete(t *testing.T) {
+	ds := NewDataSource(DSSpec{
+		Step:      time.Second,
+		Heartbeat: time.Hour,
+		RRAs:      HWRRASpecs(time.Second, 100*time.Second, 4*time.Second, HWParams{})[:2],
+	})
+	predict := hwTestRRA(ds, HWPREDICT)
+	if predict.model != nil {
+		t.Errorf("an incomplete set of RRAs should have no model")
+	}
+	start := time.Unix(1000, 0)
+	for i := 0; i < 10; i++ {
+		ds.ProcessDataPoint(1, start.Add(time.Duration(i)*time.Second))
+	}
+	if ds.PointCount() != 0 {
+		t.Errorf("an incomplete model should not produce any data")
+	}
+}
+
+func Test_HW_BestRRA_Copy(t *testing.T) {
+	ds := newHWTestDs()
+
+	if rra := ds.BestRRA(time.Time{}, time.Time{}, 0); rra == nil || rra.Spec().Function != WMEAN {
+		t.Errorf("BestRRA should only select the WMEAN RRA, got: %#v", rra)
+	}
+	if rra := ds.BestRRAFunction(DEVPREDICT, time.Time{}, time.Time{}, 0); rra == nil || rra.Spec().Function != DEVPREDICT {
+		t.Errorf("BestRRAFunction should select the DEVPREDICT RRA, got: %#v", rra)
+	}
+
+	cp := ds.Copy().(*DataSource)
+	model := hwTestRRA(cp, HWPREDICT).model
+	if model == nil || model.seasonal != hwTestRRA(cp, SEASONAL) {
+		t.Errorf("Copy: the model should be linked to the copied RRAs")
+	}
+}
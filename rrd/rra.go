@@ -16,7 +16,9 @@
 package rrd
 
 import (
+	"fmt"
 	"math"
+	"strings"
 	"time"
 )
 
@@ -27,8 +29,40 @@ const (
 	MAX                        // Max
 	MIN                        // Min
 	LAST                       // Last
+
+	// Holt-Winters aberration detection, see holtwinters.go. These
+	// are not consolidations of the data, but are maintained by a
+	// model as data arrives, and are never selected by BestRRA().
+	HWPREDICT   // Forecast
+	SEASONAL    // Seasonal coefficients
+	DEVSEASONAL // Seasonal deviations
+	DEVPREDICT  // Deviation forecast
+	FAILURES    // Aberration (1) or not (0)
 )
 
+var consolidationNames = []string{"WMEAN", "MAX", "MIN", "LAST",
+	"HWPREDICT", "SEASONAL", "DEVSEASONAL", "DEVPREDICT", "FAILURES"}
+
+// String returns the name of the consolidation, e.g. "WMEAN", as it
+// is stored in the database and used in the config.
+func (cf Consolidation) String() string {
+	if cf < 0 || int(cf) >= len(consolidationNames) {
+		return fmt.Sprintf("Consolidation(%d)", int(cf))
+	}
+	return consolidationNames[cf]
+}
+
+// ParseConsolidation returns the consolidation by its (case
+// insensitive) name, the reverse of String().
+func ParseConsolidation(name string) (Consolidation, error) {
+	for i, n := range consolidationNames {
+		if strings.EqualFold(n, name) {
+			return Consolidation(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid consolidation: %q (valid funcs: %s)", name, strings.ToLower(strings.Join(consolidationNames, ", ")))
+}
+
 // A Round Robin Archive and all its parameters.
 type RoundRobinArchive struct {
 	// Each RRA has its own PDP (duration and value). Note that
@@ -38,10 +72,10 @@ type RoundRobinArchive struct {
 	Pdp
 	// Consolidation function (CF). How data points from a
 	// higher-resolution RRA are aggregated into a lower-resolution
-	// one. Must be WMEAN, MAX, MIN, LAST. Note that since the DS PDP
-	// is always WMEAN, the RRA CF is limited to that value. E.g. MAX
-	// is not a true maximum, but the maximum of the DS PDPs, which in
-	// turn, are WMEAN.
+	// one. Must be WMEAN, MAX, MIN, LAST or one of the Holt-Winters
+	// functions. The DS keeps a PDP for each of MAX, MIN and LAST,
+	// thus e.g. MAX is the true maximum of the incoming data. Only
+	// the weighted mean DS PDP is saved though, see initCfPdps().
 	cf Consolidation
 	// The RRA step
 	step time.Duration
@@ -71,6 +105,13 @@ type RoundRobinArchive struct {
 	// having to store it. Slot numbers are aligned on millisecond,
 	// therefore an RRA step cannot be less than a millisecond.
 	dps map[int64]float64
+
+	// Holt-Winters parameters, model and (for SEASONAL and
+	// DEVSEASONAL) the full set of coefficients, which unlike dps
+	// survive clear().
+	hw    HWParams
+	model *hwModel
+	coef  map[int64]float64
 }
 
 // RoundRobinArchive as an interface
@@ -88,8 +129,10 @@ type RoundRobinArchiver interface {
 	// A side benefit from these being unexported is that you can only
 	// satisfy this interface by including this implementation
 	clear()
+	clearThrough(t time.Time)
 	includes(t time.Time) bool
 	update(periodBegin, periodEnd time.Time, value float64, duration time.Duration)
+	base() *RoundRobinArchive
 }
 
 // Latest returns the time on which the last slot ends.
@@ -122,6 +165,15 @@ func NewRoundRobinArchive(spec RRASpec) *RoundRobinArchive {
 	if len(spec.DPs) > 0 {
 		result.dps = spec.DPs
 	}
+	if IsHoltWinters(spec.Function) {
+		result.hw = spec.HW.withDefaults()
+		if spec.Function == SEASONAL || spec.Function == DEVSEASONAL {
+			result.coef = make(map[int64]float64, len(result.dps))
+			for k, v := range result.dps {
+				result.coef[k] = v
+			}
+		}
+	}
 	return result
 }
 
@@ -135,10 +187,17 @@ func (rra *RoundRobinArchive) Copy() RoundRobinArchiver {
 		latest: rra.latest,
 		xff:    rra.xff,
 		dps:    make(map[int64]float64, len(rra.dps)),
+		hw:     rra.hw,
 	}
 	for k, v := range rra.dps {
 		new_rra.dps[k] = v
 	}
+	if rra.coef != nil {
+		new_rra.coef = make(map[int64]float64, len(rra.coef))
+		for k, v := range rra.coef {
+			new_rra.coef[k] = v
+		}
+	}
 	return new_rra
 }
 
@@ -166,9 +225,12 @@ func (rra *RoundRobinArchive) Spec() RRASpec {
 		Step:     rra.step,
 		Span:     time.Duration(rra.size) * rra.step,
 		Xff:      rra.xff,
+		HW:       rra.hw,
 	}
 }
 
+func (rra *RoundRobinArchive) base() *RoundRobinArchive { return rra }
+
 // Includes tells whether the given time is within the RRA
 func (rra *RoundRobinArchive) includes(t time.Time) bool {
 	begin := rra.Begins(rra.latest)
@@ -178,6 +240,12 @@ func (rra *RoundRobinArchive) includes(t time.Time) bool {
 // update the RRA. If duration is less than the period, then the difference is considered unknown.
 func (rra *RoundRobinArchive) update(periodBegin, periodEnd time.Time, value float64, duration time.Duration) {
 
+	// Only HWPREDICT receives data, the rest of Holt-Winters RRAs
+	// are updated by its model.
+	if IsHoltWinters(rra.cf) && rra.cf != HWPREDICT {
+		return
+	}
+
 	// currentBegin is a cursor pointing at the beginning of the
 	// current slot, currentEnd points at its end. We start out
 	// with currentBegin pointing at the slot one RRA-length ago
@@ -200,7 +268,7 @@ func (rra *RoundRobinArchive) update(periodBegin, periodEnd time.Time, value flo
 		}
 
 		switch rra.cf {
-		case WMEAN:
+		case WMEAN, HWPREDICT:
 			if duration == rra.step && math.IsNaN(value) {
 				// Special case, a whole NaN gets recorded as NaN. This
 				// happens when a period is filled with NaNs due to HB
@@ -219,7 +287,11 @@ func (rra *RoundRobinArchive) update(periodBegin, periodEnd time.Time, value flo
 
 		// if end of slot, move PDP into its place in dps.
 		if currentEnd.Equal(endOfSlot) {
-			rra.movePdpToDps(endOfSlot)
+			if rra.cf == HWPREDICT {
+				rra.observe(endOfSlot)
+			} else {
+				rra.movePdpToDps(endOfSlot)
+			}
 		}
 
 		// move up the cursor
@@ -236,20 +308,24 @@ func (rra *RoundRobinArchive) movePdpToDps(endOfSlot time.Time) {
 		rra.SetValue(math.NaN(), 0)
 	}
 
+	rra.setDP(endOfSlot, rra.value)
+	rra.Reset()
+}
+
+// setDP stores value in the slot ending at endOfSlot, which becomes
+// the latest.
+func (rra *RoundRobinArchive) setDP(endOfSlot time.Time, value float64) {
 	if rra.dps == nil {
 		rra.dps = make(map[int64]float64)
 	}
-
 	slotN := SlotIndex(endOfSlot, rra.step, rra.size)
 	rra.latest = endOfSlot
-	if math.IsNaN(rra.value) {
+	if math.IsNaN(value) {
 		// No value is better than storing a NaN
 		delete(rra.dps, slotN)
 	} else {
-		rra.dps[slotN] = rra.value
+		rra.dps[slotN] = value
 	}
-
-	rra.Reset()
 }
 
 // clears the data in dps
@@ -259,6 +335,15 @@ func (rra *RoundRobinArchive) clear() {
 	}
 }
 
+// clears the data points in slots ending at or before t
+func (rra *RoundRobinArchive) clearThrough(t time.Time) {
+	for n, _ := range rra.dps {
+		if !SlotTime(n, rra.latest, rra.step, rra.size).After(t) {
+			delete(rra.dps, n)
+		}
+	}
+}
+
 // Given a slot timestamp, RRA step and size, return the slot's
 // (0-based) index in the data points array. Size of zero causes a
 // division by zero panic.
@@ -287,6 +372,7 @@ type RRASpec struct {
 	Step     time.Duration // duration of a single step
 	Span     time.Duration // duration of the whole series (should be multiple of step)
 	Xff      float32
+	HW       HWParams // Holt-Winters functions only
 
 	// These can be used to fill the initial value
 	Latest   time.Time
@@ -343,3 +343,21 @@ func Test_RoundRobinArchive_update(t *testing.T) {
 	}
 
 }
+
+func Test_Consolidation_String(t *testing.T) {
+	for cf := WMEAN; cf <= FAILURES; cf++ {
+		name := cf.String()
+		if got, err := ParseConsolidation(name); err != nil || got != cf {
+			t.Errorf("ParseConsolidation(%q): expected %d, got %d (%v)", name, cf, got, err)
+		}
+	}
+	if cf, err := ParseConsolidation("wmean"); err != nil || cf != WMEAN {
+		t.Errorf("ParseConsolidation should be case insensitive: %v %v", cf, err)
+	}
+	if _, err := ParseConsolidation("AVERAGE"); err == nil {
+		t.Errorf("ParseConsolidation(AVERAGE): expected an error")
+	}
+	if s := Consolidation(42).String(); s != "Consolidation(42)" {
+		t.Errorf("String() of an invalid cf: %q", s)
+	}
+}
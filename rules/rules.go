@@ -0,0 +1,207 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rules is a pipeline of rules for rewriting and filtering
+// the idents of incoming data points, much like what a carbon-relay
+// in front of Tgres would do.
+//
+// Rules are applied in order, each rule to the result of the
+// previous one. A rule applies to one tag ("name" by default) and
+// only if its Match regular expression (if any) matches the value of
+// that tag. The actions are:
+//
+//	rewrite - replace the value of the tag with Replace, which can
+//	          refer to submatches as $1, ${name}, etc (see
+//	          regexp.Expand)
+//	drop    - drop the data point
+//	allow   - drop the data point unless it matches
+//	tag     - set the tags in Set, values can refer to submatches
+//	extract - set tags from dot-separated components of the tag
+//	          value, Components names the tag for each component,
+//	          "" means skip; with Strip the extracted components are
+//	          removed from the value
+//
+// For example, given "servers.web1.cpu.user", an extract rule with
+// Components ["", "host"] and Strip results in
+// {"name":"servers.cpu.user", "host":"web1"}.
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/tgres/tgres/serde"
+)
+
+type Action int
+
+const (
+	Rewrite Action = iota
+	Drop
+	Allow
+	Tag
+	Extract
+)
+
+var actionNames = []string{"rewrite", "drop", "allow", "tag", "extract"}
+
+func (a Action) String() string {
+	if a >= Rewrite && a <= Extract {
+		return actionNames[a]
+	}
+	return fmt.Sprintf("Action(%d)", a)
+}
+
+// ParseAction converts an action name to an Action.
+func ParseAction(s string) (Action, error) {
+	for i, name := range actionNames {
+		if strings.ToLower(s) == name {
+			return Action(i), nil
+		}
+	}
+	return Rewrite, fmt.Errorf("invalid rule action: %q (must be one of %s)", s, strings.Join(actionNames, ", "))
+}
+
+// A Rule is a step of the pipeline, see package documentation.
+type Rule struct {
+	Action     Action
+	Tag        string            // the tag this rule applies to, "name" if blank
+	Match      *regexp.Regexp    // nil matches everything
+	Replace    string            // rewrite
+	Set        map[string]string // tag
+	Components []string          // extract
+	Strip      bool              // extract
+}
+
+// Validate checks that the rule has what its action requires.
+func (r *Rule) Validate() error {
+	switch r.Action {
+	case Rewrite, Drop, Allow:
+		if r.Match == nil {
+			return fmt.Errorf("%v rule requires match", r.Action)
+		}
+	case Tag:
+		if len(r.Set) == 0 {
+			return fmt.Errorf("tag rule requires set")
+		}
+	case Extract:
+		if len(r.Components) == 0 {
+			return fmt.Errorf("extract rule requires components")
+		}
+	default:
+		return fmt.Errorf("invalid rule action: %v", r.Action)
+	}
+	return nil
+}
+
+func (r *Rule) tag() string {
+	if r.Tag == "" {
+		return "name"
+	}
+	return r.Tag
+}
+
+// apply returns the new ident, which is the same map if nothing
+// changed, and false if the data point is to be dropped.
+func (r *Rule) apply(ident serde.Ident) (serde.Ident, bool) {
+	val, ok := ident[r.tag()]
+
+	var match []int
+	if r.Match != nil {
+		if ok {
+			match = r.Match.FindStringSubmatchIndex(val)
+		}
+		if match == nil {
+			return ident, r.Action != Allow
+		}
+	} else if !ok && r.Action != Tag {
+		return ident, true
+	}
+
+	switch r.Action {
+	case Drop:
+		return nil, false
+	case Allow:
+		return ident, true
+	case Rewrite:
+		dst := r.Match.ExpandString(nil, r.Replace, val, match)
+		return with(ident, map[string]string{r.tag(): string(dst)}), true
+	case Tag:
+		set := make(map[string]string, len(r.Set))
+		for k, v := range r.Set {
+			if match != nil {
+				v = string(r.Match.ExpandString(nil, v, val, match))
+			}
+			set[k] = v
+		}
+		return with(ident, set), true
+	case Extract:
+		parts := strings.Split(val, ".")
+		set := make(map[string]string, len(r.Components)+1)
+		var kept []string
+		for i, part := range parts {
+			if i < len(r.Components) && r.Components[i] != "" {
+				set[r.Components[i]] = part
+				if r.Strip {
+					continue
+				}
+			}
+			kept = append(kept, part)
+		}
+		if r.Strip {
+			set[r.tag()] = strings.Join(kept, ".")
+		}
+		return with(ident, set), true
+	}
+	return ident, true
+}
+
+// with returns a copy of ident with tags set. Setting a tag to "",
+// removes it.
+func with(ident serde.Ident, set map[string]string) serde.Ident {
+	result := make(serde.Ident, len(ident)+len(set))
+	for k, v := range ident {
+		result[k] = v
+	}
+	for k, v := range set {
+		if v == "" {
+			delete(result, k)
+		} else {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// Rules is the pipeline.
+type Rules []*Rule
+
+// Apply applies the rules to ident and returns the resulting ident
+// and true, or nil and false if the data point should be dropped. The
+// ident passed in is not modified. A data point whose name ends up
+// blank is dropped.
+func (rs Rules) Apply(ident serde.Ident) (serde.Ident, bool) {
+	for _, r := range rs {
+		var ok bool
+		if ident, ok = r.apply(ident); !ok {
+			return nil, false
+		}
+	}
+	if len(rs) > 0 && ident["name"] == "" {
+		return nil, false
+	}
+	return ident, true
+}
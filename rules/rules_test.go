@@ -0,0 +1,96 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rules
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/tgres/tgres/serde"
+)
+
+func Test_ParseAction(t *testing.T) {
+	for i, name := range actionNames {
+		if a, err := ParseAction(name); err != nil || a != Action(i) || a.String() != name {
+			t.Errorf("ParseAction(%q): %v %v", name, a, err)
+		}
+	}
+	if _, err := ParseAction("explode"); err == nil {
+		t.Errorf("ParseAction: expected error")
+	}
+}
+
+func Test_Rule_Validate(t *testing.T) {
+	for _, r := range []*Rule{
+		{Action: Rewrite},
+		{Action: Drop},
+		{Action: Allow},
+		{Action: Tag},
+		{Action: Extract},
+		{Action: Action(99)},
+	} {
+		if err := r.Validate(); err == nil {
+			t.Errorf("Validate: expected error for %v", r.Action)
+		}
+	}
+}
+
+func Test_Rules_Apply(t *testing.T) {
+	rs := Rules{
+		{Action: Drop, Match: regexp.MustCompile(`^junk\.`)},
+		{Action: Allow, Match: regexp.MustCompile(`^(servers|apps)\.`)},
+		{Action: Rewrite, Match: regexp.MustCompile(`^apps\.(.*)$`), Replace: "applications.$1"},
+		{Action: Extract, Match: regexp.MustCompile(`^servers\.`), Components: []string{"", "host"}, Strip: true},
+		{Action: Tag, Set: map[string]string{"dc": "east"}},
+		{Action: Tag, Tag: "host", Match: regexp.MustCompile(`^(web)\d+$`), Set: map[string]string{"role": "$1"}},
+	}
+
+	for _, c := range []struct {
+		in     serde.Ident
+		expect serde.Ident
+	}{
+		{serde.Ident{"name": "junk.foo"}, nil},
+		{serde.Ident{"name": "other.foo"}, nil},
+		{serde.Ident{"name": "apps.foo"}, serde.Ident{"name": "applications.foo", "dc": "east"}},
+		{serde.Ident{"name": "servers.web1.cpu"}, serde.Ident{"name": "servers.cpu", "host": "web1", "dc": "east", "role": "web"}},
+		{serde.Ident{"name": "servers.db1.cpu"}, serde.Ident{"name": "servers.cpu", "host": "db1", "dc": "east"}},
+	} {
+		in := serde.Ident{}
+		for k, v := range c.in {
+			in[k] = v
+		}
+		out, ok := rs.Apply(in)
+		if ok != (c.expect != nil) || !reflect.DeepEqual(out, c.expect) {
+			t.Errorf("Apply(%v): expected %v, got %v %v", c.in, c.expect, out, ok)
+		}
+		if !reflect.DeepEqual(in, c.in) {
+			t.Errorf("Apply(%v): input modified: %v", c.in, in)
+		}
+	}
+
+	// a blank name is dropped
+	rs = Rules{{Action: Rewrite, Match: regexp.MustCompile(`.*`), Replace: ""}}
+	if _, ok := rs.Apply(serde.Ident{"name": "foo"}); ok {
+		t.Errorf("Apply: blank name should be dropped")
+	}
+
+	// no rules, no change
+	in := serde.Ident{"name": "foo"}
+	if out, ok := Rules(nil).Apply(in); !ok || !reflect.DeepEqual(out, in) {
+		t.Errorf("Apply: no rules: %v %v", out, ok)
+	}
+}
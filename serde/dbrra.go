@@ -137,6 +137,7 @@ type rraRecord struct {
 	idx      int64
 	cf       string
 	xff      float32
+	hw       []byte // JSON, Holt-Winters only
 }
 
 type rraStateRecord struct {
@@ -18,9 +18,11 @@ package serde
 import (
 	"database/sql"
 	"fmt"
-	"log"
 	"math"
 	"time"
+
+	"github.com/tgres/tgres/logging"
+	"github.com/tgres/tgres/tracing"
 )
 
 type dbSeries struct {
@@ -48,6 +50,16 @@ type dbSeries struct {
 
 	// Alias
 	alias string
+
+	// Tracing, see Trace()
+	span, qspan *tracing.Span
+	nrows       int
+}
+
+// Trace makes every query a child span of sp, the span lasts until
+// Close().
+func (dps *dbSeries) Trace(sp *tracing.Span) {
+	dps.span = sp
 }
 
 func (dps *dbSeries) Step() time.Duration {
@@ -128,7 +140,7 @@ func (dps *dbSeries) seriesQuerySqlUsingViewAndSeries() (*sql.Rows, error) {
 
 	aligned_from := dps.from.Truncate(time.Duration(finalGroupByMs) * time.Millisecond)
 
-	if debug {
+	if logger.Enabled(logging.Debug) {
 		dbFormat := "2006-01-02 15:04:05 -0700"
 		sqlStatement := fmt.Sprintf(
 			"\nSELECT max(tg) mt, avg(r) ar\n"+
@@ -140,12 +152,12 @@ func (dps *dbSeries) seriesQuerySqlUsingViewAndSeries() (*sql.Rows, error) {
 			aligned_from.Format(dbFormat), dps.to.Format(dbFormat), fmt.Sprintf("%d milliseconds", rraStepMs),
 			dps.ds.Id(), dps.rra.Id(), dps.from.Format(dbFormat), dps.to.Format(dbFormat),
 			finalGroupByMs)
-		log.Printf("seriesQuerySqlUsingViewAndSeries() sqlSelectSeries -- " + sqlStatement)
+		logger.Debugf("seriesQuerySqlUsingViewAndSeries() sqlSelectSeries -- %s", sqlStatement)
 	}
 	rows, err = dps.db.sqlSelectSeries.Query(aligned_from, dps.to, fmt.Sprintf("%d milliseconds", rraStepMs), dps.ds.Id(), dps.rra.Id(), dps.from, dps.to, finalGroupByMs)
 
 	if err != nil {
-		log.Printf("seriesQuery(): error %v", err)
+		logger.Errorf("seriesQuery(): error %v", err)
 		return nil, err
 	}
 
@@ -155,18 +167,29 @@ func (dps *dbSeries) seriesQuerySqlUsingViewAndSeries() (*sql.Rows, error) {
 func (dps *dbSeries) Next() bool {
 
 	if dps.rows == nil { // First Next()
+		if dps.qspan = dps.span.Child("serde.series_query"); dps.qspan != nil {
+			dps.qspan.SetKind(tracing.KindClient)
+			dps.qspan.SetAttr("db.system", "postgresql")
+			dps.qspan.SetAttr("rra_id", dps.rra.Id())
+			dps.qspan.SetAttr("from", dps.from)
+			dps.qspan.SetAttr("to", dps.to)
+			dps.nrows = 0
+		}
 		rows, err := dps.seriesQuerySqlUsingViewAndSeries()
 		if err == nil {
 			dps.rows = rows
 		} else {
-			log.Printf("dbSeries.Next(): database error: %v", err)
+			logger.Errorf("dbSeries.Next(): database error: %v", err)
+			dps.qspan.SetError(err)
+			dps.qspan.End()
 			return false
 		}
 	}
 
 	if dps.rows.Next() {
+		dps.nrows++
 		if ts, value, err := timeValueFromRow(dps.rows); err != nil {
-			log.Printf("dbSeries.Next(): database error: %v", err)
+			logger.Errorf("dbSeries.Next(): database error: %v", err)
 			return false
 		} else {
 			dps.posBegin = dps.latest
@@ -193,6 +216,11 @@ func (dps *dbSeries) Close() error {
 	}
 	result := dps.rows.Close()
 	dps.rows = nil // next Next() will re-open
+	if dps.qspan != nil {
+		dps.qspan.SetAttr("rows", dps.nrows)
+		dps.qspan.End()
+		dps.qspan = nil
+	}
 	return result
 }
 
@@ -16,6 +16,7 @@
 package serde
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
@@ -89,8 +90,40 @@ func (m *memSerDe) FetchOrCreateDataSource(ident Ident, dsSpec *rrd.DSSpec) (rrd
 	if ds, ok := m.byIdent[ident.String()]; ok {
 		return ds, nil
 	}
+	if dsSpec == nil {
+		return nil, nil
+	}
 	m.lastId++
 	ds := NewDbDataSource(m.lastId, ident, 0, 0, rrd.NewDataSource(*dsSpec))
 	m.byIdent[ident.String()] = ds
 	return ds, nil
 }
+
+func (m *memSerDe) DeleteDataSource(ident Ident) error {
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.byIdent[ident.String()]; !ok {
+		return fmt.Errorf("DeleteDataSource: no such DS: %v", ident)
+	}
+	delete(m.byIdent, ident.String())
+	return nil
+}
+
+func (m *memSerDe) RenameDataSource(from, to Ident) error {
+	m.Lock()
+	defer m.Unlock()
+	if len(to) == 0 {
+		return fmt.Errorf("RenameDataSource: new ident is empty")
+	}
+	ds, ok := m.byIdent[from.String()]
+	if !ok {
+		return fmt.Errorf("RenameDataSource: no such DS: %v", from)
+	}
+	if _, ok := m.byIdent[to.String()]; ok {
+		return fmt.Errorf("RenameDataSource: DS already exists: %v", to)
+	}
+	delete(m.byIdent, from.String())
+	ds.ident = to
+	m.byIdent[to.String()] = ds
+	return nil
+}
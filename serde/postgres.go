@@ -32,10 +32,10 @@
 package serde
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
 	"math"
 	"math/rand"
 	"os"
@@ -43,6 +43,7 @@ import (
 	"time"
 
 	"github.com/lib/pq"
+	"github.com/tgres/tgres/logging"
 	"github.com/tgres/tgres/rrd"
 	"github.com/tgres/tgres/series"
 )
@@ -99,12 +100,20 @@ func (p *pgvSerDe) Flusher() Flusher             { return p }
 func (p *pgvSerDe) EventListener() EventListener { return p }
 func (p *pgvSerDe) DbAddresser() DbAddresser     { return p }
 
+// Ping checks that the database is reachable, giving up after
+// timeout.
+func (p *pgvSerDe) Ping(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return p.dbConn.PingContext(ctx)
+}
+
 // A hack to use the DB to see who else is connected
 func (p *pgvSerDe) ListDbClientIps() ([]string, error) {
 	const sql = "SELECT DISTINCT(client_addr) FROM pg_stat_activity"
 	rows, err := p.dbConn.Query(sql)
 	if err != nil {
-		log.Printf("ListDbClientIps(): error querying database: %v", err)
+		logger.Errorf("ListDbClientIps(): error querying database: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -113,7 +122,7 @@ func (p *pgvSerDe) ListDbClientIps() ([]string, error) {
 	for rows.Next() {
 		var addr *string
 		if err := rows.Scan(&addr); err != nil {
-			log.Printf("ListDbClientIps(): error scanning row: %v", err)
+			logger.Errorf("ListDbClientIps(): error scanning row: %v", err)
 			return nil, err
 		}
 		if addr != nil {
@@ -129,7 +138,7 @@ func (p *pgvSerDe) MyDbAddr() (*string, error) {
 	sql := fmt.Sprintf("SELECT client_addr FROM pg_stat_activity WHERE query LIKE '%%%s%%'", randToken)
 	rows, err := p.dbConn.Query(sql)
 	if err != nil {
-		log.Printf("myPostgresAddr(): error querying database: %v", err)
+		logger.Errorf("myPostgresAddr(): error querying database: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -137,11 +146,11 @@ func (p *pgvSerDe) MyDbAddr() (*string, error) {
 	for rows.Next() {
 		var addr *string
 		if err := rows.Scan(&addr); err != nil {
-			log.Printf("myPostgresAddr(): error scanning row: %v", err)
+			logger.Errorf("myPostgresAddr(): error scanning row: %v", err)
 			return nil, err
 		}
 		if addr != nil {
-			log.Printf("myPostgresAddr(): %s", *addr)
+			logger.Infof("myPostgresAddr(): %s", *addr)
 			return addr, nil
 		}
 	}
@@ -200,13 +209,13 @@ func (p *pgvSerDe) prepareSqlStatements() error {
 		return err
 	}
 	if p.sqlInsertRRA, err = p.dbConn.Prepare(fmt.Sprintf(
-		"INSERT INTO %[1]srra AS rra (ds_id, rra_bundle_id, pos, seg, idx, cf, xff) VALUES ($1, $2, $3, $4, $5, $6, $7) "+
+		"INSERT INTO %[1]srra AS rra (ds_id, rra_bundle_id, pos, seg, idx, cf, xff, hw) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) "+
 			"ON CONFLICT (ds_id, rra_bundle_id, cf) DO UPDATE SET ds_id = rra.ds_id "+
-			"RETURNING id, ds_id, rra_bundle_id, pos, seg, idx, cf, xff", p.prefix)); err != nil {
+			"RETURNING id, ds_id, rra_bundle_id, pos, seg, idx, cf, xff, hw", p.prefix)); err != nil {
 		return err
 	}
 	if p.sqlSelectRRAsByDsId, err = p.dbConn.Prepare(fmt.Sprintf(
-		"SELECT id, ds_id, rra_bundle_id, pos, seg, idx, cf, xff FROM %[1]srra rra WHERE ds_id = $1 ",
+		"SELECT id, ds_id, rra_bundle_id, pos, seg, idx, cf, xff, hw FROM %[1]srra rra WHERE ds_id = $1 ",
 		p.prefix)); err != nil {
 		return err
 	}
@@ -290,6 +299,7 @@ func (p *pgvSerDe) createTablesIfNotExist() error {
        seg INT NOT NULL,
        idx INT NOT NULL,
        xff REAL NOT NULL DEFAULT 0,
+       hw JSONB,
        value DOUBLE PRECISION NOT NULL DEFAULT 'NaN',
        duration_ms BIGINT NOT NULL DEFAULT 0);
 
@@ -309,7 +319,7 @@ func (p *pgvSerDe) createTablesIfNotExist() error {
        )
     `
 	if _, err := p.dbConn.Exec(fmt.Sprintf(create_sql, p.prefix, PgSegmentWidth)); err != nil {
-		log.Printf("ERROR: initial CREATE TABLE failed: %v", err)
+		logger.Errorf("initial CREATE TABLE failed: %v", err)
 		return err
 	}
 
@@ -385,7 +395,22 @@ END
 $$;
 `
 	if _, err := p.dbConn.Exec(fmt.Sprintf(migrate_sql, p.prefix, PgSegmentWidth)); err != nil {
-		log.Printf("ERROR: migrate failed: %v", err)
+		logger.Errorf("migrate failed: %v", err)
+		return err
+	}
+
+	// Holt-Winters parameters were added later
+	migrate_sql = `
+DO $$
+BEGIN
+  IF (SELECT COUNT(1) FROM information_schema.columns WHERE table_name='%[1]srra' and column_name='hw') = 0 THEN
+    ALTER TABLE %[1]srra ADD COLUMN hw JSONB;
+  END IF;
+END
+$$;
+`
+	if _, err := p.dbConn.Exec(fmt.Sprintf(migrate_sql, p.prefix)); err != nil {
+		logger.Errorf("migrate (rra hw) failed: %v", err)
 		return err
 	}
 
@@ -500,7 +525,7 @@ COMMIT;
 `
 	if _, err := p.dbConn.Exec(fmt.Sprintf(create_sql, p.prefix)); err != nil {
 		//if !strings.Contains(err.Error(), "already exists") {
-		log.Printf("ERROR: initial CREATE VIEW failed: %v", err)
+		logger.Errorf("initial CREATE VIEW failed: %v", err)
 		return err
 		//}
 	}
@@ -509,23 +534,26 @@ COMMIT;
 BEGIN;
 DROP TRIGGER IF EXISTS %[1]sds_delete_trigger ON %[1]sds;
 
+-- A renamed DS is deleted as far as the listeners are concerned.
 CREATE OR REPLACE FUNCTION %[1]sds_delete_notify() RETURNS TRIGGER AS
 $body$
   BEGIN
-    PERFORM pg_notify('%[1]sds_delete_event', OLD.ident::text);
+    IF TG_OP = 'DELETE' OR OLD.ident <> NEW.ident THEN
+      PERFORM pg_notify('%[1]sds_delete_event', OLD.ident::text);
+    END IF;
     RETURN NULL;
   END;
 $body$
 LANGUAGE plpgsql;
 
-CREATE TRIGGER %[1]sds_delete_trigger AFTER DELETE ON %[1]sds
+CREATE TRIGGER %[1]sds_delete_trigger AFTER DELETE OR UPDATE OF ident ON %[1]sds
   FOR EACH ROW
   EXECUTE PROCEDURE %[1]sds_delete_notify();
 
 COMMIT;
 `
 	if _, err := p.dbConn.Exec(fmt.Sprintf(create_sql, p.prefix)); err != nil {
-		log.Printf("ERROR: initial CREATE TRIGGER failed: %v", err)
+		logger.Errorf("initial CREATE TRIGGER failed: %v", err)
 		return err
 	}
 
@@ -537,7 +565,7 @@ func rraBundleRecordFromRow(rows *sql.Rows) (*rraBundleRecord, error) {
 	// id, step_ms, size, width
 	err := rows.Scan(&bundle.id, &bundle.stepMs, &bundle.size, &bundle.width)
 	if err != nil {
-		log.Printf("rraBundleRecordFromRow(): error scanning row: %v", err)
+		logger.Errorf("rraBundleRecordFromRow(): error scanning row: %v", err)
 		return nil, err
 	}
 	return &bundle, nil
@@ -546,9 +574,9 @@ func rraBundleRecordFromRow(rows *sql.Rows) (*rraBundleRecord, error) {
 func rraRecordFromRow(rows *sql.Rows) (*rraRecord, error) {
 
 	var rra rraRecord
-	err := rows.Scan(&rra.id, &rra.dsId, &rra.bundleId, &rra.pos, &rra.seg, &rra.idx, &rra.cf, &rra.xff)
+	err := rows.Scan(&rra.id, &rra.dsId, &rra.bundleId, &rra.pos, &rra.seg, &rra.idx, &rra.cf, &rra.xff, &rra.hw)
 	if err != nil {
-		log.Printf("rraRecordFromRow(): error scanning row: %v", err)
+		logger.Errorf("rraRecordFromRow(): error scanning row: %v", err)
 		return nil, err
 	}
 
@@ -579,22 +607,21 @@ func rraFromRRARecordStateAndBundle(rraRec *rraRecord, stateRec *rraStateRecord,
 		Duration: time.Duration(*stateRec.durationMs) * time.Millisecond,
 	}
 
-	switch strings.ToUpper(rraRec.cf) {
-	case "WMEAN":
-		spec.Function = rrd.WMEAN
-	case "MIN":
-		spec.Function = rrd.MIN
-	case "MAX":
-		spec.Function = rrd.MAX
-	case "LAST":
-		spec.Function = rrd.LAST
-	default:
-		return nil, fmt.Errorf("rraFromRRARecordAndBundle(): Invalid cf: %q (valid funcs: wmean, min, max, last)", rraRec.cf)
+	cf, err := rrd.ParseConsolidation(rraRec.cf)
+	if err != nil {
+		return nil, fmt.Errorf("rraFromRRARecordAndBundle(): %v", err)
+	}
+	spec.Function = cf
+
+	if len(rraRec.hw) > 0 {
+		if err := json.Unmarshal(rraRec.hw, &spec.HW); err != nil {
+			return nil, fmt.Errorf("rraFromRRARecordAndBundle(): Invalid hw: %q: %v", rraRec.hw, err)
+		}
 	}
 
 	rra, err := newDbRoundRobinArchive(rraRec.id, bundle.width, bundle.id, rraRec.pos, spec)
 	if err != nil {
-		log.Printf("rraFromRRARecordAndBundle(): error creating rra: %v", err)
+		logger.Errorf("rraFromRRARecordAndBundle(): error creating rra: %v", err)
 		return nil, err
 	}
 	return rra, nil
@@ -616,7 +643,7 @@ func (p *pgvSerDe) Search(query SearchQuery) (SearchResult, error) {
 
 	rows, err := p.dbConn.Query(fmt.Sprintf(sql, p.prefix), args...)
 	if err != nil {
-		log.Printf("Search(): error querying database: %v", err)
+		logger.Errorf("Search(): error querying database: %v", err)
 		return nil, err
 	}
 
@@ -629,7 +656,7 @@ func (p *pgvSerDe) FetchDataSources() ([]rrd.DataSourcer, error) {
 	// I'm not exactly sure why.
 	const sql = `
 WITH rra AS (
-  SELECT rra.id, rra.ds_id, rra.rra_bundle_id, rra.pos, rra.seg, rra.idx, rra.cf, rra.xff,
+  SELECT rra.id, rra.ds_id, rra.rra_bundle_id, rra.pos, rra.seg, rra.idx, rra.cf, rra.xff, rra.hw,
          rs.latest[rra.idx] AS latest, rs.value[rra.idx] AS value, rs.duration_ms[rra.idx] AS duration_ms,
          b.step_ms, b.size, b.width
     FROM %[1]srra
@@ -649,7 +676,7 @@ SELECT ds.id, ds.ident, ds.step_ms,
            ds.lastupdate,
            ds.ds_value,
            ds.ds_duration_ms,
-           rra.id, rra.rra_bundle_id, rra.pos, rra.seg, rra.idx, rra.cf, rra.xff,
+           rra.id, rra.rra_bundle_id, rra.pos, rra.seg, rra.idx, rra.cf, rra.xff, rra.hw,
            rra.step_ms, rra.size, rra.width,
            rra.latest,
            rra.value,
@@ -661,7 +688,7 @@ SELECT ds.id, ds.ident, ds.step_ms,
 
 	rows, err := p.dbConn.Query(fmt.Sprintf(sql, p.prefix))
 	if err != nil {
-		log.Printf("FetchDataSources(): error querying database: %v", err)
+		logger.Errorf("FetchDataSources(): error querying database: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -688,7 +715,7 @@ SELECT ds.id, ds.ident, ds.step_ms,
 
 		err = rows.Scan(
 			&dsr.id, &dsr.identJson, &dsr.stepMs, &dsr.hbMs, &dsr.seg, &dsr.idx, &dsr.lastupdate, &dsr.value, &dsr.durationMs, // DS
-			&rrar.id, &rrar.bundleId, &rrar.pos, &rrar.seg, &rrar.idx, &rrar.cf, &rrar.xff, // RRA
+			&rrar.id, &rrar.bundleId, &rrar.pos, &rrar.seg, &rrar.idx, &rrar.cf, &rrar.xff, &rrar.hw, // RRA
 			&bundle.stepMs, &bundle.size, &bundle.width, // Bundle
 			&state.latest, &state.value, &state.durationMs) // RRA State
 		if err != nil {
@@ -744,6 +771,15 @@ SELECT ds.id, ds.ident, ds.step_ms,
 		}
 	}
 
+	// All of the seasonal RRAs at once, rather than a query per DS
+	rrass := make([][]rrd.RoundRobinArchiver, len(dss))
+	for i := range dss {
+		rrass[i] = dss[i].rras
+	}
+	if err := p.loadSeasonalRRAs(rrass...); err != nil {
+		return nil, fmt.Errorf("error loading seasonal RRAs: %v", err)
+	}
+
 	result := make([]rrd.DataSourcer, 0, len(dss))
 	for i := 0; i < len(dss); i++ {
 		ds, err := dataSourceFromDsRec(dss[i].dsr)
@@ -751,6 +787,7 @@ SELECT ds.id, ds.ident, ds.step_ms,
 			return nil, fmt.Errorf("error scanning: %v", err)
 		}
 		ds.SetRRAs(dss[i].rras)
+		ds.ClearRRAs() // the seasonal data is already stored
 		result = append(result, ds)
 	}
 	return result, nil
@@ -759,13 +796,13 @@ SELECT ds.id, ds.ident, ds.step_ms,
 func (p *pgvSerDe) fetchOrCreateRRABundle(tx *sql.Tx, stepMs, size int64) (*rraBundleRecord, error) {
 	rows, err := tx.Stmt(p.sqlSelectRRABundleByStepSize).Query(stepMs, size)
 	if err != nil {
-		log.Printf("fetchOrCreateRRABundle(): error querying database: %v", err)
+		logger.Errorf("fetchOrCreateRRABundle(): error querying database: %v", err)
 		return nil, err
 	}
 	if !rows.Next() { // Needs to be created
 		rows, err = tx.Stmt(p.sqlInsertRRABundle).Query(stepMs, size)
 		if err != nil {
-			log.Printf("fetchOrCreateRRABundle(): error inserting: %v", err)
+			logger.Errorf("fetchOrCreateRRABundle(): error inserting: %v", err)
 			return nil, err
 		}
 		rows.Next()
@@ -774,7 +811,7 @@ func (p *pgvSerDe) fetchOrCreateRRABundle(tx *sql.Tx, stepMs, size int64) (*rraB
 
 	var bundle *rraBundleRecord
 	if bundle, err = rraBundleRecordFromRow(rows); err != nil {
-		log.Printf("fetchOrCreateRRABundle(): error: %v", err)
+		logger.Errorf("fetchOrCreateRRABundle(): error: %v", err)
 		return nil, err
 	}
 	return bundle, nil
@@ -783,7 +820,7 @@ func (p *pgvSerDe) fetchOrCreateRRABundle(tx *sql.Tx, stepMs, size int64) (*rraB
 func (p *pgvSerDe) fetchRRABundle(id int64) (*rraBundleRecord, error) {
 	rows, err := p.sqlSelectRRABundle.Query(id)
 	if err != nil {
-		log.Printf("fetchRRABundle(): error querying database: %v", err)
+		logger.Errorf("fetchRRABundle(): error querying database: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -792,7 +829,7 @@ func (p *pgvSerDe) fetchRRABundle(id int64) (*rraBundleRecord, error) {
 		if bundle, err := rraBundleRecordFromRow(rows); err == nil {
 			return bundle, nil
 		} else {
-			log.Printf("fetchRRABundle(): error: %v", err)
+			logger.Errorf("fetchRRABundle(): error: %v", err)
 			return nil, err
 		}
 	}
@@ -802,7 +839,7 @@ func (p *pgvSerDe) fetchRRABundle(id int64) (*rraBundleRecord, error) {
 func (p *pgvSerDe) fetchRRAState(bundleId, seg, idx int64) (*rraStateRecord, error) {
 	rows, err := p.sqlSelectRRAState.Query(bundleId, seg, idx)
 	if err != nil {
-		log.Printf("fetchRRAState(): error querying database: %v", err)
+		logger.Errorf("fetchRRAState(): error querying database: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -810,7 +847,7 @@ func (p *pgvSerDe) fetchRRAState(bundleId, seg, idx int64) (*rraStateRecord, err
 	if rows.Next() {
 		var state rraStateRecord
 		if err := rows.Scan(&state.latest, &state.value, &state.durationMs); err != nil {
-			log.Printf("fetchRRAState(): error scanning: %v", err)
+			logger.Errorf("fetchRRAState(): error scanning: %v", err)
 			return nil, err
 		}
 		return &state, nil
@@ -822,7 +859,7 @@ func (p *pgvSerDe) fetchRoundRobinArchives(ds *DbDataSource) ([]rrd.RoundRobinAr
 	var err error
 	rows, err := p.sqlSelectRRAsByDsId.Query(ds.Id())
 	if err != nil {
-		log.Printf("fetchRoundRobinArchives(): error querying database: %v", err)
+		logger.Errorf("fetchRoundRobinArchives(): error querying database: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -833,28 +870,28 @@ func (p *pgvSerDe) fetchRoundRobinArchives(ds *DbDataSource) ([]rrd.RoundRobinAr
 		var rraRec *rraRecord
 		rraRec, err = rraRecordFromRow(rows)
 		if err != nil {
-			log.Printf("fetchRoundRobinArchives(): error: %v", err)
+			logger.Errorf("fetchRoundRobinArchives(): error: %v", err)
 			return nil, err
 		}
 		// bundle
 		var bundle *rraBundleRecord
 		bundle, err = p.fetchRRABundle(rraRec.bundleId)
 		if err != nil {
-			log.Printf("fetchRoundRobinArchives(): error2: %v", err)
+			logger.Errorf("fetchRoundRobinArchives(): error2: %v", err)
 			return nil, err
 		}
 		// state
 		var stateRec *rraStateRecord
 		stateRec, err = p.fetchRRAState(bundle.id, rraRec.seg, rraRec.idx)
 		if err != nil {
-			log.Printf("fetchRoundRobinArchives(): error3: %v", err)
+			logger.Errorf("fetchRoundRobinArchives(): error3: %v", err)
 			return nil, err
 		}
 		// rra (finally)
 		var rra *DbRoundRobinArchive
 		rra, err = rraFromRRARecordStateAndBundle(rraRec, stateRec, bundle)
 		if err != nil {
-			log.Printf("fetchRoundRobinArchives(): error4: %v", err)
+			logger.Errorf("fetchRoundRobinArchives(): error4: %v", err)
 			return nil, err
 		}
 		// append
@@ -1028,7 +1065,7 @@ func (p *pgvSerDe) fetchDataSource(ident Ident) (*DbDataSource, error) {
 
 	rows, err := p.sqlSelectDSByIdent.Query(ident.String())
 	if err != nil {
-		log.Printf("fetchDataSource(): error querying database: %v", err)
+		logger.Errorf("fetchDataSource(): error querying database: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -1036,16 +1073,20 @@ func (p *pgvSerDe) fetchDataSource(ident Ident) (*DbDataSource, error) {
 	if rows.Next() {
 		ds, err := dataSourceFromRow(rows)
 		if err != nil {
-			log.Printf("fetchDataSource(): error scanning DS: %v", err)
+			logger.Errorf("fetchDataSource(): error scanning DS: %v", err)
 			return nil, err
 		}
 		rras, err := p.fetchRoundRobinArchives(ds)
 		if err != nil {
-			log.Printf("fetchDataSource(): error fetching RRAs: %v", err)
+			logger.Errorf("fetchDataSource(): error fetching RRAs: %v", err)
+			return nil, err
+		}
+		if err = p.loadSeasonalRRAs(rras); err != nil {
+			logger.Errorf("fetchDataSource(): error loading seasonal RRAs: %v", err)
 			return nil, err
-		} else {
-			ds.SetRRAs(rras)
 		}
+		ds.SetRRAs(rras)
+		ds.ClearRRAs() // the seasonal data is already stored
 		return ds, nil
 	}
 
@@ -1068,25 +1109,28 @@ func (p *pgvSerDe) FetchOrCreateDataSource(ident Ident, dsSpec *rrd.DSSpec) (rrd
 	if err != nil {
 		return nil, err
 	}
-	if ds != nil || dsSpec == nil {
-		return ds, err
+	if ds != nil {
+		return ds, nil
+	}
+	if dsSpec == nil {
+		return nil, nil // not a typed nil
 	}
 
 	// Now try INSERT
 	rows, err = p.sqlInsertDS.Query(ident.String(), dsSpec.Step.Nanoseconds()/1000000, dsSpec.Heartbeat.Nanoseconds()/1000000)
 	if err != nil {
-		log.Printf("FetchOrCreateDataSource(): error querying database: %v", err)
+		logger.Errorf("FetchOrCreateDataSource(): error querying database: %v", err)
 		return nil, err
 	}
 	if !rows.Next() {
-		log.Printf("FetchOrCreateDataSource(): unable to lookup/create")
+		logger.Errorf("FetchOrCreateDataSource(): unable to lookup/create")
 		return nil, fmt.Errorf("unable to lookup/create")
 	}
 	defer rows.Close()
 
 	ds, err = dataSourceFromRow(rows)
 	if err != nil {
-		log.Printf("FetchOrCreateDataSource(): error 1: %v", err)
+		logger.Errorf("FetchOrCreateDataSource(): error 1: %v", err)
 		return nil, err
 	}
 	if !ds.Created() { // UPSERT did not INSERT, nothing more to do here
@@ -1111,23 +1155,21 @@ func (p *pgvSerDe) FetchOrCreateDataSource(ident Ident, dsSpec *rrd.DSSpec) (rrd
 	for _, rraSpec := range dsSpec.RRAs {
 		stepMs := rraSpec.Step.Nanoseconds() / 1000000
 		size := rraSpec.Span.Nanoseconds() / rraSpec.Step.Nanoseconds()
-		var cf string
-		switch rraSpec.Function {
-		case rrd.WMEAN:
-			cf = "WMEAN"
-		case rrd.MIN:
-			cf = "MIN"
-		case rrd.MAX:
-			cf = "MAX"
-		case rrd.LAST:
-			cf = "LAST"
+		cf := rraSpec.Function.String()
+
+		var hw []byte
+		if rrd.IsHoltWinters(rraSpec.Function) {
+			if hw, err = json.Marshal(rraSpec.HW); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
 		}
 
 		// rra_bundle
 		var bundle *rraBundleRecord
 		bundle, err = p.fetchOrCreateRRABundle(tx, stepMs, size)
 		if err != nil {
-			log.Printf("FetchOrCreateDataSource(): error creating RRA bundle: %v", err)
+			logger.Errorf("FetchOrCreateDataSource(): error creating RRA bundle: %v", err)
 			tx.Rollback()
 			return nil, err
 		}
@@ -1138,7 +1180,7 @@ func (p *pgvSerDe) FetchOrCreateDataSource(ident Ident, dsSpec *rrd.DSSpec) (rrd
 		// the rra already exists.
 		pos, err := p.rraBundleIncrPos(tx, bundle.id)
 		if err != nil {
-			log.Printf("FetchOrCreateDataSource(): error incrementing last_pos in RRA bundle: %v", err)
+			logger.Errorf("FetchOrCreateDataSource(): error incrementing last_pos in RRA bundle: %v", err)
 			tx.Rollback()
 			return nil, err
 		}
@@ -1146,9 +1188,9 @@ func (p *pgvSerDe) FetchOrCreateDataSource(ident Ident, dsSpec *rrd.DSSpec) (rrd
 		// rra
 		var rraRows *sql.Rows
 		seg, idx := segIdxFromPosWidth(pos, bundle.width)
-		rraRows, err = tx.Stmt(p.sqlInsertRRA).Query(ds.Id(), bundle.id, pos, seg, idx, cf, rraSpec.Xff)
+		rraRows, err = tx.Stmt(p.sqlInsertRRA).Query(ds.Id(), bundle.id, pos, seg, idx, cf, rraSpec.Xff, hw)
 		if err != nil {
-			log.Printf("FetchOrCreateDataSource(): error creating RRAs: %v", err)
+			logger.Errorf("FetchOrCreateDataSource(): error creating RRAs: %v", err)
 			tx.Rollback()
 			return nil, err
 		}
@@ -1158,7 +1200,7 @@ func (p *pgvSerDe) FetchOrCreateDataSource(ident Ident, dsSpec *rrd.DSSpec) (rrd
 		rraRec, err = rraRecordFromRow(rraRows)
 		rraRows.Close()
 		if err != nil {
-			log.Printf("FetchOrCreateDataSource(): error2: %v", err)
+			logger.Errorf("FetchOrCreateDataSource(): error2: %v", err)
 			tx.Rollback()
 			return nil, err
 		}
@@ -1173,7 +1215,7 @@ func (p *pgvSerDe) FetchOrCreateDataSource(ident Ident, dsSpec *rrd.DSSpec) (rrd
 		var rra *DbRoundRobinArchive
 		rra, err = rraFromRRARecordStateAndBundle(rraRec, rraState, bundle)
 		if err != nil {
-			log.Printf("FetchOrCreateDataSource(): error3: %v", err)
+			logger.Errorf("FetchOrCreateDataSource(): error3: %v", err)
 			tx.Rollback()
 			return nil, err
 		}
@@ -1182,8 +1224,8 @@ func (p *pgvSerDe) FetchOrCreateDataSource(ident Ident, dsSpec *rrd.DSSpec) (rrd
 	}
 	ds.SetRRAs(rras)
 
-	if debug {
-		log.Printf("FetchOrCreateDataSource(): returning ds.id %d: LastUpdate: %v, %#v", ds.Id(), ds.LastUpdate(), ds)
+	if logger.Enabled(logging.Debug) {
+		logger.Debugf("FetchOrCreateDataSource(): returning ds.id %d: LastUpdate: %v, %#v", ds.Id(), ds.LastUpdate(), ds)
 	}
 
 	tx.Commit()
@@ -1202,6 +1244,28 @@ func (p *pgvSerDe) FetchSeries(ds rrd.DataSourcer, from, to time.Time, maxPoints
 		return nil, fmt.Errorf("FetchSeries: No adequate RRA found for DS id: %v from: %v to: %v maxPoints: %v", dbds.Id(), from, to, maxPoints)
 	}
 
+	return p.fetchSeries(dbds, rra, from, to, maxPoints)
+}
+
+// FetchFunctionSeries is same as FetchSeries, but only considers RRAs
+// with the consolidation function cf.
+func (p *pgvSerDe) FetchFunctionSeries(ds rrd.DataSourcer, cf rrd.Consolidation, from, to time.Time, maxPoints int64) (series.Series, error) {
+
+	dbds, ok := ds.(DbDataSourcer)
+	if !ok {
+		return nil, fmt.Errorf("FetchFunctionSeries: ds must be a DbDataSourcer")
+	}
+
+	rra := dbds.BestRRAFunction(cf, from, to, maxPoints)
+	if rra == nil {
+		return nil, fmt.Errorf("FetchFunctionSeries: No adequate RRA found for DS id: %v from: %v to: %v maxPoints: %v", dbds.Id(), from, to, maxPoints)
+	}
+
+	return p.fetchSeries(dbds, rra, from, to, maxPoints)
+}
+
+func (p *pgvSerDe) fetchSeries(dbds DbDataSourcer, rra rrd.RoundRobinArchiver, from, to time.Time, maxPoints int64) (series.Series, error) {
+
 	// If from/to are nil - assign the rra boundaries
 	rraEarliest := rra.Begins(rra.Latest())
 
@@ -1218,6 +1282,22 @@ func (p *pgvSerDe) FetchSeries(ds rrd.DataSourcer, from, to time.Time, maxPoints
 	return dps, nil
 }
 
+// rraVersions returns the slot index of the latest of rra, the
+// version of the slots up to and including it and the version of
+// the slots after it (i.e. from the previous time around).
+func rraVersions(rra rrd.RoundRobinArchiver) (latest_i int64, latestVer, prevVer int) {
+	// TODO There should be a centralized place for version calculation
+	latest_i = rrd.SlotIndex(rra.Latest(), rra.Step(), rra.Size())
+	span_ms := (rra.Step().Nanoseconds() / 1e6) * rra.Size()
+	latest_ms := rra.Latest().UnixNano() / 1e6
+	latestVer = int((latest_ms / span_ms) % 32767)
+	prevVer = latestVer - 1
+	if prevVer == -1 {
+		prevVer = 32767
+	}
+	return latest_i, latestVer, prevVer
+}
+
 func (p *pgvSerDe) loadRRADps(rra *DbRoundRobinArchive) (map[int64]float64, error) {
 	// the subselect apparently encourages index scan
 	stmt := `
@@ -1227,19 +1307,11 @@ func (p *pgvSerDe) loadRRADps(rra *DbRoundRobinArchive) (map[int64]float64, erro
            WHERE rra_bundle_id = $2 AND seg = $3 AND dp[$1] IS NOT NULL AND dp[$1] <> 'NaN') x
     WHERE (i <= $4) AND v = $5 OR (i > $4) AND v = $6
 `
-	// TODO There should be a centralized place for version calculation
-	latest_i := rrd.SlotIndex(rra.Latest(), rra.Step(), rra.Size())
-	span_ms := (rra.Step().Nanoseconds() / 1e6) * rra.Size()
-	latest_ms := rra.Latest().UnixNano() / 1e6
-	latestVer := int((latest_ms / span_ms) % 32767)
-	prevVer := latestVer - 1
-	if prevVer == -1 {
-		prevVer = 32767
-	}
+	latest_i, latestVer, prevVer := rraVersions(rra)
 
 	rows, err := p.dbConn.Query(fmt.Sprintf(stmt, p.prefix), rra.Idx(), rra.BundleId(), rra.Seg(), latest_i, latestVer, prevVer)
 	if err != nil {
-		log.Printf("LoadRRAData: error %v", err)
+		logger.Errorf("LoadRRAData: error %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -1252,7 +1324,7 @@ func (p *pgvSerDe) loadRRADps(rra *DbRoundRobinArchive) (map[int64]float64, erro
 		)
 		err = rows.Scan(&i, &val)
 		if err != nil {
-			log.Printf("LoadRRAData: error scanning %v", err)
+			logger.Errorf("LoadRRAData: error scanning %v", err)
 			return nil, err
 		}
 		if val != nil && !math.IsNaN(*val) {
@@ -1262,6 +1334,95 @@ func (p *pgvSerDe) loadRRADps(rra *DbRoundRobinArchive) (map[int64]float64, erro
 	return dps, nil
 }
 
+// The Holt-Winters model needs all of the seasonal coefficients and
+// deviations to continue updating, this replaces the SEASONAL and
+// DEVSEASONAL RRAs in every rras with ones containing all the data,
+// which is loaded in a single query.
+func (p *pgvSerDe) loadSeasonalRRAs(rrass ...[]rrd.RoundRobinArchiver) error {
+	type pos struct{ j, i int } // rrass[j][i]
+	var (
+		found                         []pos
+		ns, bundleIds, segs, latestIs []int64
+		idxs, vers, prevVers          []int64
+	)
+	for j, rras := range rrass {
+		for i, rra := range rras {
+			if cf := rra.Spec().Function; cf != rrd.SEASONAL && cf != rrd.DEVSEASONAL {
+				continue
+			}
+			dbrra, ok := rra.(*DbRoundRobinArchive)
+			if !ok {
+				return fmt.Errorf("loadSeasonalRRAs: Not a *DbRoundRobinArchive")
+			}
+			found = append(found, pos{j, i})
+			if rra.Latest().IsZero() {
+				continue // no data
+			}
+			latest_i, latestVer, prevVer := rraVersions(dbrra)
+			ns = append(ns, int64(len(found)-1))
+			idxs = append(idxs, dbrra.Idx())
+			bundleIds = append(bundleIds, dbrra.BundleId())
+			segs = append(segs, dbrra.Seg())
+			latestIs = append(latestIs, latest_i)
+			vers = append(vers, int64(latestVer))
+			prevVers = append(prevVers, int64(prevVer))
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+
+	dps := make([]map[int64]float64, len(found))
+	if len(ns) > 0 {
+		// Same as loadRRADps(), for every RRA (k.n) at once.
+		stmt := `
+  SELECT k.n, ts.i, ts.dp[k.idx]
+    FROM unnest($1::bigint[], $2::int[], $3::bigint[], $4::bigint[], $5::bigint[], $6::int[], $7::int[])
+         AS k(n, idx, bundle_id, seg, latest_i, ver, prev_ver)
+    JOIN %[1]sts ts ON ts.rra_bundle_id = k.bundle_id AND ts.seg = k.seg
+   WHERE ts.dp[k.idx] IS NOT NULL AND ts.dp[k.idx] <> 'NaN'
+     AND ((ts.i <= k.latest_i) AND ts.ver[k.idx] = k.ver OR (ts.i > k.latest_i) AND ts.ver[k.idx] = k.prev_ver)
+`
+		rows, err := p.dbConn.Query(fmt.Sprintf(stmt, p.prefix), pq.Array(ns), pq.Array(idxs), pq.Array(bundleIds),
+			pq.Array(segs), pq.Array(latestIs), pq.Array(vers), pq.Array(prevVers))
+		if err != nil {
+			logger.Errorf("loadSeasonalRRAs: error %v", err)
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var (
+				n, i int64
+				val  *float64
+			)
+			if err := rows.Scan(&n, &i, &val); err != nil {
+				logger.Errorf("loadSeasonalRRAs: error scanning %v", err)
+				return err
+			}
+			if val != nil && !math.IsNaN(*val) {
+				if dps[n] == nil {
+					dps[n] = make(map[int64]float64)
+				}
+				dps[n][i] = *val
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	for n, f := range found {
+		r, err := dbRRAWithDPs(rrass[f.j][f.i].(*DbRoundRobinArchive), dps[n])
+		if err != nil {
+			logger.Errorf("loadSeasonalRRAs: error creating rra %v", err)
+			return err
+		}
+		rrass[f.j][f.i] = r
+	}
+	return nil
+}
+
 // Returns a *new* RRA based on the one passed in, containing all the data.
 // If the database is behind and data has not been saved yet, the version system
 // will correct for it, latest does not have to be spot on accurate.
@@ -1278,26 +1439,30 @@ func (p *pgvSerDe) LoadRRAData(rra rrd.RoundRobinArchiver) (rrd.RoundRobinArchiv
 
 	if !rra.Latest().IsZero() {
 		if dps, err = p.loadRRADps(dbrra); err != nil {
-			log.Printf("LoadRRAData: error loading data points %v", err)
+			logger.Errorf("LoadRRAData: error loading data points %v", err)
 			return nil, err
 		}
 	}
 
-	spec := dbrra.Spec()
-	spec.Latest = dbrra.Latest()
-	spec.Value = dbrra.Value()
-	spec.Duration = dbrra.Duration()
-	spec.DPs = dps // could be nil if latest is zero
-
-	newrra, err := newDbRoundRobinArchive(dbrra.id, dbrra.width, dbrra.bundleId, dbrra.pos, spec)
+	newrra, err := dbRRAWithDPs(dbrra, dps) // dps could be nil if latest is zero
 	if err != nil {
-		log.Printf("LoadRRAData: error creating rra %v", err)
+		logger.Errorf("LoadRRAData: error creating rra %v", err)
 		return nil, err
 	}
 
 	return newrra, nil
 }
 
+// dbRRAWithDPs returns a new RRA like dbrra, with dps as its data.
+func dbRRAWithDPs(dbrra *DbRoundRobinArchive, dps map[int64]float64) (*DbRoundRobinArchive, error) {
+	spec := dbrra.Spec()
+	spec.Latest = dbrra.Latest()
+	spec.Value = dbrra.Value()
+	spec.Duration = dbrra.Duration()
+	spec.DPs = dps
+	return newDbRoundRobinArchive(dbrra.id, dbrra.width, dbrra.bundleId, dbrra.pos, spec)
+}
+
 func (p *pgvSerDe) TsTableSize() (size, count int64, err error) {
 	const stmt = `
   SELECT pg_total_relation_size(c.oid) AS total_bytes
@@ -1325,7 +1490,7 @@ func (p *pgvSerDe) rraBundleIncrPos(tx *sql.Tx, id int64) (int64, error) {
 	stmt := fmt.Sprintf("UPDATE %[1]srra_bundle SET last_pos = last_pos + 1 WHERE id = $1 RETURNING last_pos", p.prefix)
 	rows, err := tx.Query(stmt, id)
 	if err != nil {
-		log.Printf("rraBundleIncrPos(): error querying database: %v", err)
+		logger.Errorf("rraBundleIncrPos(): error querying database: %v", err)
 		return 0, err
 	}
 	defer rows.Close()
@@ -1333,7 +1498,7 @@ func (p *pgvSerDe) rraBundleIncrPos(tx *sql.Tx, id int64) (int64, error) {
 	var pos int64
 	if rows.Next() {
 		if err := rows.Scan(&pos); err != nil {
-			log.Printf("rraBundleIncrPos(): error scanning row: %v", err)
+			logger.Errorf("rraBundleIncrPos(): error scanning row: %v", err)
 			return 0, err
 		}
 		return pos, nil
@@ -1341,6 +1506,42 @@ func (p *pgvSerDe) rraBundleIncrPos(tx *sql.Tx, id int64) (int64, error) {
 	return 0, fmt.Errorf("rraBundleIncrPos: could not increment pos?")
 }
 
+// DeleteDataSource deletes the DS and its RRAs. The data remains in
+// the ts table, but since RRA positions are never reused, it is
+// unreachable. Receivers are notified via the delete trigger.
+func (p *pgvSerDe) DeleteDataSource(ident Ident) error {
+	res, err := p.dbConn.Exec(fmt.Sprintf("DELETE FROM %[1]sds WHERE ident = $1", p.prefix), ident.String())
+	if err != nil {
+		logger.Errorf("DeleteDataSource(): %v", err)
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return fmt.Errorf("DeleteDataSource: no such DS: %v", ident)
+	}
+	return nil
+}
+
+// RenameDataSource changes the ident of a DS. Since the data is
+// associated with the DS id, it is kept. Receivers are notified of
+// the old ident via the delete trigger.
+func (p *pgvSerDe) RenameDataSource(from, to Ident) error {
+	if len(to) == 0 {
+		return fmt.Errorf("RenameDataSource: new ident is empty")
+	}
+	res, err := p.dbConn.Exec(fmt.Sprintf("UPDATE %[1]sds SET ident = $2 WHERE ident = $1", p.prefix), from.String(), to.String())
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" { // unique_violation
+			return fmt.Errorf("RenameDataSource: DS already exists: %v", to)
+		}
+		logger.Errorf("RenameDataSource(): %v", err)
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return fmt.Errorf("RenameDataSource: no such DS: %v", from)
+	}
+	return nil
+}
+
 // DS delete LISTEN/NOTIFY
 
 func (p *pgvSerDe) RegisterDeleteListener(handler func(Ident)) error {
@@ -1360,13 +1561,13 @@ func handleDeleteNotifications(l *pq.Listener, handler func(Ident)) {
 			// TODO: Should we be checking the n.Channel value to make sure
 			// it is not some other event?
 			if n.Extra == "" {
-				log.Printf("handleDeleteNotifications: Warning: ignoring empty n.Extra string.")
+				logger.Warnf("handleDeleteNotifications: ignoring empty n.Extra string.")
 				continue
 			}
 			var ident Ident
 			err := json.Unmarshal([]byte(n.Extra), &ident)
 			if err != nil {
-				log.Printf("handleDeleteNotifications(): error unmarshalling ident: %v", err)
+				logger.Errorf("handleDeleteNotifications(): error unmarshalling ident: %v", err)
 			}
 			handler(ident)
 		case <-time.After(30 * time.Second):
@@ -1384,20 +1585,20 @@ func (p *pgvSerDe) SaveDSLCacheKeys(idents []Ident) error {
 	// Truncate the table
 	_, err := p.dbConn.Exec(fmt.Sprintf("TRUNCATE %[1]sdsl_cache", p.prefix))
 	if err != nil {
-		log.Printf("SaveDSLCacheKeys(): %v", err)
+		logger.Errorf("SaveDSLCacheKeys(): %v", err)
 		return err
 	}
 
 	// Dump them.
 	rows := make([]string, 0, len(idents))
 	for _, ident := range idents {
-		rows = append(rows, fmt.Sprintf("('%s')", ident.String()))
+		rows = append(rows, fmt.Sprintf("('%s')", strings.Replace(ident.String(), "'", "''", -1)))
 	}
 
 	stmt := fmt.Sprintf(`INSERT INTO %[1]sdsl_cache (ident) VALUES %s`, p.prefix, strings.Join(rows, ","))
 	_, err = p.dbConn.Exec(stmt)
 	if err != nil {
-		log.Printf("SaveDSLCacheKeys(): %v", err)
+		logger.Errorf("SaveDSLCacheKeys(): %v", err)
 		return err
 	}
 
@@ -1410,7 +1611,7 @@ func (p *pgvSerDe) LoadDSLCacheKeys() ([]Ident, error) {
 
 	rows, err := p.dbConn.Query(stmt)
 	if err != nil {
-		log.Printf("LoadDSLCacheKeys(): %v", err)
+		logger.Errorf("LoadDSLCacheKeys(): %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -1419,14 +1620,14 @@ func (p *pgvSerDe) LoadDSLCacheKeys() ([]Ident, error) {
 	for rows.Next() {
 		var istr string
 		if err := rows.Scan(&istr); err != nil {
-			log.Printf("LoadDSLCacheKeys(): %v", err)
+			logger.Errorf("LoadDSLCacheKeys(): %v", err)
 			return nil, err
 		}
 
 		var ident Ident
 		err := json.Unmarshal([]byte(istr), &ident)
 		if err != nil {
-			log.Printf("LoadDSLCacheKeys(): error unmarshalling ident: %v", err)
+			logger.Errorf("LoadDSLCacheKeys(): error unmarshalling ident: %v", err)
 			continue
 		}
 
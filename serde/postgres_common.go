@@ -19,7 +19,6 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
 	"strings"
 	"time"
 
@@ -42,7 +41,7 @@ func dataSourceFromDsRec(dsr *dsRecord) (*DbDataSource, error) {
 	var ident Ident
 	err := json.Unmarshal(dsr.identJson, &ident)
 	if err != nil {
-		log.Printf("dataSourceFromRow(): error unmarshalling ident: %v", err)
+		logger.Errorf("dataSourceFromRow(): error unmarshalling ident: %v", err)
 		return nil, err
 	}
 
@@ -72,7 +71,7 @@ func dsRecordFromRow(rows *sql.Rows) (*dsRecord, error) {
 func dataSourceFromRow(rows *sql.Rows) (*DbDataSource, error) {
 	dsr, err := dsRecordFromRow(rows)
 	if err != nil {
-		log.Printf("dataSourceFromRow(): error scanning row: %v", err)
+		logger.Errorf("dataSourceFromRow(): error scanning row: %v", err)
 		return nil, err
 	}
 	return dataSourceFromDsRec(dsr)
@@ -92,13 +91,13 @@ func (sr *pgSearchResult) Next() bool {
 	var b []byte
 	sr.err = sr.rows.Scan(&b)
 	if sr.err != nil {
-		log.Printf("pgSearchResult.Next(): error scanning row: %v", sr.err)
+		logger.Errorf("pgSearchResult.Next(): error scanning row: %v", sr.err)
 		return false
 	}
 	var ident Ident // we want a new map created, not reuse the same one
 	sr.err = json.Unmarshal(b, &ident)
 	if sr.err != nil {
-		log.Printf("Search(): error unmarshalling ident %q: %v", string(b), sr.err)
+		logger.Errorf("Search(): error unmarshalling ident %q: %v", string(b), sr.err)
 		return false
 	}
 	sr.ident = ident
@@ -19,19 +19,28 @@ package serde
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
 	"sort"
+	"strconv"
 	"time"
 
+	"github.com/tgres/tgres/logging"
 	"github.com/tgres/tgres/rrd"
 	"github.com/tgres/tgres/series"
 )
 
-var debug bool
+var (
+	debug  bool
+	logger = logging.New("serde")
+)
 
 func init() {
 	debug = os.Getenv("TGRES_SERDE_DEBUG") != ""
+	if debug {
+		logger.SetLevel(logging.Debug)
+	}
 }
 
 // An iterator, similar to sql.Rows.
@@ -66,6 +75,24 @@ type Fetcher interface {
 	FetchSeries(ds rrd.DataSourcer, from, to time.Time, maxPoints int64) (series.Series, error)
 }
 
+// FunctionSeriesFetcher is implemented by fetchers which can select
+// an RRA by its consolidation function. This is the only way to get
+// at the Holt-Winters RRAs, which FetchSeries never selects.
+type FunctionSeriesFetcher interface {
+	FetchFunctionSeries(ds rrd.DataSourcer, cf rrd.Consolidation, from, to time.Time, maxPoints int64) (series.Series, error)
+}
+
+// DataSourceAdmin is implemented by fetchers which can delete and
+// rename data sources. Deleting a DS deletes its RRAs and thus its
+// data, renaming changes the ident only, the data is kept. Both
+// return an error if the DS does not exist, renaming also if a DS
+// with the new ident already exists. In both cases the old ident is
+// passed to the delete listeners (see EventListener).
+type DataSourceAdmin interface {
+	DeleteDataSource(ident Ident) error
+	RenameDataSource(from, to Ident) error
+}
+
 type EventListener interface {
 	RegisterDeleteListener(func(Ident)) error
 }
@@ -111,7 +138,7 @@ func (it Ident) String() string {
 	buf := bytes.NewBuffer(make([]byte, 0, 256))
 	buf.WriteByte('{')
 	for i, k := range keys {
-		fmt.Fprintf(buf, `%q: %q`, k, it[k])
+		fmt.Fprintf(buf, `%s: %s`, quoteJSON(k), quoteJSON(it[k]))
 		if i < len(keys)-1 {
 			buf.WriteByte(',')
 		}
@@ -119,3 +146,19 @@ func (it Ident) String() string {
 	buf.WriteByte('}')
 	return buf.String()
 }
+
+// quoteJSON quotes s as a JSON string. For printable ASCII this is
+// the same as Go quoting, which is faster, but Go escapes such as \x
+// are not valid JSON.
+func quoteJSON(s string) string {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c >= 0x7f {
+			var buf bytes.Buffer
+			enc := json.NewEncoder(&buf)
+			enc.SetEscapeHTML(false)
+			enc.Encode(s)
+			return string(bytes.TrimRight(buf.Bytes(), "\n"))
+		}
+	}
+	return strconv.Quote(s)
+}
@@ -0,0 +1,60 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serde
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func Test_quoteJSON(t *testing.T) {
+	for s, expect := range map[string]string{
+		"foo.bar":       `"foo.bar"`,
+		`a"b\c`:         `"a\"b\\c"`,
+		"tab\there":     `"tab\there"`,
+		"nul\x00":       `"nul\u0000"`,
+		"del\x7f":       "\"del\x7f\"",
+		"héllo":         `"héllo"`,
+		"line\u2028sep": `"line\u2028sep"`,
+		"<&>":           `"<&>"`,
+		"bad\xffutf8":   `"bad�utf8"`,
+	} {
+		q := quoteJSON(s)
+		if q != expect {
+			t.Errorf("quoteJSON(%q): expected %s, got %s", s, expect, q)
+		}
+		var back string
+		if err := json.Unmarshal([]byte(q), &back); err != nil {
+			t.Errorf("quoteJSON(%q): invalid JSON %s: %v", s, q, err)
+		}
+	}
+}
+
+func Test_Ident_String(t *testing.T) {
+	ident := Ident{"name": "a b\n\u2028", "host": "日本", "x\x01": "\xff"}
+	s := ident.String()
+	expect := `{"host": "日本","name": "a b\n\u2028","x\u0001": "�"}`
+	if s != expect {
+		t.Errorf("Ident.String(): expected %s, got %s", expect, s)
+	}
+	var back map[string]string
+	if err := json.Unmarshal([]byte(s), &back); err != nil {
+		t.Fatalf("Ident.String() is not valid JSON: %s: %v", s, err)
+	}
+	if back["name"] != ident["name"] || back["host"] != ident["host"] {
+		t.Errorf("Ident.String() does not round trip: %v", back)
+	}
+}
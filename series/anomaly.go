@@ -0,0 +1,367 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package series
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Anomaly detection functions. These all operate on a series as a
+// []float64 in which NaN means unknown. Unknown values are skipped
+// when computing statistics and produce an unknown result, they are
+// never anomalous.
+
+// The MAD of a normal distribution multiplied by this is its
+// standard deviation.
+const MADScale = 1.4826
+
+// RollingZScore computes the mean and standard deviation of the
+// window points preceding every point of data (the point itself is
+// not included, so that an outlier does not inflate its own
+// deviation). It returns the means, deviations and z-scores. The
+// first window points have no history and are NaN, as is the
+// z-score of a point whose window has no deviation.
+func RollingZScore(data []float64, window int) (mean, dev, z []float64) {
+	mean, dev, z = nanSlice(len(data)), nanSlice(len(data)), nanSlice(len(data))
+	if window < 2 {
+		return
+	}
+	// Welford's method, with points also leaving the window. Unlike
+	// the sum of squares less n times the mean squared, this does
+	// not lose the variance to rounding when it is small relative to
+	// the mean.
+	var (
+		m, m2 float64 // mean and sum of squared deviations
+		n     int
+	)
+	for i := range data {
+		if i >= window {
+			if n > 1 {
+				mean[i] = m
+				dev[i] = math.Sqrt(math.Max(0, m2/float64(n-1)))
+				if dev[i] > 0 && !math.IsNaN(data[i]) {
+					z[i] = (data[i] - m) / dev[i]
+				}
+			}
+			if old := data[i-window]; !math.IsNaN(old) {
+				if n--; n == 0 {
+					m, m2 = 0, 0
+				} else {
+					d := old - m
+					m -= d / float64(n)
+					m2 -= d * (old - m)
+				}
+			}
+		}
+		if v := data[i]; !math.IsNaN(v) {
+			n++
+			d := v - m
+			m += d / float64(n)
+			m2 += d * (v - m)
+		}
+	}
+	return
+}
+
+// RollingMAD computes the median and the median absolute deviation
+// (MAD) of the window points preceding every point of data, and the
+// robust ("modified") z-score, which is (x - median) / (MAD *
+// MADScale). Unlike the mean and standard deviation, these are not
+// affected by the outliers in the window.
+func RollingMAD(data []float64, window int) (median, mad, z []float64) {
+	median, mad, z = nanSlice(len(data)), nanSlice(len(data)), nanSlice(len(data))
+	if window < 2 {
+		return
+	}
+	for i := window; i < len(data); i++ {
+		med, d := Median(data[i-window:i]), MAD(data[i-window:i])
+		median[i], mad[i] = med, d
+		if d > 0 && !math.IsNaN(data[i]) {
+			z[i] = (data[i] - med) / (d * MADScale)
+		}
+	}
+	return
+}
+
+// Median returns the median of the non-NaN values in data.
+func Median(data []float64) float64 {
+	cpy := make([]float64, 0, len(data))
+	for _, v := range data {
+		if !math.IsNaN(v) {
+			cpy = append(cpy, v)
+		}
+	}
+	if len(cpy) == 0 {
+		return math.NaN()
+	}
+	sort.Float64s(cpy)
+	if l := len(cpy); l%2 == 0 {
+		return (cpy[l/2-1] + cpy[l/2]) / 2
+	} else {
+		return cpy[l/2]
+	}
+}
+
+// MAD returns the median absolute deviation from the median of the
+// non-NaN values in data.
+func MAD(data []float64) float64 {
+	med := Median(data)
+	devs := make([]float64, 0, len(data))
+	for _, v := range data {
+		if !math.IsNaN(v) {
+			devs = append(devs, math.Abs(v-med))
+		}
+	}
+	return Median(devs)
+}
+
+// STLDecompose performs a Seasonal-Trend decomposition using LOESS
+// (Cleveland et al, "STL: A Seasonal-Trend Decomposition Procedure
+// Based on Loess", 1990) of data with period points per season. It
+// returns the trend, seasonal and remainder such that data = trend +
+// seasonal + remainder. With robust set, outer iterations reduce the
+// weight of outliers, which is what makes the remainder useful for
+// anomaly detection. At least two full seasons of data are required.
+func STLDecompose(data []float64, period int, robust bool) (trend, seasonal, remainder []float64, err error) {
+	n := len(data)
+	if period < 2 {
+		return nil, nil, nil, fmt.Errorf("STL period must be at least 2, got %d", period)
+	}
+	if n < period*2 {
+		return nil, nil, nil, fmt.Errorf("Not enough data for STL decomposition, need at least two seasons")
+	}
+
+	// Smoothing parameters as recommended in the paper
+	ns := 7                                                                      // seasonal
+	nl := nextOdd(period)                                                        // low-pass
+	nt := nextOdd(int(math.Ceil(1.5 * float64(period) / (1 - 1.5/float64(ns))))) // trend
+	inner, outer := 2, 0
+	if robust {
+		inner, outer = 1, 15
+	}
+
+	trend, seasonal = make([]float64, n), make([]float64, n)
+	weights := make([]float64, n)
+	for i := range weights {
+		weights[i] = 1
+	}
+
+	for o := 0; o <= outer; o++ {
+		for k := 0; k < inner; k++ {
+			// 1. Detrend
+			detrended := make([]float64, n)
+			for i, v := range data {
+				detrended[i] = v - trend[i]
+			}
+
+			// 2. Smooth each cycle-subseries
+			cycle := make([]float64, n)
+			for p := 0; p < period; p++ {
+				var sub, subw []float64
+				for i := p; i < n; i += period {
+					sub = append(sub, detrended[i])
+					subw = append(subw, weights[i])
+				}
+				smooth := loess(sub, subw, ns)
+				for j, i := 0, p; i < n; j, i = j+1, i+period {
+					cycle[i] = smooth[j]
+				}
+			}
+
+			// 3. Low-pass filter of the cycle-subseries
+			lowPass := movingAverage(movingAverage(movingAverage(cycle, period), period), 3)
+			lowPass = loess(lowPass, nil, nl)
+
+			// 4. Detrend the smoothed cycle-subseries
+			// 5. Deseasonalize
+			deseasonalized := make([]float64, n)
+			for i := range data {
+				seasonal[i] = cycle[i] - lowPass[i]
+				deseasonalized[i] = data[i] - seasonal[i]
+			}
+
+			// 6. Smooth the trend
+			trend = loess(deseasonalized, weights, nt)
+		}
+
+		remainder = make([]float64, n)
+		for i := range data {
+			remainder[i] = data[i] - trend[i] - seasonal[i]
+		}
+		if o < outer {
+			weights = bisquareWeights(remainder)
+		}
+	}
+	return trend, seasonal, remainder, nil
+}
+
+// CUSUM performs the tabular (two-sided) cumulative sum change
+// detection of data against the target mean, with slack k and
+// decision threshold h, both in units of sigma. It returns the upper
+// and lower cumulative sums (in units of sigma) and the change points,
+// which are 1 where either of the sums exceeded h (after which the
+// sums restart from zero), 0 otherwise.
+func CUSUM(data []float64, target, sigma, k, h float64) (upper, lower, changes []float64) {
+	upper, lower, changes = nanSlice(len(data)), nanSlice(len(data)), nanSlice(len(data))
+	if sigma <= 0 || math.IsNaN(sigma) {
+		return
+	}
+	var hi, lo float64
+	for i, v := range data {
+		if math.IsNaN(v) {
+			continue
+		}
+		x := (v - target) / sigma
+		hi = math.Max(0, hi+x-k)
+		lo = math.Max(0, lo-x-k)
+		upper[i], lower[i], changes[i] = hi, lo, 0
+		if hi > h || lo > h {
+			changes[i] = 1
+			hi, lo = 0, 0
+		}
+	}
+	return
+}
+
+// loess smooths data with locally weighted (tricube) linear
+// regression over span nearest points, additionally weighted by
+// weights (which may be nil). NaNs are ignored and are replaced by
+// the smoothed value.
+func loess(data, weights []float64, span int) []float64 {
+	n := len(data)
+	result := make([]float64, n)
+	if span > n {
+		span = n
+	}
+	if span < 2 {
+		copy(result, data)
+		return result
+	}
+	for i := 0; i < n; i++ {
+		// the window of span points nearest to i
+		lo := i - span/2
+		if lo < 0 {
+			lo = 0
+		}
+		hi := lo + span - 1
+		if hi >= n {
+			hi = n - 1
+			lo = hi - span + 1
+		}
+		maxDist := math.Max(float64(i-lo), float64(hi-i)) + 1
+
+		var sw, swx, swy, swxx, swxy float64
+		for j := lo; j <= hi; j++ {
+			if math.IsNaN(data[j]) {
+				continue
+			}
+			d := math.Abs(float64(j-i)) / maxDist
+			w := math.Pow(1-d*d*d, 3)
+			if weights != nil {
+				w *= weights[j]
+			}
+			x := float64(j - i)
+			sw += w
+			swx += w * x
+			swy += w * data[j]
+			swxx += w * x * x
+			swxy += w * x * data[j]
+		}
+		if sw == 0 {
+			result[i] = math.NaN()
+			continue
+		}
+		// value of the weighted least squares line at x = 0
+		denom := sw*swxx - swx*swx
+		if math.Abs(denom) < 1e-12 {
+			result[i] = swy / sw
+		} else {
+			result[i] = (swxx*swy - swx*swxy) / denom
+		}
+	}
+	return result
+}
+
+// movingAverage is a centered moving average of window points which
+// is same length as data (the window shrinks at the edges).
+func movingAverage(data []float64, window int) []float64 {
+	n := len(data)
+	result := make([]float64, n)
+	for i := range data {
+		lo, hi := i-window/2, i+(window-1)/2
+		if lo < 0 {
+			lo = 0
+		}
+		if hi >= n {
+			hi = n - 1
+		}
+		var (
+			sum float64
+			cnt int
+		)
+		for j := lo; j <= hi; j++ {
+			if !math.IsNaN(data[j]) {
+				sum += data[j]
+				cnt++
+			}
+		}
+		result[i] = math.NaN()
+		if cnt > 0 {
+			result[i] = sum / float64(cnt)
+		}
+	}
+	return result
+}
+
+// bisquareWeights are the STL robustness weights computed from the
+// remainder, they are zero for values further than 6 MADs away.
+func bisquareWeights(remainder []float64) []float64 {
+	abs := make([]float64, len(remainder))
+	for i, r := range remainder {
+		abs[i] = math.Abs(r)
+	}
+	h := 6 * Median(abs)
+	weights := make([]float64, len(remainder))
+	for i, a := range abs {
+		switch {
+		case math.IsNaN(a):
+			weights[i] = 0
+		case h == 0:
+			weights[i] = 1
+		case a < h:
+			u := a / h
+			weights[i] = (1 - u*u) * (1 - u*u)
+		}
+	}
+	return weights
+}
+
+func nextOdd(n int) int {
+	if n%2 == 0 {
+		return n + 1
+	}
+	return n
+}
+
+func nanSlice(n int) []float64 {
+	result := make([]float64, n)
+	for i := range result {
+		result[i] = math.NaN()
+	}
+	return result
+}
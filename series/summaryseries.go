@@ -51,6 +51,17 @@ func (f *SummarySeries) Min() (min float64) {
 	return
 }
 
+// Returns the sum of all the known (non-NaN) values in the series.
+func (f *SummarySeries) Sum() (sum float64) {
+	for f.Series.Next() {
+		if value := f.Series.CurrentValue(); !math.IsNaN(value) {
+			sum += value
+		}
+	}
+	f.Series.Close()
+	return
+}
+
 // Returns the simple average of all the values in the series.
 func (f *SummarySeries) Avg() float64 {
 	count := 0
@@ -27,12 +27,18 @@ import (
 
 var (
 	Prefix string = "stats"
+
+	// The aggregator command used for counters. The default,
+	// CmdAdd, is the classic statsd rate over the flush
+	// interval. CmdAddEWMA and CmdAddWindow produce rates that are
+	// not affected by flush interval jitter.
+	CounterCmd aggregator.AggCmd = aggregator.CmdAdd
 )
 
 func (st *Stat) AggregatorCmd() *aggregator.Command {
 	if st.Metric == "c" {
 		return aggregator.NewCommand(
-			aggregator.CmdAdd,
+			CounterCmd,
 			serde.Ident{"name": Prefix + "." + st.Name},
 			st.Value*(1/st.Sample))
 	} else if st.Metric == "g" {
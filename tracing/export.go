@@ -0,0 +1,252 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// An Exporter receives spans as they end. Export must not block for
+// long, it is called from the ingest path.
+type Exporter interface {
+	Export(s *Span)
+	Close() error
+}
+
+// ServiceName is reported as the service.name resource attribute.
+var ServiceName = "tgres"
+
+// WriterExporter writes spans to an io.Writer as OTLP/JSON lines. It
+// does so in its own goroutine, if the writer cannot keep up, spans
+// are dropped rather than slowing down the caller.
+type WriterExporter struct {
+	ch      chan *Span
+	w       io.Writer
+	done    chan struct{}
+	dropped int64 // atomic
+	mu      sync.RWMutex
+	closed  bool
+}
+
+// Number of spans waiting to be written before they start being
+// dropped.
+const exportQueueSize = 4096
+
+// NewWriterExporter returns a WriterExporter. If w is an io.Closer,
+// Close() will close it.
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	e := &WriterExporter{
+		ch:   make(chan *Span, exportQueueSize),
+		w:    w,
+		done: make(chan struct{}),
+	}
+	go e.writer()
+	return e
+}
+
+// OpenExporter returns a WriterExporter for dest, which is "stdout",
+// "stderr" or a file name. A file is appended to.
+func OpenExporter(dest string) (*WriterExporter, error) {
+	switch dest {
+	case "stdout":
+		return NewWriterExporter(os.Stdout), nil
+	case "stderr":
+		return NewWriterExporter(os.Stderr), nil
+	case "":
+		return nil, fmt.Errorf("OpenExporter: empty destination")
+	}
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriterExporter(f), nil
+}
+
+// Export queues the span for writing. Spans that end after Close()
+// are dropped.
+func (e *WriterExporter) Export(s *Span) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		atomic.AddInt64(&e.dropped, 1)
+		return
+	}
+	select {
+	case e.ch <- s:
+	default:
+		atomic.AddInt64(&e.dropped, 1)
+	}
+}
+
+// Dropped returns the number of spans dropped so far.
+func (e *WriterExporter) Dropped() int64 {
+	return atomic.LoadInt64(&e.dropped)
+}
+
+// Close writes out any pending spans and closes the underlying
+// writer (unless it is stdout or stderr).
+func (e *WriterExporter) Close() error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.ch)
+	}
+	e.mu.Unlock()
+	<-e.done
+	if e.w == os.Stdout || e.w == os.Stderr {
+		return nil
+	}
+	if c, ok := e.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (e *WriterExporter) writer() {
+	defer close(e.done)
+	bw := bufio.NewWriter(e.w)
+	enc := json.NewEncoder(bw)
+	for s := range e.ch {
+		if err := enc.Encode(s.request()); err != nil {
+			log.Printf("tracing: error writing span: %v", err)
+		}
+		if len(e.ch) == 0 {
+			bw.Flush()
+		}
+	}
+	bw.Flush()
+}
+
+// The OTLP/JSON structures, see
+// https://github.com/open-telemetry/opentelemetry-proto
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceId           string         `json:"traceId"`
+		SpanId            string         `json:"spanId"`
+		ParentSpanId      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              Kind           `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"` // int64 is a string in OTLP/JSON
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+func otlpAttr(key string, val interface{}) otlpKeyValue {
+	kv := otlpKeyValue{Key: key}
+	switch v := val.(type) {
+	case string:
+		kv.Value.StringValue = &v
+	case bool:
+		kv.Value.BoolValue = &v
+	case int:
+		s := strconv.FormatInt(int64(v), 10)
+		kv.Value.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		kv.Value.IntValue = &s
+	case float64:
+		kv.Value.DoubleValue = &v
+	case time.Time:
+		s := v.Format(time.RFC3339Nano)
+		kv.Value.StringValue = &s
+	case time.Duration:
+		s := strconv.FormatInt(int64(v), 10)
+		kv.Value.IntValue = &s
+	default:
+		s := fmt.Sprint(v)
+		kv.Value.StringValue = &s
+	}
+	return kv
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// request converts the span to an OTLP ExportTraceServiceRequest.
+func (s *Span) request() *otlpRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sp := otlpSpan{
+		TraceId:           hex.EncodeToString(s.traceId[:]),
+		SpanId:            hex.EncodeToString(s.spanId[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: unixNano(s.start),
+		EndTimeUnixNano:   unixNano(s.end),
+		Status:            otlpStatus{Code: s.status, Message: s.msg},
+	}
+	if s.parentId != [8]byte{} {
+		sp.ParentSpanId = hex.EncodeToString(s.parentId[:])
+	}
+	for _, a := range s.attrs {
+		sp.Attributes = append(sp.Attributes, otlpAttr(a.key, a.val))
+	}
+
+	return &otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{Attributes: []otlpKeyValue{otlpAttr("service.name", ServiceName)}},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/tgres/tgres/tracing"},
+				Spans: []otlpSpan{sp},
+			}},
+		}},
+	}
+}
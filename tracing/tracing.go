@@ -0,0 +1,213 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tracing provides lightweight spans for following a data
+// point through the receiver or a query through the DSL down to the
+// database.
+//
+// Spans follow the OpenTelemetry data model (W3C trace and span ids,
+// parent span, kind, attributes and status) and are exported in the
+// OTLP/JSON encoding, one span per line, which is what the
+// OpenTelemetry Collector otlpjsonfile receiver reads.
+//
+// A nil *Span is valid and does nothing, this is what Start() returns
+// when tracing is disabled or the trace is not sampled, so that
+// instrumented code need not check.
+//
+//	sp := tracing.Start("http.render", 1.0)
+//	defer sp.End()
+//	ch := sp.Child("dsl.parse")
+//	ch.SetAttr("query", src)
+//	...
+//	ch.End()
+package tracing
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Kind is the OpenTelemetry SpanKind.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// OpenTelemetry STATUS_CODE_ERROR, the zero value is STATUS_CODE_UNSET.
+const statusError = 2
+
+type attr struct {
+	key string
+	val interface{}
+}
+
+// A Span is a timed operation, part of a trace. All methods are safe
+// to call concurrently and on a nil Span.
+type Span struct {
+	mu       sync.Mutex
+	traceId  [16]byte
+	spanId   [8]byte
+	parentId [8]byte
+	name     string
+	kind     Kind
+	start    time.Time
+	end      time.Time
+	attrs    []attr
+	status   int
+	msg      string
+	exp      Exporter
+}
+
+var (
+	expMu    sync.RWMutex
+	exporter Exporter
+)
+
+// SetExporter makes e the destination of all ended spans. A nil
+// exporter disables tracing.
+func SetExporter(e Exporter) {
+	expMu.Lock()
+	exporter = e
+	expMu.Unlock()
+}
+
+// Shutdown disables tracing and closes the exporter, if any, writing
+// out any pending spans.
+func Shutdown() error {
+	expMu.Lock()
+	e := exporter
+	exporter = nil
+	expMu.Unlock()
+	if e != nil {
+		return e.Close()
+	}
+	return nil
+}
+
+// Enabled returns true if there is an exporter.
+func Enabled() bool {
+	expMu.RLock()
+	defer expMu.RUnlock()
+	return exporter != nil
+}
+
+func currentExporter() Exporter {
+	expMu.RLock()
+	defer expMu.RUnlock()
+	return exporter
+}
+
+// Start begins a new trace with a root span, the trace is sampled
+// with the probability of ratio (0.0 to 1.0). If tracing is disabled
+// or the trace is not sampled, nil is returned.
+func Start(name string, ratio float64) *Span {
+	exp := currentExporter()
+	if exp == nil || ratio <= 0 || (ratio < 1 && rand.Float64() >= ratio) {
+		return nil
+	}
+	s := &Span{name: name, kind: KindInternal, start: time.Now(), exp: exp}
+	binary.BigEndian.PutUint64(s.traceId[:8], rand.Uint64())
+	binary.BigEndian.PutUint64(s.traceId[8:], rand.Uint64())
+	binary.BigEndian.PutUint64(s.spanId[:], rand.Uint64())
+	return s
+}
+
+// Child begins a new span in the same trace whose parent is s. A
+// child may outlive its parent. Returns nil if s is nil.
+func (s *Span) Child(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	c := &Span{
+		traceId:  s.traceId,
+		parentId: s.spanId,
+		name:     name,
+		kind:     KindInternal,
+		start:    time.Now(),
+		exp:      s.exp,
+	}
+	binary.BigEndian.PutUint64(c.spanId[:], rand.Uint64())
+	return c
+}
+
+// SetKind sets the span kind, the default is KindInternal.
+func (s *Span) SetKind(k Kind) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.kind = k
+	s.mu.Unlock()
+}
+
+// SetAttr sets an attribute. Strings, bools, integers and floats are
+// exported as such, durations as nanoseconds, times as RFC3339,
+// anything else is formatted with fmt.Sprint.
+func (s *Span) SetAttr(key string, val interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.attrs {
+		if s.attrs[i].key == key {
+			s.attrs[i].val = val
+			return
+		}
+	}
+	s.attrs = append(s.attrs, attr{key, val})
+}
+
+// SetError marks the span as failed, a nil err is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.status, s.msg = statusError, err.Error()
+	s.mu.Unlock()
+}
+
+// End marks the end of the span and exports it. Subsequent calls do
+// nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mu.Unlock()
+	s.exp.Export(s)
+}
+
+// TraceId returns the trace id as hex, or "" if s is nil. It is
+// useful for logging or returning in a response header so that the
+// trace can be found.
+func (s *Span) TraceId() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceId[:])
+}
@@ -0,0 +1,101 @@
+//
+// Copyright 2017 Gregory Trubetskoy. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func Test_Span_disabled(t *testing.T) {
+	SetExporter(nil)
+	sp := Start("foo", 1)
+	if sp != nil {
+		t.Errorf("Start() with no exporter should return nil")
+	}
+	// nil span methods are noops
+	ch := sp.Child("bar")
+	ch.SetKind(KindClient)
+	ch.SetAttr("a", 1)
+	ch.SetError(fmt.Errorf("err"))
+	ch.End()
+	if ch != nil || sp.TraceId() != "" {
+		t.Errorf("nil span: Child() should be nil and TraceId() blank")
+	}
+}
+
+func Test_Span_export(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewWriterExporter(&buf)
+	SetExporter(e)
+	defer SetExporter(nil)
+
+	if Start("never", 0) != nil {
+		t.Errorf("ratio 0 should never sample")
+	}
+
+	root := Start("http.render", 1)
+	root.SetKind(KindServer)
+	root.SetAttr("targets", 2)
+	root.SetAttr("targets", 3) // replaces
+	ch := root.Child("dsl.parse")
+	ch.SetAttr("query", "group(foo.*)")
+	ch.SetAttr("ok", true)
+	ch.SetError(fmt.Errorf("boom"))
+	ch.End()
+	ch.End() // second End is a noop
+	root.End()
+
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var spans []otlpSpan
+	sc := bufio.NewScanner(&buf)
+	for sc.Scan() {
+		var req otlpRequest
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			t.Fatalf("%v: %s", err, sc.Text())
+		}
+		spans = append(spans, req.ResourceSpans[0].ScopeSpans[0].Spans...)
+	}
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+
+	c, r := spans[0], spans[1]
+	if r.TraceId != root.TraceId() || c.TraceId != r.TraceId || len(r.TraceId) != 32 {
+		t.Errorf("trace ids don't match: %q %q %q", root.TraceId(), r.TraceId, c.TraceId)
+	}
+	if r.ParentSpanId != "" || c.ParentSpanId != r.SpanId || len(c.SpanId) != 16 {
+		t.Errorf("bad parent: root %+v child %+v", r, c)
+	}
+	if r.Kind != KindServer || c.Kind != KindInternal {
+		t.Errorf("bad kind: %v %v", r.Kind, c.Kind)
+	}
+	if len(r.Attributes) != 1 || *r.Attributes[0].Value.IntValue != "3" {
+		t.Errorf("bad root attributes: %+v", r.Attributes)
+	}
+	if len(c.Attributes) != 2 || *c.Attributes[0].Value.StringValue != "group(foo.*)" || !*c.Attributes[1].Value.BoolValue {
+		t.Errorf("bad child attributes: %+v", c.Attributes)
+	}
+	if c.Status.Code != statusError || c.Status.Message != "boom" || r.Status.Code != 0 {
+		t.Errorf("bad status: %+v %+v", c.Status, r.Status)
+	}
+}